@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+	_ "time/tzdata" // Múi giờ khi chạy trên Windows không có cơ sở dữ liệu múi giờ
+
+	"modbus_inverter/internal/config"
+	"modbus_inverter/internal/historian"
+	"modbus_inverter/internal/report"
+)
+
+// exportConfig là phần cấu hình gateway cần cho xuất báo cáo
+type exportConfig struct {
+	Devices []struct {
+		Name       string  `json:"name"`
+		Type       string  `json:"type"`
+		RatedPower float64 `json:"rated_power"`
+	} `json:"devices"`
+	Historian historian.Config `json:"historian"`
+}
+
+func main() {
+	configPath := flag.String("config", "gateway.json", "Đường dẫn file cấu hình JSON của gateway")
+	date := flag.String("date", "", "Ngày đầu tiên cần xuất (YYYY-MM-DD), mặc định hôm qua")
+	days := flag.Int("days", 1, "Số ngày cần xuất")
+	outDir := flag.String("out", ".", "Thư mục ghi file CSV")
+	plantName := flag.String("plant", "plant", "Tên nhà máy trong file tổng")
+	tz := flag.String("tz", "Asia/Ho_Chi_Minh", "Múi giờ của báo cáo")
+	excel := flag.Bool("excel", false, "Định dạng cho Excel vùng Việt Nam: phân cách ';', dấu phẩy thập phân")
+	flag.Parse()
+
+	logger := log.New(os.Stdout, "[Export] ", log.LstdFlags)
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		logger.Fatalf("Múi giờ không hợp lệ: %v", err)
+	}
+	var cfg exportConfig
+	if err := config.Load(*configPath, &cfg); err != nil {
+		logger.Fatalf("Lỗi đọc cấu hình: %v", err)
+	}
+	if cfg.Historian.Path == "" {
+		cfg.Historian.Path = "data/historian.db"
+	}
+	if _, err := os.Stat(cfg.Historian.Path); err != nil {
+		logger.Fatalf("Không tìm thấy dữ liệu lịch sử: %v", err)
+	}
+
+	start := time.Now().In(loc).AddDate(0, 0, -1)
+	if *date != "" {
+		start, err = time.ParseInLocation("2006-01-02", *date, loc)
+		if err != nil {
+			logger.Fatalf("Ngày không hợp lệ %q: %v", *date, err)
+		}
+	}
+
+	// Chỉ đọc: không tạo bảng, không ghi, không tổng hợp
+	history, err := historian.OpenReadOnly(cfg.Historian.Path, log.New(io.Discard, "", 0))
+	if err != nil {
+		logger.Fatalf("Lỗi mở dữ liệu lịch sử: %v", err)
+	}
+	defer history.Close()
+
+	opts := report.CSVOptions{Comma: ',', BOM: true}
+	if *excel {
+		opts.Comma = ';'
+		opts.DecimalComma = true
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		logger.Fatalf("Lỗi tạo thư mục %s: %v", *outDir, err)
+	}
+
+	for d := 0; d < *days; d++ {
+		day := start.AddDate(0, 0, d)
+		devices := make(map[string][]report.Interval)
+		rated := make(map[string]float64)
+		for _, dev := range cfg.Devices {
+			if dev.Type != "inverter" {
+				continue
+			}
+			intervals, err := report.Day(history, dev.Name, day)
+			if err != nil {
+				logger.Fatalf("Lỗi tính số liệu %s: %v", dev.Name, err)
+			}
+			devices[dev.Name] = intervals
+			rated[dev.Name] = dev.RatedPower
+			if err := writeFile(*outDir, day, dev.Name, intervals, opts); err != nil {
+				logger.Fatalf("%v", err)
+			}
+		}
+		if len(devices) == 0 {
+			logger.Fatalf("Cấu hình không có inverter nào")
+		}
+		if err := writeFile(*outDir, day, *plantName, report.Plant(devices, rated), opts); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		logger.Printf("Đã xuất ngày %s: %d inverter và tổng nhà máy", day.Format("2006-01-02"), len(devices))
+	}
+}
+
+// writeFile ghi báo cáo của một thiết bị trong một ngày ra <dir>/<ngày>_<tên>.csv
+func writeFile(dir string, day time.Time, name string, intervals []report.Interval, opts report.CSVOptions) error {
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s.csv", day.Format("2006-01-02"), name))
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("tạo file %s lỗi: %w", path, err)
+	}
+	if err := report.WriteCSV(f, name, intervals, opts); err != nil {
+		f.Close()
+		return fmt.Errorf("ghi file %s lỗi: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"modbus_inverter/internal/alarm"
+	"modbus_inverter/internal/api"
+	"modbus_inverter/internal/config"
+	"modbus_inverter/internal/control"
+	"modbus_inverter/internal/counter"
+	"modbus_inverter/internal/deadband"
+	"modbus_inverter/internal/errcode"
+	"modbus_inverter/internal/historian"
+	"modbus_inverter/internal/iec104"
+	"modbus_inverter/internal/influx"
+	"modbus_inverter/internal/kpi"
+	"modbus_inverter/internal/mbtcp"
+	"modbus_inverter/internal/metrics"
+	"modbus_inverter/internal/modbus"
+	"modbus_inverter/internal/plausibility"
+	"modbus_inverter/internal/profile"
+	"modbus_inverter/internal/virtual"
+)
+
+// Loại thiết bị
+const (
+	DeviceInverter = "inverter"
+	DevicePM2120   = "pm2120"
+	DeviceSensor   = "sensor" // Cảm biến bức xạ, trạm thời tiết đọc theo profile
+)
+
+// SerialConfig cấu hình cổng serial RS-485
+type SerialConfig struct {
+	Name     string `json:"name"` // Tên bus dùng trong route chuyển tiếp Modbus TCP, mặc định "rs485"
+	Port     string `json:"port"`
+	BaudRate int    `json:"baud_rate"`
+	DataBits int    `json:"data_bits"`
+	StopBits int    `json:"stop_bits"`
+	Parity   string `json:"parity"`
+}
+
+// DeviceConfig cấu hình một thiết bị trên bus
+type DeviceConfig struct {
+	Name       string  `json:"name"`        // Tên thiết bị, dùng trong bảng IOA và lệnh điều khiển
+	Type       string  `json:"type"`        // "inverter", "pm2120" hoặc "sensor"
+	SlaveID    byte    `json:"slave_id"`    // Địa chỉ Modbus
+	Model      string  `json:"model"`       // Chọn profile đọc dữ liệu (nếu có) và bảng thanh ghi điều khiển của inverter
+	RatedPower float64 `json:"rated_power"` // Công suất định mức (kW)
+	PeakPower  float64 `json:"peak_power"`  // Công suất đỉnh dàn pin (kWp) để tính chỉ số hiệu suất, mặc định bằng RatedPower
+}
+
+// GatewayConfig cấu hình của gateway
+type GatewayConfig struct {
+	Serial       SerialConfig    `json:"serial"`
+	Devices      []DeviceConfig  `json:"devices"`
+	PollInterval config.Duration `json:"poll_interval"` // Chu kỳ đọc dữ liệu
+	StaleAfter   config.Duration `json:"stale_after"`   // Thời gian không cập nhật để giá trị bị coi là cũ, mặc định 5 lần chu kỳ đọc
+
+	IEC104            iec104.Config       `json:"iec104"`
+	ModbusTCP         mbtcp.Config        `json:"modbus_tcp"`
+	PassThrough       mbtcp.Config        `json:"pass_through"` // Chuyển tiếp Modbus TCP sang RTU cho phần mềm cấu hình của hãng
+	Control           control.Config      `json:"control"`
+	Plant             control.PlantConfig `json:"plant"`
+	API               api.Config          `json:"api"`
+	Metrics           metrics.Config      `json:"metrics"`
+	Influx            influx.Config       `json:"influx"`
+	ReportByException deadband.Config     `json:"report_by_exception"` // Lọc giá trị gửi lên InfluxDB theo ngưỡng thay đổi
+	Historian         historian.Config    `json:"historian"`
+	Alarms            alarm.Config        `json:"alarms"`
+	ErrorCodes        errcode.Config      `json:"error_codes"`
+	Plausibility      plausibility.Config `json:"plausibility"` // Kiểm tra tính hợp lý của giá trị đọc được
+	Counters          counter.Config      `json:"counters"`     // Theo dõi quay vòng và đặt lại bộ đếm năng lượng
+	KPI               kpi.Config          `json:"kpi"`          // Chỉ số hiệu suất PR, sản lượng riêng, khả dụng
+
+	VirtualDevices []virtual.Device     `json:"virtual_devices"` // Thiết bị ảo tổng hợp từ tag của các thiết bị khác
+	CalculatedTags []virtual.Calculated `json:"calculated_tags"` // Tag tính toán của từng thiết bị, tính sau mỗi lần đọc
+
+	Profiles map[string]profile.Profile `json:"profiles"` // Bảng thanh ghi đọc bổ sung hoặc thay thế profile có sẵn
+}
+
+// defaultConfig trả về cấu hình mặc định
+func defaultConfig() *GatewayConfig {
+	return &GatewayConfig{
+		Serial: SerialConfig{
+			Name:     "rs485",
+			Port:     "COM7",
+			BaudRate: 9600,
+			DataBits: 8,
+			StopBits: 1,
+			Parity:   "N",
+		},
+		Devices: []DeviceConfig{
+			{Name: "inverter1", Type: DeviceInverter, SlaveID: 1, Model: "generic"},
+		},
+		PollInterval: config.Duration(1 * time.Second),
+	}
+}
+
+// loadConfig đọc cấu hình từ file, bỏ trống đường dẫn để dùng cấu hình mặc định
+func loadConfig(path string) (*GatewayConfig, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		cfg.Devices = nil
+		if err := config.Load(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	profiles := cfg.profiles()
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, dev := range cfg.Devices {
+		if dev.Name == "" {
+			return nil, fmt.Errorf("thiết bị thứ %d chưa có tên", i+1)
+		}
+		if names[dev.Name] {
+			return nil, fmt.Errorf("tên thiết bị %q bị trùng", dev.Name)
+		}
+		names[dev.Name] = true
+		switch dev.Type {
+		case DeviceInverter:
+			if dev.Model == "" {
+				cfg.Devices[i].Model = "generic"
+			}
+		case DevicePM2120:
+		case DeviceSensor:
+			if _, ok := profiles[dev.Model]; !ok {
+				return nil, fmt.Errorf("cảm biến %q có model không hỗ trợ %q", dev.Name, dev.Model)
+			}
+		default:
+			return nil, fmt.Errorf("thiết bị %q có loại không hợp lệ %q", dev.Name, dev.Type)
+		}
+	}
+	for _, dev := range cfg.VirtualDevices {
+		if names[dev.Name] {
+			return nil, fmt.Errorf("tên thiết bị ảo %q trùng với thiết bị khác", dev.Name)
+		}
+		names[dev.Name] = true
+	}
+
+	if len(cfg.IEC104.Points) == 0 {
+		index := 0
+		for _, dev := range cfg.Devices {
+			if dev.Type == DeviceInverter {
+				if index == iec104.MaxDefaultDevices {
+					return nil, fmt.Errorf("bảng IOA mặc định chỉ hỗ trợ tối đa %d inverter, cần khai báo iec104.points", iec104.MaxDefaultDevices)
+				}
+				cfg.IEC104.Points = append(cfg.IEC104.Points, iec104.DefaultPoints(dev.Name, index)...)
+				index++
+			}
+		}
+	}
+
+	if cfg.StaleAfter == 0 {
+		cfg.StaleAfter = 5 * cfg.PollInterval
+	}
+	if cfg.Serial.Name == "" {
+		cfg.Serial.Name = "rs485"
+	}
+	if len(cfg.ModbusTCP.Registers) == 0 {
+		cfg.ModbusTCP.Registers = defaultModbusRegisters(cfg)
+	}
+	if len(cfg.Counters.Counters) == 0 {
+		cfg.Counters.Counters = defaultCounters(cfg)
+	}
+	if cfg.PassThrough.ListenAddr == "" {
+		cfg.PassThrough.ListenAddr = ":5020"
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.ListenAddr == "" && !cfg.API.Enabled {
+		// Bỏ trống cổng riêng thì /metrics phục vụ qua HTTP API
+		return nil, fmt.Errorf("metrics bật nhưng không có listen_addr và HTTP API đang tắt")
+	}
+	if len(cfg.PassThrough.Routes) == 0 && cfg.PassThrough.DefaultBus == "" {
+		// Mặc định chuyển tiếp nguyên unit ID tới bus RS-485
+		cfg.PassThrough.DefaultBus = cfg.Serial.Name
+	}
+	return cfg, nil
+}
+
+// defaultModbusRegisters sinh bảng thanh ghi Modbus TCP cho mọi thiết bị
+func defaultModbusRegisters(cfg *GatewayConfig) []mbtcp.RegisterConfig {
+	var regs []mbtcp.RegisterConfig
+	profiles := cfg.profiles()
+	for i, dev := range cfg.Devices {
+		signals := modbus.InverterSignals
+		switch dev.Type {
+		case DeviceInverter:
+			// Thêm các tín hiệu riêng của model sau các tín hiệu chung
+			if prof, ok := profiles[dev.Model]; ok {
+				signals = append([]string(nil), signals...)
+				for _, signal := range prof.Signals() {
+					if !slices.Contains(modbus.InverterSignals, signal) {
+						signals = append(signals, signal)
+					}
+				}
+			}
+		case DevicePM2120:
+			signals = modbus.PM2120Signals
+		case DeviceSensor:
+			signals = profiles[dev.Model].Signals()
+		}
+		if cfg.ModbusTCP.Map == mbtcp.MapFlat {
+			unit := cfg.ModbusTCP.UnitID
+			if unit == 0 {
+				unit = 1
+			}
+			regs = append(regs, mbtcp.DefaultRegisters(dev.Name, unit, uint16(i*mbtcp.BlockSize), signals)...)
+		} else {
+			regs = append(regs, mbtcp.DefaultRegisters(dev.Name, dev.SlaveID, 0, signals)...)
+		}
+	}
+	return regs
+}
+
+// defaultCounters trả về các bộ đếm mặc định cùng bộ đếm tổng sản lượng riêng cho
+// inverter dùng profile, độ rộng bộ đếm lấy theo kiểu thanh ghi của profile; sản
+// lượng ngày dùng bộ đếm chung vì luôn coi lần giảm là đặt lại
+func defaultCounters(cfg *GatewayConfig) []counter.Counter {
+	counters := counter.DefaultCounters()
+	profiles := cfg.profiles()
+	for _, dev := range cfg.Devices {
+		p, ok := profiles[dev.Model]
+		if dev.Type != DeviceInverter || !ok {
+			continue
+		}
+		counters = append(counters, counter.Counter{Device: dev.Name, Signal: "total_energy", Modulus: p.Modulus("total_energy")})
+	}
+	return counters
+}
+
+// profiles trả về các profile có sẵn cùng profile trong cấu hình
+func (c *GatewayConfig) profiles() map[string]profile.Profile {
+	profiles := profile.Builtin()
+	for model, p := range c.Profiles {
+		p.Model = model
+		profiles[model] = p
+	}
+	return profiles
+}
+
+// device tìm cấu hình thiết bị theo tên
+func (c *GatewayConfig) device(name string) (DeviceConfig, bool) {
+	for _, dev := range c.Devices {
+		if dev.Name == name {
+			return dev, true
+		}
+	}
+	return DeviceConfig{}, false
+}
+
+// redacted trả về bản sao cấu hình đã xóa thông tin bí mật để hiển thị qua API
+func (c *GatewayConfig) redacted() GatewayConfig {
+	cp := *c
+	cp.API.Password = ""
+	cp.API.Token = ""
+	cp.Influx.Password = ""
+	cp.Influx.Token = ""
+	return cp
+}
+
+// apiDevices trả về danh sách thiết bị cho HTTP API
+func (c *GatewayConfig) apiDevices() []api.DeviceInfo {
+	devices := make([]api.DeviceInfo, 0, len(c.Devices))
+	for _, dev := range c.Devices {
+		devices = append(devices, api.DeviceInfo{
+			Name:       dev.Name,
+			Type:       dev.Type,
+			SlaveID:    dev.SlaveID,
+			Model:      dev.Model,
+			RatedPower: dev.RatedPower,
+			PeakPower:  dev.PeakPower,
+		})
+	}
+	for _, dev := range c.VirtualDevices {
+		devices = append(devices, api.DeviceInfo{Name: dev.Name, Type: virtual.DeviceType})
+	}
+	return devices
+}
+
+// deviceTypes trả về loại của từng thiết bị theo tên
+func (c *GatewayConfig) deviceTypes() map[string]string {
+	types := make(map[string]string, len(c.Devices))
+	for _, dev := range c.Devices {
+		types[dev.Name] = dev.Type
+	}
+	for _, dev := range c.VirtualDevices {
+		types[dev.Name] = virtual.DeviceType
+	}
+	return types
+}
+
+// kpiDevices trả về các inverter và công suất đỉnh để tính chỉ số hiệu suất
+func (c *GatewayConfig) kpiDevices() []kpi.Device {
+	var devices []kpi.Device
+	for _, dev := range c.Devices {
+		if dev.Type != DeviceInverter {
+			continue
+		}
+		capacity := dev.PeakPower
+		if capacity == 0 {
+			capacity = dev.RatedPower
+		}
+		devices = append(devices, kpi.Device{Name: dev.Name, Capacity: capacity})
+	}
+	return devices
+}
+
+// deviceNames trả về tên các thiết bị theo thứ tự cấu hình
+func (c *GatewayConfig) deviceNames() []string {
+	names := make([]string, 0, len(c.Devices))
+	for _, dev := range c.Devices {
+		names = append(names, dev.Name)
+	}
+	return names
+}
+
+// errorLookup tra cứu từ điển mã lỗi theo model của từng thiết bị cho hệ thống cảnh báo
+func (c *GatewayConfig) errorLookup(codes *errcode.Registry) alarm.Lookup {
+	models := make(map[string]string, len(c.Devices))
+	for _, dev := range c.Devices {
+		models[dev.Name] = dev.Model
+	}
+	return func(device, signal string, value float64) (string, string, bool) {
+		list := codes.Describe(models[device], signal, value)
+		if len(list) == 0 {
+			return "", "", false
+		}
+		message, severity := errcode.Summary(list, c.ErrorCodes.Language)
+		return message, severity, true
+	}
+}
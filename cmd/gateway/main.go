@@ -1,61 +1,308 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"modbus_inverter/internal/alarm"
+	"modbus_inverter/internal/api"
+	"modbus_inverter/internal/control"
+	"modbus_inverter/internal/counter"
+	"modbus_inverter/internal/deadband"
+	"modbus_inverter/internal/errcode"
+	"modbus_inverter/internal/historian"
+	"modbus_inverter/internal/iec104"
+	"modbus_inverter/internal/influx"
+	"modbus_inverter/internal/kpi"
+	"modbus_inverter/internal/mbtcp"
+	"modbus_inverter/internal/metrics"
 	"modbus_inverter/internal/modbus"
+	"modbus_inverter/internal/plausibility"
+	"modbus_inverter/internal/store"
+	"modbus_inverter/internal/virtual"
 )
 
 func main() {
+	configPath := flag.String("config", "", "Đường dẫn file cấu hình JSON")
+	flag.Parse()
+
 	// Khởi tạo logger
 	logger := log.New(os.Stdout, "[Gateway] ", log.LstdFlags)
 
-	// Khởi tạo Modbus client
-	client, err := modbus.NewClient("COM7", 9600, 8, 1, "N", 1)
+	// Đọc cấu hình
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		logger.Fatalf("Lỗi đọc cấu hình: %v", err)
+	}
+
+	// Khởi tạo bus RS-485 dùng chung cho mọi thiết bị
+	bus, err := modbus.NewRTUClient(cfg.Serial.Port, cfg.Serial.BaudRate, cfg.Serial.DataBits, cfg.Serial.StopBits, cfg.Serial.Parity)
 	if err != nil {
 		logger.Fatalf("Lỗi khởi tạo client: %v", err)
 	}
-	defer client.Close()
+	defer bus.Close()
 
-	// Khởi tạo Inverter service
-	inverterService := modbus.NewInverterService(client)
+	// Giá trị mới nhất của các thiết bị, dùng chung cho các giao diện xuất dữ liệu
+	latest := store.New()
+	latest.SetStaleAfter(cfg.StaleAfter.Std())
 
-	logger.Println("Đã khởi động Gateway")
-	logger.Println("Cấu hình:")
-	logger.Println("- Cổng: COM1")
-	logger.Println("- Baud rate: 9600")
-	logger.Println("- Data bits: 8")
-	logger.Println("- Stop bits: 1")
-	logger.Println("- Parity: N")
-	logger.Println("- Địa chỉ inverter: 1")
+	// Khởi tạo chỉ số Prometheus
+	var promMetrics *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		promMetrics = metrics.New(latest)
+		bus.SetObserver(promMetrics.Bus(cfg.Serial.Name))
+		if cfg.Metrics.ListenAddr != "" {
+			if err := promMetrics.Start(cfg.Metrics, logger); err != nil {
+				logger.Fatalf("Lỗi khởi động metrics: %v", err)
+			}
+			defer promMetrics.Close()
+		}
+	}
 
-	// Vòng lặp chính để đọc dữ liệu
-	go func() {
-		for {
-			// Đọc dữ liệu từ inverter
-			data, err := inverterService.ReadData()
+	// Khởi tạo đầu ra InfluxDB
+	if cfg.Influx.Enabled {
+		writer, err := influx.NewWriter(cfg.Influx, logger)
+		if err != nil {
+			logger.Fatalf("Lỗi khởi tạo InfluxDB: %v", err)
+		}
+		writer.SetDevices(cfg.deviceTypes())
+		if promMetrics != nil {
+			writer.SetQueueObserver(func(depth int) { promMetrics.SetQueueDepth("influx", depth) })
+		}
+		var write store.UpdateFunc = writer.Write
+		if cfg.ReportByException.Enabled {
+			filter, err := deadband.NewFilter(cfg.ReportByException)
 			if err != nil {
-				logger.Printf("Lỗi đọc dữ liệu: %v", err)
-				time.Sleep(5 * time.Second)
+				logger.Fatalf("Lỗi cấu hình gửi theo ngoại lệ: %v", err)
+			}
+			write = filter.Wrap(write)
+		}
+		latest.OnUpdate(write)
+		writer.Start()
+		defer writer.Close()
+	}
+
+	// Khởi tạo lưu trữ lịch sử
+	var history *historian.Historian
+	if cfg.Historian.Enabled {
+		history, err = historian.Open(cfg.Historian, logger)
+		if err != nil {
+			logger.Fatalf("Lỗi khởi tạo lưu trữ lịch sử: %v", err)
+		}
+		latest.OnUpdate(history.Write)
+		history.Start()
+		defer history.Close()
+	}
+
+	// Chỉ số hiệu suất tính từ dữ liệu lịch sử
+	var kpis *kpi.Calculator
+	if cfg.KPI.Enabled {
+		if history == nil {
+			logger.Fatalf("Lỗi khởi tạo chỉ số hiệu suất: cần bật lưu trữ lịch sử")
+		}
+		kpis, err = kpi.NewCalculator(cfg.KPI, history, cfg.kpiDevices(), logger)
+		if err != nil {
+			logger.Fatalf("Lỗi khởi tạo chỉ số hiệu suất: %v", err)
+		}
+		kpis.SetStore(latest)
+		kpis.Start()
+		defer kpis.Close()
+	}
+
+	// Khởi tạo outstation IEC 104
+	var outstation *iec104.Outstation
+	if cfg.IEC104.Enabled {
+		outstation, err = iec104.NewOutstation(cfg.IEC104, logger)
+		if err != nil {
+			logger.Fatalf("Lỗi khởi tạo IEC 104: %v", err)
+		}
+		latest.OnTags(outstation.UpdateTags)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Từ điển mã lỗi theo model inverter
+	codes, err := errcode.Load(cfg.ErrorCodes.Dir)
+	if err != nil {
+		logger.Fatalf("Lỗi đọc từ điển mã lỗi: %v", err)
+	}
+
+	// Kiểm tra tính hợp lý của giá trị đọc được
+	var checker *plausibility.Checker
+	if cfg.Plausibility.Enabled {
+		checker, err = plausibility.NewChecker(cfg.Plausibility, logger)
+		if err != nil {
+			logger.Fatalf("Lỗi cấu hình kiểm tra hợp lý: %v", err)
+		}
+	}
+
+	// Theo dõi bộ đếm năng lượng
+	var tracker *counter.Tracker
+	if cfg.Counters.Enabled {
+		tracker, err = counter.NewTracker(cfg.Counters, logger)
+		if err != nil {
+			logger.Fatalf("Lỗi khởi tạo bộ đếm năng lượng: %v", err)
+		}
+		tracker.Start()
+		defer tracker.Close()
+	}
+
+	// Thiết bị ảo và tag tính toán
+	var virtuals *virtual.Engine
+	if len(cfg.VirtualDevices) > 0 || len(cfg.CalculatedTags) > 0 {
+		virtuals, err = virtual.NewEngine(cfg.VirtualDevices, cfg.CalculatedTags, latest)
+		if err != nil {
+			logger.Fatalf("Lỗi cấu hình thiết bị ảo: %v", err)
+		}
+	}
+
+	// Khởi tạo hệ thống cảnh báo
+	var alarms *alarm.Engine
+	if cfg.Alarms.Enabled {
+		alarms, err = alarm.NewEngine(cfg.Alarms, logger)
+		if err != nil {
+			logger.Fatalf("Lỗi khởi tạo cảnh báo: %v", err)
+		}
+		alarms.SetDevices(cfg.deviceNames())
+		alarms.SetLookup(cfg.errorLookup(codes))
+		if cfg.Alarms.Webhook != "" {
+			hook := alarm.NewWebhook(cfg.Alarms.Webhook, cfg.Alarms.WebhookTimeout.Std(), logger)
+			alarms.OnEvent(hook.Notify)
+			defer hook.Close()
+		}
+		latest.OnUpdate(alarms.Update)
+		go alarms.Run(done)
+	}
+
+	// Khởi tạo hệ thống điều khiển công suất
+	var plant *control.PlantController
+	if cfg.Control.Enabled {
+		controller := control.NewController(cfg.Control, logger)
+		for _, dev := range cfg.Devices {
+			if dev.Type != DeviceInverter {
 				continue
 			}
+			if err := controller.AddDevice(dev.Name, bus.Slave(dev.SlaveID), dev.Model, dev.RatedPower); err != nil {
+				logger.Fatalf("Lỗi khởi tạo điều khiển %s: %v", dev.Name, err)
+			}
+		}
 
-			// Chuyển đổi sang JSON
-			jsonData, err := data.ToJSON()
+		// Bộ điều khiển giới hạn công suất phát lên lưới toàn nhà máy
+		if cfg.Plant.Enabled {
+			meter, ok := cfg.device(cfg.Plant.Meter)
+			if !ok || meter.Type != DevicePM2120 {
+				logger.Fatalf("Lỗi khởi tạo điều khiển nhà máy: không tìm thấy đồng hồ PM2120 %q", cfg.Plant.Meter)
+			}
+			readMeter := func() (float64, error) {
+				power, err := modbus.ReadPM2120ActivePower(bus, meter.SlaveID)
+				return float64(power), err
+			}
+			plant, err = control.NewPlantController(cfg.Plant, controller, readMeter, logger)
 			if err != nil {
-				logger.Printf("Lỗi chuyển đổi JSON: %v", err)
-				continue
+				logger.Fatalf("Lỗi khởi tạo điều khiển nhà máy: %v", err)
 			}
+			go plant.Run(done)
+		}
+
+		if outstation != nil {
+			outstation.SetCommandHandler(func(device, command string, value float64) error {
+				if control.CommandType(command) == control.CmdPlantExportLimit {
+					if plant == nil {
+						return fmt.Errorf("chưa bật điều khiển nhà máy")
+					}
+					plant.SetExportLimit(value)
+					return nil
+				}
+				res := controller.Execute(control.Command{
+					Device: device,
+					Type:   control.CommandType(command),
+					Value:  value,
+					Source: "iec104",
+				})
+				return res.Err
+			})
+		}
+	}
+
+	if outstation != nil {
+		outstation.Start()
+		defer outstation.Close()
+	}
+
+	// Khởi tạo Modbus TCP server và cổng chuyển tiếp Modbus TCP sang RTU
+	for _, mcfg := range []mbtcp.Config{cfg.ModbusTCP, cfg.PassThrough} {
+		if !mcfg.Enabled {
+			continue
+		}
+		server, err := mbtcp.NewServer(mcfg, latest, logger)
+		if err != nil {
+			logger.Fatalf("Lỗi khởi tạo Modbus TCP %s: %v", mcfg.ListenAddr, err)
+		}
+		server.SetBus(cfg.Serial.Name, bus)
+		if err := server.Start(); err != nil {
+			logger.Fatalf("Lỗi khởi động Modbus TCP %s: %v", mcfg.ListenAddr, err)
+		}
+		defer server.Close()
+	}
 
-			// In dữ liệu
-			logger.Printf("Dữ liệu từ inverter: %s", string(jsonData))
-			time.Sleep(1 * time.Second)
+	// Khởi tạo HTTP API
+	if cfg.API.Enabled {
+		apiServer, err := api.NewServer(cfg.API, latest, logger)
+		if err != nil {
+			logger.Fatalf("Lỗi khởi tạo HTTP API: %v", err)
+		}
+		apiServer.SetDevices(cfg.apiDevices())
+		apiServer.SetBus(bus)
+		apiServer.SetConfig(cfg.redacted())
+		apiServer.SetErrorCodes(codes)
+		if checker != nil {
+			apiServer.SetPlausibility(checker)
+		}
+		if history != nil {
+			apiServer.SetHistory(history)
+		}
+		if kpis != nil {
+			apiServer.SetKPI(kpis)
+		}
+		if alarms != nil {
+			apiServer.SetAlarms(alarms)
+		}
+		if promMetrics != nil && cfg.Metrics.ListenAddr == "" {
+			apiServer.Handle("GET /metrics", promMetrics.Handler())
 		}
-	}()
+		if err := apiServer.Start(); err != nil {
+			logger.Fatalf("Lỗi khởi động HTTP API: %v", err)
+		}
+		defer apiServer.Close()
+	}
+
+	logger.Println("Đã khởi động Gateway")
+	logger.Println("Cấu hình:")
+	logger.Printf("- Cổng: %s", cfg.Serial.Port)
+	logger.Printf("- Baud rate: %d", cfg.Serial.BaudRate)
+	logger.Printf("- Data bits: %d", cfg.Serial.DataBits)
+	logger.Printf("- Stop bits: %d", cfg.Serial.StopBits)
+	logger.Printf("- Parity: %s", cfg.Serial.Parity)
+	for _, dev := range cfg.Devices {
+		logger.Printf("- Thiết bị %s (%s): địa chỉ %d", dev.Name, dev.Type, dev.SlaveID)
+	}
+	for _, dev := range cfg.VirtualDevices {
+		logger.Printf("- Thiết bị ảo %s: %d tag", dev.Name, len(dev.Tags))
+	}
+
+	// Vòng lặp chính để đọc dữ liệu
+	poller := newPoller(cfg, bus, latest, plant, logger)
+	poller.metrics = promMetrics
+	poller.codes = codes
+	poller.checker = checker
+	poller.tracker = tracker
+	poller.virtual = virtuals
+	go poller.run(done)
 
 	// Xử lý tín hiệu dừng
 	sigChan := make(chan os.Signal, 1)
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"modbus_inverter/internal/control"
+	"modbus_inverter/internal/counter"
+	"modbus_inverter/internal/errcode"
+	"modbus_inverter/internal/metrics"
+	"modbus_inverter/internal/modbus"
+	"modbus_inverter/internal/plausibility"
+	"modbus_inverter/internal/profile"
+	"modbus_inverter/internal/store"
+	"modbus_inverter/internal/virtual"
+)
+
+// poller đọc tuần tự dữ liệu các thiết bị trên bus
+type poller struct {
+	cfg     *GatewayConfig
+	bus     *modbus.RTUClient
+	logger  *log.Logger
+	store   *store.Store
+	plant   *control.PlantController // nil nếu không bật điều khiển nhà máy
+	metrics *metrics.Metrics         // nil nếu không bật Prometheus
+	codes   *errcode.Registry        // nil nếu không giải nghĩa mã lỗi
+	checker *plausibility.Checker    // nil nếu không kiểm tra tính hợp lý
+	tracker *counter.Tracker         // nil nếu không theo dõi bộ đếm năng lượng
+	virtual *virtual.Engine          // nil nếu không có thiết bị ảo và tag tính toán
+
+	inverters  map[string]*modbus.InverterService
+	sensors    map[string]profile.Profile // Profile của từng cảm biến theo tên
+	lastErrors map[string]uint16          // Mã lỗi gần nhất của từng inverter
+}
+
+// newPoller tạo poller cho các thiết bị trong cấu hình
+func newPoller(cfg *GatewayConfig, bus *modbus.RTUClient, st *store.Store, plant *control.PlantController, logger *log.Logger) *poller {
+	p := &poller{
+		cfg:        cfg,
+		bus:        bus,
+		logger:     logger,
+		store:      st,
+		plant:      plant,
+		inverters:  make(map[string]*modbus.InverterService),
+		sensors:    make(map[string]profile.Profile),
+		lastErrors: make(map[string]uint16),
+	}
+	profiles := cfg.profiles()
+	for _, dev := range cfg.Devices {
+		switch dev.Type {
+		case DeviceInverter:
+			if prof, ok := profiles[dev.Model]; ok {
+				p.inverters[dev.Name] = modbus.NewProfileInverterService(bus.Slave(dev.SlaveID), prof)
+			} else {
+				p.inverters[dev.Name] = modbus.NewInverterService(bus.Slave(dev.SlaveID))
+			}
+		case DeviceSensor:
+			p.sensors[dev.Name] = profiles[dev.Model]
+		}
+	}
+	return p
+}
+
+// run đọc dữ liệu các thiết bị theo chu kỳ cho tới khi done bị đóng
+func (p *poller) run(done <-chan struct{}) {
+	for {
+		for _, dev := range p.cfg.Devices {
+			switch dev.Type {
+			case DeviceInverter:
+				p.pollInverter(dev)
+			case DevicePM2120:
+				p.pollPM2120(dev)
+			case DeviceSensor:
+				p.pollSensor(dev)
+			}
+		}
+		if p.virtual != nil {
+			p.virtual.Evaluate(time.Now())
+		}
+		p.store.CheckStale(time.Now())
+
+		select {
+		case <-done:
+			return
+		case <-time.After(p.cfg.PollInterval.Std()):
+		}
+	}
+}
+
+// pollInverter đọc dữ liệu một inverter và cập nhật vào store
+func (p *poller) pollInverter(dev DeviceConfig) {
+	start := time.Now()
+	data, err := p.inverters[dev.Name].ReadData()
+	p.recordPoll(dev.Name, time.Since(start), err)
+	if err != nil {
+		p.logger.Printf("Lỗi đọc dữ liệu %s: %v", dev.Name, err)
+	}
+	if data == nil {
+		// EVN đọc connection_status làm trạng thái đường truyền: báo 0 với chất
+		// lượng tốt, chỉ các giá trị đo bị đánh dấu mất liên lạc
+		p.store.MarkDisconnected(dev.Name, "connection_status", time.Now())
+		if p.plant != nil {
+			p.plant.ClearProduction(dev.Name)
+		}
+		return
+	}
+
+	// Giống PM2120, đọc lỗi một phần vẫn cập nhật các tín hiệu đọc được, tín hiệu
+	// đọc lỗi được đánh dấu mất liên lạc. Store công bố tiếp qua IEC 104 và các
+	// đầu ra khác.
+	tags := store.Tags(data.Values(), data.Timestamp)
+	for _, signal := range data.Failed() {
+		if _, ok := tags[signal]; !ok {
+			tags[signal] = store.Tag{Quality: store.QualityCommFailure}
+		}
+	}
+	tags = p.process(dev.Name, tags)
+	p.store.UpdateTags(dev.Name, tags)
+
+	// Phản hồi công suất thực phát cho bộ điều khiển nhà máy
+	if p.plant != nil {
+		if tag, ok := tags["active_power"]; ok && tag.Quality.Usable() {
+			p.plant.UpdateProduction(dev.Name, tag.Value)
+		} else {
+			p.plant.ClearProduction(dev.Name)
+		}
+	}
+
+	if tag, ok := tags["error_code"]; ok && tag.Quality.Usable() {
+		p.logErrorCode(dev, data.ErrorCode)
+	}
+
+	// Chuyển đổi sang JSON
+	jsonData, err := data.ToJSON()
+	if err != nil {
+		p.logger.Printf("Lỗi chuyển đổi JSON: %v", err)
+		return
+	}
+	p.logger.Printf("Dữ liệu từ %s: %s", dev.Name, string(jsonData))
+}
+
+// pollPM2120 đọc dữ liệu đồng hồ PM2120
+func (p *poller) pollPM2120(dev DeviceConfig) {
+	start := time.Now()
+	data, err := modbus.ReadPM2120Data(p.bus, dev.SlaveID)
+	p.recordPoll(dev.Name, time.Since(start), err)
+	if err != nil {
+		p.logger.Printf("Lỗi đọc dữ liệu %s: %v", dev.Name, err)
+	}
+
+	// Đọc lỗi một phần vẫn cập nhật các trường đọc được,
+	// các trường đọc lỗi (nil) được đánh dấu mất liên lạc
+	values := data.Values()
+	if len(values) == 0 {
+		p.store.MarkInvalid(dev.Name, time.Now())
+		return
+	}
+	tags := store.Tags(values, time.Now())
+	for _, signal := range modbus.PM2120Signals {
+		if _, ok := tags[signal]; !ok {
+			tags[signal] = store.Tag{Quality: store.QualityCommFailure}
+		}
+	}
+	p.store.UpdateTags(dev.Name, p.process(dev.Name, tags))
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		p.logger.Printf("Lỗi chuyển đổi JSON: %v", err)
+		return
+	}
+	p.logger.Printf("Dữ liệu từ %s: %s", dev.Name, string(jsonData))
+}
+
+// pollSensor đọc dữ liệu cảm biến bức xạ hoặc trạm thời tiết theo profile
+func (p *poller) pollSensor(dev DeviceConfig) {
+	prof := p.sensors[dev.Name]
+	start := time.Now()
+	values, err := prof.Read(p.bus.Slave(dev.SlaveID))
+	p.recordPoll(dev.Name, time.Since(start), err)
+	if err != nil {
+		p.logger.Printf("Lỗi đọc dữ liệu %s: %v", dev.Name, err)
+	}
+
+	// Giống PM2120, tín hiệu đọc lỗi được đánh dấu mất liên lạc
+	if len(values) == 0 {
+		p.store.MarkInvalid(dev.Name, time.Now())
+		return
+	}
+	tags := store.Tags(values, time.Now())
+	for _, signal := range prof.Signals() {
+		if _, ok := tags[signal]; !ok {
+			tags[signal] = store.Tag{Quality: store.QualityCommFailure}
+		}
+	}
+	p.store.UpdateTags(dev.Name, p.process(dev.Name, tags))
+
+	jsonData, err := json.Marshal(values)
+	if err != nil {
+		p.logger.Printf("Lỗi chuyển đổi JSON: %v", err)
+		return
+	}
+	p.logger.Printf("Dữ liệu từ %s: %s", dev.Name, string(jsonData))
+}
+
+// process kiểm tra tính hợp lý, theo dõi bộ đếm năng lượng và thêm tag tính toán nếu được bật.
+// Bộ đếm chạy sau kiểm tra để không tính các giá trị bị đánh dấu ngoài dải, tag tính toán
+// chạy cuối để dùng được năng lượng tích lũy.
+func (p *poller) process(device string, tags map[string]store.Tag) map[string]store.Tag {
+	if p.checker != nil {
+		tags = p.checker.Check(device, tags)
+	}
+	if p.tracker != nil {
+		tags = p.tracker.Track(device, tags)
+	}
+	if p.virtual != nil {
+		tags = p.virtual.Apply(device, tags)
+	}
+	return tags
+}
+
+// logErrorCode ghi nhật ký nội dung và hướng xử lý khi mã lỗi của inverter thay đổi
+func (p *poller) logErrorCode(dev DeviceConfig, code uint16) {
+	if p.codes == nil || code == p.lastErrors[dev.Name] {
+		return
+	}
+	p.lastErrors[dev.Name] = code
+	if code == 0 {
+		p.logger.Printf("%s: hết lỗi", dev.Name)
+		return
+	}
+	lang := p.cfg.ErrorCodes.Language
+	for _, d := range p.codes.Describe(dev.Model, "error_code", float64(code)) {
+		p.logger.Printf("%s: mã lỗi %d [%s] %s. %s", dev.Name, code, d.Severity, d.Message.In(lang), d.Action.In(lang))
+	}
+}
+
+// recordPoll ghi nhận thống kê một lần đọc thiết bị
+func (p *poller) recordPoll(device string, duration time.Duration, err error) {
+	p.store.RecordPoll(device, duration, err)
+	if p.metrics != nil {
+		p.metrics.ObservePoll(device, duration, err)
+	}
+}
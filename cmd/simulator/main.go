@@ -29,9 +29,6 @@ func main() {
 	}
 	defer handler.Close()
 
-	// Tạo client Modbus
-	client := modbus.NewClient(handler)
-
 	// Khởi tạo dữ liệu mẫu
 	registers := make([]byte, 26) // 13 thanh ghi * 2 bytes
 	registers[0] = 0x00
@@ -0,0 +1,123 @@
+package alarm
+
+import (
+	"fmt"
+	"strings"
+
+	"modbus_inverter/internal/config"
+)
+
+// Loại quy tắc cảnh báo
+const (
+	RuleHigh  = "high"  // Giá trị lớn hơn ngưỡng
+	RuleLow   = "low"   // Giá trị nhỏ hơn ngưỡng
+	RuleRate  = "rate"  // Tốc độ thay đổi (đơn vị/giây) lớn hơn ngưỡng
+	RuleStale = "stale" // Không nhận được dữ liệu quá thời gian chờ
+	RuleBit   = "bit"   // Có bit bất kỳ trong mask được bật
+	RuleCode  = "code"  // Mã lỗi khác 0, mỗi mã là một cảnh báo riêng
+)
+
+// Mức độ nghiêm trọng
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Config cấu hình hệ thống cảnh báo
+type Config struct {
+	Enabled        bool            `json:"enabled"`
+	Rules          []Rule          `json:"rules"`
+	CheckInterval  config.Duration `json:"check_interval"`  // Chu kỳ kiểm tra mất dữ liệu và trễ kích hoạt, mặc định 5s
+	HistorySize    int             `json:"history_size"`    // Số cảnh báo đã kết thúc được giữ lại, mặc định 500
+	Webhook        string          `json:"webhook"`         // URL nhận sự kiện cảnh báo (POST JSON), bỏ trống để tắt
+	WebhookTimeout config.Duration `json:"webhook_timeout"` // Mặc định 5s
+}
+
+// Rule là một quy tắc cảnh báo
+type Rule struct {
+	Name       string            `json:"name"`       // Tên quy tắc, duy nhất
+	Device     string            `json:"device"`     // Tên thiết bị, bỏ trống: mọi thiết bị
+	Signal     string            `json:"signal"`     // Tín hiệu cần kiểm tra (không dùng cho stale)
+	Type       string            `json:"type"`       // high, low, rate, stale, bit, code
+	Limit      float64           `json:"limit"`      // Ngưỡng của high, low, rate
+	Hysteresis float64           `json:"hysteresis"` // Độ trễ khi trở về bình thường của high, low, rate
+	Mask       uint32            `json:"mask"`       // Mask của bit
+	Codes      map[string]string `json:"codes"`      // Mô tả theo mã lỗi của code
+	Timeout    config.Duration   `json:"timeout"`    // Thời gian chờ của stale
+	Delay      config.Duration   `json:"delay"`      // Điều kiện phải kéo dài ít nhất Delay mới báo
+	Severity   string            `json:"severity"`   // info, warning (mặc định), critical
+	Message    string            `json:"message"`    // Nội dung cảnh báo, mặc định sinh theo quy tắc
+}
+
+// validate kiểm tra và điền giá trị mặc định cho quy tắc
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("quy tắc cảnh báo chưa có tên")
+	}
+	if strings.Contains(r.Name, ":") {
+		return fmt.Errorf("tên quy tắc %q không được chứa ':'", r.Name)
+	}
+	switch r.Type {
+	case RuleHigh, RuleLow, RuleRate, RuleCode:
+	case RuleBit:
+		if r.Mask == 0 {
+			return fmt.Errorf("quy tắc %q chưa có mask", r.Name)
+		}
+	case RuleStale:
+		if r.Timeout <= 0 {
+			return fmt.Errorf("quy tắc %q chưa có timeout", r.Name)
+		}
+	default:
+		return fmt.Errorf("quy tắc %q có loại không hợp lệ %q", r.Name, r.Type)
+	}
+	if r.Type != RuleStale && r.Signal == "" {
+		return fmt.Errorf("quy tắc %q chưa có tín hiệu", r.Name)
+	}
+	if r.Hysteresis < 0 {
+		return fmt.Errorf("quy tắc %q có hysteresis âm", r.Name)
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("quy tắc %q có mức độ không hợp lệ %q", r.Name, r.Severity)
+	}
+	return nil
+}
+
+// matches cho biết quy tắc có áp dụng cho thiết bị không
+func (r *Rule) matches(device string) bool {
+	return r.Device == "" || r.Device == device
+}
+
+// message sinh nội dung cảnh báo
+func (r *Rule) message(value float64, code int) string {
+	if r.Type == RuleCode {
+		text := r.Codes[fmt.Sprint(code)]
+		if text == "" {
+			text = r.Message
+		}
+		if text == "" {
+			return fmt.Sprintf("Mã lỗi %d", code)
+		}
+		return fmt.Sprintf("%s (mã %d)", text, code)
+	}
+	if r.Message != "" {
+		return r.Message
+	}
+	switch r.Type {
+	case RuleHigh:
+		return fmt.Sprintf("%s cao: %.3g > %.3g", r.Signal, value, r.Limit)
+	case RuleLow:
+		return fmt.Sprintf("%s thấp: %.3g < %.3g", r.Signal, value, r.Limit)
+	case RuleRate:
+		return fmt.Sprintf("%s thay đổi nhanh: %.3g/s > %.3g/s", r.Signal, value, r.Limit)
+	case RuleStale:
+		return fmt.Sprintf("Mất dữ liệu quá %v", r.Timeout.Std())
+	case RuleBit:
+		return fmt.Sprintf("%s bật bit 0x%X", r.Signal, uint32(value)&r.Mask)
+	}
+	return r.Name
+}
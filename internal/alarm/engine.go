@@ -0,0 +1,427 @@
+package alarm
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"modbus_inverter/internal/config"
+)
+
+// State trạng thái của một cảnh báo
+type State string
+
+const (
+	StateActive       State = "active"       // Đang xảy ra, chưa xác nhận
+	StateAcknowledged State = "acknowledged" // Đang xảy ra, đã xác nhận
+	StateCleared      State = "cleared"      // Đã trở về bình thường, chưa xác nhận
+	StateClosed       State = "closed"       // Đã trở về bình thường và đã xác nhận
+)
+
+// Loại sự kiện gửi tới đầu ra
+const (
+	EventRaised       = "raised"
+	EventCleared      = "cleared"
+	EventAcknowledged = "acknowledged"
+)
+
+// Alarm là một cảnh báo
+type Alarm struct {
+	ID             string    `json:"id"` // <quy tắc>:<thiết bị>[:<mã lỗi>]
+	Rule           string    `json:"rule"`
+	Device         string    `json:"device"`
+	Signal         string    `json:"signal"`
+	Severity       string    `json:"severity"`
+	Message        string    `json:"message"`
+	Value          float64   `json:"value"` // Giá trị lúc kích hoạt
+	State          State     `json:"state"`
+	RaisedAt       time.Time `json:"raised_at"`
+	ClearedAt      time.Time `json:"cleared_at"`
+	AcknowledgedAt time.Time `json:"acknowledged_at"`
+	AcknowledgedBy string    `json:"acknowledged_by"`
+}
+
+// Event là sự kiện thay đổi trạng thái cảnh báo
+type Event struct {
+	Type  string `json:"type"` // raised, cleared, acknowledged
+	Alarm Alarm  `json:"alarm"`
+}
+
+// Lookup tra cứu nội dung và mức độ của giá trị một tín hiệu trên thiết bị
+// từ từ điển mã lỗi; ok = false nếu không có mô tả
+type Lookup func(device, signal string, value float64) (message, severity string, ok bool)
+
+// condition là trạng thái đánh giá của một quy tắc trên một thiết bị
+type condition struct {
+	rule    *Rule
+	device  string
+	pending time.Time // Thời điểm điều kiện bắt đầu đúng, zero nếu đang bình thường
+	active  string    // ID cảnh báo đang kích hoạt, rỗng nếu không có
+	value   float64   // Giá trị gần nhất dùng để đánh giá
+	code    int       // Mã lỗi (code) hoặc các bit đang bật (bit) gần nhất
+
+	prev   float64 // Giá trị trước đó của quy tắc rate
+	prevTs time.Time
+}
+
+// Engine đánh giá các quy tắc cảnh báo trên dữ liệu mới đọc, quản lý trạng
+// thái cảnh báo và gửi sự kiện tới các đầu ra
+type Engine struct {
+	cfg    Config
+	logger *log.Logger
+
+	mu        sync.Mutex
+	rules     []*Rule
+	conds     map[string]*condition
+	devices   []string
+	lastSeen  map[string]time.Time
+	started   time.Time
+	alarms    map[string]*Alarm // Cảnh báo chưa kết thúc
+	history   []Alarm           // Cảnh báo đã kết thúc, cũ nhất trước
+	listeners []func(Event)
+	lookup    Lookup
+	events    []Event // Sự kiện chờ gửi sau khi nhả khóa
+}
+
+// NewEngine tạo hệ thống cảnh báo từ cấu hình
+func NewEngine(cfg Config, logger *log.Logger) (*Engine, error) {
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = config.Duration(5 * time.Second)
+	}
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = 500
+	}
+
+	e := &Engine{
+		cfg:      cfg,
+		logger:   logger,
+		conds:    make(map[string]*condition),
+		lastSeen: make(map[string]time.Time),
+		started:  time.Now(),
+		alarms:   make(map[string]*Alarm),
+	}
+	names := make(map[string]bool)
+	for i := range cfg.Rules {
+		r := cfg.Rules[i]
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("tên quy tắc %q bị trùng", r.Name)
+		}
+		names[r.Name] = true
+		e.rules = append(e.rules, &r)
+	}
+	return e, nil
+}
+
+// SetDevices đặt danh sách thiết bị để phát hiện thiết bị chưa từng gửi dữ liệu; gọi trước Run
+func (e *Engine) SetDevices(devices []string) {
+	e.devices = devices
+}
+
+// SetLookup đặt từ điển mã lỗi dùng cho nội dung và mức độ của quy tắc code
+// và bit; gọi trước Run
+func (e *Engine) SetLookup(fn Lookup) {
+	e.lookup = fn
+}
+
+// OnEvent đăng ký hàm nhận sự kiện cảnh báo. Hàm được gọi tuần tự, không giữ khóa.
+func (e *Engine) OnEvent(fn func(Event)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, fn)
+}
+
+// Update đánh giá các quy tắc với giá trị vừa đọc của một thiết bị
+func (e *Engine) Update(device string, values map[string]float64, ts time.Time) {
+	e.mu.Lock()
+	e.lastSeen[device] = ts
+	for _, r := range e.rules {
+		if !r.matches(device) {
+			continue
+		}
+		c := e.condition(r, device)
+		if r.Type == RuleStale {
+			e.transition(c, false, 0, 0, ts)
+			continue
+		}
+		v, ok := values[r.Signal]
+		if !ok || math.IsNaN(v) {
+			continue
+		}
+		e.evaluate(c, v, ts)
+	}
+	e.dispatch()
+}
+
+// Check kiểm tra mất dữ liệu và các điều kiện đã đủ thời gian trễ tại thời điểm now
+func (e *Engine) Check(now time.Time) {
+	e.mu.Lock()
+	for _, r := range e.rules {
+		if r.Type != RuleStale {
+			continue
+		}
+		for _, device := range e.staleDevices(r) {
+			last, ok := e.lastSeen[device]
+			if !ok {
+				last = e.started
+			}
+			age := now.Sub(last)
+			e.transition(e.condition(r, device), age > r.Timeout.Std(), age.Seconds(), 0, now)
+		}
+	}
+	for _, c := range e.conds {
+		if c.active == "" && !c.pending.IsZero() && now.Sub(c.pending) >= c.rule.Delay.Std() {
+			e.raise(c, now)
+		}
+	}
+	e.dispatch()
+}
+
+// Run kiểm tra theo chu kỳ cho tới khi done bị đóng
+func (e *Engine) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(e.cfg.CheckInterval.Std())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			e.Check(now)
+		}
+	}
+}
+
+// Acknowledge xác nhận một cảnh báo
+func (e *Engine) Acknowledge(id, user string) error {
+	e.mu.Lock()
+	a, ok := e.alarms[id]
+	if !ok {
+		e.mu.Unlock()
+		return fmt.Errorf("không tìm thấy cảnh báo %q", id)
+	}
+	if a.State == StateActive || a.State == StateCleared {
+		a.AcknowledgedAt = time.Now()
+		a.AcknowledgedBy = user
+		if a.State == StateActive {
+			a.State = StateAcknowledged
+		} else {
+			e.close(a)
+		}
+		e.logger.Printf("Cảnh báo %s đã được xác nhận bởi %s", id, user)
+		e.events = append(e.events, Event{Type: EventAcknowledged, Alarm: *a})
+	}
+	e.dispatch()
+	return nil
+}
+
+// Active trả về các cảnh báo chưa kết thúc (đang xảy ra hoặc chưa xác nhận),
+// nghiêm trọng và mới nhất trước
+func (e *Engine) Active() []Alarm {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	list := make([]Alarm, 0, len(e.alarms))
+	for _, a := range e.alarms {
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if si, sj := severityRank(list[i].Severity), severityRank(list[j].Severity); si != sj {
+			return si > sj
+		}
+		return list[i].RaisedAt.After(list[j].RaisedAt)
+	})
+	return list
+}
+
+// History trả về tối đa limit cảnh báo đã kết thúc gần nhất, mới nhất trước
+func (e *Engine) History(limit int) []Alarm {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := len(e.history)
+	if limit <= 0 || limit > n {
+		limit = n
+	}
+	list := make([]Alarm, 0, limit)
+	for i := n - 1; i >= n-limit; i-- {
+		list = append(list, e.history[i])
+	}
+	return list
+}
+
+// condition trả về trạng thái đánh giá của quy tắc trên thiết bị, gọi khi đang giữ khóa
+func (e *Engine) condition(r *Rule, device string) *condition {
+	key := r.Name + ":" + device
+	c, ok := e.conds[key]
+	if !ok {
+		c = &condition{rule: r, device: device}
+		e.conds[key] = c
+	}
+	return c
+}
+
+// staleDevices trả về các thiết bị cần kiểm tra mất dữ liệu của quy tắc
+func (e *Engine) staleDevices(r *Rule) []string {
+	if r.Device != "" {
+		return []string{r.Device}
+	}
+	seen := make(map[string]bool)
+	var list []string
+	for _, d := range e.devices {
+		seen[d] = true
+		list = append(list, d)
+	}
+	for d := range e.lastSeen {
+		if !seen[d] {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// evaluate đánh giá một giá trị mới theo loại quy tắc
+func (e *Engine) evaluate(c *condition, v float64, ts time.Time) {
+	r := c.rule
+	active := c.active != ""
+	var on bool
+	code := 0
+	switch r.Type {
+	case RuleHigh:
+		on = v > r.Limit || (active && v > r.Limit-r.Hysteresis)
+	case RuleLow:
+		on = v < r.Limit || (active && v < r.Limit+r.Hysteresis)
+	case RuleRate:
+		prev, prevTs := c.prev, c.prevTs
+		c.prev, c.prevTs = v, ts
+		dt := ts.Sub(prevTs).Seconds()
+		if prevTs.IsZero() || dt <= 0 {
+			return
+		}
+		v = math.Abs(v-prev) / dt
+		on = v > r.Limit || (active && v > r.Limit-r.Hysteresis)
+	case RuleBit:
+		code = int(uint32(v) & r.Mask)
+		on = code != 0
+	case RuleCode:
+		code = int(v)
+		on = code != 0
+	}
+	e.transition(c, on, v, code, ts)
+}
+
+// transition chuyển trạng thái theo kết quả đánh giá, có xét thời gian trễ kích hoạt
+func (e *Engine) transition(c *condition, on bool, value float64, code int, ts time.Time) {
+	// Mã lỗi hoặc bit thay đổi: kết thúc cảnh báo cũ để báo lại với nội dung mới
+	if on && c.active != "" && code != c.code {
+		e.clear(c, ts)
+	}
+	c.value, c.code = value, code
+	if !on {
+		c.pending = time.Time{}
+		if c.active != "" {
+			e.clear(c, ts)
+		}
+		return
+	}
+	if c.active != "" {
+		return
+	}
+	if c.pending.IsZero() {
+		c.pending = ts
+	}
+	if ts.Sub(c.pending) >= c.rule.Delay.Std() {
+		e.raise(c, ts)
+	}
+}
+
+// raise kích hoạt cảnh báo
+func (e *Engine) raise(c *condition, ts time.Time) {
+	r := c.rule
+	id := r.Name + ":" + c.device
+	if r.Type == RuleCode {
+		id = fmt.Sprintf("%s:%d", id, c.code)
+	}
+	a := &Alarm{
+		ID:       id,
+		Rule:     r.Name,
+		Device:   c.device,
+		Signal:   r.Signal,
+		Severity: r.Severity,
+		Message:  r.message(c.value, c.code),
+		Value:    c.value,
+		State:    StateActive,
+		RaisedAt: ts,
+	}
+	// Nội dung từ từ điển mã lỗi khi quy tắc không có mô tả riêng cho mã này
+	if e.lookup != nil && (r.Type == RuleBit || (r.Type == RuleCode && r.Codes[fmt.Sprint(c.code)] == "")) {
+		if msg, severity, ok := e.lookup(c.device, r.Signal, float64(c.code)); ok {
+			a.Message, a.Severity = msg, severity
+			if r.Type == RuleCode {
+				a.Message = fmt.Sprintf("%s (mã %d)", msg, c.code)
+			}
+		}
+	}
+	// Cảnh báo cùng ID chưa được xác nhận từ lần trước được thay thế
+	if old, ok := e.alarms[id]; ok {
+		e.close(old)
+	}
+	e.alarms[id] = a
+	c.active = id
+	e.logger.Printf("Cảnh báo [%s] %s: %s", a.Severity, c.device, a.Message)
+	e.events = append(e.events, Event{Type: EventRaised, Alarm: *a})
+}
+
+// clear đánh dấu cảnh báo đã trở về bình thường
+func (e *Engine) clear(c *condition, ts time.Time) {
+	a, ok := e.alarms[c.active]
+	c.active = ""
+	if !ok {
+		return
+	}
+	a.ClearedAt = ts
+	if a.State == StateAcknowledged {
+		e.close(a)
+	} else {
+		a.State = StateCleared
+	}
+	e.logger.Printf("Hết cảnh báo %s: %s", c.device, a.Message)
+	e.events = append(e.events, Event{Type: EventCleared, Alarm: *a})
+}
+
+// close kết thúc cảnh báo và chuyển vào lịch sử
+func (e *Engine) close(a *Alarm) {
+	a.State = StateClosed
+	delete(e.alarms, a.ID)
+	e.history = append(e.history, *a)
+	if len(e.history) > e.cfg.HistorySize {
+		e.history = e.history[len(e.history)-e.cfg.HistorySize:]
+	}
+}
+
+// dispatch nhả khóa rồi gửi các sự kiện đang chờ tới các đầu ra
+func (e *Engine) dispatch() {
+	events := e.events
+	e.events = nil
+	listeners := e.listeners
+	e.mu.Unlock()
+
+	for _, ev := range events {
+		for _, fn := range listeners {
+			fn(ev)
+		}
+	}
+}
+
+// severityRank thứ tự mức độ nghiêm trọng để sắp xếp
+func severityRank(s string) int {
+	switch s {
+	case SeverityCritical:
+		return 2
+	case SeverityWarning:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+package alarm
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/config"
+)
+
+func newTestEngine(t *testing.T, rules ...Rule) (*Engine, *[]Event) {
+	e, err := NewEngine(Config{Rules: rules}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	var events []Event
+	e.OnEvent(func(ev Event) { events = append(events, ev) })
+	return e, &events
+}
+
+// TestEngine kiểm tra các loại quy tắc và vòng đời cảnh báo
+func TestEngine(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Ngưỡng cao có hysteresis và trễ kích hoạt", func(t *testing.T) {
+		e, events := newTestEngine(t, Rule{
+			Name: "temp_high", Signal: "temperature", Type: RuleHigh,
+			Limit: 70, Hysteresis: 5, Delay: config.Duration(10 * time.Second),
+		})
+
+		e.Update("inv1", map[string]float64{"temperature": 75}, now)
+		assert.Empty(t, e.Active(), "Chưa đủ thời gian trễ")
+		e.Update("inv1", map[string]float64{"temperature": 76}, now.Add(10*time.Second))
+		require.Len(t, e.Active(), 1)
+		assert.Equal(t, "temp_high:inv1", e.Active()[0].ID)
+		assert.Equal(t, StateActive, e.Active()[0].State)
+
+		e.Update("inv1", map[string]float64{"temperature": 68}, now.Add(20*time.Second))
+		assert.Equal(t, StateActive, e.Active()[0].State, "Vẫn trong dải hysteresis")
+
+		e.Update("inv1", map[string]float64{"temperature": 64}, now.Add(30*time.Second))
+		require.Len(t, e.Active(), 1)
+		assert.Equal(t, StateCleared, e.Active()[0].State, "Đã hết nhưng chưa xác nhận")
+
+		require.NoError(t, e.Acknowledge("temp_high:inv1", "admin"))
+		assert.Empty(t, e.Active())
+		history := e.History(10)
+		require.Len(t, history, 1)
+		assert.Equal(t, StateClosed, history[0].State)
+		assert.Equal(t, "admin", history[0].AcknowledgedBy)
+
+		var types []string
+		for _, ev := range *events {
+			types = append(types, ev.Type)
+		}
+		assert.Equal(t, []string{EventRaised, EventCleared, EventAcknowledged}, types)
+		assert.Error(t, e.Acknowledge("unknown", "admin"))
+	})
+
+	t.Run("Trễ kích hoạt hết hạn khi kiểm tra định kỳ", func(t *testing.T) {
+		e, _ := newTestEngine(t, Rule{Name: "off", Signal: "device_status", Type: RuleLow, Limit: 1, Delay: config.Duration(time.Minute)})
+		e.Update("inv1", map[string]float64{"device_status": 0}, now)
+		e.Check(now.Add(30 * time.Second))
+		assert.Empty(t, e.Active())
+		e.Check(now.Add(time.Minute))
+		assert.Len(t, e.Active(), 1)
+	})
+
+	t.Run("Mã lỗi và bit trạng thái", func(t *testing.T) {
+		e, _ := newTestEngine(t,
+			Rule{Name: "fault", Signal: "error_code", Type: RuleCode, Severity: SeverityCritical, Codes: map[string]string{"5": "Mất lưới"}},
+			Rule{Name: "status", Signal: "status_word", Type: RuleBit, Mask: 0x04},
+		)
+		e.Update("inv1", map[string]float64{"error_code": 5, "status_word": 0x03}, now)
+		active := e.Active()
+		require.Len(t, active, 1)
+		assert.Equal(t, "fault:inv1:5", active[0].ID)
+		assert.Equal(t, "Mất lưới (mã 5)", active[0].Message)
+
+		e.Update("inv1", map[string]float64{"error_code": 7, "status_word": 0x07}, now.Add(time.Second))
+		active = e.Active()
+		require.Len(t, active, 3)
+		assert.Equal(t, "fault:inv1:7", active[0].ID, "Nghiêm trọng và mới nhất trước")
+		assert.Equal(t, StateCleared, active[1].State, "Mã cũ đã kết thúc")
+		assert.Equal(t, "status:inv1", active[2].ID)
+	})
+
+	t.Run("Từ điển mã lỗi", func(t *testing.T) {
+		e, _ := newTestEngine(t,
+			Rule{Name: "fault", Signal: "error_code", Type: RuleCode},
+			Rule{Name: "status", Signal: "status_word", Type: RuleBit, Mask: 0xFF},
+		)
+		e.SetLookup(func(device, signal string, value float64) (string, string, bool) {
+			switch {
+			case signal == "error_code" && value == 4:
+				return "Mất lưới", SeverityCritical, true
+			case signal == "status_word" && value == 0x01:
+				return "Giảm công suất", SeverityInfo, true
+			}
+			return "", "", false
+		})
+
+		e.Update("inv1", map[string]float64{"error_code": 4, "status_word": 0x101}, now)
+		active := e.Active()
+		require.Len(t, active, 2)
+		assert.Equal(t, "Mất lưới (mã 4)", active[0].Message)
+		assert.Equal(t, SeverityCritical, active[0].Severity)
+		assert.Equal(t, "Giảm công suất", active[1].Message, "Chỉ tra các bit trong mask")
+		assert.Equal(t, SeverityInfo, active[1].Severity)
+
+		e.Update("inv1", map[string]float64{"error_code": 9, "status_word": 0x03}, now.Add(time.Second))
+		for _, a := range e.Active() {
+			if a.State == StateActive {
+				assert.Contains(t, []string{"Mã lỗi 9", "status_word bật bit 0x3"}, a.Message, "Không có trong từ điển")
+			}
+		}
+	})
+
+	t.Run("Tốc độ thay đổi", func(t *testing.T) {
+		e, _ := newTestEngine(t, Rule{Name: "ramp", Signal: "active_power", Type: RuleRate, Limit: 1})
+		e.Update("inv1", map[string]float64{"active_power": 10}, now)
+		e.Update("inv1", map[string]float64{"active_power": 12}, now.Add(5*time.Second))
+		assert.Empty(t, e.Active())
+		e.Update("inv1", map[string]float64{"active_power": 2}, now.Add(10*time.Second))
+		require.Len(t, e.Active(), 1)
+		assert.InDelta(t, 2, e.Active()[0].Value, 1e-9)
+	})
+
+	t.Run("Mất dữ liệu", func(t *testing.T) {
+		e, _ := newTestEngine(t, Rule{Name: "stale", Type: RuleStale, Timeout: config.Duration(30 * time.Second)})
+		e.SetDevices([]string{"inv1", "inv2"})
+		e.Update("inv1", map[string]float64{"active_power": 1}, now)
+		e.started = now
+
+		e.Check(now.Add(20 * time.Second))
+		assert.Empty(t, e.Active())
+		e.Check(now.Add(time.Minute))
+		assert.Len(t, e.Active(), 2, "Cả thiết bị chưa từng gửi dữ liệu")
+
+		e.Update("inv1", map[string]float64{"active_power": 1}, now.Add(61*time.Second))
+		states := map[string]State{}
+		for _, a := range e.Active() {
+			states[a.ID] = a.State
+		}
+		assert.Equal(t, map[string]State{"stale:inv1": StateCleared, "stale:inv2": StateActive}, states)
+	})
+}
+
+// TestNewEngine kiểm tra cấu hình quy tắc không hợp lệ
+func TestNewEngine(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	for _, rules := range [][]Rule{
+		{{Name: "a", Type: "unknown", Signal: "x"}},
+		{{Name: "a", Type: RuleHigh}},
+		{{Name: "a", Type: RuleStale}},
+		{{Name: "a", Type: RuleBit, Signal: "x"}},
+		{{Name: "a:b", Type: RuleHigh, Signal: "x"}},
+		{{Name: "a", Type: RuleHigh, Signal: "x"}, {Name: "a", Type: RuleLow, Signal: "x"}},
+	} {
+		_, err := NewEngine(Config{Rules: rules}, logger)
+		assert.Error(t, err, "%+v", rules)
+	}
+}
+
+// TestWebhook kiểm tra gửi sự kiện qua webhook
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var received []Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev Event
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&ev))
+		mu.Lock()
+		received = append(received, ev)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	hook := NewWebhook(srv.URL, time.Second, log.New(io.Discard, "", 0))
+	hook.Notify(Event{Type: EventRaised, Alarm: Alarm{ID: "a:inv1"}})
+	require.NoError(t, hook.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 1)
+	assert.Equal(t, "a:inv1", received[0].Alarm.ID)
+}
@@ -0,0 +1,94 @@
+package alarm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhookQueue số sự kiện tối đa chờ gửi, vượt quá thì bỏ sự kiện mới
+const webhookQueue = 100
+
+// Webhook gửi sự kiện cảnh báo dạng JSON tới một URL qua HTTP POST,
+// chạy nền để không làm chậm vòng đọc dữ liệu
+type Webhook struct {
+	url    string
+	client *http.Client
+	logger *log.Logger
+	events chan Event
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewWebhook tạo đầu ra webhook và bắt đầu gửi
+func NewWebhook(url string, timeout time.Duration, logger *log.Logger) *Webhook {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	w := &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		logger: logger,
+		events: make(chan Event, webhookQueue),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+// Notify đưa sự kiện vào hàng đợi gửi, bỏ qua nếu đã đóng
+func (w *Webhook) Notify(ev Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.events <- ev:
+	default:
+		w.logger.Printf("Webhook cảnh báo: hàng đợi đầy, bỏ sự kiện %s %s", ev.Type, ev.Alarm.ID)
+	}
+}
+
+// Close gửi nốt các sự kiện đang chờ rồi dừng
+func (w *Webhook) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	close(w.events)
+	w.mu.Unlock()
+	w.wg.Wait()
+	return nil
+}
+
+// loop gửi lần lượt các sự kiện
+func (w *Webhook) loop() {
+	defer w.wg.Done()
+	for ev := range w.events {
+		if err := w.post(ev); err != nil {
+			w.logger.Printf("Webhook cảnh báo: gửi %s %s lỗi: %v", ev.Type, ev.Alarm.ID, err)
+		}
+	}
+}
+
+// post gửi một sự kiện
+func (w *Webhook) post(ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("máy chủ trả về %s", resp.Status)
+	}
+	return nil
+}
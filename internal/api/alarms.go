@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errNoAlarms lỗi khi gateway chưa bật cảnh báo
+var errNoAlarms = errors.New("chưa bật cảnh báo")
+
+// handleAlarms trả về các cảnh báo đang xảy ra hoặc chưa xác nhận
+func (s *Server) handleAlarms(w http.ResponseWriter, r *http.Request) {
+	if s.alarms == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoAlarms)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.alarms.Active())
+}
+
+// handleAlarmHistory trả về các cảnh báo đã kết thúc: /api/alarms/history?limit=100
+func (s *Server) handleAlarmHistory(w http.ResponseWriter, r *http.Request) {
+	if s.alarms == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoAlarms)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := parseUint(v, 1, 10000, "limit")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		limit = int(n)
+	}
+	writeJSON(w, http.StatusOK, s.alarms.History(limit))
+}
+
+// handleAcknowledge xác nhận một cảnh báo, ghi lại người xác nhận theo tài khoản đăng nhập
+func (s *Server) handleAcknowledge(w http.ResponseWriter, r *http.Request) {
+	if s.alarms == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoAlarms)
+		return
+	}
+	user, _, ok := r.BasicAuth()
+	if !ok {
+		user = "token"
+	}
+	if err := s.alarms.Acknowledge(r.PathValue("id"), user); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePlausibility trả về các bất thường đang xảy ra của bộ kiểm tra tính hợp lý
+func (s *Server) handlePlausibility(w http.ResponseWriter, r *http.Request) {
+	if s.checker == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("chưa bật kiểm tra tính hợp lý"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.checker.Findings())
+}
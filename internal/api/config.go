@@ -0,0 +1,66 @@
+package api
+
+import (
+	"time"
+
+	"modbus_inverter/internal/alarm"
+	"modbus_inverter/internal/errcode"
+	"modbus_inverter/internal/historian"
+	"modbus_inverter/internal/kpi"
+	"modbus_inverter/internal/plausibility"
+)
+
+// Config cấu hình HTTP API
+type Config struct {
+	Enabled            bool   `json:"enabled"`
+	ListenAddr         string `json:"listen_addr"`          // Mặc định ":8080"
+	Username           string `json:"username"`             // Xác thực Basic
+	Password           string `json:"password"`             // Xác thực Basic
+	Token              string `json:"token"`                // Xác thực Bearer cho hệ thống khác
+	AllowRegisterWrite bool   `json:"allow_register_write"` // Cho phép ghi thanh ghi tùy ý khi chạy thử, mặc định tắt
+}
+
+// DeviceInfo thông tin cấu hình của một thiết bị
+type DeviceInfo struct {
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	SlaveID    byte    `json:"slave_id"`
+	Model      string  `json:"model,omitempty"`
+	RatedPower float64 `json:"rated_power,omitempty"`
+	PeakPower  float64 `json:"peak_power,omitempty"`
+}
+
+// RegisterBus là bus RS-485 cho phép đọc/ghi thanh ghi tùy ý theo Slave ID
+type RegisterBus interface {
+	ReadHoldingRegisters(slaveID byte, address uint16, quantity uint16) ([]byte, error)
+	ReadInputRegisters(slaveID byte, address uint16, quantity uint16) ([]byte, error)
+	WriteMultipleRegisters(slaveID byte, address uint16, values []uint16) error
+}
+
+// History là nguồn dữ liệu lịch sử cho biểu đồ xu hướng và xuất dữ liệu
+type History interface {
+	Query(device, signal string, from, to time.Time, resolution string) ([]historian.Point, error)
+	Series() ([]historian.Series, error)
+}
+
+// Alarms là hệ thống cảnh báo
+type Alarms interface {
+	Active() []alarm.Alarm
+	History(limit int) []alarm.Alarm
+	Acknowledge(id, user string) error
+}
+
+// ErrorCodes giải nghĩa mã lỗi và bit trạng thái theo model thiết bị
+type ErrorCodes interface {
+	DecodeAll(model string, values map[string]float64) []errcode.Decoded
+}
+
+// KPI tính chỉ số hiệu suất theo ngày
+type KPI interface {
+	Day(day time.Time) ([]kpi.Result, error)
+}
+
+// Plausibility là bộ kiểm tra tính hợp lý của giá trị đọc được
+type Plausibility interface {
+	Findings() []plausibility.Finding
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFS chứa giao diện web cho kỹ thuật viên chạy thử tại hiện trường
+//
+//go:embed web
+var webFS embed.FS
+
+// registerDashboard đăng ký trang giao diện web và các file tĩnh
+func (s *Server) registerDashboard() {
+	static, _ := fs.Sub(webFS, "web")
+	files := http.FileServerFS(static)
+	s.mux.Handle("GET /{$}", files)
+	s.mux.Handle("GET /static/", http.StripPrefix("/static/", files))
+}
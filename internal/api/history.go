@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"modbus_inverter/internal/historian"
+)
+
+// maxHistorySignals số tín hiệu tối đa của một yêu cầu lịch sử
+const maxHistorySignals = 20
+
+// errNoHistory lỗi khi gateway chưa bật lưu trữ lịch sử
+var errNoHistory = errors.New("chưa bật lưu trữ lịch sử")
+
+// historyResponse là kết quả truy vấn lịch sử
+type historyResponse struct {
+	Device     string                       `json:"device"`
+	Resolution string                       `json:"resolution"`
+	From       time.Time                    `json:"from"`
+	To         time.Time                    `json:"to"`
+	Signals    map[string][]historian.Point `json:"signals"`
+}
+
+// handleHistory trả về dữ liệu lịch sử cho biểu đồ xu hướng hoặc xuất CSV:
+// /api/history?device=inv1&signal=active_power,voltage&from=...&to=...&resolution=auto&format=json|csv
+// from, to theo RFC 3339 hoặc Unix giây, mặc định 24 giờ gần nhất
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoHistory)
+		return
+	}
+
+	q := r.URL.Query()
+	device := q.Get("device")
+	if device == "" {
+		writeError(w, http.StatusBadRequest, errors.New("thiếu device"))
+		return
+	}
+	var signals []string
+	for _, sig := range strings.Split(q.Get("signal"), ",") {
+		if sig = strings.TrimSpace(sig); sig != "" {
+			signals = append(signals, sig)
+		}
+	}
+	if len(signals) == 0 || len(signals) > maxHistorySignals {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("số tín hiệu phải từ 1 đến %d", maxHistorySignals))
+		return
+	}
+
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		t, err := parseTime(v, "to")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := parseTime(v, "from")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, errors.New("from phải trước to"))
+		return
+	}
+
+	resolution := q.Get("resolution")
+	if resolution == "" || resolution == historian.ResolutionAuto {
+		resolution = historian.ChooseResolution(to.Sub(from))
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("format không hợp lệ %q", format))
+		return
+	}
+
+	resp := historyResponse{
+		Device:     device,
+		Resolution: resolution,
+		From:       from,
+		To:         to,
+		Signals:    make(map[string][]historian.Point, len(signals)),
+	}
+	for _, sig := range signals {
+		points, err := s.history.Query(device, sig, from, to, resolution)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		resp.Signals[sig] = points
+	}
+
+	if format == "csv" {
+		writeHistoryCSV(w, resp, signals)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleHistorySeries trả về danh sách tín hiệu đang được lưu lịch sử
+func (s *Server) handleHistorySeries(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoHistory)
+		return
+	}
+	series, err := s.history.Series()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, series)
+}
+
+// writeHistoryCSV ghi kết quả lịch sử dạng CSV, mỗi dòng một điểm của một tín hiệu
+func writeHistoryCSV(w http.ResponseWriter, resp historyResponse, signals []string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("%s_%s_%s.csv", resp.Device, resp.Resolution, resp.From.Format("20060102T150405"))))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "device", "signal", "min", "max", "avg", "last", "count"})
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, sig := range signals {
+		for _, p := range resp.Signals[sig] {
+			cw.Write([]string{
+				p.Time.Format(time.RFC3339), resp.Device, sig,
+				format(p.Min), format(p.Max), format(p.Avg), format(p.Last),
+				strconv.FormatInt(p.Count, 10),
+			})
+		}
+	}
+	cw.Flush()
+}
+
+// parseTime đọc thời gian theo RFC 3339 hoặc Unix giây
+func parseTime(s, name string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%s phải theo RFC 3339 hoặc Unix giây", name)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// errNoKPI lỗi khi gateway chưa bật tính chỉ số hiệu suất
+var errNoKPI = errors.New("chưa bật tính chỉ số hiệu suất")
+
+// handleKPI tính chỉ số hiệu suất của từng inverter và cả nhà máy:
+// /api/kpi?day=2024-06-01, mặc định hôm nay (tính tới hiện tại)
+func (s *Server) handleKPI(w http.ResponseWriter, r *http.Request) {
+	if s.kpi == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoKPI)
+		return
+	}
+	day := time.Now()
+	if v := r.URL.Query().Get("day"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("day phải có dạng YYYY-MM-DD"))
+			return
+		}
+		day = t
+	}
+	results, err := s.kpi.Day(day)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
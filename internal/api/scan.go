@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+)
+
+// Giới hạn quét để một yêu cầu không chiếm bus quá lâu
+const (
+	maxScanRegisters = 1000
+	maxScanSlaves    = 32
+)
+
+// scanBlock là kết quả đọc một khối thanh ghi khi quét
+type scanBlock struct {
+	Address   uint16   `json:"address"`
+	Registers []uint16 `json:"registers,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// scanSlave là kết quả thử đọc một Slave ID
+type scanSlave struct {
+	SlaveID   byte   `json:"slave_id"`
+	Responded bool   `json:"responded"`
+	Error     string `json:"error,omitempty"`
+}
+
+// read đọc thanh ghi theo loại holding/input
+func (s *Server) read(function string, slave byte, address, count uint16) ([]uint16, error) {
+	var data []byte
+	var err error
+	switch function {
+	case "", "holding":
+		data, err = s.bus.ReadHoldingRegisters(slave, address, count)
+	case "input":
+		data, err = s.bus.ReadInputRegisters(slave, address, count)
+	default:
+		return nil, fmt.Errorf("function không hợp lệ %q", function)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	regs := make([]uint16, len(data)/2)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	return regs, nil
+}
+
+// handleScanRegisters quét một vùng thanh ghi của thiết bị theo từng khối,
+// khối lỗi (thanh ghi không tồn tại) được ghi lại thay vì dừng quét:
+// /api/scan/registers?slave=1&address=0&count=200&block=10&function=holding
+func (s *Server) handleScanRegisters(w http.ResponseWriter, r *http.Request) {
+	if s.bus == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoBus)
+		return
+	}
+
+	q := r.URL.Query()
+	slave, err := parseUint(q.Get("slave"), 1, 247, "slave")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	address, err := parseUint(q.Get("address"), 0, 0xFFFF, "address")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	count, err := parseUint(q.Get("count"), 1, maxScanRegisters, "count")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	block := uint64(10)
+	if v := q.Get("block"); v != "" {
+		if block, err = parseUint(v, 1, maxRegisters, "block"); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if address+count > 0x10000 {
+		count = 0x10000 - address
+	}
+
+	function := q.Get("function")
+	blocks := make([]scanBlock, 0, (count+block-1)/block)
+	for addr := address; addr < address+count; addr += block {
+		n := min(block, address+count-addr)
+		regs, err := s.read(function, byte(slave), uint16(addr), uint16(n))
+		b := scanBlock{Address: uint16(addr), Registers: regs}
+		if err != nil {
+			b.Error = err.Error()
+		}
+		blocks = append(blocks, b)
+	}
+	writeJSON(w, http.StatusOK, blocks)
+}
+
+// handleScanSlaves thử đọc một thanh ghi của các Slave ID để tìm thiết bị trên bus:
+// /api/scan/slaves?from=1&to=10&address=0&function=holding
+func (s *Server) handleScanSlaves(w http.ResponseWriter, r *http.Request) {
+	if s.bus == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoBus)
+		return
+	}
+
+	q := r.URL.Query()
+	from, err := parseUint(q.Get("from"), 1, 247, "from")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseUint(q.Get("to"), from, min(247, from+maxScanSlaves-1), "to")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var address uint64
+	if v := q.Get("address"); v != "" {
+		if address, err = parseUint(v, 0, 0xFFFF, "address"); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	results := make([]scanSlave, 0, to-from+1)
+	for id := from; id <= to; id++ {
+		_, err := s.read(q.Get("function"), byte(id), uint16(address), 1)
+		res := scanSlave{SlaveID: byte(id), Responded: err == nil}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,382 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"modbus_inverter/internal/errcode"
+	"modbus_inverter/internal/store"
+)
+
+// maxRegisters số thanh ghi tối đa của một lệnh đọc/ghi qua API
+const maxRegisters = 125
+
+// errNoBus lỗi khi gateway không có bus RS-485 để đọc/ghi thanh ghi
+var errNoBus = errors.New("chưa có bus RS-485")
+
+// Server là HTTP API của gateway
+type Server struct {
+	cfg    Config
+	store  *store.Store
+	logger *log.Logger
+	mux    *http.ServeMux
+
+	devices []DeviceInfo
+	bus     RegisterBus
+	history History
+	alarms  Alarms
+	codes   ErrorCodes
+	checker Plausibility
+	kpi     KPI
+	config  interface{}
+
+	server   *http.Server
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewServer tạo HTTP API từ cấu hình
+func NewServer(cfg Config, st *store.Store, logger *log.Logger) (*Server, error) {
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":8080"
+	}
+	if cfg.Token == "" && (cfg.Username == "" || cfg.Password == "") {
+		return nil, errors.New("chưa cấu hình xác thực (username/password hoặc token)")
+	}
+
+	s := &Server{
+		cfg:    cfg,
+		store:  st,
+		logger: logger,
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /api/devices", s.handleDevices)
+	s.mux.HandleFunc("GET /api/devices/{name}", s.handleDevice)
+	s.mux.HandleFunc("GET /api/stats", s.handleStats)
+	s.mux.HandleFunc("GET /api/config", s.handleConfig)
+	s.mux.HandleFunc("GET /api/registers", s.handleReadRegisters)
+	s.mux.HandleFunc("POST /api/registers", s.handleWriteRegisters)
+	s.mux.HandleFunc("GET /api/scan/registers", s.handleScanRegisters)
+	s.mux.HandleFunc("GET /api/scan/slaves", s.handleScanSlaves)
+	s.mux.HandleFunc("GET /api/history", s.handleHistory)
+	s.mux.HandleFunc("GET /api/history/series", s.handleHistorySeries)
+	s.mux.HandleFunc("GET /api/alarms", s.handleAlarms)
+	s.mux.HandleFunc("GET /api/alarms/history", s.handleAlarmHistory)
+	s.mux.HandleFunc("POST /api/alarms/{id}/ack", s.handleAcknowledge)
+	s.mux.HandleFunc("GET /api/plausibility", s.handlePlausibility)
+	s.mux.HandleFunc("GET /api/kpi", s.handleKPI)
+	s.registerDashboard()
+	return s, nil
+}
+
+// SetDevices đặt danh sách thiết bị, gọi trước Start
+func (s *Server) SetDevices(devices []DeviceInfo) {
+	s.devices = devices
+}
+
+// SetBus đặt bus RS-485 dùng cho đọc/ghi thanh ghi tùy ý, gọi trước Start
+func (s *Server) SetBus(bus RegisterBus) {
+	s.bus = bus
+}
+
+// SetHistory đặt nguồn dữ liệu lịch sử, gọi trước Start
+func (s *Server) SetHistory(h History) {
+	s.history = h
+}
+
+// SetAlarms đặt hệ thống cảnh báo, gọi trước Start
+func (s *Server) SetAlarms(a Alarms) {
+	s.alarms = a
+}
+
+// SetErrorCodes đặt từ điển mã lỗi để giải nghĩa trong giá trị thiết bị, gọi trước Start
+func (s *Server) SetErrorCodes(c ErrorCodes) {
+	s.codes = c
+}
+
+// SetPlausibility đặt bộ kiểm tra tính hợp lý để xem các bất thường, gọi trước Start
+func (s *Server) SetPlausibility(p Plausibility) {
+	s.checker = p
+}
+
+// SetKPI đặt bộ tính chỉ số hiệu suất, gọi trước Start
+func (s *Server) SetKPI(k KPI) {
+	s.kpi = k
+}
+
+// SetConfig đặt cấu hình hiển thị tại /api/config (đã loại bỏ thông tin bí mật), gọi trước Start
+func (s *Server) SetConfig(v interface{}) {
+	s.config = v
+}
+
+// Handle đăng ký thêm handler, yêu cầu xác thực như các API khác; gọi trước Start
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// Start bắt đầu lắng nghe kết nối
+func (s *Server) Start() error {
+	l, err := net.Listen("tcp", s.cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("lắng nghe %s lỗi: %w", s.cfg.ListenAddr, err)
+	}
+	s.listener = l
+	s.server = &http.Server{
+		Handler:           s.authenticate(s.mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Printf("HTTP API: lỗi phục vụ: %v", err)
+		}
+	}()
+	s.logger.Printf("HTTP API: lắng nghe tại %s", l.Addr())
+	return nil
+}
+
+// Addr trả về địa chỉ đang lắng nghe
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close dừng server
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	s.wg.Wait()
+	return err
+}
+
+// authenticate kiểm tra xác thực Bearer hoặc Basic cho mọi yêu cầu
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="gateway"`)
+		writeError(w, http.StatusUnauthorized, errors.New("chưa xác thực"))
+	})
+}
+
+// authorized kiểm tra thông tin xác thực của yêu cầu
+func (s *Server) authorized(r *http.Request) bool {
+	if s.cfg.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			return equal(token, s.cfg.Token)
+		}
+	}
+	if s.cfg.Username != "" && s.cfg.Password != "" {
+		if user, pass, ok := r.BasicAuth(); ok {
+			return equal(user, s.cfg.Username) && equal(pass, s.cfg.Password)
+		}
+	}
+	return false
+}
+
+// equal so sánh chuỗi trong thời gian không đổi
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// deviceResponse là thông tin và giá trị mới nhất của một thiết bị
+type deviceResponse struct {
+	DeviceInfo
+	Quality   store.Quality        `json:"quality"`
+	Timestamp time.Time            `json:"timestamp"`
+	Values    map[string]float64   `json:"values,omitempty"`
+	Tags      map[string]store.Tag `json:"tags,omitempty"`   // Chất lượng và nhãn thời gian từng tín hiệu
+	Errors    []errcode.Decoded    `json:"errors,omitempty"` // Mã lỗi và bit trạng thái đã giải nghĩa
+	Stats     store.PollStats      `json:"stats"`
+}
+
+// device ghép thông tin cấu hình với giá trị mới nhất trong store
+func (s *Server) device(info DeviceInfo, withValues bool) deviceResponse {
+	resp := deviceResponse{DeviceInfo: info, Quality: store.QualityCommFailure}
+	if snap, ok := s.store.Get(info.Name); ok {
+		resp.Quality = snap.Quality
+		resp.Timestamp = snap.Timestamp
+		resp.Stats = snap.Stats
+		if withValues {
+			resp.Values = snap.Values
+			resp.Tags = snap.Tags
+			if s.codes != nil {
+				resp.Errors = s.codes.DecodeAll(info.Model, snap.Values)
+			}
+		}
+	}
+	return resp
+}
+
+// handleDevices trả về danh sách thiết bị và trạng thái
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	resp := make([]deviceResponse, 0, len(s.devices))
+	for _, info := range s.devices {
+		resp = append(resp, s.device(info, false))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleDevice trả về giá trị mới nhất của một thiết bị
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, info := range s.devices {
+		if info.Name == name {
+			writeJSON(w, http.StatusOK, s.device(info, true))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("không tìm thấy thiết bị %q", name))
+}
+
+// handleStats trả về thống kê đọc của từng thiết bị
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	resp := make(map[string]store.PollStats)
+	for _, info := range s.devices {
+		resp[info.Name] = s.device(info, false).Stats
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleConfig trả về cấu hình đang chạy
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.config)
+}
+
+// registersResponse là kết quả đọc thanh ghi
+type registersResponse struct {
+	SlaveID   byte     `json:"slave_id"`
+	Function  string   `json:"function"`
+	Address   uint16   `json:"address"`
+	Registers []uint16 `json:"registers"`
+}
+
+// handleReadRegisters đọc thanh ghi tùy ý:
+// /api/registers?slave=1&address=0&count=10&function=holding|input
+func (s *Server) handleReadRegisters(w http.ResponseWriter, r *http.Request) {
+	if s.bus == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoBus)
+		return
+	}
+
+	q := r.URL.Query()
+	slave, err := parseUint(q.Get("slave"), 1, 247, "slave")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	address, err := parseUint(q.Get("address"), 0, 0xFFFF, "address")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	count, err := parseUint(q.Get("count"), 1, maxRegisters, "count")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	function := q.Get("function")
+	if function == "" {
+		function = "holding"
+	}
+	if function != "holding" && function != "input" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("function không hợp lệ %q", function))
+		return
+	}
+
+	regs, err := s.read(function, byte(slave), uint16(address), uint16(count))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("đọc thanh ghi lỗi: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, registersResponse{
+		SlaveID:   byte(slave),
+		Function:  function,
+		Address:   uint16(address),
+		Registers: regs,
+	})
+}
+
+// writeRequest là yêu cầu ghi thanh ghi
+type writeRequest struct {
+	SlaveID byte     `json:"slave_id"`
+	Address uint16   `json:"address"`
+	Values  []uint16 `json:"values"`
+}
+
+// handleWriteRegisters ghi thanh ghi tùy ý khi chạy thử
+func (s *Server) handleWriteRegisters(w http.ResponseWriter, r *http.Request) {
+	if !s.cfg.AllowRegisterWrite {
+		writeError(w, http.StatusForbidden, errors.New("chưa bật cho phép ghi thanh ghi"))
+		return
+	}
+	if s.bus == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoBus)
+		return
+	}
+
+	var req writeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("nội dung yêu cầu không hợp lệ: %w", err))
+		return
+	}
+	if req.SlaveID == 0 || req.SlaveID > 247 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("slave_id không hợp lệ: %d", req.SlaveID))
+		return
+	}
+	if len(req.Values) == 0 || len(req.Values) > maxRegisters {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("số thanh ghi phải từ 1 đến %d", maxRegisters))
+		return
+	}
+
+	err := s.bus.WriteMultipleRegisters(req.SlaveID, req.Address, req.Values)
+	s.logger.Printf("HTTP API: ghi thanh ghi slave %d địa chỉ %d giá trị %v từ %s: %v", req.SlaveID, req.Address, req.Values, r.RemoteAddr, err)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("ghi thanh ghi lỗi: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+// parseUint đọc số nguyên không âm trong khoảng [min, max]
+func parseUint(s string, min, max uint64, name string) (uint64, error) {
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("%s phải là số từ %d đến %d", name, min, max)
+	}
+	return v, nil
+}
+
+// writeJSON ghi phản hồi JSON, mã hóa trước khi ghi mã trạng thái để trả về
+// lỗi 500 thay vì 200 với nội dung rỗng khi không mã hóa được
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		status = http.StatusInternalServerError
+		buf.Reset()
+		json.NewEncoder(&buf).Encode(map[string]string{"error": fmt.Sprintf("mã hóa JSON lỗi: %v", err)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// writeError ghi phản hồi lỗi dạng {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
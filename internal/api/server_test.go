@@ -0,0 +1,326 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/alarm"
+	"modbus_inverter/internal/errcode"
+	"modbus_inverter/internal/historian"
+	"modbus_inverter/internal/kpi"
+	"modbus_inverter/internal/plausibility"
+	"modbus_inverter/internal/store"
+)
+
+// fakeBus giả lập bus RS-485 với bảng thanh ghi trong bộ nhớ
+type fakeBus struct {
+	regs map[byte]map[uint16]uint16
+}
+
+func (b *fakeBus) read(slaveID byte, address, quantity uint16) ([]byte, error) {
+	dev, ok := b.regs[slaveID]
+	if !ok {
+		return nil, errors.New("timeout")
+	}
+	if address+quantity > 50 {
+		return nil, errors.New("illegal data address")
+	}
+	data := make([]byte, 2*quantity)
+	for i := uint16(0); i < quantity; i++ {
+		binary.BigEndian.PutUint16(data[2*i:], dev[address+i])
+	}
+	return data, nil
+}
+
+func (b *fakeBus) ReadHoldingRegisters(slaveID byte, address, quantity uint16) ([]byte, error) {
+	return b.read(slaveID, address, quantity)
+}
+
+func (b *fakeBus) ReadInputRegisters(slaveID byte, address, quantity uint16) ([]byte, error) {
+	return b.read(slaveID, address, quantity)
+}
+
+func (b *fakeBus) WriteMultipleRegisters(slaveID byte, address uint16, values []uint16) error {
+	dev, ok := b.regs[slaveID]
+	if !ok {
+		return errors.New("timeout")
+	}
+	for i, v := range values {
+		dev[address+uint16(i)] = v
+	}
+	return nil
+}
+
+// fakeHistory trả về một điểm mỗi giờ trong khoảng truy vấn
+type fakeHistory struct{}
+
+func (fakeHistory) Query(device, signal string, from, to time.Time, resolution string) ([]historian.Point, error) {
+	if resolution != historian.Resolution1h {
+		return nil, errors.New("độ phân giải không hợp lệ")
+	}
+	var points []historian.Point
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		points = append(points, historian.Point{Time: t, Min: 1, Max: 3, Avg: 2, Last: 3, Count: 60})
+	}
+	return points, nil
+}
+
+func (fakeHistory) Series() ([]historian.Series, error) {
+	return []historian.Series{{Device: "inverter1", Signal: "active_power"}}, nil
+}
+
+// fakeKPI trả về chỉ số cố định của ngày được hỏi
+type fakeKPI struct{}
+
+func (fakeKPI) Day(day time.Time) ([]kpi.Result, error) {
+	pr := 82.5
+	return []kpi.Result{{Device: "plant", Day: day, Energy: 120, SpecificYield: 4, PerformanceRatio: &pr}}, nil
+}
+
+// client gửi yêu cầu tới API với thông tin xác thực cho trước
+type client struct {
+	t     *testing.T
+	base  string
+	token string
+}
+
+func (c *client) do(method, path string, body interface{}, out interface{}) int {
+	c.t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(c.t, err)
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, r)
+	require.NoError(c.t, err)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(c.t, err)
+	defer resp.Body.Close()
+	if out != nil {
+		require.NoError(c.t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+// TestServer kiểm tra các API chính
+func TestServer(t *testing.T) {
+	st := store.New()
+	ts := time.Now().Truncate(time.Second)
+	st.Update("inverter1", map[string]float64{"active_power": 4.5, "error_code": 4}, ts)
+	st.RecordPoll("inverter1", 50*time.Millisecond, nil)
+
+	bus := &fakeBus{regs: map[byte]map[uint16]uint16{1: {0: 1, 1: 2}}}
+	srv, err := NewServer(Config{ListenAddr: "127.0.0.1:0", Token: "secret", AllowRegisterWrite: true}, st, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	srv.SetDevices([]DeviceInfo{
+		{Name: "inverter1", Type: "inverter", Model: "generic", SlaveID: 1},
+		{Name: "meter1", Type: "pm2120", SlaveID: 10},
+	})
+	srv.SetBus(bus)
+	srv.SetConfig(map[string]string{"site": "test"})
+	srv.SetHistory(fakeHistory{})
+	srv.SetErrorCodes(errcode.Builtin())
+	alarms, err := alarm.NewEngine(alarm.Config{Rules: []alarm.Rule{
+		{Name: "temp_high", Signal: "temperature", Type: alarm.RuleHigh, Limit: 70},
+	}}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	alarms.Update("inverter1", map[string]float64{"temperature": 80}, ts)
+	srv.SetAlarms(alarms)
+	checker, err := plausibility.NewChecker(plausibility.Config{}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	checker.Check("inverter1", store.Tags(map[string]float64{"voltage": 3.4e38}, ts))
+	srv.SetPlausibility(checker)
+	srv.SetKPI(fakeKPI{})
+	require.NoError(t, srv.Start())
+	defer srv.Close()
+
+	c := &client{t: t, base: "http://" + srv.Addr().String(), token: "secret"}
+
+	t.Run("Xác thực", func(t *testing.T) {
+		anon := &client{t: t, base: c.base}
+		assert.Equal(t, http.StatusUnauthorized, anon.do("GET", "/api/devices", nil, nil))
+		wrong := &client{t: t, base: c.base, token: "wrong"}
+		assert.Equal(t, http.StatusUnauthorized, wrong.do("GET", "/api/devices", nil, nil))
+	})
+
+	t.Run("Danh sách thiết bị", func(t *testing.T) {
+		var devices []deviceResponse
+		require.Equal(t, http.StatusOK, c.do("GET", "/api/devices", nil, &devices))
+		require.Len(t, devices, 2)
+		assert.Equal(t, store.QualityGood, devices[0].Quality)
+		assert.True(t, ts.Equal(devices[0].Timestamp))
+		assert.Equal(t, store.QualityCommFailure, devices[1].Quality, "Thiết bị chưa đọc được")
+	})
+
+	t.Run("Giá trị thiết bị", func(t *testing.T) {
+		var dev deviceResponse
+		require.Equal(t, http.StatusOK, c.do("GET", "/api/devices/inverter1", nil, &dev))
+		assert.Equal(t, 4.5, dev.Values["active_power"])
+		assert.Equal(t, store.QualityGood, dev.Tags["active_power"].Quality)
+		assert.True(t, ts.Equal(dev.Tags["active_power"].Source))
+		assert.Equal(t, uint64(1), dev.Stats.Polls)
+		require.Len(t, dev.Errors, 1, "Mã lỗi được giải nghĩa theo model")
+		assert.Equal(t, "error_code", dev.Errors[0].Signal)
+		assert.Equal(t, errcode.SeverityCritical, dev.Errors[0].Severity)
+
+		assert.Equal(t, http.StatusNotFound, c.do("GET", "/api/devices/unknown", nil, nil))
+	})
+
+	t.Run("Đọc ghi thanh ghi", func(t *testing.T) {
+		status := c.do("POST", "/api/registers", writeRequest{SlaveID: 1, Address: 1, Values: []uint16{7, 8}}, nil)
+		require.Equal(t, http.StatusOK, status)
+
+		var regs registersResponse
+		require.Equal(t, http.StatusOK, c.do("GET", "/api/registers?slave=1&address=0&count=3", nil, &regs))
+		assert.Equal(t, []uint16{1, 7, 8}, regs.Registers)
+
+		assert.Equal(t, http.StatusBadRequest, c.do("GET", "/api/registers?slave=1&address=0&count=200", nil, nil))
+		assert.Equal(t, http.StatusBadGateway, c.do("GET", "/api/registers?slave=5&address=0&count=1", nil, nil))
+	})
+
+	t.Run("Lịch sử", func(t *testing.T) {
+		var resp historyResponse
+		path := "/api/history?device=inverter1&signal=active_power,voltage&from=2024-06-01T00:00:00Z&to=2024-06-01T06:00:00Z&resolution=1h"
+		require.Equal(t, http.StatusOK, c.do("GET", path, nil, &resp))
+		assert.Len(t, resp.Signals["active_power"], 6)
+		assert.Len(t, resp.Signals["voltage"], 6)
+
+		req, err := http.NewRequest("GET", c.base+path+"&format=csv", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret")
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		require.Len(t, lines, 13)
+		assert.Equal(t, "2024-06-01T00:00:00Z,inverter1,active_power,1,3,2,3,60", lines[1])
+
+		assert.Equal(t, http.StatusBadRequest, c.do("GET", "/api/history?device=inverter1&signal=active_power&resolution=raw", nil, nil))
+		assert.Equal(t, http.StatusBadRequest, c.do("GET", "/api/history?device=inverter1", nil, nil))
+
+		var series []historian.Series
+		require.Equal(t, http.StatusOK, c.do("GET", "/api/history/series", nil, &series))
+		assert.Len(t, series, 1)
+	})
+
+	t.Run("Kiểm tra hợp lý", func(t *testing.T) {
+		var findings []plausibility.Finding
+		require.Equal(t, http.StatusOK, c.do("GET", "/api/plausibility", nil, &findings))
+		require.Len(t, findings, 1)
+		assert.Equal(t, []string{"voltage"}, findings[0].Signals)
+	})
+
+	t.Run("Chỉ số hiệu suất", func(t *testing.T) {
+		var results []kpi.Result
+		require.Equal(t, http.StatusOK, c.do("GET", "/api/kpi?day=2024-06-01", nil, &results))
+		require.Len(t, results, 1)
+		assert.Equal(t, "2024-06-01", results[0].Day.Format("2006-01-02"))
+		require.NotNil(t, results[0].PerformanceRatio)
+		assert.Equal(t, 82.5, *results[0].PerformanceRatio)
+		assert.Nil(t, results[0].Availability)
+		assert.Equal(t, http.StatusBadRequest, c.do("GET", "/api/kpi?day=01/06/2024", nil, nil))
+	})
+
+	t.Run("Cảnh báo", func(t *testing.T) {
+		var active []alarm.Alarm
+		require.Equal(t, http.StatusOK, c.do("GET", "/api/alarms", nil, &active))
+		require.Len(t, active, 1)
+		assert.Equal(t, alarm.StateActive, active[0].State)
+
+		assert.Equal(t, http.StatusNoContent, c.do("POST", "/api/alarms/temp_high:inverter1/ack", nil, nil))
+		assert.Equal(t, http.StatusNotFound, c.do("POST", "/api/alarms/unknown/ack", nil, nil))
+		require.Equal(t, http.StatusOK, c.do("GET", "/api/alarms", nil, &active))
+		assert.Equal(t, alarm.StateAcknowledged, active[0].State)
+		assert.Equal(t, "token", active[0].AcknowledgedBy)
+
+		var history []alarm.Alarm
+		require.Equal(t, http.StatusOK, c.do("GET", "/api/alarms/history?limit=10", nil, &history))
+		assert.Empty(t, history)
+	})
+}
+
+// TestDashboard kiểm tra giao diện web và các công cụ quét
+func TestDashboard(t *testing.T) {
+	bus := &fakeBus{regs: map[byte]map[uint16]uint16{1: {0: 1}, 3: {}}}
+	srv, err := NewServer(Config{ListenAddr: "127.0.0.1:0", Username: "admin", Password: "pw"}, store.New(), log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	srv.SetBus(bus)
+	require.NoError(t, srv.Start())
+	defer srv.Close()
+	base := "http://" + srv.Addr().String()
+
+	get := func(path string, out interface{}) *http.Response {
+		req, err := http.NewRequest("GET", base+path, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "pw")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		if out != nil {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+		}
+		return resp
+	}
+
+	t.Run("Trang web", func(t *testing.T) {
+		resp := get("/", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+
+		resp = get("/static/app.js", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Quét thanh ghi", func(t *testing.T) {
+		var blocks []scanBlock
+		get("/api/scan/registers?slave=1&address=30&count=30&block=10", &blocks)
+		require.Len(t, blocks, 3)
+		assert.Empty(t, blocks[0].Error)
+		assert.Len(t, blocks[1].Registers, 10)
+		assert.NotEmpty(t, blocks[2].Error, "Khối vượt vùng thanh ghi báo lỗi nhưng không dừng quét")
+	})
+
+	t.Run("Tìm thiết bị", func(t *testing.T) {
+		var slaves []scanSlave
+		get("/api/scan/slaves?from=1&to=4", &slaves)
+		require.Len(t, slaves, 4)
+		assert.True(t, slaves[0].Responded)
+		assert.False(t, slaves[1].Responded)
+		assert.True(t, slaves[2].Responded)
+
+		resp := get("/api/scan/slaves?from=1&to=100", nil)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Giới hạn số Slave ID mỗi lần quét")
+	})
+}
+
+// TestNewServer kiểm tra bắt buộc cấu hình xác thực
+func TestNewServer(t *testing.T) {
+	_, err := NewServer(Config{}, store.New(), log.New(io.Discard, "", 0))
+	assert.Error(t, err)
+}
+
+// TestWriteJSON kiểm tra trả về lỗi 500 khi không mã hóa được JSON
+func TestWriteJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, map[string]float64{"active_power": math.NaN()})
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "error")
+}
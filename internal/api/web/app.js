@@ -0,0 +1,185 @@
+// Giao diện chạy thử gateway: hiển thị giá trị, tình trạng bus, cảnh báo và công cụ đọc thanh ghi
+"use strict";
+
+const REFRESH_MS = 2000;
+
+async function api(path, options) {
+  const resp = await fetch(path, options);
+  const body = await resp.json().catch(() => ({}));
+  if (!resp.ok) {
+    throw new Error(body.error || resp.statusText);
+  }
+  return body;
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  Object.assign(e, attrs || {});
+  for (const c of children) {
+    e.append(c instanceof Node ? c : String(c));
+  }
+  return e;
+}
+
+const qualities = ["Tốt", "Mất liên lạc", "Cũ", "Ngoài dải", "Thay thế"];
+
+function quality(q) {
+  return el("span", { className: q === 0 ? "good" : "bad" }, qualities[q] || "Lỗi");
+}
+
+function time(ts) {
+  const d = new Date(ts);
+  return d.getFullYear() > 1 ? d.toLocaleString("vi-VN") : "-";
+}
+
+function ms(ns) {
+  return (ns / 1e6).toFixed(0) + " ms";
+}
+
+// --- Giá trị hiện tại ---
+
+async function refreshValues() {
+  const devices = await api("/api/devices");
+  const details = await Promise.all(devices.map((d) => api("/api/devices/" + encodeURIComponent(d.name))));
+  const container = document.getElementById("devices");
+  container.replaceChildren(...details.map((d) => {
+    const rows = Object.keys(d.values || {}).sort().map((k) => {
+      const q = d.tags && d.tags[k] ? d.tags[k].quality : 0;
+      return el("tr", null, el("td", null, k), el("td", { className: "num" }, d.values[k].toFixed(3)),
+        el("td", null, q ? quality(q) : ""));
+    });
+    return el("div", { className: "card" },
+      el("h3", null, d.name),
+      el("div", { className: "meta" }, d.type === "virtual" ? "Thiết bị ảo · " : `${d.type} · Slave ${d.slave_id} · `,
+        quality(d.quality), ` · ${time(d.timestamp)}`),
+      el("table", null, el("tbody", null, ...rows)));
+  }));
+  return devices;
+}
+
+// --- Tình trạng bus ---
+
+function refreshBus(devices) {
+  document.getElementById("bus-table").replaceChildren(...devices.map((d) => {
+    const s = d.stats;
+    const rate = s.polls ? (100 * s.errors / s.polls).toFixed(1) + " %" : "-";
+    return el("tr", null,
+      el("td", null, d.name),
+      el("td", { className: "num" }, d.slave_id),
+      el("td", null, quality(d.quality)),
+      el("td", { className: "num" }, s.polls),
+      el("td", { className: "num" }, s.errors),
+      el("td", { className: "num" }, rate),
+      el("td", { className: "num" }, ms(s.avg_duration)),
+      el("td", { className: "error" }, s.last_error ? `${s.last_error} (${time(s.last_error_at)})` : ""));
+  }));
+}
+
+// --- Cảnh báo ---
+
+async function refreshAlarms() {
+  const container = document.getElementById("alarm-list");
+  let alarms;
+  try {
+    alarms = await api("/api/alarms");
+  } catch (err) {
+    container.replaceChildren(el("p", null, "Chưa bật cảnh báo trên gateway."));
+    return;
+  }
+  if (!alarms.length) {
+    container.replaceChildren(el("p", null, "Không có cảnh báo."));
+    return;
+  }
+  const states = { active: "Đang xảy ra", acknowledged: "Đã xác nhận", cleared: "Đã hết, chưa xác nhận" };
+  container.replaceChildren(el("table", null,
+    el("thead", null, el("tr", null, ...["Thời điểm", "Thiết bị", "Mức độ", "Nội dung", "Trạng thái", ""].map((k) => el("th", null, k)))),
+    el("tbody", null, ...alarms.map((a) => el("tr", { className: a.severity },
+      el("td", null, time(a.raised_at)),
+      el("td", null, a.device),
+      el("td", null, a.severity),
+      el("td", null, a.message),
+      el("td", null, states[a.state] || a.state),
+      el("td", null, a.state === "acknowledged" ? "" : el("button", { onclick: () => acknowledge(a.id) }, "Xác nhận")))))));
+}
+
+async function acknowledge(id) {
+  try {
+    await api("/api/alarms/" + encodeURIComponent(id) + "/ack", { method: "POST" });
+  } catch (err) {
+    alert("Xác nhận lỗi: " + err.message);
+  }
+  await refreshAlarms();
+}
+
+async function refresh() {
+  try {
+    const devices = await refreshValues();
+    refreshBus(devices);
+    await refreshAlarms();
+    document.getElementById("updated").textContent = "Cập nhật " + new Date().toLocaleTimeString("vi-VN");
+  } catch (err) {
+    document.getElementById("updated").textContent = "Lỗi: " + err.message;
+  }
+}
+
+// --- Công cụ ---
+
+function registerTable(address, registers) {
+  return el("table", null,
+    el("thead", null, el("tr", null, el("th", null, "Địa chỉ"), el("th", null, "Dec"), el("th", null, "Hex"), el("th", null, "Int16"))),
+    el("tbody", null, ...registers.map((v, i) => el("tr", null,
+      el("td", { className: "num" }, address + i),
+      el("td", { className: "num" }, v),
+      el("td", { className: "num" }, "0x" + v.toString(16).padStart(4, "0")),
+      el("td", { className: "num" }, v > 0x7fff ? v - 0x10000 : v)))));
+}
+
+function bindForm(id, resultId, run) {
+  document.getElementById(id).addEventListener("submit", async (ev) => {
+    ev.preventDefault();
+    const result = document.getElementById(resultId);
+    const params = new URLSearchParams(new FormData(ev.target));
+    result.replaceChildren(el("p", null, "Đang chạy..."));
+    try {
+      result.replaceChildren(await run(params));
+    } catch (err) {
+      result.replaceChildren(el("p", { className: "error" }, err.message));
+    }
+  });
+}
+
+bindForm("read-form", "read-result", async (params) => {
+  const r = await api("/api/registers?" + params);
+  return registerTable(r.address, r.registers);
+});
+
+bindForm("scan-form", "scan-result", async (params) => {
+  const blocks = await api("/api/scan/registers?" + params);
+  return el("div", null, ...blocks.map((b) => b.error
+    ? el("p", { className: "error" }, `${b.address}: ${b.error}`)
+    : registerTable(b.address, b.registers)));
+});
+
+bindForm("slaves-form", "slaves-result", async (params) => {
+  const slaves = await api("/api/scan/slaves?" + params);
+  return el("table", null, el("tbody", null, ...slaves.map((s) => el("tr", null,
+    el("td", { className: "num" }, s.slave_id),
+    el("td", null, s.responded ? el("span", { className: "good" }, "Có phản hồi") : el("span", { className: "bad" }, s.error))))));
+});
+
+// --- Điều hướng ---
+
+function show() {
+  const id = (location.hash || "#values").slice(1);
+  for (const section of document.querySelectorAll("main section")) {
+    section.hidden = section.id !== id;
+  }
+  for (const a of document.querySelectorAll("nav a")) {
+    a.classList.toggle("active", a.getAttribute("href") === "#" + id);
+  }
+}
+
+window.addEventListener("hashchange", show);
+show();
+refresh();
+setInterval(refresh, REFRESH_MS);
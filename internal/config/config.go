@@ -0,0 +1,54 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Duration là time.Duration đọc/ghi JSON dưới dạng chuỗi, ví dụ "5s", "1m30s"
+type Duration time.Duration
+
+// Std trả về giá trị time.Duration tương ứng
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// MarshalJSON ghi Duration thành chuỗi
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON đọc Duration từ chuỗi ("5s") hoặc số giây
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case float64:
+		*d = Duration(val * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("thời gian không hợp lệ %q: %w", val, err)
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("thời gian không hợp lệ: %s", string(b))
+	}
+	return nil
+}
+
+// Load đọc file cấu hình JSON vào v
+func Load(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("đọc file cấu hình %s lỗi: %w", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("giải mã file cấu hình %s lỗi: %w", path, err)
+	}
+	return nil
+}
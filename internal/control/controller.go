@@ -0,0 +1,282 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"modbus_inverter/internal/config"
+)
+
+// maxHistory số kết quả lệnh gần nhất được lưu lại
+const maxHistory = 100
+
+// ErrVerifyFailed lỗi khi giá trị đọc lại không khớp giá trị đã ghi
+var ErrVerifyFailed = errors.New("giá trị đọc lại không khớp giá trị đã ghi")
+
+// RegisterWriter là giao diện ghi/đọc thanh ghi của một inverter
+type RegisterWriter interface {
+	ReadHoldingRegisters(address uint16, quantity uint16) ([]byte, error)
+	WriteSingleRegister(address uint16, value uint16) error
+	WriteMultipleRegisters(address uint16, values []uint16) error
+}
+
+// Config cấu hình hệ thống điều khiển
+type Config struct {
+	Enabled       bool                   `json:"enabled"`
+	ReadBackDelay config.Duration        `json:"read_back_delay"` // Thời gian chờ trước khi đọc lại để xác nhận
+	RegisterMaps  map[string]RegisterMap `json:"register_maps"`   // Bảng thanh ghi bổ sung hoặc thay thế bảng có sẵn
+}
+
+// Command là một lệnh điều khiển gửi tới inverter
+type Command struct {
+	Device string      `json:"device"`
+	Type   CommandType `json:"type"`
+	Value  float64     `json:"value"`
+	Source string      `json:"source"` // Nguồn lệnh, ví dụ "iec104"
+}
+
+// Result là kết quả thực hiện một lệnh
+type Result struct {
+	Command  Command   `json:"command"`
+	Time     time.Time `json:"time"`
+	Written  []uint16  `json:"written,omitempty"`   // Giá trị thô đã ghi
+	ReadBack float64   `json:"read_back,omitempty"` // Giá trị kỹ thuật đọc lại
+	Verified bool      `json:"verified"`
+	Error    string    `json:"error,omitempty"`
+	Err      error     `json:"-"`
+}
+
+// device là một inverter chịu điều khiển
+type device struct {
+	writer     RegisterWriter
+	regs       RegisterMap
+	ratedPower float64 // kW
+}
+
+// Controller thực hiện lệnh điều khiển công suất tới các inverter
+type Controller struct {
+	cfg    Config
+	logger *log.Logger
+	maps   map[string]RegisterMap
+
+	execMu sync.Mutex // Tuần tự hóa các lệnh, giữ trong lúc ghi và đọc lại thanh ghi
+
+	mu      sync.Mutex // Bảo vệ devices và history, không giữ khi truy cập bus
+	devices map[string]*device
+	history []Result
+}
+
+// NewController tạo bộ điều khiển mới
+func NewController(cfg Config, logger *log.Logger) *Controller {
+	maps := BuiltinRegisterMaps()
+	for model, m := range cfg.RegisterMaps {
+		m.Model = model
+		maps[model] = m
+	}
+	return &Controller{
+		cfg:     cfg,
+		logger:  logger,
+		maps:    maps,
+		devices: make(map[string]*device),
+	}
+}
+
+// AddDevice đăng ký một inverter với model và công suất định mức (kW)
+func (c *Controller) AddDevice(name string, writer RegisterWriter, model string, ratedPower float64) error {
+	regs, ok := c.maps[model]
+	if !ok {
+		return fmt.Errorf("không có bảng thanh ghi điều khiển cho model %q", model)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.devices[name] = &device{writer: writer, regs: regs, ratedPower: ratedPower}
+	return nil
+}
+
+// RatedPower trả về công suất định mức (kW) của thiết bị, 0 nếu chưa đăng ký
+func (c *Controller) RatedPower(name string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if dev, ok := c.devices[name]; ok {
+		return dev.ratedPower
+	}
+	return 0
+}
+
+// Execute thực hiện lệnh: ghi thanh ghi, đọc lại để xác nhận và ghi nhật ký kết quả
+func (c *Controller) Execute(cmd Command) Result {
+	c.execMu.Lock()
+	defer c.execMu.Unlock()
+
+	c.mu.Lock()
+	dev, ok := c.devices[cmd.Device]
+	c.mu.Unlock()
+
+	res := Result{Command: cmd, Time: time.Now()}
+	if ok {
+		res.Err = c.execute(dev, cmd, &res)
+	} else {
+		res.Err = fmt.Errorf("không tìm thấy thiết bị %q", cmd.Device)
+	}
+	if res.Err != nil {
+		res.Error = res.Err.Error()
+		c.logger.Printf("Lệnh %s=%.3f tới %s (nguồn %s) thất bại: %v", cmd.Type, cmd.Value, cmd.Device, cmd.Source, res.Err)
+	} else {
+		c.logger.Printf("Lệnh %s=%.3f tới %s (nguồn %s) thành công, đọc lại %.3f", cmd.Type, cmd.Value, cmd.Device, cmd.Source, res.ReadBack)
+	}
+
+	c.mu.Lock()
+	c.history = append(c.history, res)
+	if len(c.history) > maxHistory {
+		c.history = c.history[len(c.history)-maxHistory:]
+	}
+	c.mu.Unlock()
+	return res
+}
+
+// History trả về các kết quả lệnh gần nhất, cũ nhất trước
+func (c *Controller) History() []Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Result(nil), c.history...)
+}
+
+// execute ghi và đọc lại thanh ghi của lệnh, gọi khi đang giữ execMu
+func (c *Controller) execute(dev *device, cmd Command, res *Result) error {
+	if math.IsNaN(cmd.Value) || math.IsInf(cmd.Value, 0) {
+		return fmt.Errorf("giá trị lệnh không hợp lệ: %v", cmd.Value)
+	}
+
+	typ, value, err := dev.resolve(cmd.Type, cmd.Value)
+	if err != nil {
+		return err
+	}
+	reg := dev.regs.Registers[typ]
+	if reg.Enable != nil {
+		if err := enable(dev.writer, *reg.Enable); err != nil {
+			return err
+		}
+	}
+
+	// Mã hóa giá trị
+	var words []uint16
+	if typ == CmdOnOff {
+		if value != 0 {
+			words = []uint16{reg.OnValue}
+		} else {
+			words = []uint16{reg.OffValue}
+		}
+	} else {
+		words, err = reg.encode(value)
+		if err != nil {
+			return err
+		}
+	}
+	res.Written = words
+
+	// Ghi thanh ghi
+	if len(words) == 1 {
+		err = dev.writer.WriteSingleRegister(reg.Address, words[0])
+	} else {
+		err = dev.writer.WriteMultipleRegisters(reg.Address, words)
+	}
+	if err != nil {
+		return fmt.Errorf("ghi thanh ghi %d lỗi: %w", reg.Address, err)
+	}
+
+	// Đọc lại để xác nhận
+	if c.cfg.ReadBackDelay > 0 {
+		time.Sleep(c.cfg.ReadBackDelay.Std())
+	}
+	data, err := dev.writer.ReadHoldingRegisters(reg.Address, uint16(len(words)))
+	if err != nil {
+		return fmt.Errorf("đọc lại thanh ghi %d lỗi: %w", reg.Address, err)
+	}
+
+	if typ == CmdOnOff {
+		if len(data) != 2 {
+			return fmt.Errorf("độ dài dữ liệu đọc lại không đúng (%d bytes, cần 2)", len(data))
+		}
+		got := uint16(data[0])<<8 | uint16(data[1])
+		res.ReadBack = float64(got)
+		if got != words[0] {
+			return ErrVerifyFailed
+		}
+	} else {
+		res.ReadBack, err = reg.decode(data)
+		if err != nil {
+			return err
+		}
+		// Cho phép sai lệch một bước lượng tử
+		if math.Abs(res.ReadBack-value) > 1/reg.scale()+1e-9 {
+			return ErrVerifyFailed
+		}
+	}
+
+	res.Verified = true
+	return nil
+}
+
+// enable đặt thanh ghi chế độ trước khi ghi lệnh, bỏ qua nếu đã đúng giá trị
+func enable(writer RegisterWriter, e Enable) error {
+	words := e.words()
+	data, err := writer.ReadHoldingRegisters(e.Address, uint16(len(words)))
+	if err != nil {
+		return fmt.Errorf("đọc thanh ghi chế độ %d lỗi: %w", e.Address, err)
+	}
+	if slices.Equal(decodeWords(data), words) {
+		return nil
+	}
+
+	if len(words) == 1 {
+		err = writer.WriteSingleRegister(e.Address, words[0])
+	} else {
+		err = writer.WriteMultipleRegisters(e.Address, words)
+	}
+	if err != nil {
+		return fmt.Errorf("ghi thanh ghi chế độ %d lỗi: %w", e.Address, err)
+	}
+	data, err = writer.ReadHoldingRegisters(e.Address, uint16(len(words)))
+	if err != nil {
+		return fmt.Errorf("đọc lại thanh ghi chế độ %d lỗi: %w", e.Address, err)
+	}
+	if !slices.Equal(decodeWords(data), words) {
+		return fmt.Errorf("thanh ghi chế độ %d không nhận giá trị %d", e.Address, e.Value)
+	}
+	return nil
+}
+
+// decodeWords chuyển dữ liệu đọc được thành các word
+func decodeWords(data []byte) []uint16 {
+	words := make([]uint16, len(data)/2)
+	for i := range words {
+		words[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+	}
+	return words
+}
+
+// resolve chọn thanh ghi cho lệnh; nếu model chỉ hỗ trợ giới hạn công suất theo
+// một đơn vị (% hoặc kW) thì quy đổi theo công suất định mức
+func (d *device) resolve(typ CommandType, value float64) (CommandType, float64, error) {
+	if _, ok := d.regs.Registers[typ]; ok {
+		return typ, value, nil
+	}
+
+	switch typ {
+	case CmdActivePowerKW:
+		if _, ok := d.regs.Registers[CmdActivePowerPercent]; ok && d.ratedPower > 0 {
+			return CmdActivePowerPercent, value / d.ratedPower * 100, nil
+		}
+	case CmdActivePowerPercent:
+		if _, ok := d.regs.Registers[CmdActivePowerKW]; ok && d.ratedPower > 0 {
+			return CmdActivePowerKW, value / 100 * d.ratedPower, nil
+		}
+	}
+	return "", 0, fmt.Errorf("model %q không hỗ trợ lệnh %s", d.regs.Model, typ)
+}
@@ -0,0 +1,157 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInverter giả lập bộ thanh ghi giữ của inverter
+type fakeInverter struct {
+	regs   map[uint16]uint16
+	clamp  map[uint16]uint16 // Giới hạn giá trị thiết bị chấp nhận (giả lập inverter tự kẹp giá trị)
+	writes map[uint16]int    // Số lần ghi theo địa chỉ bắt đầu
+}
+
+func newFakeInverter() *fakeInverter {
+	return &fakeInverter{regs: map[uint16]uint16{}, clamp: map[uint16]uint16{}, writes: map[uint16]int{}}
+}
+
+func (f *fakeInverter) ReadHoldingRegisters(address uint16, quantity uint16) ([]byte, error) {
+	data := make([]byte, 0, quantity*2)
+	for i := uint16(0); i < quantity; i++ {
+		v := f.regs[address+i]
+		data = append(data, byte(v>>8), byte(v))
+	}
+	return data, nil
+}
+
+func (f *fakeInverter) WriteSingleRegister(address uint16, value uint16) error {
+	return f.WriteMultipleRegisters(address, []uint16{value})
+}
+
+func (f *fakeInverter) WriteMultipleRegisters(address uint16, values []uint16) error {
+	f.writes[address]++
+	for i, v := range values {
+		if limit, ok := f.clamp[address+uint16(i)]; ok && v > limit {
+			v = limit
+		}
+		f.regs[address+uint16(i)] = v
+	}
+	return nil
+}
+
+// TestController kiểm tra ghi lệnh, quy đổi đơn vị và xác nhận đọc lại
+func TestController(t *testing.T) {
+	inv := newFakeInverter()
+	ctrl := NewController(Config{
+		RegisterMaps: map[string]RegisterMap{
+			// Model chỉ hỗ trợ giới hạn công suất theo %
+			"percent_only": {Registers: map[CommandType]WriteRegister{
+				CmdActivePowerPercent: {Address: 10, Scale: 10, Min: 0, Max: 100},
+				CmdReactivePower:      {Address: 11, Size: 2, Scale: 1000, Signed: true},
+			}},
+		},
+	}, log.New(io.Discard, "", 0))
+	require.NoError(t, ctrl.AddDevice("inv1", inv, "percent_only", 50))
+	require.NoError(t, ctrl.AddDevice("inv2", newFakeInverter(), "generic", 20))
+
+	t.Run("Giới hạn công suất theo %", func(t *testing.T) {
+		res := ctrl.Execute(Command{Device: "inv1", Type: CmdActivePowerPercent, Value: 60})
+		require.NoError(t, res.Err)
+		assert.True(t, res.Verified)
+		assert.Equal(t, uint16(600), inv.regs[10])
+	})
+
+	t.Run("Quy đổi kW sang %", func(t *testing.T) {
+		res := ctrl.Execute(Command{Device: "inv1", Type: CmdActivePowerKW, Value: 25})
+		require.NoError(t, res.Err)
+		assert.Equal(t, uint16(500), inv.regs[10], "25 kW trên 50 kW định mức = 50%")
+	})
+
+	t.Run("Giá trị âm 32 bit", func(t *testing.T) {
+		res := ctrl.Execute(Command{Device: "inv1", Type: CmdReactivePower, Value: -12.5})
+		require.NoError(t, res.Err)
+		assert.InDelta(t, -12.5, res.ReadBack, 1e-9)
+		assert.Equal(t, []uint16{0xFFFF, uint16(0x10000 - 12500)}, res.Written)
+	})
+
+	t.Run("Ngoài giới hạn", func(t *testing.T) {
+		res := ctrl.Execute(Command{Device: "inv1", Type: CmdActivePowerPercent, Value: 120})
+		assert.Error(t, res.Err)
+		assert.False(t, res.Verified)
+	})
+
+	t.Run("Đọc lại không khớp", func(t *testing.T) {
+		inv.clamp[10] = 800
+		defer delete(inv.clamp, 10)
+
+		res := ctrl.Execute(Command{Device: "inv1", Type: CmdActivePowerPercent, Value: 95})
+		assert.ErrorIs(t, res.Err, ErrVerifyFailed)
+		assert.InDelta(t, 80, res.ReadBack, 1e-9)
+	})
+
+	t.Run("Lệnh không hỗ trợ", func(t *testing.T) {
+		res := ctrl.Execute(Command{Device: "inv1", Type: CmdOnOff, Value: 1})
+		assert.Error(t, res.Err)
+	})
+
+	t.Run("Bật tắt inverter", func(t *testing.T) {
+		res := ctrl.Execute(Command{Device: "inv2", Type: CmdOnOff, Value: 0})
+		require.NoError(t, res.Err)
+		assert.True(t, res.Verified)
+	})
+
+	t.Run("Lịch sử lệnh", func(t *testing.T) {
+		history := ctrl.History()
+		require.Len(t, history, 7)
+		assert.Equal(t, CmdOnOff, history[len(history)-1].Command.Type)
+		assert.Equal(t, fmt.Sprint(ErrVerifyFailed), history[4].Error)
+	})
+}
+
+// TestControllerEnable kiểm tra đặt thanh ghi chế độ trước khi ghi giới hạn công suất
+func TestControllerEnable(t *testing.T) {
+	ctrl := NewController(Config{}, log.New(io.Discard, "", 0))
+	sungrow, sma := newFakeInverter(), newFakeInverter()
+	require.NoError(t, ctrl.AddDevice("sungrow", sungrow, "sungrow_sg", 100))
+	require.NoError(t, ctrl.AddDevice("sma", sma, "sma_stp", 100))
+
+	t.Run("Bật công tắc giới hạn Sungrow", func(t *testing.T) {
+		sungrow.regs[5006] = 0x55 // Đang tắt giới hạn
+		res := ctrl.Execute(Command{Device: "sungrow", Type: CmdActivePowerPercent, Value: 50})
+		require.NoError(t, res.Err)
+		assert.Equal(t, uint16(0xAA), sungrow.regs[5006])
+		assert.Equal(t, uint16(500), sungrow.regs[5007])
+
+		require.NoError(t, ctrl.Execute(Command{Device: "sungrow", Type: CmdActivePowerPercent, Value: 60}).Err)
+		assert.Equal(t, 1, sungrow.writes[5006], "Không ghi lại khi công tắc đã bật")
+	})
+
+	t.Run("Chế độ hệ số công suất Sungrow", func(t *testing.T) {
+		sungrow.regs[5035] = 0x55 // Tắt điều chỉnh công suất phản kháng
+		res := ctrl.Execute(Command{Device: "sungrow", Type: CmdPowerFactor, Value: -0.95})
+		require.NoError(t, res.Err)
+		assert.Equal(t, uint16(0xA1), sungrow.regs[5035])
+		assert.Equal(t, uint16(0x10000-950), sungrow.regs[5018])
+	})
+
+	t.Run("Chế độ giới hạn theo W của SMA", func(t *testing.T) {
+		res := ctrl.Execute(Command{Device: "sma", Type: CmdActivePowerKW, Value: 40})
+		require.NoError(t, res.Err)
+		assert.Equal(t, []uint16{0, 1077}, []uint16{sma.regs[40210], sma.regs[40211]})
+		assert.Equal(t, []uint16{0, 40000}, []uint16{sma.regs[40915], sma.regs[40916]})
+	})
+
+	t.Run("Inverter không nhận chế độ", func(t *testing.T) {
+		sungrow.regs[5006] = 0x55
+		sungrow.clamp[5006] = 0x55
+		res := ctrl.Execute(Command{Device: "sungrow", Type: CmdActivePowerPercent, Value: 70})
+		assert.Error(t, res.Err)
+		assert.NotEqual(t, uint16(700), sungrow.regs[5007], "Không ghi giới hạn khi chưa bật được chế độ")
+	})
+}
@@ -0,0 +1,342 @@
+package control
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"modbus_inverter/internal/config"
+)
+
+// Chế độ điều khiển nhà máy
+const (
+	PlantModeExportLimit = "export_limit" // Giới hạn công suất phát lên lưới, điều chỉnh mượt theo hệ số khuếch đại
+	PlantModeZeroExport  = "zero_export"  // Không phát ngược lên lưới, cắt giảm ngay khi vượt ngưỡng
+)
+
+// MeterFunc đọc công suất tác dụng tổng (kW) tại điểm đấu nối từ đồng hồ
+type MeterFunc func() (float64, error)
+
+// PlantConfig cấu hình bộ điều khiển công suất toàn nhà máy
+type PlantConfig struct {
+	Enabled         bool            `json:"enabled"`
+	Mode            string          `json:"mode"`              // "export_limit" (mặc định) hoặc "zero_export"
+	Meter           string          `json:"meter"`             // Tên đồng hồ PM2120 tại điểm đấu nối
+	Inverters       []string        `json:"inverters"`         // Các inverter tham gia điều khiển
+	ExportLimit     float64         `json:"export_limit"`      // Giới hạn công suất phát lên lưới ban đầu (kW), âm: không giới hạn; chế độ zero_export là ngưỡng phát tối đa
+	Interval        config.Duration `json:"interval"`          // Chu kỳ điều khiển, mặc định 5s
+	Deadband        float64         `json:"deadband"`          // Sai lệch (kW) nhỏ hơn ngưỡng này thì không điều chỉnh
+	Gain            float64         `json:"gain"`              // Hệ số khuếch đại vòng kín (0, 1], mặc định 0.5
+	RampRate        float64         `json:"ramp_rate"`         // Tốc độ thay đổi tối đa của tổng giới hạn (kW/s), 0: không giới hạn
+	MeterTimeout    config.Duration `json:"meter_timeout"`     // Thời gian mất đồng hồ trước khi vào chế độ an toàn, mặc định 30s (zero_export: 3 chu kỳ)
+	FailSafeLimit   *float64        `json:"fail_safe_limit"`   // Giới hạn công suất mỗi inverter khi mất đồng hồ (% định mức), bắt buộc ở chế độ zero_export, mặc định 0
+	InvertMeterSign bool            `json:"invert_meter_sign"` // Mặc định đồng hồ đo dương khi nhận từ lưới; bật nếu dương khi phát lên lưới
+}
+
+// PlantStatus trạng thái hiện tại của bộ điều khiển
+type PlantStatus struct {
+	ExportLimit float64   `json:"export_limit"` // kW, âm: không giới hạn
+	Export      float64   `json:"export"`       // Công suất phát lên lưới đo được (kW)
+	TotalLimit  float64   `json:"total_limit"`  // Tổng giới hạn công suất đang đặt cho các inverter (kW)
+	FailSafe    bool      `json:"fail_safe"`    // Đang ở chế độ an toàn do mất đồng hồ
+	LastMeter   time.Time `json:"last_meter"`   // Thời điểm đọc đồng hồ thành công gần nhất
+}
+
+// PlantController là bộ điều khiển vòng kín: đọc công suất phát lên lưới tại
+// điểm đấu nối và điều chỉnh giới hạn công suất của từng inverter theo tỉ lệ
+// công suất định mức để công suất phát không vượt giới hạn của EVN
+type PlantController struct {
+	cfg    PlantConfig
+	ctrl   *Controller
+	meter  MeterFunc
+	logger *log.Logger
+
+	inverters []string
+	rated     map[string]float64
+	totalRate float64
+	failSafe  float64 // Giới hạn ở chế độ an toàn (% định mức)
+
+	// stepMu tuần tự hóa các chu kỳ điều khiển, giữ trong suốt lúc đọc đồng hồ
+	// và ghi xuống inverter; mu chỉ giữ khi đọc/ghi trạng thái để poller, lệnh
+	// IEC 104 và API không phải chờ giao dịch Modbus
+	stepMu      sync.Mutex
+	started     time.Time // Chu kỳ điều khiển đầu tiên, tính thời gian chờ đồng hồ khi chưa đọc được lần nào
+	lastStep    time.Time
+	lastWritten map[string]float64 // Giới hạn (kW) đã ghi thành công cho từng inverter
+
+	mu         sync.Mutex
+	status     PlantStatus
+	production map[string]float64 // Công suất thực phát (kW) của từng inverter do poller cập nhật
+}
+
+// NewPlantController tạo bộ điều khiển nhà máy
+func NewPlantController(cfg PlantConfig, ctrl *Controller, meter MeterFunc, logger *log.Logger) (*PlantController, error) {
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = PlantModeExportLimit
+	case PlantModeExportLimit:
+	case PlantModeZeroExport:
+		// Không cho phép bỏ giới hạn ở chế độ không phát ngược
+		if cfg.ExportLimit < 0 {
+			cfg.ExportLimit = 0
+		}
+	default:
+		return nil, fmt.Errorf("chế độ điều khiển nhà máy không hợp lệ %q", cfg.Mode)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(5 * time.Second)
+	}
+	if cfg.Gain <= 0 || cfg.Gain > 1 {
+		cfg.Gain = 0.5
+	}
+	if cfg.MeterTimeout <= 0 {
+		cfg.MeterTimeout = config.Duration(30 * time.Second)
+		if cfg.Mode == PlantModeZeroExport {
+			cfg.MeterTimeout = 3 * cfg.Interval
+		}
+	}
+	failSafe := 0.0
+	switch {
+	case cfg.FailSafeLimit != nil:
+		failSafe = *cfg.FailSafeLimit
+		if failSafe < 0 || failSafe > 100 || math.IsNaN(failSafe) {
+			return nil, fmt.Errorf("giới hạn chế độ an toàn %v%% ngoài khoảng 0-100", failSafe)
+		}
+	case cfg.Mode == PlantModeZeroExport:
+		// Mất đồng hồ ở chế độ không phát ngược phải chọn rõ cắt về bao nhiêu
+		return nil, fmt.Errorf("chế độ %s cần cấu hình fail_safe_limit", cfg.Mode)
+	}
+
+	p := &PlantController{
+		cfg:         cfg,
+		ctrl:        ctrl,
+		meter:       meter,
+		logger:      logger,
+		rated:       make(map[string]float64),
+		lastWritten: make(map[string]float64),
+		production:  make(map[string]float64),
+		failSafe:    failSafe,
+	}
+	for _, name := range cfg.Inverters {
+		rated := ctrl.RatedPower(name)
+		if rated <= 0 {
+			return nil, fmt.Errorf("inverter %q chưa có công suất định mức", name)
+		}
+		p.inverters = append(p.inverters, name)
+		p.rated[name] = rated
+		p.totalRate += rated
+	}
+	if len(p.inverters) == 0 {
+		return nil, fmt.Errorf("chưa có inverter nào tham gia điều khiển")
+	}
+
+	p.status = PlantStatus{
+		ExportLimit: cfg.ExportLimit,
+		TotalLimit:  p.totalRate,
+	}
+	return p, nil
+}
+
+// SetExportLimit đặt giới hạn công suất phát lên lưới (kW), giá trị âm để bỏ giới hạn.
+// Ở chế độ zero_export giá trị âm được coi là 0.
+func (p *PlantController) SetExportLimit(kw float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cfg.Mode == PlantModeZeroExport && kw < 0 {
+		kw = 0
+	}
+	p.status.ExportLimit = kw
+	p.logger.Printf("Điều khiển nhà máy: giới hạn công suất phát lên lưới %.2f kW", kw)
+}
+
+// UpdateProduction cập nhật công suất thực phát (kW) của một inverter. Ở chế độ
+// zero_export giá trị này được dùng để cắt giảm từ công suất thực thay vì từ
+// giới hạn đang đặt khi inverter phát thấp hơn giới hạn (do bức xạ yếu).
+func (p *PlantController) UpdateProduction(device string, kw float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.rated[device]; ok {
+		p.production[device] = kw
+	}
+}
+
+// ClearProduction bỏ công suất thực phát đã biết của một inverter khi đọc lỗi
+// (mất liên lạc, inverter cắt) để không điều khiển theo công suất không còn đúng;
+// khi chưa biết đủ công suất của mọi inverter thì dùng giới hạn đang đặt
+func (p *PlantController) ClearProduction(device string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.production, device)
+}
+
+// Status trả về trạng thái hiện tại
+func (p *PlantController) Status() PlantStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// Run chạy vòng điều khiển cho tới khi done bị đóng
+func (p *PlantController) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(p.cfg.Interval.Std())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			p.Step(now)
+		}
+	}
+}
+
+// Step thực hiện một chu kỳ điều khiển
+func (p *PlantController) Step(now time.Time) {
+	p.stepMu.Lock()
+	defer p.stepMu.Unlock()
+
+	if p.started.IsZero() {
+		p.started = now
+	}
+	dt := p.cfg.Interval.Std()
+	if !p.lastStep.IsZero() {
+		dt = now.Sub(p.lastStep)
+	}
+	p.lastStep = now
+
+	power, err := p.meter()
+	if err == nil && (math.IsNaN(power) || math.IsInf(power, 0)) {
+		err = fmt.Errorf("giá trị đồng hồ không hợp lệ: %v", power)
+	}
+
+	p.mu.Lock()
+	ratio, ok := p.step(now, power, err, dt)
+	p.mu.Unlock()
+
+	if ok {
+		p.distribute(ratio)
+	}
+}
+
+// step tính tổng giới hạn mới từ giá trị đồng hồ và cập nhật trạng thái, gọi khi
+// đang giữ mu; trả về tỉ lệ giới hạn cần ghi cho các inverter, false nếu giữ nguyên
+func (p *PlantController) step(now time.Time, power float64, err error, dt time.Duration) (float64, bool) {
+	if err != nil {
+		p.logger.Printf("Điều khiển nhà máy: lỗi đọc đồng hồ %s: %v", p.cfg.Meter, err)
+		// Chưa đọc được lần nào thì tính từ chu kỳ đầu tiên, tránh cắt công
+		// suất ngay khi đồng hồ chưa kịp trả lời lúc khởi động
+		last := p.status.LastMeter
+		if last.IsZero() {
+			last = p.started
+		}
+		if now.Sub(last) >= p.cfg.MeterTimeout.Std() {
+			return p.enterFailSafe(), true
+		}
+		return 0, false
+	}
+
+	if p.status.FailSafe {
+		p.logger.Printf("Điều khiển nhà máy: đồng hồ đã hoạt động trở lại, thoát chế độ an toàn")
+		p.status.FailSafe = false
+	}
+	p.status.LastMeter = now
+
+	// Đồng hồ đo dương khi nhận điện từ lưới, công suất phát lên lưới là giá trị âm
+	export := -power
+	if p.cfg.InvertMeterSign {
+		export = power
+	}
+	p.status.Export = export
+
+	target := p.status.TotalLimit
+	if p.cfg.Mode == PlantModeZeroExport {
+		return p.zeroExport(export, dt)
+	}
+	if p.status.ExportLimit < 0 {
+		// Không giới hạn: trả dần về công suất định mức
+		target = p.totalRate
+	} else {
+		diff := p.status.ExportLimit - export
+		if math.Abs(diff) <= p.cfg.Deadband {
+			return 0, false
+		}
+		target += p.cfg.Gain * diff
+	}
+	return p.apply(target, dt), true
+}
+
+// zeroExport điều khiển để công suất phát không vượt ngưỡng: khi vượt thì cắt
+// ngay toàn bộ phần dư cộng thêm deadband, khi thấp hơn ngưỡng quá hai lần
+// deadband thì tăng dần để công suất luôn dao động dưới ngưỡng
+func (p *PlantController) zeroExport(export float64, dt time.Duration) (float64, bool) {
+	excess := export - p.status.ExportLimit
+	switch {
+	case excess > 0:
+		base := p.status.TotalLimit
+		if len(p.production) == len(p.inverters) {
+			produced := 0.0
+			for _, kw := range p.production {
+				produced += kw
+			}
+			base = math.Min(base, produced)
+		}
+		return p.apply(base-excess-p.cfg.Deadband, dt), true
+	case -excess > 2*p.cfg.Deadband:
+		return p.apply(p.status.TotalLimit+p.cfg.Gain*(-excess-p.cfg.Deadband), dt), true
+	}
+	return 0, false
+}
+
+// apply giới hạn tốc độ thay đổi, kẹp trong khoảng cho phép và trả về tỉ lệ
+// giới hạn so với công suất định mức để phân bổ cho các inverter
+func (p *PlantController) apply(target float64, dt time.Duration) float64 {
+	if p.cfg.RampRate > 0 {
+		maxStep := p.cfg.RampRate * dt.Seconds()
+		delta := target - p.status.TotalLimit
+		if delta > maxStep {
+			target = p.status.TotalLimit + maxStep
+		} else if delta < -maxStep && p.cfg.Mode != PlantModeZeroExport {
+			// Ở chế độ zero_export chỉ giới hạn tốc độ tăng, giảm công suất luôn thực hiện ngay
+			target = p.status.TotalLimit - maxStep
+		}
+	}
+	target = math.Max(0, math.Min(p.totalRate, target))
+	p.status.TotalLimit = target
+	return target / p.totalRate
+}
+
+// enterFailSafe chuyển sang chế độ an toàn khi mất đồng hồ, trả về tỉ lệ giới hạn an toàn
+func (p *PlantController) enterFailSafe() float64 {
+	if !p.status.FailSafe {
+		p.logger.Printf("Điều khiển nhà máy: mất đồng hồ quá %v, vào chế độ an toàn %.1f%%", p.cfg.MeterTimeout.Std(), p.failSafe)
+		p.status.FailSafe = true
+	}
+	ratio := p.failSafe / 100
+	p.status.TotalLimit = ratio * p.totalRate
+	return ratio
+}
+
+// distribute ghi giới hạn công suất cho từng inverter theo tỉ lệ định mức, gọi
+// khi đang giữ stepMu. Chỉ ghi khi giá trị thay đổi đáng kể hoặc lần ghi trước thất bại.
+func (p *PlantController) distribute(ratio float64) {
+	for _, name := range p.inverters {
+		limit := ratio * p.rated[name]
+		if last, ok := p.lastWritten[name]; ok && math.Abs(last-limit) < p.rated[name]*0.001 {
+			continue
+		}
+		res := p.ctrl.Execute(Command{
+			Device: name,
+			Type:   CmdActivePowerKW,
+			Value:  limit,
+			Source: "plant",
+		})
+		if res.Err != nil {
+			delete(p.lastWritten, name)
+			continue
+		}
+		p.lastWritten[name] = limit
+	}
+}
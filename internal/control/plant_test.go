@@ -0,0 +1,229 @@
+package control
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/config"
+)
+
+// simPlant giả lập nhà máy gồm các inverter, phụ tải và đồng hồ tại điểm đấu nối
+type simPlant struct {
+	inverters map[string]*fakeInverter
+	available map[string]float64 // Công suất khả dụng theo bức xạ (kW)
+	load      float64            // Phụ tải tại chỗ (kW)
+	meterErr  error
+}
+
+// limit đọc giới hạn kW đã ghi vào thanh ghi 101 của model generic
+func (s *simPlant) limit(name string) float64 {
+	inv := s.inverters[name]
+	if _, ok := inv.regs[101]; !ok {
+		return math.Inf(1)
+	}
+	return float64(inv.regs[101]) / 100
+}
+
+// meter trả về công suất đồng hồ đo, dương khi nhận từ lưới
+func (s *simPlant) meter() (float64, error) {
+	if s.meterErr != nil {
+		return 0, s.meterErr
+	}
+	production := 0.0
+	for name, avail := range s.available {
+		production += math.Min(avail, s.limit(name))
+	}
+	return s.load - production, nil
+}
+
+// step báo công suất thực phát của các inverter rồi chạy một chu kỳ điều khiển
+func (s *simPlant) step(plant *PlantController, now time.Time) {
+	for name, avail := range s.available {
+		plant.UpdateProduction(name, math.Min(avail, s.limit(name)))
+	}
+	plant.Step(now)
+}
+
+// percent trả về con trỏ cho giá trị % cấu hình
+func percent(v float64) *float64 {
+	return &v
+}
+
+func newSimPlant(t *testing.T, cfg PlantConfig) (*simPlant, *PlantController) {
+	sim := &simPlant{
+		inverters: map[string]*fakeInverter{"inv1": newFakeInverter(), "inv2": newFakeInverter()},
+		available: map[string]float64{"inv1": 8, "inv2": 18},
+		load:      5,
+	}
+	logger := log.New(io.Discard, "", 0)
+	ctrl := NewController(Config{}, logger)
+	require.NoError(t, ctrl.AddDevice("inv1", sim.inverters["inv1"], "generic", 10))
+	require.NoError(t, ctrl.AddDevice("inv2", sim.inverters["inv2"], "generic", 20))
+
+	cfg.Inverters = []string{"inv1", "inv2"}
+	cfg.Interval = config.Duration(time.Second)
+	plant, err := NewPlantController(cfg, ctrl, sim.meter, logger)
+	require.NoError(t, err)
+	return sim, plant
+}
+
+// TestPlantController kiểm tra vòng điều khiển giới hạn công suất phát lên lưới
+func TestPlantController(t *testing.T) {
+	t.Run("Hội tụ về giới hạn", func(t *testing.T) {
+		sim, plant := newSimPlant(t, PlantConfig{ExportLimit: 10, Deadband: 0.2})
+
+		now := time.Now()
+		for i := 0; i < 30; i++ {
+			now = now.Add(time.Second)
+			plant.Step(now)
+		}
+
+		power, _ := sim.meter()
+		assert.InDelta(t, 10, -power, 0.5, "Công suất phát lên lưới phải hội tụ về giới hạn")
+		assert.InDelta(t, 0.5, sim.limit("inv1")/sim.limit("inv2"), 0.01, "Giới hạn phải tỉ lệ với công suất định mức")
+	})
+
+	t.Run("Giới hạn tốc độ thay đổi", func(t *testing.T) {
+		_, plant := newSimPlant(t, PlantConfig{ExportLimit: 0, RampRate: 1})
+
+		plant.Step(time.Now())
+		assert.InDelta(t, 29, plant.Status().TotalLimit, 1e-6, "Mỗi giây chỉ được giảm 1 kW")
+	})
+
+	t.Run("Không phát ngược lên lưới", func(t *testing.T) {
+		sim, plant := newSimPlant(t, PlantConfig{Mode: PlantModeZeroExport, Deadband: 0.5, RampRate: 1, FailSafeLimit: percent(0)})
+
+		// Lần đầu phải cắt ngay về dưới ngưỡng, không bị giới hạn tốc độ
+		now := time.Now()
+		sim.step(plant, now)
+		power, _ := sim.meter()
+		assert.GreaterOrEqual(t, power, 0.0, "Không được phát lên lưới sau chu kỳ đầu")
+
+		// Phụ tải giảm đột ngột, công suất phát phải về dưới ngưỡng sau một chu kỳ
+		for i := 0; i < 30; i++ {
+			now = now.Add(time.Second)
+			sim.step(plant, now)
+		}
+		sim.load = 2
+		now = now.Add(time.Second)
+		sim.step(plant, now)
+		power, _ = sim.meter()
+		assert.GreaterOrEqual(t, power, 0.0)
+		assert.LessOrEqual(t, power, 1.5, "Không được cắt giảm quá nhiều")
+	})
+
+	t.Run("Chế độ an toàn khi mất đồng hồ", func(t *testing.T) {
+		sim, plant := newSimPlant(t, PlantConfig{
+			ExportLimit:   10,
+			MeterTimeout:  config.Duration(3 * time.Second),
+			FailSafeLimit: percent(20),
+		})
+
+		now := time.Now()
+		plant.Step(now)
+		sim.meterErr = errors.New("timeout")
+
+		now = now.Add(2 * time.Second)
+		plant.Step(now)
+		assert.False(t, plant.Status().FailSafe, "Chưa quá thời gian chờ")
+
+		now = now.Add(2 * time.Second)
+		plant.Step(now)
+		assert.True(t, plant.Status().FailSafe)
+		assert.InDelta(t, 2, sim.limit("inv1"), 1e-6)
+		assert.InDelta(t, 4, sim.limit("inv2"), 1e-6)
+
+		sim.meterErr = nil
+		plant.Step(now.Add(time.Second))
+		assert.False(t, plant.Status().FailSafe, "Thoát chế độ an toàn khi đồng hồ hoạt động lại")
+	})
+
+	t.Run("Chờ đồng hồ khi khởi động", func(t *testing.T) {
+		sim, plant := newSimPlant(t, PlantConfig{
+			ExportLimit:   10,
+			MeterTimeout:  config.Duration(3 * time.Second),
+			FailSafeLimit: percent(20),
+		})
+		sim.meterErr = errors.New("timeout")
+
+		// Lỗi đọc đầu tiên sau khởi động chưa phải mất đồng hồ
+		now := time.Now()
+		plant.Step(now)
+		assert.False(t, plant.Status().FailSafe)
+		_, written := sim.inverters["inv1"].regs[101]
+		assert.False(t, written, "Không ghi giới hạn khi chưa quá thời gian chờ")
+
+		now = now.Add(3 * time.Second)
+		plant.Step(now)
+		assert.True(t, plant.Status().FailSafe)
+		assert.InDelta(t, 2, sim.limit("inv1"), 1e-6)
+	})
+
+	t.Run("Cấu hình chế độ an toàn", func(t *testing.T) {
+		ctrl := NewController(Config{}, log.New(io.Discard, "", 0))
+		require.NoError(t, ctrl.AddDevice("inv1", newFakeInverter(), "generic", 10))
+		newPlant := func(cfg PlantConfig) error {
+			cfg.Inverters = []string{"inv1"}
+			_, err := NewPlantController(cfg, ctrl, func() (float64, error) { return 0, nil }, log.New(io.Discard, "", 0))
+			return err
+		}
+
+		assert.Error(t, newPlant(PlantConfig{Mode: PlantModeZeroExport}), "zero_export phải cấu hình giới hạn an toàn")
+		assert.NoError(t, newPlant(PlantConfig{Mode: PlantModeZeroExport, FailSafeLimit: percent(0)}))
+		assert.NoError(t, newPlant(PlantConfig{Mode: PlantModeExportLimit}))
+		assert.Error(t, newPlant(PlantConfig{FailSafeLimit: percent(150)}))
+		assert.Error(t, newPlant(PlantConfig{FailSafeLimit: percent(-5)}))
+	})
+
+	t.Run("Không khóa trạng thái khi đọc đồng hồ", func(t *testing.T) {
+		sim, plant := newSimPlant(t, PlantConfig{ExportLimit: 10})
+		reading := make(chan struct{})
+		release := make(chan struct{})
+		plant.meter = func() (float64, error) {
+			close(reading)
+			<-release
+			return sim.meter()
+		}
+
+		done := make(chan struct{})
+		go func() {
+			plant.Step(time.Now())
+			close(done)
+		}()
+		<-reading
+
+		// Poller, lệnh IEC 104 và API không phải chờ chu kỳ điều khiển
+		plant.UpdateProduction("inv1", 5)
+		plant.SetExportLimit(8)
+		assert.Equal(t, 8.0, plant.Status().ExportLimit)
+
+		close(release)
+		<-done
+		assert.Less(t, plant.Status().TotalLimit, 30.0)
+	})
+
+	t.Run("Bỏ công suất của inverter mất liên lạc", func(t *testing.T) {
+		_, plant := newSimPlant(t, PlantConfig{Mode: PlantModeZeroExport, Deadband: 0.5, FailSafeLimit: percent(0)})
+		plant.UpdateProduction("inv1", 8)
+		plant.UpdateProduction("inv2", 18)
+		plant.ClearProduction("inv2")
+
+		// Không dùng công suất cũ của inv2, cắt theo giới hạn đang đặt
+		plant.mu.Lock()
+		plant.status.ExportLimit = 0
+		plant.status.TotalLimit = 30
+		ratio, ok := plant.zeroExport(4, time.Second)
+		_, known := plant.production["inv2"]
+		plant.mu.Unlock()
+		require.True(t, ok)
+		assert.False(t, known)
+		assert.InDelta(t, (30-4-0.5)/30, ratio, 1e-9)
+	})
+}
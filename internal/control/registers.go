@@ -0,0 +1,195 @@
+package control
+
+import (
+	"fmt"
+	"math"
+)
+
+// CommandType loại lệnh điều khiển inverter
+type CommandType string
+
+// Các loại lệnh được hỗ trợ
+const (
+	CmdActivePowerPercent CommandType = "p_limit_percent" // Giới hạn công suất tác dụng (% công suất định mức)
+	CmdActivePowerKW      CommandType = "p_limit_kw"      // Giới hạn công suất tác dụng (kW)
+	CmdReactivePower      CommandType = "q_setpoint_kvar" // Đặt công suất phản kháng (kVar)
+	CmdPowerFactor        CommandType = "pf_setpoint"     // Đặt hệ số công suất
+	CmdOnOff              CommandType = "on_off"          // Bật (1) / tắt (0) inverter
+
+	// Lệnh cấp nhà máy, được chuyển cho PlantController thay vì ghi xuống inverter
+	CmdPlantExportLimit CommandType = "export_limit_kw" // Giới hạn công suất phát lên lưới toàn nhà máy (kW)
+)
+
+// WriteRegister mô tả thanh ghi dùng để ghi một loại lệnh
+type WriteRegister struct {
+	Address  uint16  `json:"address"`   // Địa chỉ thanh ghi giữ (0-based)
+	Size     uint16  `json:"size"`      // Số thanh ghi: 1 (16 bit) hoặc 2 (32 bit, word cao trước), mặc định 1
+	Scale    float64 `json:"scale"`     // Giá trị thanh ghi = giá trị kỹ thuật * Scale, mặc định 1
+	Signed   bool    `json:"signed"`    // Thanh ghi có dấu
+	Min      float64 `json:"min"`       // Giới hạn dưới của giá trị kỹ thuật
+	Max      float64 `json:"max"`       // Giới hạn trên của giá trị kỹ thuật
+	OnValue  uint16  `json:"on_value"`  // Chỉ dùng cho lệnh on_off: giá trị ghi khi bật
+	OffValue uint16  `json:"off_value"` // Chỉ dùng cho lệnh on_off: giá trị ghi khi tắt
+	Enable   *Enable `json:"enable"`    // Thanh ghi chế độ phải đặt trước thì lệnh mới có hiệu lực
+}
+
+// Enable là thanh ghi chế độ (ví dụ công tắc giới hạn công suất) phải có giá trị
+// định trước thì thanh ghi lệnh mới có hiệu lực. Chỉ ghi khi giá trị đọc được khác
+// để tránh ghi lặp vào bộ nhớ cố định của inverter.
+type Enable struct {
+	Address uint16 `json:"address"` // Địa chỉ thanh ghi giữ (0-based)
+	Size    uint16 `json:"size"`    // Số thanh ghi: 1 hoặc 2 (word cao trước), mặc định 1
+	Value   uint32 `json:"value"`
+}
+
+// RegisterMap bảng thanh ghi điều khiển của một model inverter
+type RegisterMap struct {
+	Model     string                        `json:"model"`
+	Registers map[CommandType]WriteRegister `json:"registers"`
+}
+
+// BuiltinRegisterMaps trả về các bảng thanh ghi điều khiển có sẵn
+func BuiltinRegisterMaps() map[string]RegisterMap {
+	maps := map[string]RegisterMap{
+		// Bảng thanh ghi của simulator trong thư mục simulator/
+		"generic": {
+			Model: "generic",
+			Registers: map[CommandType]WriteRegister{
+				CmdActivePowerPercent: {Address: 100, Scale: 10, Min: 0, Max: 100},
+				CmdActivePowerKW:      {Address: 101, Scale: 100, Min: 0, Max: 655},
+				CmdReactivePower:      {Address: 102, Scale: 100, Signed: true, Min: -327, Max: 327},
+				CmdPowerFactor:        {Address: 103, Scale: 1000, Signed: true, Min: -1, Max: 1},
+				CmdOnOff:              {Address: 104, OnValue: 1, OffValue: 0},
+			},
+		},
+		// Huawei SUN2000: bật/tắt dùng hai thanh ghi chỉ ghi 40200/40201 riêng
+		// nên không hỗ trợ on_off, giới hạn công suất 0% để dừng phát
+		"huawei_sun2000": {
+			Model: "huawei_sun2000",
+			Registers: map[CommandType]WriteRegister{
+				CmdActivePowerPercent: {Address: 40125, Scale: 10, Signed: true, Min: 0, Max: 100},
+				CmdActivePowerKW:      {Address: 40120, Scale: 10, Min: 0, Max: 6553.5},
+				CmdPowerFactor:        {Address: 40122, Scale: 1000, Signed: true, Min: -1, Max: 1},
+			},
+		},
+		// Sungrow SG (địa chỉ 0-based, tài liệu hãng đánh số từ 1): giới hạn
+		// công suất 5007 chỉ có hiệu lực khi công tắc giới hạn 5006 là 0xAA (bật),
+		// hệ số công suất 5018 chỉ có hiệu lực khi công tắc điều chỉnh công suất
+		// phản kháng 5035 là 0xA1 (chế độ PF), bật/tắt bằng 0xCF/0xCE ở thanh ghi 5005
+		"sungrow_sg": {
+			Model: "sungrow_sg",
+			Registers: map[CommandType]WriteRegister{
+				CmdActivePowerPercent: {Address: 5007, Scale: 10, Min: 0, Max: 110, Enable: &Enable{Address: 5006, Value: 0xAA}},
+				CmdPowerFactor:        {Address: 5018, Scale: 1000, Signed: true, Min: -1, Max: 1, Enable: &Enable{Address: 5035, Value: 0xA1}},
+				CmdOnOff:              {Address: 5005, OnValue: 0xCF, OffValue: 0xCE},
+			},
+		},
+		// Growatt MAX/MID
+		"growatt_max": {
+			Model: "growatt_max",
+			Registers: map[CommandType]WriteRegister{
+				CmdActivePowerPercent: {Address: 3, Min: 0, Max: 100},
+				CmdOnOff:              {Address: 0, OnValue: 1, OffValue: 0},
+			},
+		},
+		// SMA Sunny Tripower: giới hạn theo W cần đặt chế độ quản lý phát 40210
+		// là 1077 (giới hạn công suất P theo W) trước
+		"sma_stp": {
+			Model: "sma_stp",
+			Registers: map[CommandType]WriteRegister{
+				CmdActivePowerKW: {Address: 40915, Size: 2, Scale: 1000, Min: 0, Max: 1000, Enable: &Enable{Address: 40210, Size: 2, Value: 1077}},
+			},
+		},
+	}
+	mid := maps["growatt_max"]
+	mid.Model = "growatt_mid"
+	maps["growatt_mid"] = mid
+	return maps
+}
+
+// size trả về số thanh ghi, mặc định 1
+func (r WriteRegister) size() uint16 {
+	if r.Size == 0 {
+		return 1
+	}
+	return r.Size
+}
+
+// scale trả về hệ số tỉ lệ, mặc định 1
+func (r WriteRegister) scale() float64 {
+	if r.Scale == 0 {
+		return 1
+	}
+	return r.Scale
+}
+
+// words trả về các word cần ghi của thanh ghi chế độ
+func (e Enable) words() []uint16 {
+	if e.Size == 2 {
+		return []uint16{uint16(e.Value >> 16), uint16(e.Value)}
+	}
+	return []uint16{uint16(e.Value)}
+}
+
+// encode chuyển giá trị kỹ thuật thành các word để ghi
+func (r WriteRegister) encode(value float64) ([]uint16, error) {
+	if (r.Min != 0 || r.Max != 0) && (value < r.Min || value > r.Max) {
+		return nil, fmt.Errorf("giá trị %.3f ngoài giới hạn [%.3f, %.3f]", value, r.Min, r.Max)
+	}
+
+	raw := math.Round(value * r.scale())
+	switch r.size() {
+	case 1:
+		if r.Signed {
+			if raw < math.MinInt16 || raw > math.MaxInt16 {
+				return nil, fmt.Errorf("giá trị %.3f vượt quá thanh ghi int16", value)
+			}
+			return []uint16{uint16(int16(raw))}, nil
+		}
+		if raw < 0 || raw > math.MaxUint16 {
+			return nil, fmt.Errorf("giá trị %.3f vượt quá thanh ghi uint16", value)
+		}
+		return []uint16{uint16(raw)}, nil
+	case 2:
+		var v uint32
+		if r.Signed {
+			if raw < math.MinInt32 || raw > math.MaxInt32 {
+				return nil, fmt.Errorf("giá trị %.3f vượt quá thanh ghi int32", value)
+			}
+			v = uint32(int32(raw))
+		} else {
+			if raw < 0 || raw > math.MaxUint32 {
+				return nil, fmt.Errorf("giá trị %.3f vượt quá thanh ghi uint32", value)
+			}
+			v = uint32(raw)
+		}
+		return []uint16{uint16(v >> 16), uint16(v)}, nil
+	}
+	return nil, fmt.Errorf("kích thước thanh ghi không hỗ trợ: %d", r.Size)
+}
+
+// decode chuyển dữ liệu đọc lại thành giá trị kỹ thuật
+func (r WriteRegister) decode(data []byte) (float64, error) {
+	if len(data) != int(r.size())*2 {
+		return 0, fmt.Errorf("độ dài dữ liệu đọc lại không đúng (%d bytes, cần %d)", len(data), r.size()*2)
+	}
+
+	var raw float64
+	switch r.size() {
+	case 1:
+		v := uint16(data[0])<<8 | uint16(data[1])
+		if r.Signed {
+			raw = float64(int16(v))
+		} else {
+			raw = float64(v)
+		}
+	case 2:
+		v := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+		if r.Signed {
+			raw = float64(int32(v))
+		} else {
+			raw = float64(v)
+		}
+	}
+	return raw / r.scale(), nil
+}
@@ -0,0 +1,251 @@
+package counter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"modbus_inverter/internal/config"
+	"modbus_inverter/internal/store"
+)
+
+// OutputSuffix hậu tố mặc định của tín hiệu năng lượng tích lũy
+const OutputSuffix = "_acc"
+
+// Config cấu hình theo dõi bộ đếm năng lượng
+type Config struct {
+	Enabled      bool            `json:"enabled"`
+	StatePath    string          `json:"state_path"`    // File lưu trạng thái để tính tiếp sau khi khởi động lại, mặc định data/counters.json
+	SaveInterval config.Duration `json:"save_interval"` // Chu kỳ lưu trạng thái, mặc định 1m
+	Counters     []Counter       `json:"counters"`      // Bỏ trống dùng DefaultCounters
+}
+
+// Counter mô tả một bộ đếm năng lượng của thiết bị
+type Counter struct {
+	Device  string  `json:"device"`   // Tên thiết bị, bỏ trống: mọi thiết bị không có bộ đếm riêng cùng tín hiệu
+	Signal  string  `json:"signal"`   // Tín hiệu bộ đếm
+	Modulus float64 `json:"modulus"`  // Giá trị bộ đếm quay vòng về 0 (theo đơn vị tín hiệu), 0: không quay vòng
+	MaxStep float64 `json:"max_step"` // Mức tăng tối đa giữa hai lần đọc để coi lần giảm là quay vòng, mặc định Modulus/2
+	Output  string  `json:"output"`   // Tín hiệu năng lượng tích lũy, mặc định Signal + "_acc"
+}
+
+// DefaultCounters trả về các bộ đếm của inverter generic (16 bit, 0.1 kWh) và
+// PM2120 (64 bit); inverter có độ rộng bộ đếm khác cần bộ đếm riêng theo Device.
+// Sản lượng ngày được đặt lại mỗi sáng và không đạt tới giới hạn thanh ghi nên
+// mọi lần giảm đều là đặt lại, kể cả khi giá trị trước đó lớn
+func DefaultCounters() []Counter {
+	const uint16Modulus = 65536 / 10.0
+	return []Counter{
+		{Signal: "total_energy", Modulus: uint16Modulus},
+		{Signal: "daily_energy"},
+		{Signal: "active_energy_delivered_wh"},
+		{Signal: "active_energy_received_wh"},
+		{Signal: "reactive_energy_delivered_varh"},
+		{Signal: "reactive_energy_received_varh"},
+		{Signal: "apparent_energy_delivered_vah"},
+		{Signal: "apparent_energy_received_vah"},
+	}
+}
+
+// State là trạng thái của một bộ đếm, được lưu qua các lần khởi động
+type State struct {
+	Raw         float64   `json:"raw"`         // Giá trị đọc gần nhất
+	Accumulated float64   `json:"accumulated"` // Năng lượng tích lũy phía gateway
+	Time        time.Time `json:"time"`        // Thời điểm đọc gần nhất
+	Wraps       uint64    `json:"wraps"`       // Số lần quay vòng
+	Resets      uint64    `json:"resets"`      // Số lần bị đặt lại
+}
+
+// Tracker theo dõi bộ đếm năng lượng, phát hiện quay vòng và đặt lại, duy trì
+// năng lượng tích lũy liên tục
+type Tracker struct {
+	cfg    Config
+	logger *log.Logger
+
+	mu     sync.Mutex
+	states map[string]*State // Khóa: thiết bị + "/" + tín hiệu
+	dirty  bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTracker tạo bộ theo dõi và đọc trạng thái đã lưu
+func NewTracker(cfg Config, logger *log.Logger) (*Tracker, error) {
+	if cfg.StatePath == "" {
+		cfg.StatePath = "data/counters.json"
+	}
+	if cfg.SaveInterval <= 0 {
+		cfg.SaveInterval = config.Duration(time.Minute)
+	}
+	if len(cfg.Counters) == 0 {
+		cfg.Counters = DefaultCounters()
+	}
+	for i := range cfg.Counters {
+		c := &cfg.Counters[i]
+		if c.Signal == "" {
+			return nil, fmt.Errorf("bộ đếm thứ %d chưa có tín hiệu", i+1)
+		}
+		if c.Modulus < 0 || c.MaxStep < 0 {
+			return nil, fmt.Errorf("bộ đếm %s có giá trị âm", c.Signal)
+		}
+		if c.MaxStep == 0 {
+			c.MaxStep = c.Modulus / 2
+		}
+		if c.Output == "" {
+			c.Output = c.Signal + OutputSuffix
+		}
+	}
+
+	t := &Tracker{
+		cfg:    cfg,
+		logger: logger,
+		states: make(map[string]*State),
+		done:   make(chan struct{}),
+	}
+	data, err := os.ReadFile(cfg.StatePath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("đọc trạng thái bộ đếm lỗi: %w", err)
+	default:
+		if err := json.Unmarshal(data, &t.states); err != nil {
+			return nil, fmt.Errorf("trạng thái bộ đếm %s lỗi: %w", cfg.StatePath, err)
+		}
+	}
+	return t, nil
+}
+
+// Track cập nhật các bộ đếm có trong tags, thêm vào tags các tín hiệu năng
+// lượng tích lũy và trả về tags. Chỉ dùng giá trị có chất lượng dùng được.
+func (t *Tracker) Track(device string, tags map[string]store.Tag) map[string]store.Tag {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.cfg.Counters {
+		c := &t.cfg.Counters[i]
+		if c.Device != "" && c.Device != device {
+			continue
+		}
+		if c.Device == "" && t.hasOwn(device, c.Signal) {
+			continue
+		}
+		tag, ok := tags[c.Signal]
+		if !ok || !tag.Quality.Usable() {
+			continue
+		}
+		acc := t.update(device, c, tag.Value, tag.Acquired)
+		tags[c.Output] = store.Tag{Value: acc, Quality: tag.Quality, Source: tag.Source, Acquired: tag.Acquired}
+	}
+	return tags
+}
+
+// hasOwn kiểm tra thiết bị có bộ đếm riêng cho tín hiệu, được ưu tiên hơn bộ đếm chung
+func (t *Tracker) hasOwn(device, signal string) bool {
+	for _, c := range t.cfg.Counters {
+		if c.Device == device && c.Signal == signal {
+			return true
+		}
+	}
+	return false
+}
+
+// update tính năng lượng tích lũy từ giá trị mới của bộ đếm; gọi khi đang giữ khóa
+func (t *Tracker) update(device string, c *Counter, raw float64, ts time.Time) float64 {
+	key := device + "/" + c.Signal
+	st, ok := t.states[key]
+	if !ok {
+		st = &State{Raw: raw, Accumulated: raw, Time: ts}
+		t.states[key] = st
+		t.dirty = true
+		return st.Accumulated
+	}
+
+	delta := raw - st.Raw
+	if delta < 0 {
+		if wrapped := raw + c.Modulus - st.Raw; c.Modulus > 0 && wrapped <= c.MaxStep {
+			delta = wrapped
+			st.Wraps++
+			t.logger.Printf("Bộ đếm %s %s quay vòng: %g -> %g", device, c.Signal, st.Raw, raw)
+		} else {
+			// Bộ đếm bắt đầu lại từ 0, năng lượng từ lúc đặt lại là giá trị hiện tại
+			delta = raw
+			st.Resets++
+			t.logger.Printf("Bộ đếm %s %s bị đặt lại: %g -> %g", device, c.Signal, st.Raw, raw)
+		}
+	}
+	st.Raw = raw
+	st.Accumulated += delta
+	st.Time = ts
+	t.dirty = true
+	return st.Accumulated
+}
+
+// States trả về bản sao trạng thái các bộ đếm, khóa là thiết bị + "/" + tín hiệu
+func (t *Tracker) States() map[string]State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	states := make(map[string]State, len(t.states))
+	for k, st := range t.states {
+		states[k] = *st
+	}
+	return states
+}
+
+// Start bắt đầu lưu trạng thái định kỳ
+func (t *Tracker) Start() {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.cfg.SaveInterval.Std())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				if err := t.Save(); err != nil {
+					t.logger.Printf("Lưu trạng thái bộ đếm lỗi: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Close dừng lưu định kỳ và lưu trạng thái lần cuối
+func (t *Tracker) Close() error {
+	close(t.done)
+	t.wg.Wait()
+	return t.Save()
+}
+
+// Save ghi trạng thái ra file nếu có thay đổi, ghi file tạm rồi đổi tên để
+// không hỏng file khi mất điện
+func (t *Tracker) Save() error {
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(t.states, "", "  ")
+	t.dirty = false
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.cfg.StatePath), 0o755); err != nil {
+		return err
+	}
+	tmp := t.cfg.StatePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.cfg.StatePath)
+}
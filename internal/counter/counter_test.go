@@ -0,0 +1,101 @@
+package counter
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/store"
+)
+
+// TestTracker kiểm tra quay vòng, đặt lại và tính tiếp sau khi khởi động lại
+func TestTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.json")
+	logger := log.New(io.Discard, "", 0)
+	tr, err := NewTracker(Config{StatePath: path}, logger)
+	require.NoError(t, err)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	track := func(tr *Tracker, values map[string]float64) map[string]store.Tag {
+		now = now.Add(time.Minute)
+		return tr.Track("inverter1", store.Tags(values, now))
+	}
+
+	tags := track(tr, map[string]float64{"total_energy": 6550, "daily_energy": 20})
+	assert.InDelta(t, 6550, tags["total_energy_acc"].Value, 1e-9, "Bắt đầu từ giá trị bộ đếm")
+	assert.Equal(t, now, tags["total_energy_acc"].Acquired)
+
+	tags = track(tr, map[string]float64{"total_energy": 2.4, "daily_energy": 25.4})
+	assert.InDelta(t, 6556, tags["total_energy_acc"].Value, 1e-9, "Quay vòng 16 bit")
+	assert.InDelta(t, 25.4, tags["daily_energy_acc"].Value, 1e-9)
+
+	tags = track(tr, map[string]float64{"total_energy": 3, "daily_energy": 0.5})
+	assert.InDelta(t, 6556.6, tags["total_energy_acc"].Value, 1e-9)
+	assert.InDelta(t, 25.9, tags["daily_energy_acc"].Value, 1e-9, "Đặt lại đầu ngày")
+
+	// Giá trị mất liên lạc không được tính
+	bad := store.Tags(map[string]float64{"total_energy": 0}, now)
+	bad["total_energy"] = store.Tag{Quality: store.QualityCommFailure}
+	assert.NotContains(t, tr.Track("inverter1", bad), "total_energy_acc")
+
+	states := tr.States()
+	assert.Equal(t, uint64(1), states["inverter1/total_energy"].Wraps)
+	assert.Equal(t, uint64(1), states["inverter1/daily_energy"].Resets)
+	require.NoError(t, tr.Close())
+
+	// Khởi động lại: năng lượng phát trong lúc gateway dừng vẫn được tính
+	tr, err = NewTracker(Config{StatePath: path}, logger)
+	require.NoError(t, err)
+	tags = track(tr, map[string]float64{"total_energy": 10})
+	assert.InDelta(t, 6563.6, tags["total_energy_acc"].Value, 1e-9)
+
+	// Bộ đếm 64 bit của PM2120 bị đặt lại bởi thiết bị
+	meter := func(v float64) float64 {
+		now = now.Add(time.Minute)
+		return tr.Track("meter1", store.Tags(map[string]float64{"active_energy_delivered_wh": v}, now))["active_energy_delivered_wh_acc"].Value
+	}
+	meter(1e6)
+	meter(1.5e6)
+	assert.InDelta(t, 1.5e6+200, meter(200), 1e-9)
+
+	_, err = NewTracker(Config{StatePath: path, Counters: []Counter{{Signal: "e", Modulus: -1}}}, logger)
+	assert.Error(t, err)
+}
+
+// TestTrackerDeviceCounter kiểm tra bộ đếm riêng của thiết bị được ưu tiên hơn bộ đếm chung
+func TestTrackerDeviceCounter(t *testing.T) {
+	counters := append(DefaultCounters(), Counter{Device: "inverter3", Signal: "total_energy", Modulus: 42949672.96})
+	tr, err := NewTracker(Config{StatePath: filepath.Join(t.TempDir(), "counters.json"), Counters: counters}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	now := time.Date(2024, 6, 1, 23, 59, 0, 0, time.UTC)
+
+	for _, device := range []string{"inverter1", "inverter3"} {
+		tr.Track(device, store.Tags(map[string]float64{"total_energy": 5000}, now))
+		tr.Track(device, store.Tags(map[string]float64{"total_energy": 2}, now.Add(2*time.Minute)))
+	}
+
+	states := tr.States()
+	assert.Equal(t, uint64(1), states["inverter1/total_energy"].Wraps, "Bộ đếm chung 16 bit coi là quay vòng")
+	assert.Equal(t, State{Raw: 2, Accumulated: 5002, Time: now.Add(2 * time.Minute), Resets: 1}, states["inverter3/total_energy"], "Bộ đếm 32 bit không quay vòng ở 6553.6")
+}
+
+// TestTrackerDailyReset kiểm tra sản lượng ngày lớn bị đặt lại đầu ngày không bị coi là quay vòng
+func TestTrackerDailyReset(t *testing.T) {
+	tr, err := NewTracker(Config{StatePath: filepath.Join(t.TempDir(), "counters.json")}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	now := time.Date(2024, 6, 1, 23, 59, 0, 0, time.UTC)
+
+	// Với modulus 16 bit, 6000 -> 1 sẽ bị tính là quay vòng thêm 554.6 kWh
+	tr.Track("inverter1", store.Tags(map[string]float64{"daily_energy": 6000}, now))
+	tags := tr.Track("inverter1", store.Tags(map[string]float64{"daily_energy": 1}, now.Add(6*time.Hour)))
+	assert.InDelta(t, 6001, tags["daily_energy_acc"].Value, 1e-9)
+
+	state := tr.States()["inverter1/daily_energy"]
+	assert.Equal(t, uint64(1), state.Resets)
+	assert.Zero(t, state.Wraps)
+}
@@ -0,0 +1,153 @@
+package deadband
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"modbus_inverter/internal/config"
+	"modbus_inverter/internal/store"
+)
+
+// defaultIntegrityPeriod chu kỳ gửi toàn bộ giá trị mặc định
+const defaultIntegrityPeriod = 15 * time.Minute
+
+// Config cấu hình gửi theo ngoại lệ (report by exception) cho các đầu ra gửi lên
+type Config struct {
+	Enabled         bool            `json:"enabled"`
+	Rules           []Rule          `json:"rules"`            // Quy tắc theo thứ tự ưu tiên, quy tắc khớp đầu tiên được dùng
+	IntegrityPeriod config.Duration `json:"integrity_period"` // Chu kỳ gửi toàn bộ giá trị của thiết bị, mặc định 15m
+}
+
+// Rule là ngưỡng thay đổi của các tín hiệu khớp Device và Signal.
+// Giá trị chỉ được gửi khi thay đổi so với giá trị đã gửi vượt quá
+// max(Absolute, Percent% * |giá trị đã gửi|), hoặc đã im lặng quá MaxSilence.
+// Tín hiệu không khớp quy tắc nào được gửi khi có thay đổi bất kỳ.
+type Rule struct {
+	Device     string          `json:"device"`      // Tên thiết bị, bỏ trống: mọi thiết bị
+	Signal     string          `json:"signal"`      // Tên tín hiệu, bỏ trống: mọi tín hiệu
+	Absolute   float64         `json:"absolute"`    // Ngưỡng tuyệt đối, theo đơn vị của tín hiệu
+	Percent    float64         `json:"percent"`     // Ngưỡng theo phần trăm giá trị đã gửi
+	MaxSilence config.Duration `json:"max_silence"` // Thời gian tối đa không gửi, 0: không giới hạn
+}
+
+// matches kiểm tra quy tắc có áp dụng cho tín hiệu của thiết bị không
+func (r *Rule) matches(device, signal string) bool {
+	return (r.Device == "" || r.Device == device) && (r.Signal == "" || r.Signal == signal)
+}
+
+// tagState trạng thái gửi của một tín hiệu
+type tagState struct {
+	rule   *Rule
+	latest float64
+	sent   float64
+	sentAt time.Time
+	ok     bool // Đã gửi ít nhất một lần
+}
+
+// deviceState trạng thái gửi của một thiết bị
+type deviceState struct {
+	tags      map[string]*tagState
+	integrity time.Time // Thời điểm gửi toàn bộ gần nhất
+}
+
+// Filter lọc các giá trị không đổi đáng kể trước khi chuyển cho đầu ra
+type Filter struct {
+	cfg  Config
+	none Rule // Quy tắc cho tín hiệu không khớp quy tắc nào
+
+	mu      sync.Mutex
+	devices map[string]*deviceState
+}
+
+// NewFilter tạo bộ lọc từ cấu hình
+func NewFilter(cfg Config) (*Filter, error) {
+	if cfg.IntegrityPeriod == 0 {
+		cfg.IntegrityPeriod = config.Duration(defaultIntegrityPeriod)
+	}
+	for i, r := range cfg.Rules {
+		if r.Absolute < 0 || r.Percent < 0 || r.MaxSilence < 0 {
+			return nil, fmt.Errorf("quy tắc deadband thứ %d có ngưỡng âm", i+1)
+		}
+	}
+	return &Filter{cfg: cfg, devices: make(map[string]*deviceState)}, nil
+}
+
+// Wrap trả về hàm nhận giá trị từ store, chỉ chuyển cho next các giá trị cần gửi
+func (f *Filter) Wrap(next store.UpdateFunc) store.UpdateFunc {
+	return func(device string, values map[string]float64, ts time.Time) {
+		if out := f.Filter(device, values, ts); len(out) > 0 {
+			next(device, out, ts)
+		}
+	}
+}
+
+// Filter trả về các giá trị cần gửi trong values. Khi tới chu kỳ toàn vẹn,
+// trả về giá trị mới nhất của mọi tín hiệu đã biết của thiết bị.
+func (f *Filter) Filter(device string, values map[string]float64, ts time.Time) map[string]float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dev, ok := f.devices[device]
+	if !ok {
+		dev = &deviceState{tags: make(map[string]*tagState), integrity: ts}
+		f.devices[device] = dev
+	}
+
+	out := make(map[string]float64, len(values))
+	for signal, v := range values {
+		tag, ok := dev.tags[signal]
+		if !ok {
+			tag = &tagState{rule: f.rule(device, signal)}
+			dev.tags[signal] = tag
+		}
+		tag.latest = v
+		if tag.exceeded(v, ts) {
+			out[signal] = v
+		}
+	}
+
+	if p := f.cfg.IntegrityPeriod.Std(); p > 0 && ts.Sub(dev.integrity) >= p {
+		dev.integrity = ts
+		for signal, tag := range dev.tags {
+			out[signal] = tag.latest
+		}
+	}
+
+	for signal, v := range out {
+		tag := dev.tags[signal]
+		tag.sent, tag.sentAt, tag.ok = v, ts, true
+	}
+	return out
+}
+
+// rule trả về quy tắc đầu tiên khớp tín hiệu
+func (f *Filter) rule(device, signal string) *Rule {
+	for i := range f.cfg.Rules {
+		if f.cfg.Rules[i].matches(device, signal) {
+			return &f.cfg.Rules[i]
+		}
+	}
+	return &f.none
+}
+
+// exceeded kiểm tra giá trị mới có cần gửi không
+func (t *tagState) exceeded(v float64, ts time.Time) bool {
+	if !t.ok {
+		return true
+	}
+	if s := t.rule.MaxSilence.Std(); s > 0 && ts.Sub(t.sentAt) >= s {
+		return true
+	}
+	diff := math.Abs(v - t.sent)
+	if math.IsNaN(diff) {
+		// NaN chỉ gửi khi chuyển từ hoặc sang NaN
+		return math.IsNaN(v) != math.IsNaN(t.sent)
+	}
+	threshold := math.Max(t.rule.Absolute, t.rule.Percent/100*math.Abs(t.sent))
+	if threshold == 0 {
+		return diff > 0
+	}
+	return diff > threshold
+}
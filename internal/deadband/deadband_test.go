@@ -0,0 +1,52 @@
+package deadband
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/config"
+)
+
+// TestFilter kiểm tra ngưỡng tuyệt đối, phần trăm, thời gian im lặng và chu kỳ toàn vẹn
+func TestFilter(t *testing.T) {
+	f, err := NewFilter(Config{
+		Rules: []Rule{
+			{Device: "inverter1", Signal: "active_power", Absolute: 0.5, MaxSilence: config.Duration(time.Minute)},
+			{Signal: "voltage", Percent: 1},
+		},
+		IntegrityPeriod: config.Duration(10 * time.Minute),
+	})
+	require.NoError(t, err)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	out := f.Filter("inverter1", map[string]float64{"active_power": 10, "voltage": 230, "device_status": 1}, now)
+	assert.Len(t, out, 3, "Lần đầu gửi mọi giá trị")
+
+	out = f.Filter("inverter1", map[string]float64{"active_power": 10.4, "voltage": 232, "device_status": 1}, now.Add(time.Second))
+	assert.Empty(t, out, "Thay đổi trong ngưỡng")
+
+	out = f.Filter("inverter1", map[string]float64{"active_power": 10.6, "voltage": 232.5, "device_status": 0}, now.Add(2*time.Second))
+	assert.Equal(t, map[string]float64{"active_power": 10.6, "voltage": 232.5, "device_status": 0}, out)
+
+	out = f.Filter("inverter1", map[string]float64{"active_power": 10.6}, now.Add(62*time.Second))
+	assert.Equal(t, map[string]float64{"active_power": 10.6}, out, "Gửi lại khi im lặng quá lâu")
+
+	out = f.Filter("inverter2", map[string]float64{"active_power": 5}, now)
+	require.Len(t, out, 1)
+	out = f.Filter("inverter2", map[string]float64{"active_power": 5.1}, now.Add(time.Second))
+	assert.Len(t, out, 1, "Quy tắc chỉ áp dụng cho inverter1")
+
+	out = f.Filter("inverter1", map[string]float64{"voltage": 232.6}, now.Add(10*time.Minute))
+	assert.Equal(t, map[string]float64{"active_power": 10.6, "voltage": 232.6, "device_status": 0}, out, "Chu kỳ toàn vẹn gửi mọi giá trị")
+
+	var sent []map[string]float64
+	write := f.Wrap(func(_ string, values map[string]float64, _ time.Time) { sent = append(sent, values) })
+	write("inverter1", map[string]float64{"voltage": 232.6}, now.Add(11*time.Minute))
+	assert.Empty(t, sent, "Không gọi đầu ra khi không có gì để gửi")
+
+	_, err = NewFilter(Config{Rules: []Rule{{Absolute: -1}}})
+	assert.Error(t, err)
+}
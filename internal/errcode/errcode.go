@@ -0,0 +1,270 @@
+package errcode
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed dictionaries
+var builtin embed.FS
+
+// Loại bảng mã
+const (
+	KindCode = "code" // Giá trị là một mã
+	KindBits = "bits" // Mỗi bit có ý nghĩa riêng
+)
+
+// Mức độ nghiêm trọng, trùng với mức độ của cảnh báo
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Ngôn ngữ
+const (
+	LangVi = "vi"
+	LangEn = "en"
+)
+
+// Config cấu hình từ điển mã lỗi
+type Config struct {
+	Dir      string `json:"dir"`      // Thư mục chứa file từ điển JSON bổ sung hoặc thay thế từ điển có sẵn
+	Language string `json:"language"` // Ngôn ngữ của cảnh báo và nhật ký: vi (mặc định) hoặc en
+}
+
+// Text là nội dung song ngữ
+type Text struct {
+	Vi string `json:"vi"`
+	En string `json:"en"`
+}
+
+// In trả về nội dung theo ngôn ngữ, dùng tiếng Việt nếu thiếu bản dịch
+func (t Text) In(lang string) string {
+	if lang == LangEn && t.En != "" {
+		return t.En
+	}
+	return t.Vi
+}
+
+// Entry là mô tả của một mã hoặc một bit
+type Entry struct {
+	Message  Text   `json:"message"`
+	Severity string `json:"severity"` // info, warning (mặc định), critical
+	Action   Text   `json:"action"`   // Hướng xử lý khuyến nghị
+}
+
+// Table là bảng mã của một tín hiệu
+type Table struct {
+	Kind          string           `json:"kind"`           // code hoặc bits
+	ReportUnknown bool             `json:"report_unknown"` // Với code: báo mã khác 0 không có trong bảng
+	Entries       map[string]Entry `json:"entries"`        // Khóa là mã (thập phân hoặc 0x..) hoặc số thứ tự bit (0-31)
+
+	entries map[uint32]Entry
+}
+
+// Dictionary là từ điển mã lỗi của một model inverter
+type Dictionary struct {
+	Model   string            `json:"model"`
+	Signals map[string]*Table `json:"signals"` // Bảng theo tên tín hiệu, ví dụ error_code
+}
+
+// Decoded là một mã hoặc bit đã được giải nghĩa
+type Decoded struct {
+	Signal   string `json:"signal"`
+	Code     uint32 `json:"code"` // Mã, hoặc số thứ tự bit với bảng bits
+	Message  Text   `json:"message"`
+	Severity string `json:"severity"`
+	Action   Text   `json:"action"`
+}
+
+// Registry chứa từ điển của các model
+type Registry struct {
+	dicts map[string]*Dictionary
+}
+
+// Builtin trả về registry gồm các từ điển có sẵn
+func Builtin() *Registry {
+	r := &Registry{dicts: make(map[string]*Dictionary)}
+	files, _ := builtin.ReadDir("dictionaries")
+	for _, f := range files {
+		data, err := builtin.ReadFile("dictionaries/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		if err := r.add(data); err != nil {
+			panic(fmt.Sprintf("từ điển có sẵn %s lỗi: %v", f.Name(), err))
+		}
+	}
+	return r
+}
+
+// Load trả về registry gồm các từ điển có sẵn và các file *.json trong dir
+// (nếu dir khác rỗng); từ điển trong dir thay thế từ điển có sẵn cùng model
+func Load(dir string) (*Registry, error) {
+	r := Builtin()
+	if dir == "" {
+		return r, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("đọc từ điển %s lỗi: %w", path, err)
+		}
+		if err := r.add(data); err != nil {
+			return nil, fmt.Errorf("từ điển %s lỗi: %w", path, err)
+		}
+	}
+	return r, nil
+}
+
+// add giải mã, kiểm tra và thêm một từ điển
+func (r *Registry) add(data []byte) error {
+	var d Dictionary
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	if d.Model == "" {
+		return fmt.Errorf("chưa có model")
+	}
+	for signal, t := range d.Signals {
+		if t.Kind != KindCode && t.Kind != KindBits {
+			return fmt.Errorf("tín hiệu %s có loại bảng không hợp lệ %q", signal, t.Kind)
+		}
+		t.entries = make(map[uint32]Entry, len(t.Entries))
+		for key, e := range t.Entries {
+			code, err := strconv.ParseUint(key, 0, 32)
+			if err != nil || (t.Kind == KindBits && code > 31) {
+				return fmt.Errorf("tín hiệu %s có mã không hợp lệ %q", signal, key)
+			}
+			if e.Message.Vi == "" && e.Message.En == "" {
+				return fmt.Errorf("tín hiệu %s mã %s chưa có nội dung", signal, key)
+			}
+			if e.Message.Vi == "" {
+				e.Message.Vi = e.Message.En
+			}
+			switch e.Severity {
+			case "":
+				e.Severity = SeverityWarning
+			case SeverityInfo, SeverityWarning, SeverityCritical:
+			default:
+				return fmt.Errorf("tín hiệu %s mã %s có mức độ không hợp lệ %q", signal, key, e.Severity)
+			}
+			t.entries[uint32(code)] = e
+		}
+	}
+	r.dicts[d.Model] = &d
+	return nil
+}
+
+// Models trả về danh sách model có từ điển
+func (r *Registry) Models() []string {
+	models := make([]string, 0, len(r.dicts))
+	for m := range r.dicts {
+		models = append(models, m)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// Describe giải nghĩa giá trị của một tín hiệu theo từ điển của model;
+// trả về rỗng nếu không có bảng hoặc giá trị bình thường
+func (r *Registry) Describe(model, signal string, value float64) []Decoded {
+	d, ok := r.dicts[model]
+	if !ok {
+		return nil
+	}
+	t, ok := d.Signals[signal]
+	if !ok || math.IsNaN(value) || value < 0 || value > math.MaxUint32 {
+		return nil
+	}
+	v := uint32(value)
+
+	if t.Kind == KindCode {
+		if e, ok := t.entries[v]; ok {
+			return []Decoded{decoded(signal, v, e)}
+		}
+		if t.ReportUnknown && v != 0 {
+			return []Decoded{{
+				Signal:   signal,
+				Code:     v,
+				Message:  Text{Vi: fmt.Sprintf("Mã không xác định %d", v), En: fmt.Sprintf("Unknown code %d", v)},
+				Severity: SeverityWarning,
+			}}
+		}
+		return nil
+	}
+
+	var list []Decoded
+	for bit := uint32(0); bit < 32; bit++ {
+		if v&(1<<bit) == 0 {
+			continue
+		}
+		if e, ok := t.entries[bit]; ok {
+			list = append(list, decoded(signal, bit, e))
+		}
+	}
+	return list
+}
+
+// DecodeAll giải nghĩa mọi tín hiệu có bảng mã trong values, sắp theo tín hiệu và mã
+func (r *Registry) DecodeAll(model string, values map[string]float64) []Decoded {
+	d, ok := r.dicts[model]
+	if !ok {
+		return nil
+	}
+	var list []Decoded
+	for signal := range d.Signals {
+		if v, ok := values[signal]; ok {
+			list = append(list, r.Describe(model, signal, v)...)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Signal != list[j].Signal {
+			return list[i].Signal < list[j].Signal
+		}
+		return list[i].Code < list[j].Code
+	})
+	return list
+}
+
+// Summary gộp các mục đã giải nghĩa thành một nội dung theo ngôn ngữ và mức
+// độ cao nhất
+func Summary(list []Decoded, lang string) (message, severity string) {
+	texts := make([]string, 0, len(list))
+	rank := -1
+	for _, d := range list {
+		texts = append(texts, d.Message.In(lang))
+		if r := severityRank(d.Severity); r > rank {
+			rank, severity = r, d.Severity
+		}
+	}
+	return strings.Join(texts, "; "), severity
+}
+
+// decoded tạo mục đã giải nghĩa từ mô tả trong bảng
+func decoded(signal string, code uint32, e Entry) Decoded {
+	return Decoded{Signal: signal, Code: code, Message: e.Message, Severity: e.Severity, Action: e.Action}
+}
+
+// severityRank thứ tự mức độ nghiêm trọng
+func severityRank(s string) int {
+	switch s {
+	case SeverityCritical:
+		return 2
+	case SeverityWarning:
+		return 1
+	}
+	return 0
+}
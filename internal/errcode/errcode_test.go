@@ -0,0 +1,78 @@
+package errcode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRegistry kiểm tra giải nghĩa mã lỗi và bit trạng thái
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "acme.json"), []byte(`{
+		"model": "acme",
+		"signals": {
+			"error_code": {"kind": "code", "entries": {"0x10": {"message": {"vi": "Mất lưới", "en": "Grid loss"}, "severity": "critical"}}},
+			"status_word": {"kind": "bits", "entries": {
+				"0": {"message": {"en": "Derating"}, "severity": "info"},
+				"3": {"message": {"vi": "Quạt hỏng", "en": "Fan fault"}}
+			}}
+		}
+	}`), 0o644))
+
+	r, err := Load(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"acme", "generic", "huawei_sun2000"}, r.Models())
+
+	d := r.Describe("acme", "error_code", 16)
+	require.Len(t, d, 1)
+	assert.Equal(t, "Mất lưới", d[0].Message.In(LangVi))
+	assert.Equal(t, "Grid loss", d[0].Message.In(LangEn))
+	assert.Empty(t, r.Describe("acme", "error_code", 5), "Không báo mã không có trong bảng")
+
+	d = r.Describe("acme", "status_word", 0x0B)
+	require.Len(t, d, 2)
+	assert.Equal(t, "Derating", d[0].Message.In(LangVi), "Thiếu tiếng Việt thì dùng tiếng Anh")
+	assert.Equal(t, SeverityWarning, d[1].Severity, "Mức độ mặc định")
+	msg, severity := Summary(d, LangEn)
+	assert.Equal(t, "Derating; Fan fault", msg)
+	assert.Equal(t, SeverityWarning, severity)
+
+	// Từ điển có sẵn
+	d = r.Describe("generic", "error_code", 4)
+	require.Len(t, d, 1)
+	assert.Equal(t, SeverityCritical, d[0].Severity)
+	assert.NotEmpty(t, d[0].Action.Vi)
+	d = r.Describe("generic", "error_code", 999)
+	require.Len(t, d, 1)
+	assert.Equal(t, "Unknown code 999", d[0].Message.In(LangEn))
+	d = r.Describe("huawei_sun2000", "alarm_1", 0x0180)
+	require.Len(t, d, 2)
+	assert.Equal(t, "Grid loss (2032)", d[0].Message.In(LangEn))
+	assert.Equal(t, uint32(8), d[1].Code)
+
+	all := r.DecodeAll("generic", map[string]float64{"error_code": 7, "device_status": 0, "active_power": 5})
+	require.Len(t, all, 2)
+	assert.Equal(t, "device_status", all[0].Signal)
+	assert.Empty(t, r.DecodeAll("generic", map[string]float64{"error_code": 0, "device_status": 1}))
+	assert.Empty(t, r.DecodeAll("unknown", map[string]float64{"error_code": 7}))
+}
+
+// TestLoadInvalid kiểm tra từ điển không hợp lệ
+func TestLoadInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"signals": {}}`,
+		`{"model": "x", "signals": {"e": {"kind": "list"}}}`,
+		`{"model": "x", "signals": {"e": {"kind": "bits", "entries": {"32": {"message": {"vi": "a"}}}}}}`,
+		`{"model": "x", "signals": {"e": {"kind": "code", "entries": {"1": {"message": {}}}}}}`,
+		`{"model": "x", "signals": {"e": {"kind": "code", "entries": {"1": {"message": {"vi": "a"}, "severity": "fatal"}}}}}`,
+	} {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "x.json"), []byte(content), 0o644))
+		_, err := Load(dir)
+		assert.Error(t, err, content)
+	}
+}
@@ -0,0 +1,129 @@
+// Package expr tính biểu thức trên giá trị tag của các thiết bị, dùng cho
+// thiết bị ảo và tag tính toán.
+//
+// Cú pháp:
+//
+//	inverter1.active_power            tag của thiết bị
+//	active_power                      tag của thiết bị đang tính (thiết bị mặc định)
+//	sum(inverter*.active_power)       hàm gộp trên mọi thiết bị khớp mẫu (path.Match)
+//	+ - * / ( )                       phép tính số học
+//	abs(x) sqrt(x) hypot(x, y)        hàm một giá trị
+//	sum avg min max count             hàm gộp, bỏ qua giá trị không dùng được
+//	wavg(x, w)                        trung bình của x theo trọng số w, ghép theo thiết bị
+//
+// Chất lượng của kết quả là chất lượng xấu nhất của các giá trị dùng trong phép
+// tính. Hàm gộp bỏ qua giá trị không dùng được: kết quả là thay thế nếu thiếu
+// một phần, mất liên lạc nếu không còn giá trị nào.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"modbus_inverter/internal/store"
+)
+
+// Env cung cấp giá trị tag cho biểu thức
+type Env interface {
+	// Tag trả về tag của thiết bị, false nếu không có
+	Tag(device, signal string) (store.Tag, bool)
+	// Devices trả về tên các thiết bị khớp mẫu, sắp theo tên
+	Devices(pattern string) []string
+}
+
+// Expr là biểu thức đã phân tích
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse phân tích biểu thức
+func Parse(src string) (*Expr, error) {
+	p := &parser{lex: lexer{src: src}}
+	p.next()
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("biểu thức %q: %w", src, err)
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("biểu thức %q: thừa %q ở vị trí %d", src, p.tok.text, p.tok.pos)
+	}
+	if err := checkPatterns(root, false); err != nil {
+		return nil, fmt.Errorf("biểu thức %q: %w", src, err)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String trả về biểu thức gốc
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Refs trả về các tham chiếu trong biểu thức dạng thiết bị.tín hiệu (thiết bị
+// có thể là mẫu hoặc rỗng nếu là thiết bị mặc định)
+func (e *Expr) Refs() []string {
+	var refs []string
+	walk(e.root, func(n node) {
+		if r, ok := n.(*refNode); ok {
+			refs = append(refs, r.device+"."+r.signal)
+		}
+	})
+	return refs
+}
+
+// Eval tính biểu thức, device là thiết bị mặc định của tham chiếu không ghi thiết bị.
+// Tag trả về có Source là nhãn thời gian nguồn mới nhất của các giá trị đã dùng,
+// Acquired để trống cho nơi gọi điền.
+func (e *Expr) Eval(env Env, device string) store.Tag {
+	v := e.root.eval(&context{env: env, device: device})
+	if v.quality == store.QualityGood && (math.IsNaN(v.value) || math.IsInf(v.value, 0)) {
+		v.quality = store.QualityOutOfRange
+	}
+	return store.Tag{Value: v.value, Quality: v.quality, Source: v.source}
+}
+
+// context là ngữ cảnh khi tính
+type context struct {
+	env    Env
+	device string
+}
+
+// value là kết quả trung gian
+type value struct {
+	value   float64
+	quality store.Quality
+	source  time.Time
+}
+
+// combine gộp chất lượng và nhãn thời gian của hai giá trị
+func combine(v float64, a, b value) value {
+	r := value{value: v, quality: worst(a.quality, b.quality), source: a.source}
+	if b.source.After(r.source) {
+		r.source = b.source
+	}
+	return r
+}
+
+// worst trả về chất lượng xấu hơn
+func worst(a, b store.Quality) store.Quality {
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// rank thứ tự chất lượng từ tốt tới xấu
+func rank(q store.Quality) int {
+	switch q {
+	case store.QualityGood:
+		return 0
+	case store.QualitySubstituted:
+		return 1
+	case store.QualityStale:
+		return 2
+	case store.QualityOutOfRange:
+		return 3
+	}
+	return 4
+}
@@ -0,0 +1,119 @@
+package expr
+
+import (
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/store"
+)
+
+// mapEnv là Env đơn giản cho kiểm thử
+type mapEnv map[string]map[string]store.Tag
+
+func (m mapEnv) Tag(device, signal string) (store.Tag, bool) {
+	tag, ok := m[device][signal]
+	return tag, ok
+}
+
+func (m mapEnv) Devices(pattern string) []string {
+	var names []string
+	for name := range m {
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestEval kiểm tra phép tính, hàm gộp và lan truyền chất lượng
+func TestEval(t *testing.T) {
+	ts := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	good := func(v float64) store.Tag {
+		return store.Tag{Value: v, Quality: store.QualityGood, Source: ts}
+	}
+	env := mapEnv{
+		"inverter1": {"active_power": good(10), "power_factor": good(0.9), "voltage": good(400)},
+		"inverter2": {"active_power": good(30), "power_factor": good(1), "voltage": good(410)},
+		"meter1":    {"active_power_total": good(38)},
+	}
+	eval := func(src string) store.Tag {
+		x, err := Parse(src)
+		require.NoError(t, err, src)
+		return x.Eval(env, "inverter1")
+	}
+
+	for src, want := range map[string]float64{
+		"1 + 2 * 3":                       7,
+		"(1 + 2) * 3":                     9,
+		"-2 * -3":                         6,
+		"1.5e3 / 3":                       500,
+		"inverter1.active_power*2":        20,
+		"active_power - 1":                9,
+		"sum(inverter*.active_power)":     40,
+		"avg(inverter*.voltage)":          405,
+		"max(inverter*.active_power)":     30,
+		"min(inverter*.active_power)":     10,
+		"count(inverter*.voltage)":        2,
+		"hypot(3, 4) + sqrt(4) + abs(-1)": 8,
+		"wavg(inverter*.power_factor, inverter*.active_power)":    0.975,
+		"sum(inverter*.active_power) - meter1.active_power_total": 2,
+		"sum(inverter1.active_power, inverter2.active_power, 5)":  45,
+	} {
+		tag := eval(src)
+		assert.InDelta(t, want, tag.Value, 1e-9, src)
+		assert.Equal(t, store.QualityGood, tag.Quality, src)
+	}
+	assert.Equal(t, ts, eval("sum(inverter*.active_power)").Source)
+	assert.Equal(t, store.QualityOutOfRange, eval("1 / 0").Quality)
+
+	t.Run("Thiếu thiết bị", func(t *testing.T) {
+		env["inverter3"] = map[string]store.Tag{"active_power": {Value: 50, Quality: store.QualityCommFailure}}
+		defer delete(env, "inverter3")
+
+		tag := eval("sum(inverter*.active_power)")
+		assert.InDelta(t, 40, tag.Value, 1e-9, "Bỏ qua thiết bị mất liên lạc")
+		assert.Equal(t, store.QualitySubstituted, tag.Quality)
+		assert.InDelta(t, 2, eval("count(inverter*.active_power)").Value, 1e-9)
+
+		assert.Equal(t, store.QualityCommFailure, eval("inverter3.active_power + 1").Quality)
+		assert.Equal(t, store.QualityCommFailure, eval("inverter9.active_power").Quality, "Không có tag")
+		assert.Equal(t, store.QualityCommFailure, eval("sum(pv*.active_power)").Quality, "Không khớp thiết bị nào")
+	})
+
+	t.Run("Trọng số bằng 0", func(t *testing.T) {
+		env["inverter1"]["active_power"] = good(0)
+		env["inverter2"]["active_power"] = good(0)
+		assert.InDelta(t, 0.95, eval("wavg(inverter*.power_factor, inverter*.active_power)").Value, 1e-9)
+	})
+}
+
+// TestParse kiểm tra lỗi cú pháp và tham chiếu
+func TestParse(t *testing.T) {
+	x, err := Parse("sum(inverter*.active_power) / plant.rated + p")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"inverter*.active_power", "plant.rated", ".p"}, x.Refs())
+
+	for _, src := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"foo(1)",
+		"hypot(1)",
+		"sum()",
+		"inverter*.active_power",
+		"abs(inverter*.active_power)",
+		"sum(inverter*.active_power * 2)",
+		"inverter1.*",
+		"1 $ 2",
+		"1 2",
+	} {
+		_, err := Parse(src)
+		assert.Error(t, err, src)
+	}
+}
@@ -0,0 +1,307 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+
+	"modbus_inverter/internal/store"
+)
+
+// node là một nút của cây biểu thức
+type node interface {
+	eval(ctx *context) value
+}
+
+// numberNode là hằng số
+type numberNode struct {
+	v float64
+}
+
+func (n *numberNode) eval(*context) value {
+	return value{value: n.v, quality: store.QualityGood}
+}
+
+// refNode là tham chiếu tới tag, pattern khi tên thiết bị là mẫu
+type refNode struct {
+	device  string
+	signal  string
+	pattern bool
+}
+
+func (n *refNode) eval(ctx *context) value {
+	device := n.device
+	if device == "" {
+		device = ctx.device
+	}
+	return lookup(ctx, device, n.signal)
+}
+
+// items trả về giá trị của tham chiếu, với mẫu là mọi thiết bị khớp
+func (n *refNode) items(ctx *context) []item {
+	if !n.pattern {
+		device := n.device
+		if device == "" {
+			device = ctx.device
+		}
+		return []item{{device: device, value: lookup(ctx, device, n.signal)}}
+	}
+	var items []item
+	for _, device := range ctx.env.Devices(n.device) {
+		items = append(items, item{device: device, value: lookup(ctx, device, n.signal)})
+	}
+	return items
+}
+
+// lookup đọc tag, không có tag được coi là mất liên lạc
+func lookup(ctx *context, device, signal string) value {
+	tag, ok := ctx.env.Tag(device, signal)
+	if !ok {
+		return value{value: math.NaN(), quality: store.QualityCommFailure}
+	}
+	return value{value: tag.Value, quality: tag.Quality, source: tag.Source}
+}
+
+// negNode là phép đổi dấu
+type negNode struct {
+	n node
+}
+
+func (n *negNode) eval(ctx *context) value {
+	v := n.n.eval(ctx)
+	v.value = -v.value
+	return v
+}
+
+// binaryNode là phép tính hai ngôi
+type binaryNode struct {
+	op          byte
+	left, right node
+}
+
+func (n *binaryNode) eval(ctx *context) value {
+	a, b := n.left.eval(ctx), n.right.eval(ctx)
+	var v float64
+	switch n.op {
+	case '+':
+		v = a.value + b.value
+	case '-':
+		v = a.value - b.value
+	case '*':
+		v = a.value * b.value
+	case '/':
+		v = a.value / b.value
+	}
+	return combine(v, a, b)
+}
+
+// item là một giá trị đầu vào của hàm gộp
+type item struct {
+	device string
+	value  value
+}
+
+// function mô tả một hàm, hàm gộp có aggregate, hàm thường có scalar
+type function struct {
+	args      int // Số đối số, 0: không giới hạn
+	scalar    func(args []float64) float64
+	aggregate func(args [][]item) value
+}
+
+// functions là các hàm hỗ trợ
+var functions = map[string]function{
+	"abs":   {args: 1, scalar: func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {args: 1, scalar: func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"hypot": {args: 2, scalar: func(a []float64) float64 { return math.Hypot(a[0], a[1]) }},
+	"sum": {aggregate: reduce(func(vs []float64) float64 {
+		s := 0.0
+		for _, v := range vs {
+			s += v
+		}
+		return s
+	})},
+	"avg": {aggregate: reduce(func(vs []float64) float64 {
+		s := 0.0
+		for _, v := range vs {
+			s += v
+		}
+		return s / float64(len(vs))
+	})},
+	"min": {aggregate: reduce(func(vs []float64) float64 {
+		m := vs[0]
+		for _, v := range vs[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	})},
+	"max": {aggregate: reduce(func(vs []float64) float64 {
+		m := vs[0]
+		for _, v := range vs[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	})},
+	"count": {aggregate: count},
+	"wavg":  {args: 2, aggregate: wavg},
+}
+
+// callNode là lời gọi hàm
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *callNode) eval(ctx *context) value {
+	if n.fn.aggregate != nil {
+		args := make([][]item, len(n.args))
+		for i, arg := range n.args {
+			if r, ok := arg.(*refNode); ok {
+				args[i] = r.items(ctx)
+			} else {
+				args[i] = []item{{value: arg.eval(ctx)}}
+			}
+		}
+		return n.fn.aggregate(args)
+	}
+
+	r := value{quality: store.QualityGood}
+	vs := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v := arg.eval(ctx)
+		vs[i] = v.value
+		r = combine(0, r, v)
+	}
+	r.value = n.fn.scalar(vs)
+	return r
+}
+
+// gather gộp các giá trị dùng được; thiếu một phần cho chất lượng thay thế,
+// không còn giá trị nào cho mất liên lạc
+func gather(items []item) ([]float64, value) {
+	r := value{quality: store.QualityGood}
+	var vs []float64
+	for _, it := range items {
+		if !it.value.quality.Usable() {
+			r.quality = worst(r.quality, store.QualitySubstituted)
+			continue
+		}
+		vs = append(vs, it.value.value)
+		r = combine(0, r, it.value)
+	}
+	if len(vs) == 0 {
+		r.quality = store.QualityCommFailure
+	}
+	return vs, r
+}
+
+// reduce tạo hàm gộp từ phép tính trên các giá trị dùng được
+func reduce(f func(vs []float64) float64) func(args [][]item) value {
+	return func(args [][]item) value {
+		var all []item
+		for _, a := range args {
+			all = append(all, a...)
+		}
+		vs, r := gather(all)
+		if len(vs) == 0 {
+			r.value = math.NaN()
+			return r
+		}
+		r.value = f(vs)
+		return r
+	}
+}
+
+// count đếm số giá trị dùng được
+func count(args [][]item) value {
+	var all []item
+	for _, a := range args {
+		all = append(all, a...)
+	}
+	vs, r := gather(all)
+	r.value = float64(len(vs))
+	r.quality = store.QualityGood
+	return r
+}
+
+// wavg tính trung bình của đối số thứ nhất theo trọng số là đối số thứ hai,
+// ghép theo thiết bị. Tổng trọng số bằng 0 (ví dụ ban đêm khi trọng số là công
+// suất) thì lấy trung bình thường.
+func wavg(args [][]item) value {
+	weights := make(map[string]value, len(args[1]))
+	for _, w := range args[1] {
+		weights[w.device] = w.value
+	}
+	pairs := make([]item, 0, len(args[0]))
+	for _, x := range args[0] {
+		w, ok := weights[x.device]
+		if !ok {
+			w = value{quality: store.QualityCommFailure}
+		}
+		p := combine(x.value.value*w.value, x.value, w)
+		pairs = append(pairs, item{device: x.device, value: p})
+	}
+	_, r := gather(pairs)
+	if r.quality == store.QualityCommFailure {
+		r.value = math.NaN()
+		return r
+	}
+
+	var sum, sumW, plain float64
+	n := 0
+	for i, p := range pairs {
+		if !p.value.quality.Usable() {
+			continue
+		}
+		x := args[0][i].value.value
+		sum += p.value.value
+		sumW += weights[args[0][i].device].value
+		plain += x
+		n++
+	}
+	if sumW == 0 {
+		r.value = plain / float64(n)
+	} else {
+		r.value = sum / sumW
+	}
+	return r
+}
+
+// walk duyệt cây biểu thức
+func walk(n node, f func(node)) {
+	f(n)
+	switch n := n.(type) {
+	case *negNode:
+		walk(n.n, f)
+	case *binaryNode:
+		walk(n.left, f)
+		walk(n.right, f)
+	case *callNode:
+		for _, arg := range n.args {
+			walk(arg, f)
+		}
+	}
+}
+
+// checkPatterns kiểm tra mẫu thiết bị chỉ là đối số trực tiếp của hàm gộp
+func checkPatterns(n node, allowed bool) error {
+	switch n := n.(type) {
+	case *refNode:
+		if n.pattern && !allowed {
+			return fmt.Errorf("mẫu %s.%s chỉ dùng làm đối số của hàm gộp", n.device, n.signal)
+		}
+	case *negNode:
+		return checkPatterns(n.n, false)
+	case *binaryNode:
+		if err := checkPatterns(n.left, false); err != nil {
+			return err
+		}
+		return checkPatterns(n.right, false)
+	case *callNode:
+		for _, arg := range n.args {
+			if err := checkPatterns(arg, n.fn.aggregate != nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Loại token
+const (
+	tokEOF = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+// token là một phần tử của biểu thức
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// lexer tách biểu thức thành token
+type lexer struct {
+	src string
+	pos int
+}
+
+// isName kiểm tra ký tự thuộc tên thiết bị hoặc tín hiệu
+func isName(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// patternAt kiểm tra ký tự đại diện '*' hoặc '?' ở vị trí i thuộc mẫu tên thiết
+// bị, tức là sau nó (qua các ký tự tên và ký tự đại diện) là dấu '.'; nếu không,
+// '*' là phép nhân
+func (l *lexer) patternAt(i int) bool {
+	for ; i < len(l.src); i++ {
+		c := l.src[i]
+		if c == '.' {
+			return true
+		}
+		if !isName(c) && c != '*' && c != '?' {
+			return false
+		}
+	}
+	return false
+}
+
+// next trả về token tiếp theo
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t') {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case isName(c) && !(c >= '0' && c <= '9'), (c == '*' || c == '?') && l.patternAt(l.pos):
+		signal := false // Đã qua dấu '.', phần còn lại là tên tín hiệu
+		for l.pos < len(l.src) {
+			c := l.src[l.pos]
+			switch {
+			case isName(c):
+			case c == '.':
+				signal = true
+			case (c == '*' || c == '?') && !signal && l.patternAt(l.pos):
+			default:
+				return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+			}
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:], pos: start}, nil
+	case strings.IndexByte("+-*/(),", c) >= 0:
+		l.pos++
+		return token{kind: tokOp, text: string(c), pos: start}, nil
+	case c >= '0' && c <= '9' || c == '.':
+		for l.pos < len(l.src) {
+			c := l.src[l.pos]
+			if c >= '0' && c <= '9' || c == '.' {
+				l.pos++
+				continue
+			}
+			// Số mũ 1e3, 1e-3
+			if (c == 'e' || c == 'E') && l.pos+1 < len(l.src) {
+				l.pos++
+				if s := l.src[l.pos]; s == '+' || s == '-' {
+					l.pos++
+				}
+				continue
+			}
+			break
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}, nil
+	}
+	return token{}, fmt.Errorf("ký tự không hợp lệ %q ở vị trí %d", c, start)
+}
+
+// parser phân tích biểu thức theo đệ quy xuống
+type parser struct {
+	lex lexer
+	tok token
+	err error
+}
+
+// next đọc token tiếp theo, lỗi được giữ tới khi phân tích xong
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+	if p.err != nil {
+		p.tok = token{kind: tokEOF, pos: p.lex.pos}
+	}
+}
+
+// isOp kiểm tra token hiện tại là toán tử op
+func (p *parser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+// expect đọc qua toán tử op
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.unexpected(fmt.Sprintf("cần %q", op))
+	}
+	p.next()
+	return nil
+}
+
+// unexpected tạo lỗi token không mong đợi
+func (p *parser) unexpected(want string) error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("%s nhưng hết biểu thức", want)
+	}
+	return fmt.Errorf("%s ở vị trí %d, gặp %q", want, p.tok.pos, p.tok.text)
+}
+
+// parseExpr: term (('+' | '-') term)*
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+") || p.isOp("-") {
+		op := p.tok.text[0]
+		p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, p.err
+}
+
+// parseTerm: unary (('*' | '/') unary)*
+func (p *parser) parseTerm() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*") || p.isOp("/") {
+		op := p.tok.text[0]
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary: '-' unary | primary
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("-") {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negNode{n: n}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary: số | tham chiếu | hàm '(' đối số ')' | '(' expr ')'
+func (p *parser) parsePrimary() (node, error) {
+	switch {
+	case p.tok.kind == tokNumber:
+		v, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("số không hợp lệ %q ở vị trí %d", p.tok.text, p.tok.pos)
+		}
+		p.next()
+		return &numberNode{v: v}, nil
+	case p.isOp("("):
+		p.next()
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case p.tok.kind == tokIdent:
+		tok := p.tok
+		p.next()
+		if p.isOp("(") {
+			return p.parseCall(tok)
+		}
+		return parseRef(tok)
+	}
+	return nil, p.unexpected("cần số, tín hiệu hoặc hàm")
+}
+
+// parseCall phân tích lời gọi hàm sau tên hàm
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("hàm không hỗ trợ %q ở vị trí %d", name.text, name.pos)
+	}
+	p.next()
+	var args []node
+	for !p.isOp(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+
+	if fn.args > 0 && len(args) != fn.args {
+		return nil, fmt.Errorf("hàm %s cần %d đối số", name.text, fn.args)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("hàm %s chưa có đối số", name.text)
+	}
+	return &callNode{name: name.text, fn: fn, args: args}, nil
+}
+
+// parseRef phân tích tham chiếu thiết bị.tín hiệu hoặc tín hiệu
+func parseRef(tok token) (node, error) {
+	device, signal := "", tok.text
+	if i := strings.LastIndexByte(tok.text, '.'); i >= 0 {
+		device, signal = tok.text[:i], tok.text[i+1:]
+		if device == "" {
+			return nil, fmt.Errorf("tham chiếu %q thiếu tên thiết bị", tok.text)
+		}
+	}
+	if signal == "" || strings.ContainsAny(signal, "*?") {
+		return nil, fmt.Errorf("tham chiếu %q có tên tín hiệu không hợp lệ", tok.text)
+	}
+	return &refNode{device: device, signal: signal, pattern: strings.ContainsAny(device, "*?")}, nil
+}
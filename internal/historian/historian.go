@@ -0,0 +1,331 @@
+package historian
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"modbus_inverter/internal/config"
+)
+
+// Độ phân giải dữ liệu lịch sử
+const (
+	ResolutionRaw  = "raw"  // Giá trị gốc theo từng chu kỳ đọc
+	Resolution1m   = "1m"   // Tổng hợp 1 phút
+	Resolution15m  = "15m"  // Tổng hợp 15 phút
+	Resolution1h   = "1h"   // Tổng hợp 1 giờ
+	ResolutionAuto = "auto" // Chọn theo độ dài khoảng thời gian truy vấn
+)
+
+// rollups các mức tổng hợp theo thứ tự, mỗi mức được tính từ mức liền trước
+var rollups = []struct {
+	name string
+	step time.Duration
+}{
+	{Resolution1m, time.Minute},
+	{Resolution15m, 15 * time.Minute},
+	{Resolution1h, time.Hour},
+}
+
+// defaultRetention thời gian lưu mặc định theo độ phân giải, 0: lưu vĩnh viễn
+var defaultRetention = map[string]time.Duration{
+	ResolutionRaw: 7 * 24 * time.Hour,
+	Resolution1m:  30 * 24 * time.Hour,
+	Resolution15m: 365 * 24 * time.Hour,
+	Resolution1h:  0,
+}
+
+// seriesCleanupInterval khoảng cách tối thiểu giữa hai lần xóa chuỗi dữ liệu không còn bản ghi
+const seriesCleanupInterval = time.Hour
+
+// lateness thời gian chờ dữ liệu đến muộn trước khi tổng hợp một khoảng
+const lateness = 30 * time.Second
+
+const schema = `
+CREATE TABLE IF NOT EXISTS series (
+	id     INTEGER PRIMARY KEY,
+	device TEXT NOT NULL,
+	signal TEXT NOT NULL,
+	UNIQUE (device, signal)
+);
+CREATE TABLE IF NOT EXISTS raw (
+	series INTEGER NOT NULL,
+	ts     INTEGER NOT NULL,
+	value  REAL NOT NULL,
+	PRIMARY KEY (series, ts)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS raw_ts ON raw (ts);
+CREATE TABLE IF NOT EXISTS rollup (
+	step   INTEGER NOT NULL,
+	series INTEGER NOT NULL,
+	ts     INTEGER NOT NULL,
+	min    REAL NOT NULL,
+	max    REAL NOT NULL,
+	avg    REAL NOT NULL,
+	last   REAL NOT NULL,
+	count  INTEGER NOT NULL,
+	PRIMARY KEY (step, series, ts)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS rollup_ts ON rollup (step, ts);
+CREATE INDEX IF NOT EXISTS rollup_series ON rollup (series);
+CREATE TABLE IF NOT EXISTS watermark (
+	step INTEGER PRIMARY KEY,
+	ts   INTEGER NOT NULL
+);
+`
+
+// Config cấu hình bộ lưu trữ lịch sử
+type Config struct {
+	Enabled             bool                       `json:"enabled"`
+	Path                string                     `json:"path"`                 // File SQLite, mặc định data/historian.db
+	FlushInterval       config.Duration            `json:"flush_interval"`       // Chu kỳ ghi xuống đĩa, mặc định 10s
+	MaintenanceInterval config.Duration            `json:"maintenance_interval"` // Chu kỳ tổng hợp và xóa dữ liệu hết hạn, mặc định 1m
+	Retention           map[string]config.Duration `json:"retention"`            // Thời gian lưu theo độ phân giải (raw, 1m, 15m, 1h), 0: lưu vĩnh viễn
+}
+
+// sample là một giá trị chờ ghi xuống đĩa
+type sample struct {
+	device, signal string
+	ts             int64 // Unix milli giây
+	value          float64
+}
+
+// Historian ghi mọi giá trị đọc được vào SQLite, tổng hợp theo 1 phút,
+// 15 phút, 1 giờ (min/max/avg/last) và xóa dữ liệu quá thời gian lưu
+type Historian struct {
+	cfg       Config
+	db        *sql.DB
+	logger    *log.Logger
+	retention map[string]time.Duration
+
+	mu      sync.Mutex
+	pending []sample
+
+	writeMu     sync.Mutex          // Tuần tự hóa ghi và bảo trì
+	series      map[[2]string]int64 // Bộ nhớ đệm id chuỗi dữ liệu
+	expired     bool                // Đã xóa dữ liệu hết hạn từ lần dọn chuỗi trước
+	lastCleanup time.Time           // Thời điểm dọn chuỗi dữ liệu gần nhất
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open mở (hoặc tạo) cơ sở dữ liệu lịch sử
+func Open(cfg Config, logger *log.Logger) (*Historian, error) {
+	if cfg.Path == "" {
+		cfg.Path = "data/historian.db"
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = config.Duration(10 * time.Second)
+	}
+	if cfg.MaintenanceInterval <= 0 {
+		cfg.MaintenanceInterval = config.Duration(time.Minute)
+	}
+	retention := make(map[string]time.Duration, len(defaultRetention))
+	for name, d := range defaultRetention {
+		retention[name] = d
+	}
+	for name, d := range cfg.Retention {
+		if _, ok := retention[name]; !ok {
+			return nil, fmt.Errorf("độ phân giải không hợp lệ trong retention: %q", name)
+		}
+		retention[name] = d.Std()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("tạo thư mục lịch sử lỗi: %w", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+cfg.Path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, fmt.Errorf("mở %s lỗi: %w", cfg.Path, err)
+	}
+	// SQLite chỉ cho một kết nối ghi, dùng một kết nối để tránh lỗi khóa
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("khởi tạo cơ sở dữ liệu %s lỗi: %w", cfg.Path, err)
+	}
+
+	return &Historian{
+		cfg:       cfg,
+		db:        db,
+		logger:    logger,
+		retention: retention,
+		series:    make(map[[2]string]int64),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// OpenReadOnly mở cơ sở dữ liệu lịch sử đã có chỉ để truy vấn: không tạo thư
+// mục, không khởi tạo bảng và không ghi; Write, Flush, Maintain không được dùng
+func OpenReadOnly(path string, logger *log.Logger) (*Historian, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("mở %s lỗi: %w", path, err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("mở %s lỗi: %w", path, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("mở %s lỗi: %w", path, err)
+	}
+	return &Historian{
+		cfg:    Config{Path: path},
+		db:     db,
+		logger: logger,
+		series: make(map[[2]string]int64),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Write thêm các giá trị vừa đọc của một thiết bị vào hàng chờ ghi
+func (h *Historian) Write(device string, values map[string]float64, ts time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for signal, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		h.pending = append(h.pending, sample{device: device, signal: signal, ts: ts.UnixMilli(), value: v})
+	}
+}
+
+// Start bắt đầu ghi và bảo trì theo chu kỳ
+func (h *Historian) Start() {
+	h.wg.Add(1)
+	go h.loop()
+	h.logger.Printf("Lịch sử: lưu tại %s", h.cfg.Path)
+}
+
+// Close ghi nốt dữ liệu còn lại và đóng cơ sở dữ liệu
+func (h *Historian) Close() error {
+	close(h.done)
+	h.wg.Wait()
+	if err := h.Flush(); err != nil {
+		h.logger.Printf("Lịch sử: %v", err)
+	}
+	return h.db.Close()
+}
+
+// loop ghi dữ liệu và bảo trì theo chu kỳ
+func (h *Historian) loop() {
+	defer h.wg.Done()
+	flush := time.NewTicker(h.cfg.FlushInterval.Std())
+	defer flush.Stop()
+	maintain := time.NewTicker(h.cfg.MaintenanceInterval.Std())
+	defer maintain.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-flush.C:
+			if err := h.Flush(); err != nil {
+				h.logger.Printf("Lịch sử: %v", err)
+			}
+		case now := <-maintain.C:
+			if err := h.Maintain(now); err != nil {
+				h.logger.Printf("Lịch sử: %v", err)
+			}
+		}
+	}
+}
+
+// Flush ghi các giá trị đang chờ xuống đĩa trong một giao dịch
+func (h *Historian) Flush() error {
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = nil
+	h.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	err := h.tx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("INSERT OR REPLACE INTO raw (series, ts, value) VALUES (?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, s := range pending {
+			id, err := h.seriesID(tx, s.device, s.signal)
+			if err != nil {
+				return err
+			}
+			if _, err := stmt.Exec(id, s.ts, s.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Xóa bộ nhớ đệm vì id mới tạo trong giao dịch lỗi đã bị hủy
+		h.series = make(map[[2]string]int64)
+		return fmt.Errorf("ghi %d giá trị lỗi: %w", len(pending), err)
+	}
+	return nil
+}
+
+// Maintain ghi dữ liệu đang chờ, tổng hợp các khoảng đã kết thúc trước now
+// và xóa dữ liệu quá thời gian lưu
+func (h *Historian) Maintain(now time.Time) error {
+	if err := h.Flush(); err != nil {
+		return err
+	}
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	source := ""
+	for _, r := range rollups {
+		if err := h.rollup(source, r.step, now); err != nil {
+			return fmt.Errorf("tổng hợp %s lỗi: %w", r.name, err)
+		}
+		source = r.name
+	}
+	if err := h.prune(now); err != nil {
+		return fmt.Errorf("xóa dữ liệu hết hạn lỗi: %w", err)
+	}
+	return nil
+}
+
+// seriesID trả về id của chuỗi dữ liệu, tạo mới nếu chưa có
+func (h *Historian) seriesID(tx *sql.Tx, device, signal string) (int64, error) {
+	key := [2]string{device, signal}
+	if id, ok := h.series[key]; ok {
+		return id, nil
+	}
+	if _, err := tx.Exec("INSERT OR IGNORE INTO series (device, signal) VALUES (?, ?)", device, signal); err != nil {
+		return 0, err
+	}
+	var id int64
+	if err := tx.QueryRow("SELECT id FROM series WHERE device = ? AND signal = ?", device, signal).Scan(&id); err != nil {
+		return 0, err
+	}
+	h.series[key] = id
+	return id, nil
+}
+
+// tx chạy fn trong một giao dịch
+func (h *Historian) tx(fn func(tx *sql.Tx) error) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
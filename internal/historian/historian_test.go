@@ -0,0 +1,166 @@
+package historian
+
+import (
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/config"
+)
+
+func openTest(t *testing.T, retention map[string]config.Duration) *Historian {
+	h, err := Open(Config{
+		Path:      filepath.Join(t.TempDir(), "historian.db"),
+		Retention: retention,
+	}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// TestHistorian kiểm tra ghi, tổng hợp và truy vấn dữ liệu lịch sử
+func TestHistorian(t *testing.T) {
+	h := openTest(t, nil)
+
+	// Hai giờ dữ liệu, mỗi 10 giây một giá trị; công suất tăng dần theo phút
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 720; i++ {
+		ts := start.Add(time.Duration(i) * 10 * time.Second)
+		h.Write("inv1", map[string]float64{
+			"active_power": float64(i / 6),
+			"total_energy": 1000 + float64(i),
+			"bad":          math.NaN(),
+		}, ts)
+	}
+	end := start.Add(2 * time.Hour)
+	require.NoError(t, h.Maintain(end.Add(time.Minute)))
+
+	raw, err := h.Query("inv1", "active_power", start, start.Add(time.Minute), ResolutionRaw)
+	require.NoError(t, err)
+	assert.Len(t, raw, 6)
+
+	minute, err := h.Query("inv1", "active_power", start, end, Resolution1m)
+	require.NoError(t, err)
+	require.Len(t, minute, 120)
+	assert.Equal(t, Point{Time: start.Add(5 * time.Minute).Local(), Min: 5, Max: 5, Avg: 5, Last: 5, Count: 6}, minute[5])
+
+	quarter, err := h.Query("inv1", "active_power", start, end, Resolution15m)
+	require.NoError(t, err)
+	require.Len(t, quarter, 8)
+	assert.Equal(t, 0.0, quarter[0].Min)
+	assert.Equal(t, 14.0, quarter[0].Max)
+	assert.InDelta(t, 7, quarter[0].Avg, 1e-9)
+	assert.Equal(t, int64(90), quarter[0].Count)
+
+	hour, err := h.Query("inv1", "total_energy", start, end, Resolution1h)
+	require.NoError(t, err)
+	require.Len(t, hour, 2)
+	assert.Equal(t, 1359.0, hour[0].Last, "Giá trị cuối cùng của giờ đầu")
+	assert.Equal(t, 1719.0, hour[1].Last)
+
+	series, err := h.Series()
+	require.NoError(t, err)
+	assert.Equal(t, []Series{{"inv1", "active_power"}, {"inv1", "total_energy"}}, series)
+
+	// Tổng hợp lại không làm thay đổi kết quả
+	require.NoError(t, h.Maintain(end.Add(2*time.Minute)))
+	again, err := h.Query("inv1", "active_power", start, end, Resolution15m)
+	require.NoError(t, err)
+	assert.Equal(t, quarter, again)
+
+	_, err = h.Query("inv1", "active_power", start, end, "5m")
+	assert.Error(t, err)
+}
+
+// TestOpenReadOnly kiểm tra mở chỉ đọc truy vấn được nhưng không ghi, không tạo file
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "historian.db")
+	h, err := Open(Config{Path: path}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	h.Write("inv1", map[string]float64{"active_power": 5}, start)
+	require.NoError(t, h.Close())
+
+	ro, err := OpenReadOnly(path, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	defer ro.Close()
+	points, err := ro.Query("inv1", "active_power", start, start.Add(time.Minute), ResolutionRaw)
+	require.NoError(t, err)
+	assert.Len(t, points, 1)
+
+	ro.Write("inv1", map[string]float64{"active_power": 6}, start.Add(time.Second))
+	assert.Error(t, ro.Flush(), "Không được ghi khi mở chỉ đọc")
+
+	missing := filepath.Join(t.TempDir(), "khong_co", "historian.db")
+	_, err = OpenReadOnly(missing, log.New(io.Discard, "", 0))
+	assert.Error(t, err)
+	assert.NoDirExists(t, filepath.Dir(missing))
+}
+
+// TestRetention kiểm tra xóa dữ liệu quá thời gian lưu
+func TestRetention(t *testing.T) {
+	h := openTest(t, map[string]config.Duration{ResolutionRaw: config.Duration(time.Hour)})
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3*60; i++ {
+		h.Write("pm1", map[string]float64{"active_power": 1}, start.Add(time.Duration(i)*time.Minute))
+	}
+	now := start.Add(3*time.Hour + time.Minute)
+	require.NoError(t, h.Maintain(now))
+
+	raw, err := h.Query("pm1", "active_power", start, now, ResolutionRaw)
+	require.NoError(t, err)
+	assert.Len(t, raw, 59, "Chỉ còn dữ liệu gốc trong một giờ gần nhất")
+
+	hour, err := h.Query("pm1", "active_power", start, now, Resolution1h)
+	require.NoError(t, err)
+	assert.Len(t, hour, 3, "Dữ liệu tổng hợp vẫn được giữ")
+
+	_, err = Open(Config{Path: filepath.Join(t.TempDir(), "x.db"), Retention: map[string]config.Duration{"1d": 0}}, log.New(io.Discard, "", 0))
+	assert.Error(t, err)
+}
+
+// TestSeriesCleanup kiểm tra xóa chuỗi dữ liệu đã hết bản ghi, tối đa mỗi giờ một lần
+func TestSeriesCleanup(t *testing.T) {
+	keep := config.Duration(time.Hour)
+	h := openTest(t, map[string]config.Duration{
+		ResolutionRaw: keep, Resolution1m: keep, Resolution15m: keep, Resolution1h: keep,
+	})
+	names := func() []string {
+		series, err := h.Series()
+		require.NoError(t, err)
+		var devices []string
+		for _, s := range series {
+			devices = append(devices, s.Device)
+		}
+		return devices
+	}
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	h.Write("old", map[string]float64{"active_power": 1}, start)
+	now := start.Add(3 * time.Hour)
+	h.Write("new", map[string]float64{"active_power": 1}, now)
+	require.NoError(t, h.Maintain(now))
+	assert.Equal(t, []string{"new"}, names())
+
+	// Dữ liệu hết hạn ngay sau lần dọn trước: chuỗi chỉ bị xóa sau một giờ
+	h.Write("late", map[string]float64{"active_power": 1}, start)
+	require.NoError(t, h.Maintain(now.Add(30*time.Minute)))
+	assert.Equal(t, []string{"late", "new"}, names())
+	require.NoError(t, h.Maintain(now.Add(time.Hour)))
+	assert.Equal(t, []string{"new"}, names())
+}
+
+// TestChooseResolution kiểm tra chọn độ phân giải theo khoảng thời gian
+func TestChooseResolution(t *testing.T) {
+	assert.Equal(t, ResolutionRaw, ChooseResolution(time.Hour))
+	assert.Equal(t, Resolution1m, ChooseResolution(24*time.Hour))
+	assert.Equal(t, Resolution15m, ChooseResolution(30*24*time.Hour))
+	assert.Equal(t, Resolution1h, ChooseResolution(365*24*time.Hour))
+}
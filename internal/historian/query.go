@@ -0,0 +1,103 @@
+package historian
+
+import (
+	"fmt"
+	"time"
+)
+
+// Point là một điểm dữ liệu lịch sử. Với dữ liệu gốc Min, Max, Avg, Last bằng
+// nhau và Count = 1; với dữ liệu tổng hợp Time là thời điểm bắt đầu khoảng.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Last  float64   `json:"last"`
+	Count int64     `json:"count"`
+}
+
+// Series là một chuỗi dữ liệu đang được lưu
+type Series struct {
+	Device string `json:"device"`
+	Signal string `json:"signal"`
+}
+
+// ChooseResolution chọn độ phân giải phù hợp để khoảng thời gian span có số
+// điểm vừa đủ cho biểu đồ xu hướng
+func ChooseResolution(span time.Duration) string {
+	switch {
+	case span <= 6*time.Hour:
+		return ResolutionRaw
+	case span <= 3*24*time.Hour:
+		return Resolution1m
+	case span <= 60*24*time.Hour:
+		return Resolution15m
+	}
+	return Resolution1h
+}
+
+// Query trả về dữ liệu của một tín hiệu trong khoảng [from, to) theo độ phân
+// giải cho trước, sắp theo thời gian. Dữ liệu chưa ghi xuống đĩa không có trong
+// kết quả; khoảng tổng hợp chỉ có sau khi đã kết thúc.
+func (h *Historian) Query(device, signal string, from, to time.Time, resolution string) ([]Point, error) {
+	if resolution == "" || resolution == ResolutionAuto {
+		resolution = ChooseResolution(to.Sub(from))
+	}
+
+	var query string
+	args := []interface{}{device, signal, from.UnixMilli(), to.UnixMilli()}
+	if resolution == ResolutionRaw {
+		query = `SELECT r.ts, r.value, r.value, r.value, r.value, 1 FROM raw r
+			JOIN series s ON s.id = r.series
+			WHERE s.device = ? AND s.signal = ? AND r.ts >= ? AND r.ts < ? ORDER BY r.ts`
+	} else {
+		step := resolutionStep(resolution)
+		if step == 0 {
+			return nil, fmt.Errorf("độ phân giải không hợp lệ %q", resolution)
+		}
+		query = `SELECT r.ts, r.min, r.max, r.avg, r.last, r.count FROM rollup r
+			JOIN series s ON s.id = r.series
+			WHERE s.device = ? AND s.signal = ? AND r.ts >= ? AND r.ts < ? AND r.step = ? ORDER BY r.ts`
+		args = append(args, step.Milliseconds())
+	}
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("truy vấn lịch sử lỗi: %w", err)
+	}
+	defer rows.Close()
+
+	points := []Point{}
+	for rows.Next() {
+		var p Point
+		var ts int64
+		if err := rows.Scan(&ts, &p.Min, &p.Max, &p.Avg, &p.Last, &p.Count); err != nil {
+			return nil, fmt.Errorf("truy vấn lịch sử lỗi: %w", err)
+		}
+		p.Time = time.UnixMilli(ts)
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("truy vấn lịch sử lỗi: %w", err)
+	}
+	return points, nil
+}
+
+// Series trả về danh sách chuỗi dữ liệu đang được lưu, sắp theo thiết bị và tín hiệu
+func (h *Historian) Series() ([]Series, error) {
+	rows, err := h.db.Query("SELECT device, signal FROM series ORDER BY device, signal")
+	if err != nil {
+		return nil, fmt.Errorf("truy vấn danh sách tín hiệu lỗi: %w", err)
+	}
+	defer rows.Close()
+
+	list := []Series{}
+	for rows.Next() {
+		var s Series
+		if err := rows.Scan(&s.Device, &s.Signal); err != nil {
+			return nil, fmt.Errorf("truy vấn danh sách tín hiệu lỗi: %w", err)
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
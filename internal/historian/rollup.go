@@ -0,0 +1,150 @@
+package historian
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+// aggregate là giá trị tổng hợp của một chuỗi dữ liệu trong một khoảng
+type aggregate struct {
+	series   int64
+	ts       int64
+	min, max float64
+	sum      float64 // Tổng có trọng số để tính trung bình
+	last     float64
+	count    int64
+}
+
+// add gộp một bản ghi (gốc hoặc đã tổng hợp) vào khoảng
+func (a *aggregate) add(min, max, avg, last float64, count int64) {
+	if a.count == 0 {
+		a.min, a.max = min, max
+	} else {
+		a.min = math.Min(a.min, min)
+		a.max = math.Max(a.max, max)
+	}
+	a.sum += avg * float64(count)
+	a.last = last
+	a.count += count
+}
+
+// rollup tính các khoảng step đã kết thúc trước now từ dữ liệu nguồn
+// (source rỗng là dữ liệu gốc) kể từ lần tổng hợp trước
+func (h *Historian) rollup(source string, step time.Duration, now time.Time) error {
+	stepMs := step.Milliseconds()
+	cutoff := now.Add(-lateness).Truncate(step).UnixMilli()
+
+	var from int64
+	err := h.db.QueryRow("SELECT ts FROM watermark WHERE step = ?", stepMs).Scan(&from)
+	if errors.Is(err, sql.ErrNoRows) {
+		from = math.MinInt64
+	} else if err != nil {
+		return err
+	}
+	if from >= cutoff {
+		return nil
+	}
+
+	var rows *sql.Rows
+	if source == "" {
+		rows, err = h.db.Query(`SELECT series, ts, value, value, value, value, 1 FROM raw
+			WHERE ts >= ? AND ts < ? ORDER BY series, ts`, from, cutoff)
+	} else {
+		rows, err = h.db.Query(`SELECT series, ts, min, max, avg, last, count FROM rollup
+			WHERE step = ? AND ts >= ? AND ts < ? ORDER BY series, ts`,
+			resolutionStep(source).Milliseconds(), from, cutoff)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Dữ liệu được sắp theo chuỗi rồi thời gian nên các khoảng nối tiếp nhau
+	var result []*aggregate
+	var cur *aggregate
+	for rows.Next() {
+		var (
+			series, ts, count   int64
+			min, max, avg, last float64
+		)
+		if err := rows.Scan(&series, &ts, &min, &max, &avg, &last, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		bucket := ts - ts%stepMs
+		if cur == nil || cur.series != series || cur.ts != bucket {
+			cur = &aggregate{series: series, ts: bucket}
+			result = append(result, cur)
+		}
+		cur.add(min, max, avg, last, count)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return h.tx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT OR REPLACE INTO rollup (step, series, ts, min, max, avg, last, count)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, a := range result {
+			if _, err := stmt.Exec(stepMs, a.series, a.ts, a.min, a.max, a.sum/float64(a.count), a.last, a.count); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("INSERT OR REPLACE INTO watermark (step, ts) VALUES (?, ?)", stepMs, cutoff)
+		return err
+	})
+}
+
+// prune xóa dữ liệu quá thời gian lưu của từng độ phân giải
+func (h *Historian) prune(now time.Time) error {
+	for name, keep := range h.retention {
+		if keep <= 0 {
+			continue
+		}
+		before := now.Add(-keep).UnixMilli()
+		var res sql.Result
+		var err error
+		if name == ResolutionRaw {
+			res, err = h.db.Exec("DELETE FROM raw WHERE ts < ?", before)
+		} else {
+			res, err = h.db.Exec("DELETE FROM rollup WHERE step = ? AND ts < ?", resolutionStep(name).Milliseconds(), before)
+		}
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			h.expired = true
+		}
+	}
+
+	// Chuỗi chỉ mất hết bản ghi khi có dữ liệu hết hạn; quét toàn bảng nên
+	// chỉ chạy tối đa mỗi giờ một lần
+	if !h.expired || now.Sub(h.lastCleanup) < seriesCleanupInterval {
+		return nil
+	}
+	_, err := h.db.Exec(`DELETE FROM series WHERE NOT EXISTS (SELECT 1 FROM raw WHERE raw.series = series.id)
+		AND NOT EXISTS (SELECT 1 FROM rollup WHERE rollup.series = series.id)`)
+	if err != nil {
+		return err
+	}
+	h.expired = false
+	h.lastCleanup = now
+	h.series = make(map[[2]string]int64)
+	return nil
+}
+
+// resolutionStep trả về độ dài khoảng của mức tổng hợp, 0 nếu là dữ liệu gốc hoặc không hợp lệ
+func resolutionStep(name string) time.Duration {
+	for _, r := range rollups {
+		if r.name == name {
+			return r.step
+		}
+	}
+	return 0
+}
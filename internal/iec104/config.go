@@ -0,0 +1,73 @@
+package iec104
+
+import (
+	"modbus_inverter/internal/config"
+)
+
+// Kiểu điểm dữ liệu IEC 104
+const (
+	PointSingle = "single" // M_SP: tín hiệu trạng thái 0/1
+	PointFloat  = "float"  // M_ME_NC: giá trị đo dạng số thực ngắn
+)
+
+// Config cấu hình outstation IEC 60870-5-104
+type Config struct {
+	Enabled        bool            `json:"enabled"`
+	ListenAddr     string          `json:"listen_addr"`     // Mặc định ":2404"
+	CommonAddress  uint16          `json:"common_address"`  // Địa chỉ chung ASDU (CA), mặc định 1
+	CyclicInterval config.Duration `json:"cyclic_interval"` // Chu kỳ gửi giá trị đo, 0: tắt
+	Points         []PointConfig   `json:"points"`          // Bảng ánh xạ tín hiệu -> IOA
+	Commands       []CommandConfig `json:"commands"`        // Bảng ánh xạ IOA lệnh -> lệnh điều khiển
+}
+
+// PointConfig ánh xạ một tín hiệu của thiết bị sang một IOA
+type PointConfig struct {
+	Device   string  `json:"device"`   // Tên thiết bị
+	Signal   string  `json:"signal"`   // Tên tín hiệu, trùng tên trường JSON của InverterData
+	IOA      uint32  `json:"ioa"`      // Địa chỉ đối tượng thông tin
+	Type     string  `json:"type"`     // "single" hoặc "float", bỏ trống để tự chọn theo tín hiệu
+	Deadband float64 `json:"deadband"` // Ngưỡng thay đổi tối thiểu để gửi tự phát (giá trị tuyệt đối)
+}
+
+// CommandConfig ánh xạ một IOA lệnh (C_SC_NA_1, C_SE_NC_1) sang lệnh điều khiển thiết bị
+type CommandConfig struct {
+	Device  string `json:"device"`  // Tên thiết bị
+	Command string `json:"command"` // Loại lệnh: p_limit_percent, p_limit_kw, q_setpoint_kvar, pf_setpoint, on_off, export_limit_kw
+	IOA     uint32 `json:"ioa"`     // Địa chỉ đối tượng thông tin của lệnh
+}
+
+// MaxDefaultDevices số inverter tối đa dùng được bảng IOA mặc định: từ inverter
+// thứ 11 khối trạng thái (index*100+1) trùng khối giá trị đo (1001...)
+const MaxDefaultDevices = 10
+
+// DefaultPoints trả về bảng IOA mặc định cho các tín hiệu bắt buộc theo yêu cầu EVN.
+// index là thứ tự của inverter (0 đến MaxDefaultDevices-1), mỗi inverter dùng
+// một khối 100 IOA.
+func DefaultPoints(device string, index int) []PointConfig {
+	offset := uint32(index) * 100
+	return []PointConfig{
+		// Tín hiệu kết nối bắt buộc
+		{Device: device, Signal: "connection_status", IOA: offset + 1, Type: PointSingle},
+		{Device: device, Signal: "device_status", IOA: offset + 2, Type: PointSingle},
+
+		// Tín hiệu giám sát bắt buộc
+		{Device: device, Signal: "active_power", IOA: offset + 1001, Type: PointFloat},
+		{Device: device, Signal: "reactive_power", IOA: offset + 1002, Type: PointFloat},
+		{Device: device, Signal: "power_factor", IOA: offset + 1003, Type: PointFloat},
+		{Device: device, Signal: "frequency", IOA: offset + 1004, Type: PointFloat},
+		{Device: device, Signal: "voltage", IOA: offset + 1005, Type: PointFloat},
+		{Device: device, Signal: "current", IOA: offset + 1006, Type: PointFloat},
+	}
+}
+
+// pointType xác định kiểu điểm khi cấu hình bỏ trống
+func pointType(p PointConfig) string {
+	if p.Type != "" {
+		return p.Type
+	}
+	switch p.Signal {
+	case "connection_status", "device_status":
+		return PointSingle
+	}
+	return PointFloat
+}
@@ -0,0 +1,520 @@
+package iec104
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/cs104"
+
+	"modbus_inverter/internal/store"
+)
+
+// maxInfosPerASDU giới hạn số đối tượng thông tin trong một ASDU
+// để không vượt quá độ dài tối đa 249 byte
+const maxInfosPerASDU = 20
+
+// point lưu trạng thái hiện tại của một điểm dữ liệu
+type point struct {
+	cfg     PointConfig
+	typ     string
+	value   float64
+	qds     asdu.QualityDescriptor
+	ts      time.Time
+	sent    float64 // Giá trị đã gửi tự phát gần nhất
+	sentQds asdu.QualityDescriptor
+	hasSent bool
+}
+
+// CommandHandler thực hiện lệnh điều khiển nhận từ master, trả về lỗi nếu lệnh thất bại
+type CommandHandler func(device, command string, value float64) error
+
+// Outstation là trạm bị điều khiển (slave) IEC 60870-5-104, công bố dữ liệu
+// của gateway cho hệ thống SCADA của EVN
+type Outstation struct {
+	cfg    Config
+	server *cs104.Server
+	logger *log.Logger
+
+	mu          sync.Mutex
+	points      []*point          // Sắp xếp theo IOA
+	byKey       map[string]*point // Khóa: thiết bị + "/" + tín hiệu
+	byIOA       map[uint32]*point
+	clockOffset time.Duration // Độ lệch giữa đồng hồ master và đồng hồ cục bộ
+
+	commands       map[uint32]CommandConfig
+	commandHandler CommandHandler
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewOutstation tạo một outstation mới từ cấu hình
+func NewOutstation(cfg Config, logger *log.Logger) (*Outstation, error) {
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = fmt.Sprintf(":%d", cs104.Port)
+	}
+	if cfg.CommonAddress == 0 {
+		cfg.CommonAddress = 1
+	}
+
+	o := &Outstation{
+		cfg:      cfg,
+		logger:   logger,
+		byKey:    make(map[string]*point),
+		byIOA:    make(map[uint32]*point),
+		commands: make(map[uint32]CommandConfig),
+		done:     make(chan struct{}),
+	}
+
+	for _, pc := range cfg.Points {
+		if pc.IOA == 0 {
+			return nil, fmt.Errorf("điểm %s/%s chưa có IOA", pc.Device, pc.Signal)
+		}
+		if _, ok := o.byIOA[pc.IOA]; ok {
+			return nil, fmt.Errorf("IOA %d bị trùng", pc.IOA)
+		}
+		typ := pointType(pc)
+		if typ != PointSingle && typ != PointFloat {
+			return nil, fmt.Errorf("kiểu điểm không hợp lệ %q (IOA %d)", typ, pc.IOA)
+		}
+		p := &point{cfg: pc, typ: typ, qds: asdu.QDSInvalid}
+		o.points = append(o.points, p)
+		o.byKey[pc.Device+"/"+pc.Signal] = p
+		o.byIOA[pc.IOA] = p
+	}
+	sort.Slice(o.points, func(i, j int) bool { return o.points[i].cfg.IOA < o.points[j].cfg.IOA })
+
+	for _, cc := range cfg.Commands {
+		if cc.IOA == 0 {
+			return nil, fmt.Errorf("lệnh %s/%s chưa có IOA", cc.Device, cc.Command)
+		}
+		if _, ok := o.commands[cc.IOA]; ok {
+			return nil, fmt.Errorf("IOA lệnh %d bị trùng", cc.IOA)
+		}
+		o.commands[cc.IOA] = cc
+	}
+
+	o.server = cs104.NewServer(&handler{o: o})
+	o.server.SetOnConnectionHandler(func(c asdu.Connect) {
+		o.logger.Printf("IEC 104: master đã kết nối")
+	})
+	o.server.SetConnectionLostHandler(func(c asdu.Connect) {
+		o.logger.Printf("IEC 104: master đã ngắt kết nối")
+	})
+
+	return o, nil
+}
+
+// SetCommandHandler đặt hàm thực hiện lệnh điều khiển, gọi trước Start
+func (o *Outstation) SetCommandHandler(h CommandHandler) {
+	o.commandHandler = h
+}
+
+// Start bắt đầu lắng nghe kết nối và truyền dữ liệu chu kỳ
+func (o *Outstation) Start() {
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		o.server.ListenAndServer(o.cfg.ListenAddr)
+	}()
+
+	if o.cfg.CyclicInterval > 0 {
+		o.wg.Add(1)
+		go o.cyclicLoop()
+	}
+	o.logger.Printf("IEC 104: outstation lắng nghe tại %s, CA=%d, %d điểm", o.cfg.ListenAddr, o.cfg.CommonAddress, len(o.points))
+}
+
+// Close dừng outstation
+func (o *Outstation) Close() error {
+	close(o.done)
+	err := o.server.Close()
+	o.wg.Wait()
+	return err
+}
+
+// Update cập nhật giá trị mới của một thiết bị và gửi tự phát các điểm thay đổi
+func (o *Outstation) Update(device string, values map[string]float64, ts time.Time) {
+	o.UpdateTags(device, store.Tags(values, ts))
+}
+
+// UpdateTags cập nhật giá trị và chất lượng của các tag của một thiết bị và gửi
+// tự phát các điểm thay đổi, nhãn thời gian là thời điểm tại nguồn
+func (o *Outstation) UpdateTags(device string, tags map[string]store.Tag) {
+	o.mu.Lock()
+	var changed []*point
+	for signal, tag := range tags {
+		p, ok := o.byKey[device+"/"+signal]
+		if !ok {
+			continue
+		}
+		p.value = tag.Value
+		p.qds = qualityDescriptor(tag.Quality, p.typ)
+		if !tag.Source.IsZero() {
+			p.ts = tag.Source
+		}
+		if p.needsSpontaneous() {
+			changed = append(changed, p)
+		}
+	}
+	infos := o.snapshot(changed)
+	o.mu.Unlock()
+
+	o.sendSpontaneous(infos)
+}
+
+// MarkInvalid đánh dấu toàn bộ điểm của thiết bị là không hợp lệ khi mất liên lạc
+func (o *Outstation) MarkInvalid(device string, ts time.Time) {
+	o.mu.Lock()
+	var changed []*point
+	for _, p := range o.points {
+		if p.cfg.Device != device {
+			continue
+		}
+		p.qds = asdu.QDSInvalid
+		p.ts = ts
+		if p.needsSpontaneous() {
+			changed = append(changed, p)
+		}
+	}
+	infos := o.snapshot(changed)
+	o.mu.Unlock()
+
+	o.sendSpontaneous(infos)
+}
+
+// qualityDescriptor chuyển chất lượng của tag sang bộ mô tả chất lượng QDS
+func qualityDescriptor(q store.Quality, typ string) asdu.QualityDescriptor {
+	switch q {
+	case store.QualityGood:
+		return asdu.QDSGood
+	case store.QualityStale:
+		return asdu.QDSNotTopical
+	case store.QualitySubstituted:
+		return asdu.QDSSubstituted
+	case store.QualityOutOfRange:
+		// Bit OV chỉ có trong QDS của giá trị đo, điểm trạng thái dùng IV
+		if typ == PointFloat {
+			return asdu.QDSOverflow
+		}
+	}
+	return asdu.QDSInvalid
+}
+
+// needsSpontaneous kiểm tra điểm có cần gửi tự phát không và ghi nhận giá trị đã gửi
+func (p *point) needsSpontaneous() bool {
+	send := !p.hasSent || p.qds != p.sentQds
+	if !send {
+		if p.typ == PointSingle {
+			send = (p.value != 0) != (p.sent != 0)
+		} else {
+			send = math.Abs(p.value-p.sent) > p.cfg.Deadband
+		}
+	}
+	if send {
+		p.sent = p.value
+		p.sentQds = p.qds
+		p.hasSent = true
+	}
+	return send
+}
+
+// pointInfo là bản sao giá trị của một điểm để gửi đi ngoài khóa
+type pointInfo struct {
+	ioa   uint32
+	typ   string
+	value float64
+	qds   asdu.QualityDescriptor
+	ts    time.Time
+}
+
+// snapshot sao chép giá trị các điểm, gọi khi đang giữ khóa
+func (o *Outstation) snapshot(points []*point) []pointInfo {
+	infos := make([]pointInfo, 0, len(points))
+	for _, p := range points {
+		infos = append(infos, pointInfo{
+			ioa:   p.cfg.IOA,
+			typ:   p.typ,
+			value: p.value,
+			qds:   p.qds,
+			ts:    p.ts.Add(o.clockOffset),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ioa < infos[j].ioa })
+	return infos
+}
+
+// sendSpontaneous gửi các điểm thay đổi kèm nhãn thời gian CP56Time2a tới mọi master
+func (o *Outstation) sendSpontaneous(infos []pointInfo) {
+	if len(infos) == 0 {
+		return
+	}
+	if err := o.send(o.server, asdu.Spontaneous, true, infos); err != nil {
+		o.logger.Printf("IEC 104: lỗi gửi tự phát: %v", err)
+	}
+}
+
+// cyclicLoop gửi định kỳ các giá trị đo (COT = periodic)
+func (o *Outstation) cyclicLoop() {
+	defer o.wg.Done()
+	ticker := time.NewTicker(o.cfg.CyclicInterval.Std())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			o.mu.Lock()
+			var floats []*point
+			for _, p := range o.points {
+				if p.typ == PointFloat {
+					floats = append(floats, p)
+				}
+			}
+			infos := o.snapshot(floats)
+			o.mu.Unlock()
+
+			if err := o.send(o.server, asdu.Periodic, false, infos); err != nil {
+				o.logger.Printf("IEC 104: lỗi gửi chu kỳ: %v", err)
+			}
+		}
+	}
+}
+
+// send gửi danh sách điểm, tách theo kiểu và chia nhỏ theo giới hạn độ dài ASDU
+func (o *Outstation) send(c asdu.Connect, cause asdu.Cause, withTime bool, infos []pointInfo) error {
+	coa := asdu.CauseOfTransmission{Cause: cause}
+	ca := asdu.CommonAddr(o.cfg.CommonAddress)
+
+	var singles []asdu.SinglePointInfo
+	var floats []asdu.MeasuredValueFloatInfo
+	for _, info := range infos {
+		switch info.typ {
+		case PointSingle:
+			singles = append(singles, asdu.SinglePointInfo{
+				Ioa:   asdu.InfoObjAddr(info.ioa),
+				Value: info.value != 0,
+				Qds:   info.qds,
+				Time:  info.ts,
+			})
+		case PointFloat:
+			floats = append(floats, asdu.MeasuredValueFloatInfo{
+				Ioa:   asdu.InfoObjAddr(info.ioa),
+				Value: float32(info.value),
+				Qds:   info.qds,
+				Time:  info.ts,
+			})
+		}
+	}
+
+	for start := 0; start < len(singles); start += maxInfosPerASDU {
+		chunk := singles[start:min(start+maxInfosPerASDU, len(singles))]
+		var err error
+		if withTime {
+			err = asdu.SingleCP56Time2a(c, coa, ca, chunk...)
+		} else {
+			err = asdu.Single(c, false, coa, ca, chunk...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(floats); start += maxInfosPerASDU {
+		chunk := floats[start:min(start+maxInfosPerASDU, len(floats))]
+		var err error
+		if withTime {
+			err = asdu.MeasuredValueFloatCP56Time2a(c, coa, ca, chunk...)
+		} else {
+			err = asdu.MeasuredValueFloat(c, false, coa, ca, chunk...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acceptsCommonAddr kiểm tra CA của lệnh có thuộc outstation không
+func (o *Outstation) acceptsCommonAddr(ca asdu.CommonAddr) bool {
+	return ca == asdu.CommonAddr(o.cfg.CommonAddress) || ca == asdu.GlobalCommonAddr
+}
+
+// reply gửi trả lời cho một lệnh với nguyên nhân truyền mới.
+// Thư viện đã đọc mất phần đối tượng thông tin của ASDU nhận được
+// (SendReplyMirror gửi thiếu IOA) nên phải dựng lại ASDU trả lời.
+func reply(c asdu.Connect, pack *asdu.ASDU, cause asdu.Cause, ioa asdu.InfoObjAddr, payload ...byte) error {
+	id := pack.Identifier
+	id.Coa.Cause = cause
+	id.Variable = asdu.VariableStruct{Number: 1}
+
+	u := asdu.NewASDU(c.Params(), id)
+	if err := u.AppendInfoObjAddr(ioa); err != nil {
+		return err
+	}
+	u.AppendBytes(payload...)
+	return c.Send(u)
+}
+
+// handler xử lý các lệnh từ master
+type handler struct {
+	o *Outstation
+}
+
+// InterrogationHandler xử lý lệnh tổng hỏi (C_IC_NA_1)
+func (h *handler) InterrogationHandler(c asdu.Connect, pack *asdu.ASDU, qoi asdu.QualifierOfInterrogation) error {
+	o := h.o
+	if !o.acceptsCommonAddr(pack.CommonAddr) {
+		return reply(c, pack, asdu.UnknownCA, asdu.InfoObjAddrIrrelevant, byte(qoi))
+	}
+	if pack.Identifier.Coa.Cause == asdu.Deactivation {
+		return reply(c, pack, asdu.DeactivationCon, asdu.InfoObjAddrIrrelevant, byte(qoi))
+	}
+	if err := reply(c, pack, asdu.ActivationCon, asdu.InfoObjAddrIrrelevant, byte(qoi)); err != nil {
+		return err
+	}
+
+	// Mọi nhóm tổng hỏi đều trả về toàn bộ điểm
+	o.mu.Lock()
+	infos := o.snapshot(o.points)
+	o.mu.Unlock()
+
+	if err := o.send(c, asdu.InterrogatedByStation, false, infos); err != nil {
+		o.logger.Printf("IEC 104: lỗi trả lời tổng hỏi: %v", err)
+	}
+	return reply(c, pack, asdu.ActivationTerm, asdu.InfoObjAddrIrrelevant, byte(qoi))
+}
+
+// CounterInterrogationHandler xử lý lệnh tổng hỏi bộ đếm (chưa có điểm bộ đếm)
+func (h *handler) CounterInterrogationHandler(c asdu.Connect, pack *asdu.ASDU, qcc asdu.QualifierCountCall) error {
+	qualifier := byte(qcc.Request) | byte(qcc.Freeze)
+	if !h.o.acceptsCommonAddr(pack.CommonAddr) {
+		return reply(c, pack, asdu.UnknownCA, asdu.InfoObjAddrIrrelevant, qualifier)
+	}
+	if err := reply(c, pack, asdu.ActivationCon, asdu.InfoObjAddrIrrelevant, qualifier); err != nil {
+		return err
+	}
+	return reply(c, pack, asdu.ActivationTerm, asdu.InfoObjAddrIrrelevant, qualifier)
+}
+
+// ReadHandler xử lý lệnh đọc một điểm (C_RD_NA_1)
+func (h *handler) ReadHandler(c asdu.Connect, pack *asdu.ASDU, ioa asdu.InfoObjAddr) error {
+	o := h.o
+	if !o.acceptsCommonAddr(pack.CommonAddr) {
+		return reply(c, pack, asdu.UnknownCA, ioa)
+	}
+
+	o.mu.Lock()
+	p, ok := o.byIOA[uint32(ioa)]
+	var infos []pointInfo
+	if ok {
+		infos = o.snapshot([]*point{p})
+	}
+	o.mu.Unlock()
+
+	if !ok {
+		return reply(c, pack, asdu.UnknownIOA, ioa)
+	}
+	return o.send(c, asdu.Request, true, infos)
+}
+
+// ClockSyncHandler xử lý lệnh đồng bộ thời gian (C_CS_NA_1)
+// Gateway không chỉnh đồng hồ hệ thống mà lưu độ lệch để gắn nhãn thời gian
+func (h *handler) ClockSyncHandler(c asdu.Connect, pack *asdu.ASDU, t time.Time) error {
+	o := h.o
+	timeBytes := asdu.CP56Time2a(t, c.Params().InfoObjTimeZone)
+	if !o.acceptsCommonAddr(pack.CommonAddr) {
+		return reply(c, pack, asdu.UnknownCA, asdu.InfoObjAddrIrrelevant, timeBytes...)
+	}
+
+	offset := time.Until(t)
+	o.mu.Lock()
+	o.clockOffset = offset
+	o.mu.Unlock()
+	o.logger.Printf("IEC 104: đồng bộ thời gian %s, độ lệch %v", t.Format(time.RFC3339), offset)
+
+	return reply(c, pack, asdu.ActivationCon, asdu.InfoObjAddrIrrelevant, timeBytes...)
+}
+
+// ResetProcessHandler xử lý lệnh reset tiến trình (chỉ xác nhận)
+func (h *handler) ResetProcessHandler(c asdu.Connect, pack *asdu.ASDU, qrp asdu.QualifierOfResetProcessCmd) error {
+	return reply(c, pack, asdu.ActivationCon, asdu.InfoObjAddrIrrelevant, byte(qrp))
+}
+
+// DelayAcquisitionHandler xử lý lệnh thu thập độ trễ (chỉ xác nhận)
+func (h *handler) DelayAcquisitionHandler(c asdu.Connect, pack *asdu.ASDU, msec uint16) error {
+	return reply(c, pack, asdu.ActivationCon, asdu.InfoObjAddrIrrelevant, byte(msec), byte(msec>>8))
+}
+
+// ASDUHandler xử lý lệnh điều khiển (C_SC_NA_1, C_SE_NC_1), các kiểu khác chưa hỗ trợ
+func (h *handler) ASDUHandler(c asdu.Connect, pack *asdu.ASDU) error {
+	switch pack.Identifier.Type {
+	case asdu.C_SC_NA_1, asdu.C_SE_NC_1:
+		return h.o.handleCommand(c, pack)
+	}
+	return fmt.Errorf("không hỗ trợ kiểu ASDU %v", pack.Identifier.Type)
+}
+
+// handleCommand thực hiện lệnh điều khiển từ master.
+// Lệnh chọn (select) chỉ được xác nhận, lệnh thực hiện (execute) được chuyển
+// cho CommandHandler và trả lời xác nhận âm nếu thất bại.
+func (o *Outstation) handleCommand(c asdu.Connect, pack *asdu.ASDU) error {
+	// Giữ bản sao nguyên vẹn để trả lời, vì giải mã sẽ đọc mất đối tượng thông tin
+	raw := pack.Clone()
+	respond := func(cause asdu.Cause, negative bool) error {
+		raw.Identifier.Coa.IsNegative = negative
+		return raw.SendReplyMirror(c, cause)
+	}
+
+	if pack.Identifier.Coa.Cause == asdu.Deactivation {
+		return respond(asdu.DeactivationCon, false)
+	}
+	if pack.Identifier.Coa.Cause != asdu.Activation {
+		return respond(asdu.UnknownCOT, true)
+	}
+	if !o.acceptsCommonAddr(pack.CommonAddr) {
+		return respond(asdu.UnknownCA, true)
+	}
+
+	var ioa asdu.InfoObjAddr
+	var value float64
+	var inSelect bool
+	switch pack.Identifier.Type {
+	case asdu.C_SC_NA_1:
+		cmd := pack.GetSingleCmd()
+		ioa, inSelect = cmd.Ioa, cmd.Qoc.InSelect
+		if cmd.Value {
+			value = 1
+		}
+	case asdu.C_SE_NC_1:
+		cmd := pack.GetSetpointFloatCmd()
+		ioa, value, inSelect = cmd.Ioa, float64(cmd.Value), cmd.Qos.InSelect
+	}
+
+	cc, ok := o.commands[uint32(ioa)]
+	if !ok {
+		return respond(asdu.UnknownIOA, true)
+	}
+	if inSelect {
+		return respond(asdu.ActivationCon, false)
+	}
+	if o.commandHandler == nil {
+		o.logger.Printf("IEC 104: chưa có bộ xử lý lệnh, từ chối lệnh IOA %d", ioa)
+		return respond(asdu.ActivationCon, true)
+	}
+
+	o.logger.Printf("IEC 104: nhận lệnh %s=%.3f cho %s (IOA %d)", cc.Command, value, cc.Device, ioa)
+	if err := o.commandHandler(cc.Device, cc.Command, value); err != nil {
+		o.logger.Printf("IEC 104: lệnh IOA %d thất bại: %v", ioa, err)
+		return respond(asdu.ActivationCon, true)
+	}
+	if err := respond(asdu.ActivationCon, false); err != nil {
+		return err
+	}
+	return respond(asdu.ActivationTerm, false)
+}
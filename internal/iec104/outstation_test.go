@@ -0,0 +1,247 @@
+package iec104
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/cs104"
+
+	"modbus_inverter/internal/store"
+)
+
+// masterHandler thu thập các ASDU mà master nhận được
+type masterHandler struct {
+	received chan *asdu.ASDU
+}
+
+func (m *masterHandler) push(pack *asdu.ASDU) error {
+	m.received <- pack
+	return nil
+}
+
+func (m *masterHandler) InterrogationHandler(_ asdu.Connect, p *asdu.ASDU) error { return m.push(p) }
+func (m *masterHandler) CounterInterrogationHandler(_ asdu.Connect, p *asdu.ASDU) error {
+	return m.push(p)
+}
+func (m *masterHandler) ReadHandler(_ asdu.Connect, p *asdu.ASDU) error             { return m.push(p) }
+func (m *masterHandler) TestCommandHandler(_ asdu.Connect, p *asdu.ASDU) error      { return m.push(p) }
+func (m *masterHandler) ClockSyncHandler(_ asdu.Connect, p *asdu.ASDU) error        { return m.push(p) }
+func (m *masterHandler) ResetProcessHandler(_ asdu.Connect, p *asdu.ASDU) error     { return m.push(p) }
+func (m *masterHandler) DelayAcquisitionHandler(_ asdu.Connect, p *asdu.ASDU) error { return m.push(p) }
+func (m *masterHandler) ASDUHandler(_ asdu.Connect, p *asdu.ASDU) error             { return m.push(p) }
+
+// freeAddr tìm một cổng TCP còn trống trên localhost
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+// waitFor chờ ASDU thỏa điều kiện trong thời gian cho phép
+func waitFor(t *testing.T, ch chan *asdu.ASDU, match func(*asdu.ASDU) bool) *asdu.ASDU {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-ch:
+			if match(p) {
+				return p
+			}
+		case <-timeout:
+			t.Fatal("hết thời gian chờ ASDU")
+			return nil
+		}
+	}
+}
+
+// TestOutstation kiểm tra tổng hỏi, gửi tự phát và đồng bộ thời gian
+func TestOutstation(t *testing.T) {
+	addr := freeAddr(t)
+	o, err := NewOutstation(Config{
+		ListenAddr:    addr,
+		CommonAddress: 1,
+		Points:        DefaultPoints("inverter1", 0),
+		Commands: []CommandConfig{
+			{Device: "inverter1", Command: "p_limit_percent", IOA: 5001},
+		},
+	}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+
+	commands := make(chan float64, 4)
+	o.SetCommandHandler(func(device, command string, value float64) error {
+		if value > 100 {
+			return errors.New("ngoài giới hạn")
+		}
+		commands <- value
+		return nil
+	})
+	o.Start()
+	defer o.Close()
+
+	o.Update("inverter1", map[string]float64{
+		"connection_status": 1,
+		"device_status":     1,
+		"active_power":      5.5,
+		"frequency":         50,
+	}, time.Now())
+
+	mh := &masterHandler{received: make(chan *asdu.ASDU, 64)}
+	opt := cs104.NewOption()
+	require.NoError(t, opt.AddRemoteServer(addr))
+	opt.SetAutoReconnect(true)
+	opt.SetReconnectInterval(100 * time.Millisecond)
+	connected := make(chan struct{}, 1)
+	master := cs104.NewClient(mh, opt)
+	master.SetOnConnectHandler(func(c *cs104.Client) {
+		c.SendStartDt()
+		connected <- struct{}{}
+	})
+	require.NoError(t, master.Start())
+	defer master.Close()
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("master không kết nối được tới outstation")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	t.Run("Tổng hỏi", func(t *testing.T) {
+		require.NoError(t, master.InterrogationCmd(asdu.CauseOfTransmission{Cause: asdu.Activation}, 1, asdu.QOIStation))
+
+		p := waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			return p.Type == asdu.M_ME_NC_1 && p.Coa.Cause == asdu.InterrogatedByStation
+		})
+		values := map[asdu.InfoObjAddr]asdu.MeasuredValueFloatInfo{}
+		for _, v := range p.GetMeasuredValueFloat() {
+			values[v.Ioa] = v
+		}
+		assert.InDelta(t, 5.5, values[1001].Value, 1e-6, "Công suất tác dụng")
+		assert.Equal(t, asdu.QDSGood, values[1001].Qds)
+		assert.InDelta(t, 50, values[1004].Value, 1e-6, "Tần số")
+		assert.Equal(t, asdu.QDSInvalid, values[1002].Qds, "Điểm chưa có dữ liệu phải không hợp lệ")
+
+		waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			return p.Type == asdu.C_IC_NA_1 && p.Coa.Cause == asdu.ActivationTerm
+		})
+	})
+
+	t.Run("Gửi tự phát", func(t *testing.T) {
+		o.Update("inverter1", map[string]float64{"active_power": 7.25}, time.Now())
+
+		p := waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			return p.Type == asdu.M_ME_TF_1 && p.Coa.Cause == asdu.Spontaneous
+		})
+		infos := p.GetMeasuredValueFloat()
+		require.Len(t, infos, 1)
+		assert.Equal(t, asdu.InfoObjAddr(1001), infos[0].Ioa)
+		assert.InDelta(t, 7.25, infos[0].Value, 1e-6)
+	})
+
+	t.Run("Chất lượng và nhãn thời gian", func(t *testing.T) {
+		src := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+		o.UpdateTags("inverter1", map[string]store.Tag{
+			"active_power":  {Value: 7.25, Quality: store.QualityOutOfRange, Source: src, Acquired: time.Now()},
+			"device_status": {Value: 1, Quality: store.QualityStale, Source: src, Acquired: time.Now()},
+		})
+
+		// Điểm trạng thái được gửi trước giá trị đo
+		p := waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			return p.Type == asdu.M_SP_TB_1 && p.Coa.Cause == asdu.Spontaneous
+		})
+		singles := p.GetSinglePoint()
+		require.Len(t, singles, 1)
+		assert.Equal(t, asdu.QDSNotTopical, singles[0].Qds)
+
+		p = waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			return p.Type == asdu.M_ME_TF_1 && p.Coa.Cause == asdu.Spontaneous
+		})
+		infos := p.GetMeasuredValueFloat()
+		require.Len(t, infos, 1)
+		assert.Equal(t, asdu.QDSOverflow, infos[0].Qds)
+		assert.True(t, src.Equal(infos[0].Time), "Nhãn thời gian tại nguồn")
+	})
+
+	t.Run("Đồng bộ thời gian", func(t *testing.T) {
+		master.ClockSynchronizationCmd(asdu.CauseOfTransmission{Cause: asdu.Activation}, 1, time.Now().Add(time.Hour))
+
+		waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			return p.Type == asdu.C_CS_NA_1 && p.Coa.Cause == asdu.ActivationCon
+		})
+		o.mu.Lock()
+		offset := o.clockOffset
+		o.mu.Unlock()
+		assert.InDelta(t, time.Hour.Seconds(), offset.Seconds(), 5)
+	})
+
+	t.Run("Lệnh đặt công suất", func(t *testing.T) {
+		setpoint := func(v float32) error {
+			return asdu.SetpointCmdFloat(master, asdu.C_SE_NC_1, asdu.CauseOfTransmission{Cause: asdu.Activation}, 1,
+				asdu.SetpointCommandFloatInfo{Ioa: 5001, Value: v})
+		}
+
+		require.NoError(t, setpoint(60))
+		p := waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			return p.Type == asdu.C_SE_NC_1 && p.Coa.Cause == asdu.ActivationCon
+		})
+		assert.False(t, p.Coa.IsNegative)
+		assert.InDelta(t, 60, <-commands, 1e-6)
+
+		require.NoError(t, setpoint(150))
+		p = waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			return p.Type == asdu.C_SE_NC_1 && p.Coa.Cause == asdu.ActivationCon
+		})
+		assert.True(t, p.Coa.IsNegative, "Lệnh thất bại phải được xác nhận âm")
+	})
+
+	t.Run("Mất liên lạc", func(t *testing.T) {
+		// Poller đọc lỗi: trạng thái kết nối báo 0 hợp lệ, giá trị đo không hợp lệ
+		st := store.New()
+		st.OnTags(o.UpdateTags)
+		st.Update("inverter1", map[string]float64{"connection_status": 1, "active_power": 8}, time.Now())
+		st.MarkDisconnected("inverter1", "connection_status", time.Now())
+
+		var status asdu.SinglePointInfo
+		waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			if p.Type != asdu.M_SP_TB_1 || p.Coa.Cause != asdu.Spontaneous {
+				return false
+			}
+			singles := p.GetSinglePoint()
+			if len(singles) != 1 || singles[0].Ioa != 1 || singles[0].Value {
+				return false
+			}
+			status = singles[0]
+			return true
+		})
+		assert.Equal(t, asdu.QDSGood, status.Qds, "Mất kết nối là giá trị hợp lệ")
+
+		waitFor(t, mh.received, func(p *asdu.ASDU) bool {
+			if p.Type != asdu.M_ME_TF_1 || p.Coa.Cause != asdu.Spontaneous {
+				return false
+			}
+			infos := p.GetMeasuredValueFloat()
+			return len(infos) == 1 && infos[0].Ioa == 1001 && infos[0].Qds == asdu.QDSInvalid
+		})
+	})
+}
+
+// TestDefaultPoints kiểm tra bảng IOA mặc định không trùng giữa các inverter
+func TestDefaultPoints(t *testing.T) {
+	seen := map[uint32]string{}
+	for index := 0; index < MaxDefaultDevices; index++ {
+		for _, p := range DefaultPoints("inverter", index) {
+			_, dup := seen[p.IOA]
+			assert.False(t, dup, "IOA %d của inverter %d bị trùng", p.IOA, index)
+			seen[p.IOA] = p.Signal
+		}
+	}
+}
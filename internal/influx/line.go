@@ -0,0 +1,79 @@
+package influx
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Độ chính xác thời gian của line protocol
+const (
+	PrecisionNS = "ns"
+	PrecisionMS = "ms"
+	PrecisionS  = "s"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// Line mã hóa một bản ghi thành một dòng line protocol:
+// measurement,tag=value field=value timestamp
+// Tag và field được sắp xếp theo tên; giá trị NaN/Inf bị bỏ qua vì InfluxDB
+// không chấp nhận. Trả về chuỗi rỗng nếu không còn field nào.
+func Line(measurement string, tags map[string]string, fields map[string]float64, ts time.Time, precision string) string {
+	fieldKeys := make([]string, 0, len(fields))
+	for k, v := range fields {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			fieldKeys = append(fieldKeys, k)
+		}
+	}
+	if len(fieldKeys) == 0 {
+		return ""
+	}
+	sort.Strings(fieldKeys)
+
+	tagKeys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			tagKeys = append(tagKeys, k)
+		}
+	}
+	sort.Strings(tagKeys)
+
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(measurement))
+	for _, k := range tagKeys {
+		b.WriteByte(',')
+		b.WriteString(keyEscaper.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(keyEscaper.Replace(tags[k]))
+	}
+	for i, k := range fieldKeys {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(keyEscaper.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(strconv.FormatFloat(fields[k], 'g', -1, 64))
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(timestamp(ts, precision), 10))
+	return b.String()
+}
+
+// timestamp chuyển thời gian sang số nguyên theo độ chính xác
+func timestamp(ts time.Time, precision string) int64 {
+	switch precision {
+	case PrecisionS:
+		return ts.Unix()
+	case PrecisionMS:
+		return ts.UnixMilli()
+	}
+	return ts.UnixNano()
+}
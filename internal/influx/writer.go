@@ -0,0 +1,321 @@
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"modbus_inverter/internal/config"
+	"modbus_inverter/internal/queue"
+)
+
+// Config cấu hình đầu ra InfluxDB / VictoriaMetrics
+type Config struct {
+	Enabled       bool              `json:"enabled"`
+	URL           string            `json:"url"`             // Endpoint ghi, ví dụ http://host:8086/api/v2/write?org=o&bucket=b hoặc http://host:8428/write
+	Token         string            `json:"token"`           // InfluxDB 2.x: Authorization: Token ...
+	Username      string            `json:"username"`        // InfluxDB 1.x / VictoriaMetrics: xác thực Basic
+	Password      string            `json:"password"`        // InfluxDB 1.x / VictoriaMetrics: xác thực Basic
+	Site          string            `json:"site"`            // Giá trị tag site
+	Tags          map[string]string `json:"tags"`            // Tag bổ sung cho mọi bản ghi
+	Precision     string            `json:"precision"`       // ns, ms, s; mặc định s
+	BatchSize     int               `json:"batch_size"`      // Số dòng tối đa mỗi lần gửi, mặc định 500
+	FlushInterval config.Duration   `json:"flush_interval"`  // Chu kỳ gửi, mặc định 10s
+	Timeout       config.Duration   `json:"timeout"`         // Thời gian chờ mỗi yêu cầu HTTP, mặc định 10s
+	MaxRetries    int               `json:"max_retries"`     // Số lần thử lại trước khi đưa vào hàng đợi, mặc định 3, âm: không thử lại
+	RetryBackoff  config.Duration   `json:"retry_backoff"`   // Thời gian chờ lần thử lại đầu tiên (tăng gấp đôi mỗi lần), mặc định 1s
+	QueueDir      string            `json:"queue_dir"`       // Thư mục hàng đợi lưu và chuyển tiếp, bỏ trống để tắt
+	QueueMaxBytes int64             `json:"queue_max_bytes"` // Dung lượng tối đa của hàng đợi, mặc định 100 MB
+}
+
+// errPermanent lỗi do dữ liệu bị từ chối (4xx), gửi lại cũng không thành công
+var errPermanent = errors.New("máy chủ từ chối dữ liệu")
+
+// Writer gom các bản ghi thành lô và gửi theo line protocol qua HTTP. Lô gửi
+// thất bại sau khi thử lại được lưu vào hàng đợi trên đĩa và gửi lại theo
+// đúng thứ tự khi kết nối phục hồi.
+type Writer struct {
+	cfg     Config
+	client  *http.Client
+	queue   *queue.Queue // nil nếu không bật lưu và chuyển tiếp
+	logger  *log.Logger
+	devices map[string]string // Tên thiết bị -> loại thiết bị (measurement)
+	onDepth func(depth int)
+
+	mu      sync.Mutex
+	pending []string
+	flush   chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewWriter tạo writer từ cấu hình
+func NewWriter(cfg Config, logger *log.Logger) (*Writer, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("url InfluxDB không hợp lệ %q", cfg.URL)
+	}
+	switch cfg.Precision {
+	case "":
+		cfg.Precision = PrecisionS
+	case PrecisionNS, PrecisionMS, PrecisionS:
+	default:
+		return nil, fmt.Errorf("precision không hợp lệ %q", cfg.Precision)
+	}
+	// Thêm precision vào URL nếu chưa có
+	q := u.Query()
+	if q.Get("precision") == "" {
+		q.Set("precision", cfg.Precision)
+		u.RawQuery = q.Encode()
+		cfg.URL = u.String()
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = config.Duration(10 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(10 * time.Second)
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = config.Duration(time.Second)
+	}
+	if cfg.QueueMaxBytes <= 0 {
+		cfg.QueueMaxBytes = 100 << 20
+	}
+
+	w := &Writer{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout.Std()},
+		logger:  logger,
+		devices: make(map[string]string),
+		flush:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if cfg.QueueDir != "" {
+		w.queue, err = queue.Open(cfg.QueueDir, cfg.QueueMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// SetDevices đặt loại của từng thiết bị, dùng làm measurement; gọi trước Start
+func (w *Writer) SetDevices(types map[string]string) {
+	w.devices = types
+}
+
+// SetQueueObserver đặt hàm nhận số bản ghi trong hàng đợi sau mỗi lần gửi, gọi trước Start
+func (w *Writer) SetQueueObserver(fn func(depth int)) {
+	w.onDepth = fn
+}
+
+// Write thêm các giá trị vừa đọc của một thiết bị vào lô chờ gửi
+func (w *Writer) Write(device string, values map[string]float64, ts time.Time) {
+	measurement := w.devices[device]
+	if measurement == "" {
+		measurement = "device"
+	}
+	tags := map[string]string{"site": w.cfg.Site, "device": device}
+	for k, v := range w.cfg.Tags {
+		tags[k] = v
+	}
+	line := Line(measurement, tags, values, ts, w.cfg.Precision)
+	if line == "" {
+		return
+	}
+
+	w.mu.Lock()
+	w.pending = append(w.pending, line)
+	full := len(w.pending) >= w.cfg.BatchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// QueueDepth trả về số bản ghi đang chờ trong hàng đợi trên đĩa
+func (w *Writer) QueueDepth() int {
+	if w.queue == nil {
+		return 0
+	}
+	return w.queue.Len()
+}
+
+// Start bắt đầu gửi dữ liệu theo chu kỳ
+func (w *Writer) Start() {
+	w.wg.Add(1)
+	go w.loop()
+	w.logger.Printf("InfluxDB: gửi tới %s mỗi %v", w.cfg.URL, w.cfg.FlushInterval.Std())
+}
+
+// Close gửi nốt dữ liệu còn lại (không thử lại) và dừng writer;
+// lô gửi thất bại được giữ trong hàng đợi cho lần chạy sau
+func (w *Writer) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	w.send(false)
+	return nil
+}
+
+// loop gửi dữ liệu theo chu kỳ hoặc khi lô đầy
+func (w *Writer) loop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.cfg.FlushInterval.Std())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		case <-w.flush:
+		}
+		w.send(true)
+	}
+}
+
+// send gửi hàng đợi trên đĩa trước (giữ thứ tự thời gian), sau đó gửi lô mới.
+// Khi gửi thất bại, các lô chưa gửi được đưa vào hàng đợi.
+func (w *Writer) send(retry bool) {
+	defer func() {
+		if w.onDepth != nil {
+			w.onDepth(w.QueueDepth())
+		}
+	}()
+
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+
+	if w.queue != nil {
+		for {
+			lines, err := w.queue.Peek()
+			if err != nil {
+				w.logger.Printf("InfluxDB: %v", err)
+				break
+			}
+			if lines == nil {
+				break
+			}
+			err = w.post(lines, retry)
+			if err != nil && !errors.Is(err, errPermanent) {
+				w.spill(pending)
+				return
+			}
+			if err := w.queue.Pop(); err != nil {
+				w.logger.Printf("InfluxDB: %v", err)
+				break
+			}
+		}
+	}
+
+	for len(pending) > 0 {
+		n := min(len(pending), w.cfg.BatchSize)
+		err := w.post(pending[:n], retry)
+		if err != nil && !errors.Is(err, errPermanent) {
+			w.spill(pending)
+			return
+		}
+		pending = pending[n:]
+	}
+}
+
+// spill đưa các dòng chưa gửi được vào hàng đợi trên đĩa
+func (w *Writer) spill(lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	if w.queue == nil {
+		w.logger.Printf("InfluxDB: bỏ %d bản ghi do chưa bật hàng đợi", len(lines))
+		return
+	}
+	for len(lines) > 0 {
+		n := min(len(lines), w.cfg.BatchSize)
+		if err := w.queue.Push(lines[:n]); err != nil {
+			w.logger.Printf("InfluxDB: %v", err)
+			return
+		}
+		lines = lines[n:]
+	}
+}
+
+// post gửi một lô, thử lại với thời gian chờ tăng dần nếu lỗi tạm thời
+func (w *Writer) post(lines []string, retry bool) error {
+	body := []byte(strings.Join(lines, "\n") + "\n")
+	backoff := w.cfg.RetryBackoff.Std()
+	attempts := 1
+	if retry {
+		attempts += w.cfg.MaxRetries
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-w.done:
+				return err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = w.postOnce(body); err == nil || errors.Is(err, errPermanent) {
+			if err != nil {
+				w.logger.Printf("InfluxDB: bỏ %d bản ghi: %v", len(lines), err)
+			}
+			return err
+		}
+	}
+	w.logger.Printf("InfluxDB: gửi %d bản ghi thất bại sau %d lần: %v", len(lines), attempts, err)
+	return err
+}
+
+// postOnce gửi một yêu cầu HTTP
+func (w *Writer) postOnce(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.cfg.Token)
+	} else if w.cfg.Username != "" {
+		req.SetBasicAuth(w.cfg.Username, w.cfg.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden:
+		// Dữ liệu sai định dạng: gửi lại cũng bị từ chối
+		return fmt.Errorf("%w: %s %s", errPermanent, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,126 @@
+package influx
+
+import (
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/config"
+)
+
+// TestLine kiểm tra mã hóa line protocol
+func TestLine(t *testing.T) {
+	ts := time.Unix(1700000000, 500)
+	line := Line("inverter", map[string]string{"site": "Nhà máy 1", "device": "inv,1", "empty": ""},
+		map[string]float64{"voltage": 230.5, "active_power": 4, "bad": math.NaN()}, ts, PrecisionS)
+	assert.Equal(t, `inverter,device=inv\,1,site=Nhà\ máy\ 1 active_power=4,voltage=230.5 1700000000`, line)
+
+	assert.Equal(t, "", Line("inverter", nil, map[string]float64{"bad": math.NaN()}, ts, PrecisionS), "Không có field hợp lệ")
+	assert.True(t, strings.HasSuffix(Line("m", nil, map[string]float64{"a": 1}, ts, PrecisionNS), " 1700000000000000500"))
+}
+
+// fakeInflux giả lập máy chủ InfluxDB, trả lỗi 503 khi down
+type fakeInflux struct {
+	mu    sync.Mutex
+	down  bool
+	lines []string
+	auth  string
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = r.Header.Get("Authorization")
+	if f.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.URL.Query().Get("precision") != "s" {
+		http.Error(w, "bad precision", http.StatusBadRequest)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.lines = append(f.lines, strings.Fields(strings.ReplaceAll(string(body), " ", "_"))...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeInflux) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.lines...)
+}
+
+// TestWriter kiểm tra gửi theo lô và lưu chuyển tiếp khi mất kết nối
+func TestWriter(t *testing.T) {
+	srv := &fakeInflux{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	w, err := NewWriter(Config{
+		URL:           ts.URL + "/api/v2/write?org=o&bucket=b",
+		Token:         "secret",
+		Site:          "site1",
+		BatchSize:     2,
+		FlushInterval: config.Duration(time.Hour),
+		MaxRetries:    -1,
+		QueueDir:      t.TempDir(),
+	}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	w.SetDevices(map[string]string{"inverter1": "inverter"})
+	var depth int
+	w.SetQueueObserver(func(d int) { depth = d })
+
+	write := func(v float64) {
+		w.Write("inverter1", map[string]float64{"active_power": v}, time.Unix(int64(1700000000+v), 0))
+	}
+
+	// Mất kết nối: dữ liệu được đưa vào hàng đợi
+	srv.down = true
+	write(1)
+	write(2)
+	write(3)
+	w.send(true)
+	assert.Empty(t, srv.received())
+	assert.Equal(t, 3, depth)
+
+	// Kết nối phục hồi: gửi hàng đợi trước, giữ đúng thứ tự
+	srv.down = false
+	write(4)
+	w.send(true)
+	assert.Equal(t, 0, depth)
+
+	got := srv.received()
+	require.Len(t, got, 4)
+	for i, line := range got {
+		assert.Contains(t, line, "active_power="+string(rune('1'+i)))
+	}
+	assert.Contains(t, got[0], "inverter,device=inverter1,site=site1")
+	assert.Equal(t, "Token secret", srv.auth)
+}
+
+// TestWriterPermanentError kiểm tra dữ liệu bị từ chối không bị gửi lại mãi
+func TestWriterPermanentError(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "bad line", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	w, err := NewWriter(Config{URL: ts.URL + "/write", QueueDir: t.TempDir(), RetryBackoff: config.Duration(time.Millisecond)}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+
+	w.Write("inverter1", map[string]float64{"active_power": 1}, time.Now())
+	w.send(true)
+	assert.Equal(t, 1, calls, "Không thử lại khi bị từ chối")
+	assert.Equal(t, 0, w.QueueDepth(), "Không đưa vào hàng đợi")
+}
@@ -0,0 +1,298 @@
+// Package kpi tính các chỉ số hiệu suất của nhà máy điện mặt trời theo ngày:
+// hệ số hiệu suất (PR), sản lượng riêng (kWh/kWp) và khả dụng kỹ thuật.
+package kpi
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"modbus_inverter/internal/historian"
+	"modbus_inverter/internal/report"
+	"modbus_inverter/internal/store"
+)
+
+// Tín hiệu chỉ số được ghi vào store
+const (
+	SignalEnergy           = "kpi_energy"            // Điện năng trong ngày (kWh)
+	SignalSpecificYield    = "kpi_specific_yield"    // Sản lượng riêng (kWh/kWp)
+	SignalPerformanceRatio = "kpi_performance_ratio" // Hệ số hiệu suất (%)
+	SignalAvailability     = "kpi_availability"      // Khả dụng kỹ thuật (%)
+	SignalInsolation       = "kpi_insolation"        // Tổng bức xạ trong ngày (kWh/m²)
+)
+
+// Config cấu hình tính chỉ số hiệu suất
+type Config struct {
+	Enabled    bool    `json:"enabled"`
+	Irradiance string  `json:"irradiance"` // Bức xạ trên mặt phẳng tấm pin (W/m²) dạng thiết bị.tín hiệu, ví dụ "weather1.irradiance"
+	Threshold  float64 `json:"threshold"`  // Bức xạ tối thiểu để tính vào thời gian khả dụng (W/m²), mặc định 50
+	Plant      string  `json:"plant"`      // Tên thiết bị dùng cho chỉ số cả nhà máy, mặc định "plant"
+	PublishAt  string  `json:"publish_at"` // Giờ tính và công bố chỉ số của ngày hôm trước (HH:MM), mặc định "00:15"
+}
+
+// Device là inverter được tính chỉ số
+type Device struct {
+	Name     string
+	Capacity float64 // Công suất đỉnh (kWp)
+}
+
+// Source là nguồn dữ liệu lịch sử (historian.Historian)
+type Source interface {
+	Query(device, signal string, from, to time.Time, resolution string) ([]historian.Point, error)
+}
+
+// Result là chỉ số của một inverter hoặc cả nhà máy trong một ngày. PR và khả
+// dụng bỏ trống khi không có dữ liệu bức xạ hoặc không có lúc nào đủ nắng.
+type Result struct {
+	Device           string    `json:"device"`
+	Day              time.Time `json:"day"`
+	Energy           float64   `json:"energy"`                      // kWh
+	Capacity         float64   `json:"capacity"`                    // kWp
+	Insolation       float64   `json:"insolation"`                  // kWh/m²
+	SpecificYield    float64   `json:"specific_yield"`              // kWh/kWp
+	PerformanceRatio *float64  `json:"performance_ratio,omitempty"` // %
+	Availability     *float64  `json:"availability,omitempty"`      // %
+}
+
+// Calculator tính chỉ số từ dữ liệu lịch sử và công bố hằng ngày vào store
+type Calculator struct {
+	cfg     Config
+	src     Source
+	devices []Device
+	logger  *log.Logger
+	store   *store.Store
+
+	sensor, signal string // Thiết bị và tín hiệu bức xạ
+	publishAt      time.Duration
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewCalculator tạo bộ tính chỉ số cho các inverter
+func NewCalculator(cfg Config, src Source, devices []Device, logger *log.Logger) (*Calculator, error) {
+	if cfg.Threshold == 0 {
+		cfg.Threshold = 50
+	}
+	if cfg.Plant == "" {
+		cfg.Plant = "plant"
+	}
+	if cfg.PublishAt == "" {
+		cfg.PublishAt = "00:15"
+	}
+	at, err := time.Parse("15:04", cfg.PublishAt)
+	if err != nil {
+		return nil, fmt.Errorf("giờ công bố chỉ số %q không hợp lệ", cfg.PublishAt)
+	}
+	sensor, signal, ok := strings.Cut(cfg.Irradiance, ".")
+	if !ok || sensor == "" || signal == "" {
+		return nil, fmt.Errorf("tín hiệu bức xạ %q phải có dạng thiết bị.tín hiệu", cfg.Irradiance)
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("chưa có inverter để tính chỉ số")
+	}
+	for _, dev := range devices {
+		if dev.Capacity <= 0 {
+			return nil, fmt.Errorf("inverter %q chưa có công suất đỉnh", dev.Name)
+		}
+	}
+	return &Calculator{
+		cfg:       cfg,
+		src:       src,
+		devices:   devices,
+		logger:    logger,
+		sensor:    sensor,
+		signal:    signal,
+		publishAt: time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute,
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// SetStore đặt store để công bố chỉ số hằng ngày, gọi trước Start
+func (c *Calculator) SetStore(st *store.Store) {
+	c.store = st
+}
+
+// Day tính chỉ số của từng inverter và cả nhà máy (phần tử cuối) trong ngày chứa
+// day theo múi giờ của day. Ngày đang diễn ra được tính tới thời điểm hiện tại.
+func (c *Calculator) Day(day time.Time) ([]Result, error) {
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	to := from.AddDate(0, 0, 1)
+
+	insolation, sunny, err := c.irradiance(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	plant := Result{Device: c.cfg.Plant, Day: from, Insolation: insolation}
+	var availSum, availWeight float64
+	results := make([]Result, 0, len(c.devices)+1)
+	for _, dev := range c.devices {
+		intervals, err := report.Day(c.src, dev.Name, from)
+		if err != nil {
+			return nil, err
+		}
+		r := Result{Device: dev.Name, Day: from, Capacity: dev.Capacity, Insolation: insolation}
+		for _, iv := range intervals {
+			if !math.IsNaN(iv.Energy) {
+				r.Energy += iv.Energy
+			}
+		}
+		r.SpecificYield = r.Energy / dev.Capacity
+		r.PerformanceRatio = ratio(r.SpecificYield, insolation)
+
+		if len(sunny) > 0 {
+			running, err := c.running(dev.Name, from, to, sunny)
+			if err != nil {
+				return nil, err
+			}
+			avail := float64(running) / float64(len(sunny)) * 100
+			r.Availability = &avail
+			availSum += avail * dev.Capacity
+			availWeight += dev.Capacity
+		}
+		results = append(results, r)
+
+		plant.Energy += r.Energy
+		plant.Capacity += dev.Capacity
+	}
+
+	plant.SpecificYield = plant.Energy / plant.Capacity
+	plant.PerformanceRatio = ratio(plant.SpecificYield, insolation)
+	if availWeight > 0 {
+		avail := availSum / availWeight
+		plant.Availability = &avail
+	}
+	return append(results, plant), nil
+}
+
+// irradiance tính tổng bức xạ trong ngày (kWh/m²) từ trung bình 15 phút và các
+// phút có bức xạ không nhỏ hơn ngưỡng (Unix giây)
+func (c *Calculator) irradiance(from, to time.Time) (float64, map[int64]bool, error) {
+	points, err := c.src.Query(c.sensor, c.signal, from, to, historian.Resolution15m)
+	if err != nil {
+		return 0, nil, fmt.Errorf("đọc bức xạ %s lỗi: %w", c.cfg.Irradiance, err)
+	}
+	var insolation float64
+	for _, p := range points {
+		if p.Avg > 0 {
+			insolation += p.Avg * report.IntervalLength.Hours() / 1000
+		}
+	}
+
+	points, err = c.src.Query(c.sensor, c.signal, from, to, historian.Resolution1m)
+	if err != nil {
+		return 0, nil, fmt.Errorf("đọc bức xạ %s lỗi: %w", c.cfg.Irradiance, err)
+	}
+	sunny := make(map[int64]bool)
+	for _, p := range points {
+		if p.Avg >= c.cfg.Threshold {
+			sunny[p.Time.Unix()] = true
+		}
+	}
+	return insolation, sunny, nil
+}
+
+// running đếm số phút đủ nắng inverter hoạt động (device_status ≥ 1 cả phút),
+// phút thiếu dữ liệu được coi là không hoạt động
+func (c *Calculator) running(device string, from, to time.Time, sunny map[int64]bool) (int, error) {
+	status, err := c.src.Query(device, "device_status", from, to, historian.Resolution1m)
+	if err != nil {
+		return 0, fmt.Errorf("đọc device_status của %s lỗi: %w", device, err)
+	}
+	n := 0
+	for _, p := range status {
+		if p.Min >= 1 && sunny[p.Time.Unix()] {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ratio tính PR (%) từ sản lượng riêng và tổng bức xạ (so với 1 kW/m²), nil nếu không có bức xạ
+func ratio(specificYield, insolation float64) *float64 {
+	if insolation <= 0 {
+		return nil
+	}
+	pr := specificYield / insolation * 100
+	return &pr
+}
+
+// Publish tính chỉ số của ngày chứa day và ghi vào store với nhãn thời gian
+// nguồn là đầu ngày
+func (c *Calculator) Publish(day time.Time) error {
+	results, err := c.Day(day)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, r := range results {
+		tag := func(v float64) store.Tag {
+			return store.Tag{Value: v, Quality: store.QualityGood, Source: r.Day, Acquired: now}
+		}
+		tags := map[string]store.Tag{
+			SignalEnergy:        tag(r.Energy),
+			SignalSpecificYield: tag(r.SpecificYield),
+			SignalInsolation:    tag(r.Insolation),
+		}
+		if r.PerformanceRatio != nil {
+			tags[SignalPerformanceRatio] = tag(*r.PerformanceRatio)
+		}
+		if r.Availability != nil {
+			tags[SignalAvailability] = tag(*r.Availability)
+		}
+		if c.store != nil {
+			c.store.UpdateTags(r.Device, tags)
+		}
+	}
+
+	plant := results[len(results)-1]
+	msg := fmt.Sprintf("Chỉ số ngày %s: điện năng %.1f kWh, sản lượng riêng %.2f kWh/kWp, bức xạ %.2f kWh/m²",
+		plant.Day.Format("02/01/2006"), plant.Energy, plant.SpecificYield, plant.Insolation)
+	if plant.PerformanceRatio != nil {
+		msg += fmt.Sprintf(", PR %.1f%%", *plant.PerformanceRatio)
+	}
+	if plant.Availability != nil {
+		msg += fmt.Sprintf(", khả dụng %.1f%%", *plant.Availability)
+	}
+	c.logger.Println(msg)
+	return nil
+}
+
+// Start bắt đầu công bố chỉ số của ngày hôm trước vào giờ cấu hình mỗi ngày
+func (c *Calculator) Start() {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			timer := time.NewTimer(time.Until(c.next(time.Now())))
+			select {
+			case <-c.done:
+				timer.Stop()
+				return
+			case now := <-timer.C:
+				if err := c.Publish(now.AddDate(0, 0, -1)); err != nil {
+					c.logger.Printf("Tính chỉ số hiệu suất lỗi: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// next trả về thời điểm công bố tiếp theo sau now
+func (c *Calculator) next(now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Add(c.publishAt)
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// Close dừng công bố định kỳ
+func (c *Calculator) Close() {
+	close(c.done)
+	c.wg.Wait()
+}
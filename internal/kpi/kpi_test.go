@@ -0,0 +1,125 @@
+package kpi
+
+import (
+	"io"
+	"log"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/historian"
+	"modbus_inverter/internal/store"
+)
+
+// fakeSource giả lập dữ liệu lịch sử: bức xạ 1000 W/m² từ 06:00 đến 12:00, mỗi
+// inverter phát 60 kWh trong khoảng đó; inverter2 chỉ chạy tới 09:00
+type fakeSource struct {
+	day time.Time
+}
+
+func (s fakeSource) Query(device, signal string, from, to time.Time, resolution string) ([]historian.Point, error) {
+	step := 15 * time.Minute
+	if resolution == historian.Resolution1m {
+		step = time.Minute
+	}
+	var points []historian.Point
+	for t := from; t.Before(to); t = t.Add(step) {
+		h := t.Sub(s.day).Hours()
+		sunny := h >= 6 && h < 12
+		var v float64
+		switch device + "." + signal {
+		case "weather1.irradiance":
+			if sunny {
+				v = 1000
+			}
+		case "inverter1.total_energy_acc", "inverter2.total_energy_acc":
+			v = 10 * math.Min(math.Max(h-6, 0), 6)
+		case "inverter1.device_status":
+			if sunny {
+				v = 1
+			}
+		case "inverter2.device_status":
+			if h >= 6 && h < 9 {
+				v = 1
+			}
+		default:
+			return nil, nil
+		}
+		points = append(points, historian.Point{Time: t, Min: v, Max: v, Avg: v, Last: v, Count: 1})
+	}
+	return points, nil
+}
+
+// TestCalculator kiểm tra PR, sản lượng riêng và khả dụng của inverter và nhà máy
+func TestCalculator(t *testing.T) {
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	logger := log.New(io.Discard, "", 0)
+	devices := []Device{{Name: "inverter1", Capacity: 10}, {Name: "inverter2", Capacity: 20}}
+	c, err := NewCalculator(Config{Irradiance: "weather1.irradiance"}, fakeSource{day: day}, devices, logger)
+	require.NoError(t, err)
+
+	results, err := c.Day(day.Add(15 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	inv1, inv2, plant := results[0], results[1], results[2]
+	assert.Equal(t, day, inv1.Day)
+	assert.InDelta(t, 60, inv1.Energy, 1e-9)
+	assert.InDelta(t, 6, inv1.Insolation, 1e-9)
+	assert.InDelta(t, 6, inv1.SpecificYield, 1e-9)
+	require.NotNil(t, inv1.PerformanceRatio)
+	assert.InDelta(t, 100, *inv1.PerformanceRatio, 1e-9)
+	require.NotNil(t, inv1.Availability)
+	assert.InDelta(t, 100, *inv1.Availability, 1e-9)
+
+	assert.InDelta(t, 3, inv2.SpecificYield, 1e-9)
+	assert.InDelta(t, 50, *inv2.PerformanceRatio, 1e-9)
+	assert.InDelta(t, 50, *inv2.Availability, 1e-9)
+
+	assert.Equal(t, "plant", plant.Device)
+	assert.InDelta(t, 120, plant.Energy, 1e-9)
+	assert.InDelta(t, 30, plant.Capacity, 1e-9)
+	assert.InDelta(t, 4, plant.SpecificYield, 1e-9)
+	assert.InDelta(t, 66.666667, *plant.PerformanceRatio, 1e-6)
+	assert.InDelta(t, 66.666667, *plant.Availability, 1e-6, "Trung bình theo công suất đỉnh")
+
+	t.Run("Công bố vào store", func(t *testing.T) {
+		st := store.New()
+		c.SetStore(st)
+		require.NoError(t, c.Publish(day))
+		snap, ok := st.Get("plant")
+		require.True(t, ok)
+		assert.InDelta(t, 4, snap.Values[SignalSpecificYield], 1e-9)
+		assert.Equal(t, day, snap.Tags[SignalPerformanceRatio].Source)
+		snap, _ = st.Get("inverter2")
+		assert.InDelta(t, 50, snap.Values[SignalAvailability], 1e-9)
+	})
+
+	t.Run("Không có bức xạ", func(t *testing.T) {
+		c, err := NewCalculator(Config{Irradiance: "weather2.irradiance"}, fakeSource{day: day}, devices, logger)
+		require.NoError(t, err)
+		results, err := c.Day(day)
+		require.NoError(t, err)
+		assert.InDelta(t, 6, results[0].SpecificYield, 1e-9)
+		assert.Nil(t, results[0].PerformanceRatio)
+		assert.Nil(t, results[2].Availability)
+	})
+
+	t.Run("Giờ công bố", func(t *testing.T) {
+		assert.Equal(t, day.Add(15*time.Minute), c.next(day))
+		assert.Equal(t, day.Add(24*time.Hour+15*time.Minute), c.next(day.Add(15*time.Minute)))
+	})
+
+	for _, cfg := range []Config{
+		{Irradiance: "irradiance"},
+		{Irradiance: "weather1.irradiance", PublishAt: "25:00"},
+	} {
+		_, err := NewCalculator(cfg, fakeSource{}, devices, logger)
+		assert.Error(t, err, "%+v", cfg)
+	}
+	_, err = NewCalculator(Config{Irradiance: "weather1.irradiance"}, fakeSource{}, []Device{{Name: "inverter1"}}, logger)
+	assert.Error(t, err, "Thiếu công suất đỉnh")
+}
@@ -0,0 +1,88 @@
+package mbtcp
+
+// Kiểu dữ liệu thanh ghi
+const (
+	TypeUint16  = "uint16"
+	TypeInt16   = "int16"
+	TypeUint32  = "uint32"
+	TypeInt32   = "int32"
+	TypeFloat32 = "float32"
+)
+
+// Tín hiệu đặc biệt của mỗi thiết bị
+const (
+	SignalQuality   = "quality"   // Chất lượng dữ liệu: 0 tốt, 1 mất liên lạc, 2 cũ
+	SignalTimestamp = "timestamp" // Thời điểm đọc thành công gần nhất (Unix giây)
+)
+
+// Kiểu bảng thanh ghi mặc định
+const (
+	MapUnit = "unit" // Mỗi thiết bị một unit ID (bằng địa chỉ slave RS-485), cùng bố cục thanh ghi
+	MapFlat = "flat" // Mọi thiết bị trên một unit ID, mỗi thiết bị một khối BlockSize thanh ghi
+)
+
+// BlockSize số thanh ghi dành cho mỗi thiết bị trong bảng mặc định
+const BlockSize = 200
+
+// firstSignalOffset vị trí thanh ghi của tín hiệu đầu tiên trong khối,
+// các thanh ghi trước đó dành cho chất lượng và thời gian
+const firstSignalOffset = 10
+
+// Config cấu hình Modbus TCP server
+type Config struct {
+	Enabled    bool             `json:"enabled"`
+	ListenAddr string           `json:"listen_addr"` // Mặc định ":502"
+	Map        string           `json:"map"`         // "unit" (mặc định) hoặc "flat", dùng khi tự sinh bảng thanh ghi
+	UnitID     byte             `json:"unit_id"`     // Unit ID của bảng "flat", mặc định 1
+	Registers  []RegisterConfig `json:"registers"`   // Bảng thanh ghi, bỏ trống để tự sinh
+	Routes     []RouteConfig    `json:"routes"`      // Chuyển tiếp unit ID sang thiết bị trên bus RS-485
+	DefaultBus string           `json:"default_bus"` // Unit ID không có bảng thanh ghi và route được chuyển tiếp nguyên địa chỉ tới bus này, bỏ trống để tắt
+}
+
+// RouteConfig chuyển tiếp các yêu cầu tới một unit ID sang thiết bị RS-485
+type RouteConfig struct {
+	UnitID  byte   `json:"unit_id"`
+	Bus     string `json:"bus"`      // Tên bus
+	SlaveID byte   `json:"slave_id"` // Địa chỉ thiết bị trên bus, 0: bằng unit ID
+}
+
+// RegisterConfig ánh xạ một tín hiệu của thiết bị sang thanh ghi Modbus
+type RegisterConfig struct {
+	UnitID  byte    `json:"unit_id"`
+	Address uint16  `json:"address"` // Địa chỉ 0-based
+	Device  string  `json:"device"`
+	Signal  string  `json:"signal"` // Tên tín hiệu hoặc "quality", "timestamp"
+	Type    string  `json:"type"`   // uint16, int16, uint32, int32, float32; mặc định float32
+	Scale   float64 `json:"scale"`  // Hệ số nhân trước khi mã hóa, mặc định 1
+}
+
+// DefaultRegisters sinh bảng thanh ghi cho một thiết bị bắt đầu tại base:
+// base+0 chất lượng (uint16), base+1 thời gian (uint32), từ base+10 các
+// tín hiệu dạng float32 theo thứ tự của signals
+func DefaultRegisters(device string, unitID byte, base uint16, signals []string) []RegisterConfig {
+	regs := []RegisterConfig{
+		{UnitID: unitID, Address: base, Device: device, Signal: SignalQuality, Type: TypeUint16},
+		{UnitID: unitID, Address: base + 1, Device: device, Signal: SignalTimestamp, Type: TypeUint32},
+	}
+	for i, sig := range signals {
+		regs = append(regs, RegisterConfig{
+			UnitID:  unitID,
+			Address: base + firstSignalOffset + uint16(2*i),
+			Device:  device,
+			Signal:  sig,
+			Type:    TypeFloat32,
+		})
+	}
+	return regs
+}
+
+// size trả về số thanh ghi của kiểu dữ liệu, 0 nếu kiểu không hợp lệ
+func size(typ string) uint16 {
+	switch typ {
+	case TypeUint16, TypeInt16:
+		return 1
+	case TypeUint32, TypeInt32, TypeFloat32:
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,350 @@
+package mbtcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"sync"
+
+	"modbus_inverter/internal/store"
+)
+
+// Mã hàm Modbus
+const (
+	fcReadHoldingRegisters = 0x03
+	fcReadInputRegisters   = 0x04
+)
+
+// Mã ngoại lệ Modbus
+const (
+	exIllegalFunction    = 0x01
+	exIllegalAddress     = 0x02
+	exIllegalValue       = 0x03
+	exGatewayUnavailable = 0x0A
+	exGatewayNoResponse  = 0x0B
+)
+
+// maxReadQuantity số thanh ghi tối đa của một lệnh đọc
+const maxReadQuantity = 125
+
+// register là một thanh ghi (hoặc cặp thanh ghi) đã kiểm tra cấu hình
+type register struct {
+	cfg  RegisterConfig
+	size uint16
+}
+
+// Forwarder gửi nguyên PDU tới thiết bị trên bus RS-485 và trả về PDU phản hồi
+type Forwarder interface {
+	Forward(slaveID byte, pdu []byte) ([]byte, error)
+}
+
+// Server là Modbus TCP server công bố giá trị mới nhất của các thiết bị
+// trong store theo bảng thanh ghi cấu hình
+type Server struct {
+	cfg    Config
+	store  *store.Store
+	logger *log.Logger
+	units  map[byte][]register // Sắp xếp theo địa chỉ
+	routes map[byte]RouteConfig
+	buses  map[string]Forwarder
+
+	listener net.Listener
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer tạo Modbus TCP server từ cấu hình
+func NewServer(cfg Config, st *store.Store, logger *log.Logger) (*Server, error) {
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":502"
+	}
+
+	s := &Server{
+		cfg:    cfg,
+		store:  st,
+		logger: logger,
+		units:  make(map[byte][]register),
+		routes: make(map[byte]RouteConfig),
+		buses:  make(map[string]Forwarder),
+		conns:  make(map[net.Conn]struct{}),
+	}
+
+	for _, rc := range cfg.Registers {
+		if rc.Type == "" {
+			rc.Type = TypeFloat32
+		}
+		if rc.Scale == 0 {
+			rc.Scale = 1
+		}
+		n := size(rc.Type)
+		if n == 0 {
+			return nil, fmt.Errorf("kiểu thanh ghi không hợp lệ %q (unit %d, địa chỉ %d)", rc.Type, rc.UnitID, rc.Address)
+		}
+		if int(rc.Address)+int(n) > 0x10000 {
+			return nil, fmt.Errorf("thanh ghi %d vượt quá vùng địa chỉ (unit %d)", rc.Address, rc.UnitID)
+		}
+		s.units[rc.UnitID] = append(s.units[rc.UnitID], register{cfg: rc, size: n})
+	}
+
+	for unit, regs := range s.units {
+		sort.Slice(regs, func(i, j int) bool { return regs[i].cfg.Address < regs[j].cfg.Address })
+		for i := 1; i < len(regs); i++ {
+			prev := regs[i-1]
+			if prev.cfg.Address+prev.size > regs[i].cfg.Address {
+				return nil, fmt.Errorf("thanh ghi %d và %d bị chồng lấn (unit %d)", prev.cfg.Address, regs[i].cfg.Address, unit)
+			}
+		}
+	}
+
+	for _, rc := range cfg.Routes {
+		if _, ok := s.units[rc.UnitID]; ok {
+			return nil, fmt.Errorf("unit %d vừa có bảng thanh ghi vừa được chuyển tiếp", rc.UnitID)
+		}
+		if _, ok := s.routes[rc.UnitID]; ok {
+			return nil, fmt.Errorf("route unit %d bị trùng", rc.UnitID)
+		}
+		if rc.SlaveID == 0 {
+			rc.SlaveID = rc.UnitID
+		}
+		s.routes[rc.UnitID] = rc
+	}
+	return s, nil
+}
+
+// SetBus đăng ký bus RS-485 để chuyển tiếp yêu cầu, gọi trước Start
+func (s *Server) SetBus(name string, f Forwarder) {
+	s.buses[name] = f
+}
+
+// Start bắt đầu lắng nghe kết nối
+func (s *Server) Start() error {
+	for _, rc := range s.routes {
+		if _, ok := s.buses[rc.Bus]; !ok {
+			return fmt.Errorf("route unit %d tới bus chưa đăng ký %q", rc.UnitID, rc.Bus)
+		}
+	}
+	if s.cfg.DefaultBus != "" {
+		if _, ok := s.buses[s.cfg.DefaultBus]; !ok {
+			return fmt.Errorf("bus mặc định chưa đăng ký %q", s.cfg.DefaultBus)
+		}
+	}
+
+	l, err := net.Listen("tcp", s.cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("lắng nghe %s lỗi: %w", s.cfg.ListenAddr, err)
+	}
+	s.listener = l
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	s.logger.Printf("Modbus TCP: server lắng nghe tại %s, %d unit, %d route", l.Addr(), len(s.units), len(s.routes))
+	return nil
+}
+
+// Addr trả về địa chỉ đang lắng nghe
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close dừng server và đóng mọi kết nối
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// acceptLoop chấp nhận kết nối mới cho tới khi listener bị đóng
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+// serve xử lý các yêu cầu trên một kết nối
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	header := make([]byte, 7)
+	for {
+		// MBAP: transaction ID (2), protocol ID (2), độ dài (2), unit ID (1)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:6])
+		if binary.BigEndian.Uint16(header[2:4]) != 0 || length < 2 || length > 254 {
+			s.logger.Printf("Modbus TCP: khung không hợp lệ từ %s", conn.RemoteAddr())
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		resp := s.handle(header[6], pdu)
+
+		out := make([]byte, 7+len(resp))
+		copy(out, header[:4])
+		binary.BigEndian.PutUint16(out[4:6], uint16(len(resp)+1))
+		out[6] = header[6]
+		copy(out[7:], resp)
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+// handle xử lý một PDU yêu cầu và trả về PDU phản hồi
+func (s *Server) handle(unit byte, pdu []byte) []byte {
+	fc := pdu[0]
+	regs, ok := s.units[unit]
+	if !ok {
+		return s.forward(unit, pdu)
+	}
+
+	switch fc {
+	case fcReadHoldingRegisters, fcReadInputRegisters:
+		if len(pdu) != 5 {
+			return exception(fc, exIllegalValue)
+		}
+		address := binary.BigEndian.Uint16(pdu[1:3])
+		quantity := binary.BigEndian.Uint16(pdu[3:5])
+		if quantity == 0 || quantity > maxReadQuantity {
+			return exception(fc, exIllegalValue)
+		}
+		data, ok := s.read(regs, address, quantity)
+		if !ok {
+			return exception(fc, exIllegalAddress)
+		}
+		return append([]byte{fc, byte(len(data))}, data...)
+	}
+	return exception(fc, exIllegalFunction)
+}
+
+// forward chuyển tiếp yêu cầu tới thiết bị RS-485 theo route hoặc bus mặc định
+func (s *Server) forward(unit byte, pdu []byte) []byte {
+	rc, ok := s.routes[unit]
+	if !ok {
+		if s.cfg.DefaultBus == "" {
+			return exception(pdu[0], exGatewayUnavailable)
+		}
+		// Unit 0 là broadcast, 248-255 là địa chỉ dành riêng: không thiết bị nào
+		// trả lời trên bus nên gateway trả lời luôn thay vì chờ hết timeout
+		if unit == 0 || unit >= 248 {
+			return exception(pdu[0], exGatewayNoResponse)
+		}
+		rc = RouteConfig{UnitID: unit, Bus: s.cfg.DefaultBus, SlaveID: unit}
+	}
+
+	resp, err := s.buses[rc.Bus].Forward(rc.SlaveID, pdu)
+	if err != nil {
+		s.logger.Printf("Modbus TCP: chuyển tiếp unit %d tới %s/%d lỗi: %v", unit, rc.Bus, rc.SlaveID, err)
+		return exception(pdu[0], exGatewayNoResponse)
+	}
+	return resp
+}
+
+// read mã hóa vùng thanh ghi [address, address+quantity). Thanh ghi không có
+// trong bảng đọc ra 0; trả về false nếu vùng đọc không chứa thanh ghi nào
+// hoặc cắt ngang một giá trị 32 bit.
+func (s *Server) read(regs []register, address, quantity uint16) ([]byte, bool) {
+	start := int(address)
+	end := start + int(quantity)
+	data := make([]byte, 2*quantity)
+	snapshots := make(map[string]store.Snapshot)
+
+	found := false
+	for _, r := range regs {
+		rs := int(r.cfg.Address)
+		re := rs + int(r.size)
+		if re <= start || rs >= end {
+			continue
+		}
+		if rs < start || re > end {
+			return nil, false
+		}
+		found = true
+
+		snap, ok := snapshots[r.cfg.Device]
+		if !ok {
+			snap, ok = s.store.Get(r.cfg.Device)
+			if !ok {
+				snap = store.Snapshot{Device: r.cfg.Device, Quality: store.QualityCommFailure}
+			}
+			snapshots[r.cfg.Device] = snap
+		}
+		encode(data[2*(rs-start):2*(re-start)], r.cfg, snap)
+	}
+	return data, found
+}
+
+// encode ghi giá trị tín hiệu vào buf theo kiểu dữ liệu của thanh ghi
+func encode(buf []byte, rc RegisterConfig, snap store.Snapshot) {
+	var value float64
+	switch rc.Signal {
+	case SignalQuality:
+		value = float64(snap.Quality)
+	case SignalTimestamp:
+		if !snap.Timestamp.IsZero() {
+			value = float64(snap.Timestamp.Unix())
+		}
+	default:
+		v, ok := snap.Values[rc.Signal]
+		if !ok {
+			v = math.NaN()
+		}
+		value = v * rc.Scale
+	}
+
+	switch rc.Type {
+	case TypeFloat32:
+		binary.BigEndian.PutUint32(buf, math.Float32bits(float32(value)))
+	case TypeUint16:
+		binary.BigEndian.PutUint16(buf, uint16(clamp(value, 0, math.MaxUint16)))
+	case TypeInt16:
+		binary.BigEndian.PutUint16(buf, uint16(int16(clamp(value, math.MinInt16, math.MaxInt16))))
+	case TypeUint32:
+		binary.BigEndian.PutUint32(buf, uint32(clamp(value, 0, math.MaxUint32)))
+	case TypeInt32:
+		binary.BigEndian.PutUint32(buf, uint32(int32(clamp(value, math.MinInt32, math.MaxInt32))))
+	}
+}
+
+// clamp làm tròn và kẹp giá trị trong khoảng của kiểu số nguyên, NaN thành 0
+func clamp(v, min, max float64) float64 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return math.Max(min, math.Min(max, math.Round(v)))
+}
+
+// exception tạo PDU phản hồi ngoại lệ
+func exception(fc, code byte) []byte {
+	return []byte{fc | 0x80, code}
+}
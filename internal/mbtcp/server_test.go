@@ -0,0 +1,209 @@
+package mbtcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/store"
+)
+
+// newTestServer khởi động server trên cổng ngẫu nhiên với bảng thanh ghi cho trước
+func newTestServer(t *testing.T, st *store.Store, regs []RegisterConfig) *Server {
+	srv, err := NewServer(Config{ListenAddr: "127.0.0.1:0", Registers: regs}, st, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	require.NoError(t, srv.Start())
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// newTestClient kết nối tới server với unit ID cho trước
+func newTestClient(t *testing.T, srv *Server, unit byte) modbus.Client {
+	handler := modbus.NewTCPClientHandler(srv.Addr().String())
+	handler.SlaveId = unit
+	handler.Timeout = 2 * time.Second
+	require.NoError(t, handler.Connect())
+	t.Cleanup(func() { handler.Close() })
+	return modbus.NewClient(handler)
+}
+
+// TestServer kiểm tra Modbus TCP server công bố dữ liệu từ store
+func TestServer(t *testing.T) {
+	st := store.New()
+	ts := time.Unix(1700000000, 0)
+	st.Update("inverter1", map[string]float64{"active_power": 4.5, "voltage": 230.1}, ts)
+	st.Update("meter1", map[string]float64{"active_power_total": -2.25}, ts)
+
+	regs := DefaultRegisters("inverter1", 1, 0, []string{"active_power", "voltage", "temperature"})
+	regs = append(regs, DefaultRegisters("meter1", 10, 0, []string{"active_power_total"})...)
+	regs = append(regs,
+		RegisterConfig{UnitID: 1, Address: 100, Device: "inverter1", Signal: "active_power", Type: TypeUint16, Scale: 100},
+		RegisterConfig{UnitID: 1, Address: 101, Device: "meter1", Signal: "active_power_total", Type: TypeInt32, Scale: 1000},
+	)
+	srv := newTestServer(t, st, regs)
+
+	t.Run("Đọc theo unit ID", func(t *testing.T) {
+		client := newTestClient(t, srv, 1)
+		data, err := client.ReadHoldingRegisters(0, 16)
+		require.NoError(t, err)
+
+		assert.Equal(t, uint16(store.QualityGood), binary.BigEndian.Uint16(data[0:2]), "Chất lượng")
+		assert.Equal(t, uint32(ts.Unix()), binary.BigEndian.Uint32(data[2:6]), "Thời gian")
+		assert.InDelta(t, 4.5, math.Float32frombits(binary.BigEndian.Uint32(data[20:24])), 1e-6)
+		assert.InDelta(t, 230.1, math.Float32frombits(binary.BigEndian.Uint32(data[24:28])), 1e-3)
+		assert.True(t, math.IsNaN(float64(math.Float32frombits(binary.BigEndian.Uint32(data[28:32])))), "Tín hiệu chưa có giá trị là NaN")
+
+		meter := newTestClient(t, srv, 10)
+		data, err = meter.ReadInputRegisters(10, 2)
+		require.NoError(t, err)
+		assert.InDelta(t, -2.25, math.Float32frombits(binary.BigEndian.Uint32(data)), 1e-6)
+	})
+
+	t.Run("Kiểu số nguyên và hệ số", func(t *testing.T) {
+		client := newTestClient(t, srv, 1)
+		data, err := client.ReadHoldingRegisters(100, 3)
+		require.NoError(t, err)
+		assert.Equal(t, uint16(450), binary.BigEndian.Uint16(data[0:2]))
+		assert.Equal(t, int32(-2250), int32(binary.BigEndian.Uint32(data[2:6])))
+	})
+
+	t.Run("Chất lượng khi đọc lỗi", func(t *testing.T) {
+		st.MarkInvalid("inverter1", time.Now())
+		defer st.Update("inverter1", nil, ts)
+
+		client := newTestClient(t, srv, 1)
+		data, err := client.ReadHoldingRegisters(0, 1)
+		require.NoError(t, err)
+		assert.Equal(t, uint16(store.QualityCommFailure), binary.BigEndian.Uint16(data))
+	})
+
+	t.Run("Ngoại lệ", func(t *testing.T) {
+		client := newTestClient(t, srv, 1)
+
+		_, err := client.ReadHoldingRegisters(1, 1)
+		assert.Error(t, err, "Không được cắt ngang giá trị 32 bit")
+
+		_, err = client.ReadHoldingRegisters(5000, 2)
+		assert.Error(t, err, "Vùng không có thanh ghi")
+
+		unknown := newTestClient(t, srv, 99)
+		_, err = unknown.ReadHoldingRegisters(0, 1)
+		assert.Error(t, err, "Unit ID không tồn tại")
+	})
+}
+
+// fakeBus giả lập bus RS-485, trả về PDU phản hồi cố định và ghi lại yêu cầu
+type fakeBus struct {
+	mu       sync.Mutex
+	requests []byte // Slave ID của các yêu cầu đã nhận
+	fail     bool
+}
+
+func (b *fakeBus) Forward(slaveID byte, pdu []byte) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, slaveID)
+	if b.fail {
+		return nil, errors.New("timeout")
+	}
+	if slaveID == 7 {
+		return []byte{pdu[0] | 0x80, 0x02}, nil
+	}
+	quantity := binary.BigEndian.Uint16(pdu[3:5])
+	data := make([]byte, 2*quantity)
+	for i := range data {
+		data[i] = slaveID
+	}
+	return append([]byte{pdu[0], byte(len(data))}, data...), nil
+}
+
+// TestPassThrough kiểm tra chuyển tiếp yêu cầu Modbus TCP sang bus RS-485
+func TestPassThrough(t *testing.T) {
+	bus := &fakeBus{}
+	srv, err := NewServer(Config{
+		ListenAddr: "127.0.0.1:0",
+		Registers:  DefaultRegisters("inverter1", 1, 0, []string{"active_power"}),
+		Routes:     []RouteConfig{{UnitID: 100, Bus: "rs485", SlaveID: 3}},
+		DefaultBus: "rs485",
+	}, store.New(), log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	srv.SetBus("rs485", bus)
+	require.NoError(t, srv.Start())
+	defer srv.Close()
+
+	t.Run("Theo route", func(t *testing.T) {
+		data, err := newTestClient(t, srv, 100).ReadHoldingRegisters(0, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{3, 3, 3, 3}, data, "Unit 100 được chuyển tới slave 3")
+	})
+
+	t.Run("Theo bus mặc định", func(t *testing.T) {
+		data, err := newTestClient(t, srv, 5).ReadHoldingRegisters(0, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{5, 5}, data)
+	})
+
+	t.Run("Unit có bảng thanh ghi không chuyển tiếp", func(t *testing.T) {
+		bus.requests = nil
+		_, err := newTestClient(t, srv, 1).ReadHoldingRegisters(0, 1)
+		require.NoError(t, err)
+		assert.Empty(t, bus.requests)
+	})
+
+	t.Run("Ngoại lệ từ thiết bị và lỗi bus", func(t *testing.T) {
+		_, err := newTestClient(t, srv, 7).ReadHoldingRegisters(0, 1)
+		var mbErr *modbus.ModbusError
+		require.ErrorAs(t, err, &mbErr)
+		assert.Equal(t, byte(0x02), mbErr.ExceptionCode)
+
+		bus.fail = true
+		defer func() { bus.fail = false }()
+		_, err = newTestClient(t, srv, 5).ReadHoldingRegisters(0, 1)
+		require.ErrorAs(t, err, &mbErr)
+		assert.Equal(t, byte(exGatewayNoResponse), mbErr.ExceptionCode)
+	})
+
+	t.Run("Broadcast và unit dành riêng không chuyển tiếp", func(t *testing.T) {
+		bus.requests = nil
+		for _, unit := range []byte{0, 248, 255} {
+			_, err := newTestClient(t, srv, unit).ReadHoldingRegisters(0, 1)
+			var mbErr *modbus.ModbusError
+			require.ErrorAs(t, err, &mbErr, "Unit %d", unit)
+			assert.Equal(t, byte(exGatewayNoResponse), mbErr.ExceptionCode)
+		}
+		assert.Empty(t, bus.requests)
+	})
+}
+
+// TestNewServer kiểm tra cấu hình bảng thanh ghi không hợp lệ
+func TestNewServer(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+
+	_, err := NewServer(Config{Registers: []RegisterConfig{
+		{UnitID: 1, Address: 0, Signal: "a", Type: TypeFloat32},
+		{UnitID: 1, Address: 1, Signal: "b", Type: TypeUint16},
+	}}, store.New(), logger)
+	assert.Error(t, err, "Thanh ghi chồng lấn")
+
+	_, err = NewServer(Config{Registers: []RegisterConfig{{UnitID: 1, Signal: "a", Type: "double"}}}, store.New(), logger)
+	assert.Error(t, err, "Kiểu không hợp lệ")
+
+	_, err = NewServer(Config{
+		Registers: []RegisterConfig{{UnitID: 1, Signal: "a"}},
+		Routes:    []RouteConfig{{UnitID: 1, Bus: "rs485"}},
+	}, store.New(), logger)
+	assert.Error(t, err, "Unit vừa có bảng thanh ghi vừa chuyển tiếp")
+
+	srv, err := NewServer(Config{ListenAddr: "127.0.0.1:0", Routes: []RouteConfig{{UnitID: 2, Bus: "rs485"}}}, store.New(), logger)
+	require.NoError(t, err)
+	assert.Error(t, srv.Start(), "Route tới bus chưa đăng ký")
+}
@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"modbus_inverter/internal/modbus"
+	"modbus_inverter/internal/store"
+)
+
+// namespace tiền tố tên metric
+const namespace = "gateway"
+
+// Config cấu hình endpoint Prometheus /metrics
+type Config struct {
+	Enabled    bool   `json:"enabled"`
+	ListenAddr string `json:"listen_addr"` // Cổng riêng không xác thực, bỏ trống để phục vụ qua HTTP API (có xác thực)
+}
+
+// Metrics thu thập các chỉ số giám sát của gateway cho Prometheus
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests     *prometheus.CounterVec
+	timeouts     *prometheus.CounterVec
+	crcErrors    *prometheus.CounterVec
+	exceptions   *prometheus.CounterVec
+	otherErrors  *prometheus.CounterVec
+	reconnects   *prometheus.CounterVec
+	pollDuration *prometheus.HistogramVec
+	pollErrors   *prometheus.CounterVec
+	queueDepth   *prometheus.GaugeVec
+
+	server *http.Server
+	wg     sync.WaitGroup
+}
+
+// New tạo bộ thu thập chỉ số, giá trị thiết bị được đọc từ store khi Prometheus lấy mẫu
+func New(st *store.Store) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "modbus_requests_total",
+			Help: "Số giao dịch Modbus đã gửi trên bus",
+		}, []string{"bus"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "modbus_timeouts_total",
+			Help: "Số giao dịch Modbus không có phản hồi",
+		}, []string{"bus"}),
+		crcErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "modbus_crc_errors_total",
+			Help: "Số phản hồi Modbus sai CRC hoặc sai khung",
+		}, []string{"bus"}),
+		exceptions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "modbus_exceptions_total",
+			Help: "Số phản hồi ngoại lệ Modbus theo mã ngoại lệ",
+		}, []string{"bus", "code"}),
+		otherErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "modbus_errors_total",
+			Help: "Số lỗi cổng serial và lỗi khác",
+		}, []string{"bus"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "modbus_reconnects_total",
+			Help: "Số lần mở lại cổng serial sau lỗi",
+		}, []string{"bus"}),
+		pollDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "poll_duration_seconds",
+			Help:    "Thời gian đọc dữ liệu một thiết bị",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"device"}),
+		pollErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "poll_errors_total",
+			Help: "Số lần đọc dữ liệu thiết bị thất bại",
+		}, []string{"device"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "queue_depth",
+			Help: "Số bản ghi đang chờ gửi trong hàng đợi lưu và chuyển tiếp",
+		}, []string{"queue"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests, m.timeouts, m.crcErrors, m.exceptions, m.otherErrors, m.reconnects,
+		m.pollDuration, m.pollErrors, m.queueDepth,
+		&storeCollector{store: st},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler trả về HTTP handler của endpoint /metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Bus trả về bộ theo dõi giao dịch cho một bus RS-485
+func (m *Metrics) Bus(name string) modbus.BusObserver {
+	return &busObserver{m: m, bus: name}
+}
+
+// ObservePoll ghi nhận một lần đọc thiết bị
+func (m *Metrics) ObservePoll(device string, duration time.Duration, err error) {
+	m.pollDuration.WithLabelValues(device).Observe(duration.Seconds())
+	if err != nil {
+		m.pollErrors.WithLabelValues(device).Inc()
+	}
+}
+
+// SetQueueDepth cập nhật số bản ghi đang chờ trong một hàng đợi
+func (m *Metrics) SetQueueDepth(queue string, depth int) {
+	m.queueDepth.WithLabelValues(queue).Set(float64(depth))
+}
+
+// Start mở cổng riêng cho /metrics nếu cấu hình ListenAddr
+func (m *Metrics) Start(cfg Config, logger *log.Logger) error {
+	l, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("lắng nghe %s lỗi: %w", cfg.ListenAddr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.Handler())
+	m.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if err := m.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Printf("Metrics: lỗi phục vụ: %v", err)
+		}
+	}()
+	logger.Printf("Metrics: lắng nghe tại %s", l.Addr())
+	return nil
+}
+
+// Close dừng cổng /metrics riêng nếu đang chạy
+func (m *Metrics) Close() error {
+	if m.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := m.server.Shutdown(ctx)
+	m.wg.Wait()
+	return err
+}
+
+// busObserver đếm giao dịch và lỗi trên một bus
+type busObserver struct {
+	m   *Metrics
+	bus string
+}
+
+func (o *busObserver) ObserveRequest(slaveID byte, err error) {
+	o.m.requests.WithLabelValues(o.bus).Inc()
+	switch modbus.ErrorKind(err) {
+	case modbus.ErrorKindTimeout:
+		o.m.timeouts.WithLabelValues(o.bus).Inc()
+	case modbus.ErrorKindCRC:
+		o.m.crcErrors.WithLabelValues(o.bus).Inc()
+	case modbus.ErrorKindException:
+		code, _ := modbus.ExceptionCode(err)
+		o.m.exceptions.WithLabelValues(o.bus, strconv.Itoa(int(code))).Inc()
+	case modbus.ErrorKindOther:
+		o.m.otherErrors.WithLabelValues(o.bus).Inc()
+	}
+}
+
+func (o *busObserver) ObserveReconnect() {
+	o.m.reconnects.WithLabelValues(o.bus).Inc()
+}
+
+// storeCollector xuất giá trị mới nhất của mọi thiết bị trong store
+type storeCollector struct {
+	store *store.Store
+}
+
+var (
+	valueDesc = prometheus.NewDesc(namespace+"_device_value",
+		"Giá trị mới nhất của tín hiệu thiết bị", []string{"device", "signal"}, nil)
+	qualityDesc = prometheus.NewDesc(namespace+"_device_quality",
+		"Chất lượng dữ liệu thiết bị: 0 tốt, 1 mất liên lạc, 2 cũ", []string{"device"}, nil)
+	timestampDesc = prometheus.NewDesc(namespace+"_device_last_update_timestamp_seconds",
+		"Thời điểm đọc thành công gần nhất (Unix giây)", []string{"device"}, nil)
+)
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- valueDesc
+	ch <- qualityDesc
+	ch <- timestampDesc
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, snap := range c.store.All() {
+		ch <- prometheus.MustNewConstMetric(qualityDesc, prometheus.GaugeValue, float64(snap.Quality), snap.Device)
+		if !snap.Timestamp.IsZero() {
+			ch <- prometheus.MustNewConstMetric(timestampDesc, prometheus.GaugeValue, float64(snap.Timestamp.Unix()), snap.Device)
+		}
+		for signal, v := range snap.Values {
+			ch <- prometheus.MustNewConstMetric(valueDesc, prometheus.GaugeValue, v, snap.Device, signal)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/store"
+)
+
+// TestMetrics kiểm tra các chỉ số xuất ra endpoint /metrics
+func TestMetrics(t *testing.T) {
+	st := store.New()
+	st.Update("inverter1", map[string]float64{"active_power": 4.5}, time.Unix(1700000000, 0))
+	m := New(st)
+
+	bus := m.Bus("rs485")
+	bus.ObserveRequest(1, nil)
+	bus.ObserveRequest(1, serial.ErrTimeout)
+	bus.ObserveRequest(1, errors.New("modbus: response crc '1' does not match expected '2'"))
+	bus.ObserveRequest(1, &modbus.ModbusError{FunctionCode: 0x83, ExceptionCode: 2})
+	bus.ObserveRequest(1, &modbus.ModbusError{FunctionCode: 0x83, ExceptionCode: 2})
+	bus.ObserveReconnect()
+	m.ObservePoll("inverter1", 120*time.Millisecond, nil)
+	m.ObservePoll("inverter1", 2*time.Second, errors.New("timeout"))
+	m.SetQueueDepth("influx", 42)
+
+	assert.Equal(t, 5.0, testutil.ToFloat64(m.requests.WithLabelValues("rs485")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.timeouts.WithLabelValues("rs485")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.crcErrors.WithLabelValues("rs485")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.exceptions.WithLabelValues("rs485", "2")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.reconnects.WithLabelValues("rs485")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.pollErrors.WithLabelValues("inverter1")))
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	text := string(body)
+
+	for _, line := range []string{
+		`gateway_device_value{device="inverter1",signal="active_power"} 4.5`,
+		`gateway_device_quality{device="inverter1"} 0`,
+		`gateway_device_last_update_timestamp_seconds{device="inverter1"} 1.7e+09`,
+		`gateway_poll_duration_seconds_count{device="inverter1"} 2`,
+		`gateway_queue_depth{queue="influx"} 42`,
+	} {
+		assert.True(t, strings.Contains(text, line), "Thiếu dòng %q", line)
+	}
+}
@@ -2,6 +2,7 @@ package modbus
 
 import (
 	"encoding/binary"
+	"fmt"
 	"time"
 
 	"github.com/goburrow/modbus"
@@ -60,3 +61,179 @@ func (c *Client) WriteMultipleRegisters(address uint16, values []uint16) error {
 	_, err := c.client.WriteMultipleRegisters(address, uint16(len(values)), data)
 	return err
 }
+
+// RTUClient đại diện cho một bus RS-485 dùng chung cho nhiều thiết bị,
+// mỗi lệnh đọc/ghi chỉ định Slave ID riêng. Các giao dịch được phục vụ
+// lần lượt theo thứ tự đến để poller và lệnh chuyển tiếp chia sẻ bus công bằng.
+type RTUClient struct {
+	mu       fairMutex
+	handler  *modbus.RTUClientHandler
+	client   modbus.Client
+	observer BusObserver
+	closed   bool // Cổng serial đã bị đóng sau lỗi, sẽ mở lại ở giao dịch tiếp theo
+}
+
+// NewRTUClient mở cổng serial và tạo client dùng chung cho cả bus
+func NewRTUClient(port string, baudRate int, dataBits int, stopBits int, parity string) (*RTUClient, error) {
+	handler := modbus.NewRTUClientHandler(port)
+	handler.BaudRate = baudRate
+	handler.DataBits = dataBits
+	handler.StopBits = stopBits
+	handler.Parity = parity
+	handler.Timeout = 2 * time.Second
+
+	err := handler.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RTUClient{
+		handler: handler,
+		client:  modbus.NewClient(handler),
+	}, nil
+}
+
+// SetObserver đặt bộ theo dõi giao dịch trên bus, gọi trước khi sử dụng client
+func (c *RTUClient) SetObserver(o BusObserver) {
+	c.observer = o
+}
+
+// Close đóng cổng serial của bus
+func (c *RTUClient) Close() error {
+	return c.handler.Close()
+}
+
+// do thực hiện một giao dịch với thiết bị có Slave ID cho trước khi đang giữ bus.
+// Lỗi cổng serial (không phải ngoại lệ hay sai CRC) sẽ đóng cổng để giao dịch
+// sau mở lại, giúp gateway tự phục hồi khi bộ chuyển đổi USB-RS485 bị rút ra.
+func (c *RTUClient) do(slaveID byte, fn func() error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		if err := c.handler.Connect(); err != nil {
+			if c.observer != nil {
+				c.observer.ObserveRequest(slaveID, err)
+			}
+			return err
+		}
+		c.closed = false
+		if c.observer != nil {
+			c.observer.ObserveReconnect()
+		}
+	}
+
+	c.handler.SlaveId = slaveID
+	err := fn()
+	if c.observer != nil {
+		c.observer.ObserveRequest(slaveID, err)
+	}
+	if ErrorKind(err) == ErrorKindOther {
+		c.handler.Close()
+		c.closed = true
+	}
+	return err
+}
+
+// ReadHoldingRegisters đọc các thanh ghi giữ của thiết bị có Slave ID cho trước
+func (c *RTUClient) ReadHoldingRegisters(slaveID byte, address uint16, quantity uint16) ([]byte, error) {
+	var data []byte
+	err := c.do(slaveID, func() (err error) {
+		data, err = c.client.ReadHoldingRegisters(address, quantity)
+		return err
+	})
+	return data, err
+}
+
+// ReadInputRegisters đọc các thanh ghi đầu vào của thiết bị có Slave ID cho trước
+func (c *RTUClient) ReadInputRegisters(slaveID byte, address uint16, quantity uint16) ([]byte, error) {
+	var data []byte
+	err := c.do(slaveID, func() (err error) {
+		data, err = c.client.ReadInputRegisters(address, quantity)
+		return err
+	})
+	return data, err
+}
+
+// WriteSingleRegister ghi một thanh ghi của thiết bị có Slave ID cho trước
+func (c *RTUClient) WriteSingleRegister(slaveID byte, address uint16, value uint16) error {
+	return c.do(slaveID, func() error {
+		_, err := c.client.WriteSingleRegister(address, value)
+		return err
+	})
+}
+
+// WriteMultipleRegisters ghi nhiều thanh ghi của thiết bị có Slave ID cho trước
+func (c *RTUClient) WriteMultipleRegisters(slaveID byte, address uint16, values []uint16) error {
+	data := make([]byte, len(values)*2)
+	for i, v := range values {
+		binary.BigEndian.PutUint16(data[i*2:], v)
+	}
+
+	return c.do(slaveID, func() error {
+		_, err := c.client.WriteMultipleRegisters(address, uint16(len(values)), data)
+		return err
+	})
+}
+
+// Forward gửi nguyên PDU (mã hàm + dữ liệu) tới thiết bị và trả về PDU phản hồi,
+// kể cả phản hồi ngoại lệ. Dùng cho chế độ chuyển tiếp Modbus TCP sang RTU.
+func (c *RTUClient) Forward(slaveID byte, pdu []byte) ([]byte, error) {
+	if len(pdu) == 0 {
+		return nil, fmt.Errorf("PDU rỗng")
+	}
+
+	var resp []byte
+	err := c.do(slaveID, func() error {
+		request, err := c.handler.Encode(&modbus.ProtocolDataUnit{FunctionCode: pdu[0], Data: pdu[1:]})
+		if err != nil {
+			return err
+		}
+		response, err := c.handler.Send(request)
+		if err != nil {
+			return err
+		}
+		if err := c.handler.Verify(request, response); err != nil {
+			return err
+		}
+		decoded, err := c.handler.Decode(response)
+		if err != nil {
+			return err
+		}
+		resp = append([]byte{decoded.FunctionCode}, decoded.Data...)
+		return nil
+	})
+	return resp, err
+}
+
+// Slave trả về client gắn với một thiết bị trên bus
+func (c *RTUClient) Slave(slaveID byte) *SlaveClient {
+	return &SlaveClient{bus: c, slaveID: slaveID}
+}
+
+// SlaveClient là client của một thiết bị trên bus dùng chung,
+// có cùng các hàm đọc/ghi như Client
+type SlaveClient struct {
+	bus     *RTUClient
+	slaveID byte
+}
+
+// ReadHoldingRegisters đọc các thanh ghi giữ
+func (s *SlaveClient) ReadHoldingRegisters(address uint16, quantity uint16) ([]byte, error) {
+	return s.bus.ReadHoldingRegisters(s.slaveID, address, quantity)
+}
+
+// ReadInputRegisters đọc các thanh ghi đầu vào
+func (s *SlaveClient) ReadInputRegisters(address uint16, quantity uint16) ([]byte, error) {
+	return s.bus.ReadInputRegisters(s.slaveID, address, quantity)
+}
+
+// WriteSingleRegister ghi một thanh ghi
+func (s *SlaveClient) WriteSingleRegister(address uint16, value uint16) error {
+	return s.bus.WriteSingleRegister(s.slaveID, address, value)
+}
+
+// WriteMultipleRegisters ghi nhiều thanh ghi
+func (s *SlaveClient) WriteMultipleRegisters(address uint16, values []uint16) error {
+	return s.bus.WriteMultipleRegisters(s.slaveID, address, values)
+}
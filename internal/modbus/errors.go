@@ -0,0 +1,54 @@
+package modbus
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+)
+
+// Loại lỗi giao dịch Modbus, dùng cho thống kê
+const (
+	ErrorKindNone      = ""
+	ErrorKindTimeout   = "timeout"   // Thiết bị không phản hồi
+	ErrorKindCRC       = "crc"       // Sai CRC hoặc khung phản hồi không hợp lệ
+	ErrorKindException = "exception" // Thiết bị trả về mã ngoại lệ
+	ErrorKindOther     = "other"     // Lỗi cổng serial hoặc lỗi khác
+)
+
+// BusObserver nhận kết quả từng giao dịch trên bus, dùng cho giám sát
+type BusObserver interface {
+	// ObserveRequest được gọi sau mỗi giao dịch, err là nil nếu thành công
+	ObserveRequest(slaveID byte, err error)
+	// ObserveReconnect được gọi khi cổng serial được mở lại sau lỗi
+	ObserveReconnect()
+}
+
+// ErrorKind phân loại lỗi giao dịch Modbus
+func ErrorKind(err error) string {
+	if err == nil {
+		return ErrorKindNone
+	}
+	if _, ok := ExceptionCode(err); ok {
+		return ErrorKindException
+	}
+	if errors.Is(err, serial.ErrTimeout) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorKindTimeout
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "crc") || strings.Contains(msg, "does not match") || strings.Contains(msg, "response length") {
+		return ErrorKindCRC
+	}
+	return ErrorKindOther
+}
+
+// ExceptionCode trả về mã ngoại lệ nếu err là phản hồi ngoại lệ từ thiết bị
+func ExceptionCode(err error) (byte, bool) {
+	var mbErr *modbus.ModbusError
+	if errors.As(err, &mbErr) {
+		return mbErr.ExceptionCode, true
+	}
+	return 0, false
+}
@@ -0,0 +1,38 @@
+package modbus
+
+import "sync"
+
+// fairMutex là khóa theo thứ tự đến trước phục vụ trước. sync.Mutex không đảm
+// bảo thứ tự nên một goroutine đọc liên tục (poller) có thể chiếm bus lâu;
+// với fairMutex mỗi bên chờ đến lượt mình, nên lệnh chuyển tiếp từ Modbus TCP
+// chỉ phải chờ tối đa một giao dịch của mỗi bên đang xếp hàng.
+type fairMutex struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	next    uint64 // Số thứ tự phát cho lượt chờ tiếp theo
+	serving uint64 // Số thứ tự đang được phục vụ
+}
+
+// Lock chờ đến lượt theo thứ tự gọi
+func (m *fairMutex) Lock() {
+	m.mu.Lock()
+	if m.cond == nil {
+		m.cond = sync.NewCond(&m.mu)
+	}
+	ticket := m.next
+	m.next++
+	for ticket != m.serving {
+		m.cond.Wait()
+	}
+	m.mu.Unlock()
+}
+
+// Unlock chuyển lượt cho goroutine chờ tiếp theo
+func (m *fairMutex) Unlock() {
+	m.mu.Lock()
+	m.serving++
+	if m.cond != nil {
+		m.cond.Broadcast()
+	}
+	m.mu.Unlock()
+}
@@ -0,0 +1,33 @@
+package modbus
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFairMutex kiểm tra khóa phục vụ theo thứ tự đến
+func TestFairMutex(t *testing.T) {
+	var m fairMutex
+	var order []int
+	var wg sync.WaitGroup
+
+	m.Lock()
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.Lock()
+			order = append(order, i)
+			m.Unlock()
+		}(i)
+		// Chờ goroutine xếp hàng trước khi tạo goroutine tiếp theo
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.Unlock()
+	wg.Wait()
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order)
+}
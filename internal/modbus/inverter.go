@@ -2,7 +2,10 @@ package modbus
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
+
+	"modbus_inverter/internal/profile"
 )
 
 // InverterData đại diện cho dữ liệu của inverter
@@ -27,22 +30,63 @@ type InverterData struct {
 	DailyEnergy float64 `json:"daily_energy"` // kWh
 	TotalEnergy float64 `json:"total_energy"` // kWh
 	Efficiency  float64 `json:"efficiency"`   // %
+
+	// Giá trị từng chuỗi PV/MPPT và từng pha, số lượng tùy model
+	Strings []StringData `json:"strings,omitempty"`
+	Phases  []PhaseData  `json:"phases,omitempty"`
+
+	// Tín hiệu riêng khác của model đọc theo profile (bit cảnh báo, trạng thái gốc...)
+	Extra map[string]float64 `json:"extra,omitempty"`
+
+	missing []string // Tín hiệu chung model không có hoặc thiết bị báo không có giá trị
+	failed  []string // Tín hiệu thuộc các lần đọc lỗi
+}
+
+// RegisterReader là nguồn đọc thanh ghi giữ của một thiết bị (Client hoặc SlaveClient)
+type RegisterReader interface {
+	ReadHoldingRegisters(address uint16, quantity uint16) ([]byte, error)
 }
 
 // InverterService xử lý giao tiếp với inverter
 type InverterService struct {
-	client *Client
+	client  RegisterReader
+	reader  profile.Reader
+	profile *profile.Profile // Bảng thanh ghi của hãng, nil nếu dùng bảng thanh ghi của simulator
 }
 
 // NewInverterService tạo một service mới
-func NewInverterService(client *Client) *InverterService {
+func NewInverterService(client RegisterReader) *InverterService {
 	return &InverterService{
 		client: client,
 	}
 }
 
-// ReadData đọc dữ liệu từ inverter
+// NewProfileInverterService tạo service đọc inverter theo profile của hãng
+func NewProfileInverterService(client profile.Reader, p profile.Profile) *InverterService {
+	return &InverterService{
+		client:  client,
+		reader:  client,
+		profile: &p,
+	}
+}
+
+// ReadData đọc dữ liệu từ inverter. Với profile, đọc lỗi một phần vẫn trả về
+// dữ liệu đọc được cùng lỗi đầu tiên, các tín hiệu đọc lỗi nằm trong Failed;
+// dữ liệu là nil khi không đọc được tín hiệu nào.
 func (s *InverterService) ReadData() (*InverterData, error) {
+	if s.profile != nil {
+		values, failed, err := s.profile.ReadPartial(s.reader)
+		if len(values) == 0 {
+			if err == nil {
+				err = fmt.Errorf("inverter không trả về tín hiệu nào")
+			}
+			return nil, err
+		}
+		data := NewInverterData(values, time.Now())
+		data.failed = failed
+		return data, err
+	}
+
 	// Đọc tất cả các thanh ghi
 	data, err := s.client.ReadHoldingRegisters(0, 13)
 	if err != nil {
@@ -76,7 +120,93 @@ func (s *InverterService) ReadData() (*InverterData, error) {
 	return inverterData, nil
 }
 
+// NewInverterData tạo dữ liệu inverter từ các tín hiệu đọc theo profile. Tín hiệu
+// chuỗi PV (pv1_voltage, pv1_current, pv1_power) và tín hiệu pha (voltage_an,
+// current_a, active_power_a) được đưa vào Strings và Phases, tín hiệu khác
+// không thuộc mô hình chung được giữ trong Extra, tín hiệu chung không có trong
+// values bị bỏ khỏi Values. Đọc được dữ liệu nghĩa là đang kết nối nên
+// connection_status mặc định là 1.
+func NewInverterData(values map[string]float64, ts time.Time) *InverterData {
+	d := &InverterData{Timestamp: ts, ConnectionStatus: 1}
+	fields := map[string]*float64{
+		"active_power":   &d.ActivePower,
+		"reactive_power": &d.ReactivePower,
+		"power_factor":   &d.PowerFactor,
+		"frequency":      &d.Frequency,
+		"voltage":        &d.Voltage,
+		"current":        &d.Current,
+		"temperature":    &d.Temperature,
+		"daily_energy":   &d.DailyEnergy,
+		"total_energy":   &d.TotalEnergy,
+		"efficiency":     &d.Efficiency,
+	}
+	codes := map[string]*uint16{
+		"connection_status": &d.ConnectionStatus,
+		"device_status":     &d.DeviceStatus,
+		"error_code":        &d.ErrorCode,
+	}
+	for signal, v := range values {
+		if f, ok := fields[signal]; ok {
+			*f = v
+		} else if c, ok := codes[signal]; ok {
+			*c = uint16(v)
+		} else if !d.setDetail(signal, v) {
+			if d.Extra == nil {
+				d.Extra = make(map[string]float64)
+			}
+			d.Extra[signal] = v
+		}
+	}
+	d.stringPowers()
+	for _, signal := range InverterSignals {
+		if _, ok := values[signal]; !ok && signal != "connection_status" {
+			d.missing = append(d.missing, signal)
+		}
+	}
+	return d
+}
+
+// Failed trả về các tín hiệu không đọc được do lỗi giao tiếp trong lần đọc cuối
+func (d *InverterData) Failed() []string {
+	return d.failed
+}
+
 // ToJSON chuyển đổi dữ liệu sang JSON
 func (d *InverterData) ToJSON() ([]byte, error) {
 	return json.Marshal(d)
 }
+
+// InverterSignals là danh sách tên các tín hiệu của InverterData theo thứ tự cố định
+var InverterSignals = []string{
+	"connection_status", "device_status", "error_code",
+	"active_power", "reactive_power", "power_factor", "frequency", "voltage", "current", "temperature",
+	"daily_energy", "total_energy", "efficiency",
+}
+
+// Values trả về các tín hiệu dưới dạng map, khóa trùng với tên trường JSON,
+// kèm giá trị từng chuỗi PV, từng pha và các tín hiệu riêng của model
+func (d *InverterData) Values() map[string]float64 {
+	values := map[string]float64{
+		"connection_status": float64(d.ConnectionStatus),
+		"device_status":     float64(d.DeviceStatus),
+		"error_code":        float64(d.ErrorCode),
+		"active_power":      d.ActivePower,
+		"reactive_power":    d.ReactivePower,
+		"power_factor":      d.PowerFactor,
+		"frequency":         d.Frequency,
+		"voltage":           d.Voltage,
+		"current":           d.Current,
+		"temperature":       d.Temperature,
+		"daily_energy":      d.DailyEnergy,
+		"total_energy":      d.TotalEnergy,
+		"efficiency":        d.Efficiency,
+	}
+	for _, signal := range d.missing {
+		delete(values, signal)
+	}
+	d.detailValues(values)
+	for signal, v := range d.Extra {
+		values[signal] = v
+	}
+	return values
+}
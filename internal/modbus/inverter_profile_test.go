@@ -0,0 +1,398 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/profile"
+)
+
+// registerSim giả lập bảng thanh ghi của một inverter, trả lỗi ngoại lệ khi đọc
+// vào vùng địa chỉ chưa khai báo giống thiết bị thật
+type registerSim struct {
+	holding, input map[uint16]uint16
+	reads          int // Số lần đọc
+}
+
+func newRegisterSim() *registerSim {
+	return &registerSim{holding: make(map[uint16]uint16), input: make(map[uint16]uint16)}
+}
+
+// set ghi giá trị kỹ thuật vào thanh ghi theo kiểu dữ liệu và hệ số tỉ lệ
+func (s *registerSim) set(regs map[uint16]uint16, address uint16, typ string, scale, value float64) {
+	raw := math.Round(value * scale)
+	switch typ {
+	case profile.TypeU16, profile.TypeS16:
+		regs[address] = uint16(int64(raw))
+	case profile.TypeU32, profile.TypeS32:
+		v := uint32(int64(raw))
+		regs[address], regs[address+1] = uint16(v>>16), uint16(v)
+	case profile.TypeF32:
+		v := math.Float32bits(float32(value))
+		regs[address], regs[address+1] = uint16(v>>16), uint16(v)
+	}
+}
+
+// fill khai báo vùng địa chỉ với giá trị 0
+func (s *registerSim) fill(regs map[uint16]uint16, from, to uint16) {
+	for a := from; a <= to; a++ {
+		if _, ok := regs[a]; !ok {
+			regs[a] = 0
+		}
+	}
+}
+
+func (s *registerSim) read(regs map[uint16]uint16, address, quantity uint16) ([]byte, error) {
+	s.reads++
+	data := make([]byte, 0, quantity*2)
+	for a := address; a < address+quantity; a++ {
+		v, ok := regs[a]
+		if !ok {
+			return nil, fmt.Errorf("ngoại lệ 02: thanh ghi %d không tồn tại", a)
+		}
+		data = append(data, byte(v>>8), byte(v))
+	}
+	return data, nil
+}
+
+func (s *registerSim) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return s.read(s.holding, address, quantity)
+}
+
+func (s *registerSim) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return s.read(s.input, address, quantity)
+}
+
+// TestHuaweiSUN2000 kiểm tra đọc Huawei SUN2000 vào mô hình inverter chung
+func TestHuaweiSUN2000(t *testing.T) {
+	sim := newRegisterSim()
+	h := sim.holding
+	sim.fill(h, 32000, 32120)
+	sim.set(h, 32008, profile.TypeU16, 1, 0x0080) // Bit 7: mất lưới
+	sim.set(h, 32016, profile.TypeS16, 10, 612.3)
+	sim.set(h, 32017, profile.TypeS16, 100, 9.87)
+	sim.set(h, 32064, profile.TypeS32, 1000, 51.2)
+	sim.set(h, 32066, profile.TypeU16, 10, 401.5)
+	sim.set(h, 32072, profile.TypeS32, 1000, 72.125)
+	sim.set(h, 32080, profile.TypeS32, 1000, 50.1)
+	sim.set(h, 32082, profile.TypeS32, 1000, -3.2)
+	sim.set(h, 32084, profile.TypeS16, 1000, -0.998)
+	sim.set(h, 32085, profile.TypeU16, 100, 50.02)
+	sim.set(h, 32086, profile.TypeU16, 100, 98.6)
+	sim.set(h, 32087, profile.TypeS16, 10, 45.3)
+	sim.set(h, 32089, profile.TypeU16, 1, 0x0201)
+	sim.set(h, 32106, profile.TypeU32, 100, 123456.78)
+	sim.set(h, 32114, profile.TypeU32, 100, 312.45)
+
+	service := NewProfileInverterService(sim, profile.Builtin()["huawei_sun2000"])
+	data, err := service.ReadData()
+	require.NoError(t, err)
+
+	assert.Equal(t, uint16(1), data.ConnectionStatus)
+	assert.Equal(t, uint16(1), data.DeviceStatus, "Hòa lưới bị giới hạn công suất vẫn là đang hoạt động")
+	assert.InDelta(t, 50.1, data.ActivePower, 1e-9)
+	assert.InDelta(t, -3.2, data.ReactivePower, 1e-9)
+	assert.InDelta(t, -0.998, data.PowerFactor, 1e-9)
+	assert.InDelta(t, 50.02, data.Frequency, 1e-9)
+	assert.InDelta(t, 401.5, data.Voltage, 1e-9)
+	assert.InDelta(t, 72.125, data.Current, 1e-9)
+	assert.InDelta(t, 45.3, data.Temperature, 1e-9)
+	assert.InDelta(t, 312.45, data.DailyEnergy, 1e-9)
+	assert.InDelta(t, 123456.78, data.TotalEnergy, 1e-9)
+	assert.InDelta(t, 98.6, data.Efficiency, 1e-9)
+
+	values := data.Values()
+	assert.InDelta(t, 0x0080, values["alarm_1"], 1e-9)
+	assert.InDelta(t, 0x0201, values["inverter_state"], 1e-9)
+	assert.InDelta(t, 612.3, values["pv1_voltage"], 1e-9)
+	assert.InDelta(t, 9.87, values["pv1_current"], 1e-9)
+	assert.InDelta(t, 51.2, values["dc_power"], 1e-9)
+
+	t.Run("Dừng do lỗi", func(t *testing.T) {
+		sim.set(h, 32089, profile.TypeU16, 1, 0x0300)
+		data, err := service.ReadData()
+		require.NoError(t, err)
+		assert.Equal(t, uint16(0), data.DeviceStatus)
+	})
+
+	t.Run("Mất liên lạc", func(t *testing.T) {
+		data, err := NewProfileInverterService(newRegisterSim(), profile.Builtin()["huawei_sun2000"]).ReadData()
+		assert.Error(t, err)
+		assert.Nil(t, data)
+	})
+}
+
+// TestInverterProfiles đọc Sungrow, Growatt và SMA giả lập vào mô hình inverter chung.
+// Địa chỉ, kiểu và hệ số tỉ lệ lấy theo tài liệu Modbus của từng hãng, không lấy từ profile.
+func TestInverterProfiles(t *testing.T) {
+	// Giá trị biểu diễn được ở độ phân giải thô nhất (Sungrow: 0.1 Hz, 1 kWh)
+	common := map[string]float64{
+		"active_power": 48.5, "reactive_power": -2.5, "power_factor": 0.987, "frequency": 50.1,
+		"voltage": 399.8, "current": 70.2, "temperature": 41.5, "daily_energy": 215.4, "total_energy": 98765,
+	}
+
+	tests := []struct {
+		model   string
+		setup   func(sim *registerSim) // Bảng thanh ghi của thiết bị đang phát lên lưới
+		stop    func(sim *registerSim) // Chuyển sang trạng thái dừng
+		pv      [2][2]float64          // Điện áp, dòng của chuỗi/MPPT 1 và 2
+		phases  [3]float64             // Dòng pha a, b, c
+		voltage [3]float64             // Điện áp pha a, b, c, 0: model không có
+		missing []string               // Tín hiệu chung model không có
+	}{
+		{
+			// Sungrow: thanh ghi đầu vào, địa chỉ = số thanh ghi trong tài liệu - 1,
+			// kiểu 32 bit có word thấp trước
+			model: "sungrow_sg",
+			setup: func(sim *registerSim) {
+				r := sim.input
+				sim.fill(r, 4999, 5100)
+				r[5002] = 2154                    // Điện năng ngày, 0.1 kWh
+				r[5003], r[5004] = 0x81CD, 0x0001 // Tổng điện năng 98765 kWh
+				r[5007] = 415                     // Nhiệt độ, 0.1 °C
+				r[5010], r[5011] = 6123, 98       // MPPT 1: 0.1 V, 0.1 A
+				r[5012], r[5013] = 6105, 95       // MPPT 2
+				r[5016], r[5017] = 0xC288, 0x0000 // Công suất DC 49800 W
+				r[5018] = 3998                    // Điện áp dây AB, 0.1 V
+				r[5021], r[5022], r[5023] = 702, 703, 701
+				r[5030], r[5031] = 0xBD74, 0x0000 // Công suất tác dụng 48500 W
+				r[5032], r[5033] = 0xF63C, 0xFFFF // Công suất phản kháng -2500 var
+				r[5034] = 987                     // Hệ số công suất, 0.001
+				r[5035] = 501                     // Tần số, 0.1 Hz
+				r[5037] = 0x8100                  // Chạy giảm công suất
+			},
+			stop:    func(sim *registerSim) { sim.input[5037] = 0x5500 }, // Dừng do lỗi
+			pv:      [2][2]float64{{612.3, 9.8}, {610.5, 9.5}},
+			phases:  [3]float64{70.2, 70.3, 70.1},
+			missing: []string{"efficiency"},
+		},
+		{
+			// Growatt MAX/MID: thanh ghi đầu vào giao thức RTU II, 32 bit word cao trước
+			model: "growatt_max",
+			setup: func(sim *registerSim) {
+				r := sim.input
+				sim.fill(r, 0, 124)
+				r[0] = 1 // Bình thường
+				sim.set(r, 1, profile.TypeU32, 10000, 49.8)
+				sim.set(r, 3, profile.TypeU16, 10, 612.3)
+				sim.set(r, 4, profile.TypeU16, 10, 9.8)
+				sim.set(r, 5, profile.TypeU32, 10000, 6.0)
+				sim.set(r, 7, profile.TypeU16, 10, 610.5)
+				sim.set(r, 8, profile.TypeU16, 10, 9.5)
+				sim.set(r, 9, profile.TypeU32, 10000, 5.8)
+				sim.set(r, 35, profile.TypeU32, 10000, 48.5)
+				sim.set(r, 37, profile.TypeU16, 100, 50.1)
+				sim.set(r, 38, profile.TypeU16, 10, 230.8)
+				sim.set(r, 39, profile.TypeU16, 10, 70.2)
+				sim.set(r, 40, profile.TypeU32, 10000, 16.2)
+				sim.set(r, 42, profile.TypeU16, 10, 231)
+				sim.set(r, 43, profile.TypeU16, 10, 70.3)
+				sim.set(r, 44, profile.TypeU32, 10000, 16.1)
+				sim.set(r, 46, profile.TypeU16, 10, 230.5)
+				sim.set(r, 47, profile.TypeU16, 10, 70.1)
+				sim.set(r, 48, profile.TypeU32, 10000, 16.2)
+				sim.set(r, 50, profile.TypeU16, 10, 399.8)
+				sim.set(r, 53, profile.TypeU32, 10, 215.4)
+				sim.set(r, 55, profile.TypeU32, 10, 98765)
+				sim.set(r, 93, profile.TypeU16, 10, 41.5)
+			},
+			stop:    func(sim *registerSim) { sim.input[0] = 3 }, // Lỗi
+			pv:      [2][2]float64{{612.3, 9.8}, {610.5, 9.5}},
+			phases:  [3]float64{70.2, 70.3, 70.1},
+			voltage: [3]float64{230.8, 231, 230.5},
+			missing: []string{"reactive_power", "power_factor", "efficiency"},
+		},
+		{
+			// SMA: thanh ghi giữ, địa chỉ đúng như tài liệu, mọi giá trị đo 32 bit
+			model: "sma_stp",
+			setup: func(sim *registerSim) {
+				h := sim.holding
+				sim.set(h, 30247, profile.TypeU32, 1, 0) // Mã lỗi
+				sim.set(h, 30529, profile.TypeU32, 1000, 98765)
+				sim.set(h, 30535, profile.TypeU32, 1000, 215.4)
+				sim.set(h, 30769, profile.TypeS32, 1000, 9.8)
+				sim.set(h, 30771, profile.TypeS32, 100, 612.3)
+				sim.set(h, 30773, profile.TypeS32, 1000, 6.0)
+				sim.set(h, 30775, profile.TypeS32, 1000, 48.5)
+				sim.set(h, 30777, profile.TypeS32, 1000, 16.2)
+				sim.set(h, 30779, profile.TypeS32, 1000, 16.1)
+				sim.set(h, 30781, profile.TypeS32, 1000, 16.2)
+				sim.set(h, 30783, profile.TypeU32, 100, 230.8)
+				sim.set(h, 30785, profile.TypeU32, 100, 231)
+				sim.set(h, 30787, profile.TypeU32, 100, 230.5)
+				sim.set(h, 30789, profile.TypeU32, 100, 399.8)
+				sim.set(h, 30803, profile.TypeU32, 100, 50.1)
+				sim.set(h, 30805, profile.TypeS32, 1000, -2.5)
+				sim.set(h, 30949, profile.TypeU32, 1000, 0.987)
+				sim.set(h, 30953, profile.TypeS32, 10, 41.5)
+				sim.set(h, 30957, profile.TypeS32, 1000, 9.5)
+				sim.set(h, 30959, profile.TypeS32, 100, 610.5)
+				sim.set(h, 30961, profile.TypeS32, 1000, 5.8)
+				sim.set(h, 30977, profile.TypeS32, 1000, 70.2)
+				sim.set(h, 30979, profile.TypeS32, 1000, 70.3)
+				sim.set(h, 30981, profile.TypeS32, 1000, 70.1)
+				sim.set(h, 40029, profile.TypeU32, 1, 295) // MPP
+				// Thanh ghi có trong tài liệu nằm giữa các tín hiệu: tổng sản lượng
+				// kWh/MWh, điện áp dây L2-L3/L3-L1, dòng lưới và dòng pha; khóa trạng thái
+				// thiết bị và nhiệt độ bên trong
+				sim.fill(h, 30531, 30534)
+				sim.fill(h, 30791, 30802)
+				sim.fill(h, 30951, 30952)
+				sim.fill(h, 30955, 30956)
+			},
+			stop:    func(sim *registerSim) { sim.set(sim.holding, 40029, profile.TypeU32, 1, 1392) }, // Lỗi
+			pv:      [2][2]float64{{612.3, 9.8}, {610.5, 9.5}},
+			phases:  [3]float64{70.2, 70.3, 70.1},
+			voltage: [3]float64{230.8, 231, 230.5},
+			missing: []string{"efficiency"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			sim := newRegisterSim()
+			tt.setup(sim)
+
+			service := NewProfileInverterService(sim, profile.Builtin()[tt.model])
+			data, err := service.ReadData()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(1), data.DeviceStatus)
+			assert.Equal(t, uint16(0), data.ErrorCode)
+
+			values := data.Values()
+			for signal, want := range common {
+				if slices.Contains(tt.missing, signal) {
+					assert.NotContains(t, values, signal)
+					continue
+				}
+				assert.InDelta(t, want, values[signal], 1e-9, signal)
+			}
+			require.GreaterOrEqual(t, len(data.Strings), 2)
+			for i, pv := range tt.pv {
+				require.NotNil(t, data.Strings[i].Voltage)
+				require.NotNil(t, data.Strings[i].Current)
+				assert.InDelta(t, pv[0], *data.Strings[i].Voltage, 1e-9)
+				assert.InDelta(t, pv[1], *data.Strings[i].Current, 1e-9)
+			}
+			require.Len(t, data.Phases, 3)
+			for i, current := range tt.phases {
+				require.NotNil(t, data.Phases[i].Current)
+				assert.InDelta(t, current, *data.Phases[i].Current, 1e-9)
+				if tt.voltage[i] == 0 {
+					assert.Nil(t, data.Phases[i].Voltage)
+				} else if assert.NotNil(t, data.Phases[i].Voltage) {
+					assert.InDelta(t, tt.voltage[i], *data.Phases[i].Voltage, 1e-9)
+				}
+			}
+
+			tt.stop(sim)
+			data, err = service.ReadData()
+			require.NoError(t, err)
+			assert.Equal(t, uint16(0), data.DeviceStatus)
+		})
+	}
+
+	t.Run("SMA không có giá trị", func(t *testing.T) {
+		sim := newRegisterSim()
+		tests[2].setup(sim)
+		sim.holding[30775], sim.holding[30776] = 0x8000, 0x0000
+		sim.holding[30529], sim.holding[30530] = 0xFFFF, 0xFFFF
+		data, err := NewProfileInverterService(sim, profile.Builtin()["sma_stp"]).ReadData()
+		require.NoError(t, err)
+		values := data.Values()
+		assert.NotContains(t, values, "active_power")
+		assert.NotContains(t, values, "total_energy")
+		assert.Contains(t, values, "frequency")
+		assert.Empty(t, data.Failed(), "Không có giá trị không phải lỗi giao tiếp")
+	})
+
+	t.Run("SMA gộp lần đọc", func(t *testing.T) {
+		sim := newRegisterSim()
+		tests[2].setup(sim)
+		_, err := NewProfileInverterService(sim, profile.Builtin()["sma_stp"]).ReadData()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, sim.reads, 6)
+	})
+
+	t.Run("Đọc lỗi một phần", func(t *testing.T) {
+		sim := newRegisterSim()
+		tests[2].setup(sim)
+		delete(sim.holding, 40029)
+		data, err := NewProfileInverterService(sim, profile.Builtin()["sma_stp"]).ReadData()
+		assert.Error(t, err)
+		require.NotNil(t, data, "Vẫn trả về các tín hiệu đọc được")
+		assert.ElementsMatch(t, []string{"inverter_state", "device_status"}, data.Failed())
+		values := data.Values()
+		assert.NotContains(t, values, "device_status")
+		assert.InDelta(t, 48.5, values["active_power"], 1e-9)
+		assert.Equal(t, uint16(1), data.ConnectionStatus)
+	})
+}
+
+// TestInverterDataStrings kiểm tra giá trị từng chuỗi PV, từng pha và phát hiện chuỗi yếu
+func TestInverterDataStrings(t *testing.T) {
+	values := map[string]float64{
+		"active_power": 30,
+		"pv10_voltage": 600, "pv10_current": 9.8,
+		"pv2_voltage": 610, "pv2_current": 6.1, "pv2_power": 3.7,
+		"pv1_voltage": 605, "pv1_current": 10,
+		"pv3_voltage": 2, "pv3_current": 0, // Đầu vào bỏ trống
+		"voltage_an": 230.1, "current_a": 43.5, "current_b": 43.7,
+		"alarm_1": 4,
+	}
+	data := NewInverterData(values, time.Now())
+
+	require.Len(t, data.Strings, 4)
+	assert.Equal(t, []int{1, 2, 3, 10}, []int{data.Strings[0].Index, data.Strings[1].Index, data.Strings[2].Index, data.Strings[3].Index})
+	assert.InDelta(t, 6.05, *data.Strings[0].Power, 1e-9, "Tính từ điện áp và dòng")
+	assert.InDelta(t, 3.7, *data.Strings[1].Power, 1e-9, "Công suất đọc từ thiết bị")
+	require.Len(t, data.Phases, 2)
+	assert.Equal(t, "a", data.Phases[0].Phase)
+	assert.InDelta(t, 230.1, *data.Phases[0].Voltage, 1e-9)
+	assert.Nil(t, data.Phases[1].Voltage)
+	assert.Equal(t, map[string]float64{"alarm_1": 4}, data.Extra)
+
+	ratio, weakest, ok := data.StringCurrentRatio()
+	require.True(t, ok)
+	assert.Equal(t, 2, weakest)
+	assert.InDelta(t, 6.1/9.8*100, ratio, 1e-9, "So với trung vị của 3 chuỗi có điện áp")
+
+	out := data.Values()
+	assert.InDelta(t, 6.05, out["pv1_power"], 1e-9)
+	assert.InDelta(t, 9.8, out["pv10_current"], 1e-9)
+	assert.InDelta(t, 43.7, out["current_b"], 1e-9)
+	assert.NotContains(t, out, "voltage_bn")
+	assert.InDelta(t, ratio, out[SignalStringCurrentRatio], 1e-9)
+	assert.InDelta(t, 2, out[SignalStringWeakest], 1e-9)
+	assert.NotContains(t, out, "reactive_power", "Model không có")
+
+	t.Run("Chỉ đọc được điện áp", func(t *testing.T) {
+		// SMA báo không có giá trị dòng chuỗi ban đêm
+		data := NewInverterData(map[string]float64{
+			"pv1_voltage": 520, "pv2_voltage": 515, "pv2_current": 8,
+		}, time.Now())
+		out := data.Values()
+		assert.InDelta(t, 520, out["pv1_voltage"], 1e-9)
+		assert.NotContains(t, out, "pv1_current")
+		assert.NotContains(t, out, "pv1_power", "Không tính công suất khi thiếu dòng")
+		assert.Nil(t, data.Strings[0].Power)
+		assert.InDelta(t, 4.12, out["pv2_power"], 1e-9)
+		_, _, ok := data.StringCurrentRatio()
+		assert.False(t, ok, "Chỉ một chuỗi đọc được dòng")
+	})
+
+	t.Run("Trời tối", func(t *testing.T) {
+		data := NewInverterData(map[string]float64{
+			"pv1_voltage": 520, "pv1_current": 0.1, "pv2_voltage": 515, "pv2_current": 0.2,
+		}, time.Now())
+		_, _, ok := data.StringCurrentRatio()
+		assert.False(t, ok)
+		assert.NotContains(t, data.Values(), SignalStringCurrentRatio)
+	})
+}
@@ -330,6 +330,104 @@ func ReadPM2120Data(client *RTUClient, slaveID byte) (*PM2120Data, error) {
 	return data, errAccumulator
 }
 
+// ReadPM2120ActivePower chỉ đọc công suất tác dụng tổng (kW), dùng cho vòng điều khiển
+// cần phản hồi nhanh thay vì đọc toàn bộ dữ liệu bằng ReadPM2120Data
+func ReadPM2120ActivePower(client *RTUClient, slaveID byte) (float32, error) {
+	val, err := readAndDecodeFloat32(client, slaveID, addrActivePowerTotal, "ActivePowerTotal")
+	if err != nil {
+		return 0, err
+	}
+	return *val, nil
+}
+
+// PM2120Signals là danh sách tên các tín hiệu của PM2120Data theo thứ tự cố định.
+// current_n không có trong danh sách vì ReadPM2120Data chưa đọc dòng trung tính.
+var PM2120Signals = []string{
+	"current_a", "current_b", "current_c", "current_avg",
+	"voltage_ab", "voltage_bc", "voltage_ca", "voltage_ll_avg", "voltage_an", "voltage_bn", "voltage_cn", "voltage_ln_avg",
+	"active_power_a", "active_power_b", "active_power_c", "active_power_total",
+	"reactive_power_a", "reactive_power_b", "reactive_power_c", "reactive_power_total",
+	"apparent_power_a", "apparent_power_b", "apparent_power_c", "apparent_power_total",
+	"power_factor_a", "power_factor_b", "power_factor_c", "power_factor_total",
+	"frequency",
+	"active_energy_delivered_wh", "active_energy_received_wh",
+	"reactive_energy_delivered_varh", "reactive_energy_received_varh",
+	"apparent_energy_delivered_vah", "apparent_energy_received_vah",
+	"thd_current_a_percent", "thd_current_b_percent", "thd_current_c_percent",
+	"thd_voltage_ab_percent", "thd_voltage_bc_percent", "thd_voltage_ca_percent",
+	"thd_voltage_an_percent", "thd_voltage_bn_percent", "thd_voltage_cn_percent",
+}
+
+// Values trả về các tín hiệu đọc được dưới dạng map, khóa trùng với tên trường JSON.
+// Các trường đọc lỗi (nil) không có trong map.
+func (d *PM2120Data) Values() map[string]float64 {
+	values := make(map[string]float64)
+	f := func(key string, v *float32) {
+		if v != nil {
+			values[key] = float64(*v)
+		}
+	}
+	i := func(key string, v *int64) {
+		if v != nil {
+			values[key] = float64(*v)
+		}
+	}
+
+	f("current_a", d.CurrentA)
+	f("current_b", d.CurrentB)
+	f("current_c", d.CurrentC)
+	f("current_n", d.CurrentN)
+	f("current_avg", d.CurrentAvg)
+
+	f("voltage_ab", d.VoltageAB)
+	f("voltage_bc", d.VoltageBC)
+	f("voltage_ca", d.VoltageCA)
+	f("voltage_ll_avg", d.VoltageLLAvg)
+	f("voltage_an", d.VoltageAN)
+	f("voltage_bn", d.VoltageBN)
+	f("voltage_cn", d.VoltageCN)
+	f("voltage_ln_avg", d.VoltageLNAvg)
+
+	f("active_power_a", d.ActivePowerA)
+	f("active_power_b", d.ActivePowerB)
+	f("active_power_c", d.ActivePowerC)
+	f("active_power_total", d.ActivePowerTotal)
+	f("reactive_power_a", d.ReactivePowerA)
+	f("reactive_power_b", d.ReactivePowerB)
+	f("reactive_power_c", d.ReactivePowerC)
+	f("reactive_power_total", d.ReactivePowerTotal)
+	f("apparent_power_a", d.ApparentPowerA)
+	f("apparent_power_b", d.ApparentPowerB)
+	f("apparent_power_c", d.ApparentPowerC)
+	f("apparent_power_total", d.ApparentPowerTotal)
+
+	f("power_factor_a", d.PowerFactorA)
+	f("power_factor_b", d.PowerFactorB)
+	f("power_factor_c", d.PowerFactorC)
+	f("power_factor_total", d.PowerFactorTotal)
+
+	f("frequency", d.Frequency)
+
+	i("active_energy_delivered_wh", d.ActiveEnergyDelivered)
+	i("active_energy_received_wh", d.ActiveEnergyReceived)
+	i("reactive_energy_delivered_varh", d.ReactiveEnergyDelivered)
+	i("reactive_energy_received_varh", d.ReactiveEnergyReceived)
+	i("apparent_energy_delivered_vah", d.ApparentEnergyDelivered)
+	i("apparent_energy_received_vah", d.ApparentEnergyReceived)
+
+	f("thd_current_a_percent", d.THDCurrentA)
+	f("thd_current_b_percent", d.THDCurrentB)
+	f("thd_current_c_percent", d.THDCurrentC)
+	f("thd_voltage_ab_percent", d.THDVoltageAB)
+	f("thd_voltage_bc_percent", d.THDVoltageBC)
+	f("thd_voltage_ca_percent", d.THDVoltageCA)
+	f("thd_voltage_an_percent", d.THDVoltageAN)
+	f("thd_voltage_bn_percent", d.THDVoltageBN)
+	f("thd_voltage_cn_percent", d.THDVoltageCN)
+
+	return values
+}
+
 // --- Helper Functions for Data Type Conversion ---
 // Giả định thứ tự byte là Big Endian. Sửa thành LittleEndian nếu cần.
 
@@ -0,0 +1,192 @@
+package modbus
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Ngưỡng để so sánh dòng giữa các chuỗi PV: chuỗi có điện áp thấp hơn được coi
+// là đầu vào bỏ trống, dòng trung vị thấp hơn (sáng sớm, chiều tối) thì không so sánh
+const (
+	stringMinVoltage = 50  // V
+	stringMinCurrent = 0.5 // A
+)
+
+// Tín hiệu tổng hợp từ các chuỗi PV
+const (
+	SignalStringCurrentRatio = "string_current_ratio" // Dòng chuỗi yếu nhất so với trung vị các chuỗi (%)
+	SignalStringWeakest      = "string_weakest"       // Số thứ tự chuỗi yếu nhất
+)
+
+// StringData là giá trị phía DC của một chuỗi PV hoặc một MPPT, tùy model;
+// trường nil khi không đọc được (model không có hoặc thiết bị báo không có giá trị)
+type StringData struct {
+	Index   int      `json:"index"`             // Số thứ tự, từ 1
+	Voltage *float64 `json:"voltage,omitempty"` // V
+	Current *float64 `json:"current,omitempty"` // A
+	Power   *float64 `json:"power,omitempty"`   // kW, tính từ điện áp và dòng nếu model không có
+}
+
+// PhaseData là giá trị phía AC của một pha, trường nil khi model không có
+type PhaseData struct {
+	Phase       string   `json:"phase"`                  // a, b hoặc c
+	Voltage     *float64 `json:"voltage,omitempty"`      // Điện áp pha (V)
+	Current     *float64 `json:"current,omitempty"`      // A
+	ActivePower *float64 `json:"active_power,omitempty"` // kW
+}
+
+var (
+	stringSignal = regexp.MustCompile(`^pv([0-9]+)_(voltage|current|power)$`)
+	phaseSignal  = regexp.MustCompile(`^(voltage|current|active_power)_(a|b|c)n?$`)
+)
+
+// phaseKey trả về tên tín hiệu của một đại lượng pha, điện áp pha đặt tên theo PM2120 (voltage_an)
+func phaseKey(quantity, phase string) string {
+	if quantity == "voltage" {
+		return quantity + "_" + phase + "n"
+	}
+	return quantity + "_" + phase
+}
+
+// setDetail ghi tín hiệu chuỗi PV (pv1_voltage...) hoặc tín hiệu pha
+// (voltage_an, current_a, active_power_a) vào Strings/Phases; false nếu không phải
+func (d *InverterData) setDetail(signal string, v float64) bool {
+	value := v
+	if m := stringSignal.FindStringSubmatch(signal); m != nil {
+		index, err := strconv.Atoi(m[1])
+		if err != nil || index == 0 {
+			return false
+		}
+		s := d.stringAt(index)
+		switch m[2] {
+		case "voltage":
+			s.Voltage = &value
+		case "current":
+			s.Current = &value
+		case "power":
+			s.Power = &value
+		}
+		return true
+	}
+	if m := phaseSignal.FindStringSubmatch(signal); m != nil && signal == phaseKey(m[1], m[2]) {
+		p := d.phaseAt(m[2])
+		switch m[1] {
+		case "voltage":
+			p.Voltage = &value
+		case "current":
+			p.Current = &value
+		case "active_power":
+			p.ActivePower = &value
+		}
+		return true
+	}
+	return false
+}
+
+// stringPowers tính công suất các chuỗi model không có từ điện áp và dòng, chỉ
+// khi đọc được cả hai
+func (d *InverterData) stringPowers() {
+	for i, s := range d.Strings {
+		if s.Power == nil && s.Voltage != nil && s.Current != nil {
+			power := *s.Voltage * *s.Current / 1000
+			d.Strings[i].Power = &power
+		}
+	}
+}
+
+// stringAt trả về chuỗi theo số thứ tự, thêm mới và giữ danh sách theo thứ tự nếu chưa có
+func (d *InverterData) stringAt(index int) *StringData {
+	i := sort.Search(len(d.Strings), func(i int) bool { return d.Strings[i].Index >= index })
+	if i == len(d.Strings) || d.Strings[i].Index != index {
+		d.Strings = append(d.Strings, StringData{})
+		copy(d.Strings[i+1:], d.Strings[i:])
+		d.Strings[i] = StringData{Index: index}
+	}
+	return &d.Strings[i]
+}
+
+// phaseAt trả về pha theo tên, thêm mới và giữ danh sách theo thứ tự nếu chưa có
+func (d *InverterData) phaseAt(phase string) *PhaseData {
+	i := sort.Search(len(d.Phases), func(i int) bool { return d.Phases[i].Phase >= phase })
+	if i == len(d.Phases) || d.Phases[i].Phase != phase {
+		d.Phases = append(d.Phases, PhaseData{})
+		copy(d.Phases[i+1:], d.Phases[i:])
+		d.Phases[i] = PhaseData{Phase: phase}
+	}
+	return &d.Phases[i]
+}
+
+// detailValues thêm giá trị đọc được của từng chuỗi PV, từng pha và chỉ số chuỗi
+// yếu nhất vào values
+func (d *InverterData) detailValues(values map[string]float64) {
+	for _, s := range d.Strings {
+		if s.Voltage != nil {
+			values[fmt.Sprintf("pv%d_voltage", s.Index)] = *s.Voltage
+		}
+		if s.Current != nil {
+			values[fmt.Sprintf("pv%d_current", s.Index)] = *s.Current
+		}
+		if s.Power != nil {
+			values[fmt.Sprintf("pv%d_power", s.Index)] = *s.Power
+		}
+	}
+	for _, p := range d.Phases {
+		if p.Voltage != nil {
+			values[phaseKey("voltage", p.Phase)] = *p.Voltage
+		}
+		if p.Current != nil {
+			values[phaseKey("current", p.Phase)] = *p.Current
+		}
+		if p.ActivePower != nil {
+			values[phaseKey("active_power", p.Phase)] = *p.ActivePower
+		}
+	}
+	if ratio, weakest, ok := d.StringCurrentRatio(); ok {
+		values[SignalStringCurrentRatio] = ratio
+		values[SignalStringWeakest] = float64(weakest)
+	}
+}
+
+// StringCurrentRatio so sánh dòng của các chuỗi đang có điện áp, trả về tỉ lệ (%)
+// giữa dòng nhỏ nhất và dòng trung vị cùng số thứ tự chuỗi đó. Chỉ có ý nghĩa
+// khi các chuỗi cùng số tấm pin và hướng; false khi ít hơn hai chuỗi đọc được
+// cả điện áp và dòng hoặc trời còn tối.
+func (d *InverterData) StringCurrentRatio() (float64, int, bool) {
+	type reading struct {
+		index   int
+		current float64
+	}
+	var active []reading
+	for _, s := range d.Strings {
+		if s.Voltage != nil && *s.Voltage >= stringMinVoltage && s.Current != nil {
+			active = append(active, reading{s.Index, *s.Current})
+		}
+	}
+	if len(active) < 2 {
+		return 0, 0, false
+	}
+
+	currents := make([]float64, len(active))
+	for i, s := range active {
+		currents[i] = s.current
+	}
+	sort.Float64s(currents)
+	n := len(currents)
+	median := currents[n/2]
+	if n%2 == 0 {
+		median = (currents[n/2-1] + currents[n/2]) / 2
+	}
+	if median < stringMinCurrent {
+		return 0, 0, false
+	}
+
+	weakest := active[0]
+	for _, s := range active[1:] {
+		if s.current < weakest.current {
+			weakest = s
+		}
+	}
+	return weakest.current / median * 100, weakest.index, true
+}
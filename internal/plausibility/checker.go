@@ -0,0 +1,295 @@
+package plausibility
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"modbus_inverter/internal/store"
+)
+
+// defaultMaxMagnitude giá trị tuyệt đối tối đa mặc định
+const defaultMaxMagnitude = 1e12
+
+// Finding là một bất thường đang xảy ra, gợi ý cấu hình thanh ghi có thể sai
+type Finding struct {
+	Device  string    `json:"device"`
+	Check   string    `json:"check"` // range, monotonic hoặc tên kiểm tra chéo
+	Signals []string  `json:"signals"`
+	Message string    `json:"message"`
+	Since   time.Time `json:"since"` // Thời điểm phát hiện
+	Last    time.Time `json:"last"`  // Thời điểm gặp lại gần nhất
+	Count   uint64    `json:"count"` // Số lần gặp
+}
+
+// counter trạng thái của một bộ đếm năng lượng
+type counter struct {
+	last  float64
+	lower int // Số lần liên tiếp đọc được giá trị nhỏ hơn
+}
+
+// Checker kiểm tra tính hợp lý của các tag trước khi ghi vào store
+type Checker struct {
+	cfg    Config
+	logger *log.Logger
+
+	mu         sync.Mutex
+	counters   map[string]*counter // Khóa: thiết bị + "/" + tín hiệu
+	mismatches map[string]int      // Số lần sai lệch liên tiếp, khóa: thiết bị + "/" + kiểm tra
+	findings   map[string]*Finding // Khóa: thiết bị + "/" + kiểm tra + "/" + tín hiệu
+}
+
+// NewChecker tạo bộ kiểm tra từ cấu hình
+func NewChecker(cfg Config, logger *log.Logger) (*Checker, error) {
+	switch cfg.Action {
+	case "":
+		cfg.Action = ActionFlag
+	case ActionFlag, ActionDrop:
+	default:
+		return nil, fmt.Errorf("cách xử lý không hợp lệ %q", cfg.Action)
+	}
+	if cfg.MaxMagnitude == 0 {
+		cfg.MaxMagnitude = defaultMaxMagnitude
+	}
+	if cfg.Persist <= 0 {
+		cfg.Persist = 3
+	}
+	if len(cfg.Ranges) == 0 {
+		cfg.Ranges = DefaultRanges()
+	}
+	for _, r := range cfg.Ranges {
+		if r.Signal == "" || r.Min >= r.Max {
+			return nil, fmt.Errorf("dải của tín hiệu %q không hợp lệ", r.Signal)
+		}
+	}
+	if len(cfg.Checks) == 0 {
+		cfg.Checks = DefaultChecks()
+	}
+	names := make(map[string]bool)
+	for i := range cfg.Checks {
+		if err := cfg.Checks[i].validate(); err != nil {
+			return nil, err
+		}
+		if names[cfg.Checks[i].Name] {
+			return nil, fmt.Errorf("tên kiểm tra %q bị trùng", cfg.Checks[i].Name)
+		}
+		names[cfg.Checks[i].Name] = true
+	}
+
+	return &Checker{
+		cfg:        cfg,
+		logger:     logger,
+		counters:   make(map[string]*counter),
+		mismatches: make(map[string]int),
+		findings:   make(map[string]*Finding),
+	}, nil
+}
+
+// Check kiểm tra các tag vừa đọc của thiết bị và trả về các tag sau xử lý:
+// giá trị không hợp lý bị đánh dấu ngoài dải hoặc bị bỏ theo cấu hình.
+// Sai lệch giữa các tín hiệu chỉ được báo, không ảnh hưởng giá trị.
+func (c *Checker) Check(device string, tags map[string]store.Tag) map[string]store.Tag {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var now time.Time
+	for _, tag := range tags {
+		if tag.Acquired.After(now) {
+			now = tag.Acquired
+		}
+	}
+
+	bad := make(map[string]bool)
+	for signal, tag := range tags {
+		if !checked(tag) {
+			continue
+		}
+		key := device + "/range/" + signal
+		if reason := c.checkRange(device, signal, tag.Value); reason != "" {
+			bad[signal] = true
+			c.report(key, Finding{Device: device, Check: "range", Signals: []string{signal}, Message: reason}, now)
+		} else {
+			c.resolve(key)
+		}
+	}
+
+	for i := range c.cfg.Checks {
+		chk := &c.cfg.Checks[i]
+		if !chk.applies(device) {
+			continue
+		}
+		if chk.Type == CheckMonotonic {
+			for _, signal := range chk.Signals {
+				tag, ok := tags[signal]
+				if !ok || bad[signal] || !checked(tag) {
+					continue
+				}
+				key := device + "/monotonic/" + signal
+				if reason := c.checkCounter(device, signal, tag.Value); reason != "" {
+					bad[signal] = true
+					c.report(key, Finding{Device: device, Check: CheckMonotonic, Signals: []string{signal}, Message: reason}, now)
+				} else {
+					c.resolve(key)
+				}
+			}
+			continue
+		}
+
+		values := make([]float64, 0, len(chk.Signals))
+		for _, signal := range chk.Signals {
+			tag, ok := tags[signal]
+			if !ok || bad[signal] || !checked(tag) {
+				break
+			}
+			values = append(values, tag.Value)
+		}
+		if len(values) == len(chk.Signals) {
+			c.checkRelation(device, chk, values, now)
+		}
+	}
+
+	out := make(map[string]store.Tag, len(tags))
+	for signal, tag := range tags {
+		if bad[signal] {
+			if c.cfg.Action == ActionDrop {
+				continue
+			}
+			tag.Quality = store.QualityOutOfRange
+		}
+		out[signal] = tag
+	}
+	return out
+}
+
+// Findings trả về các bất thường đang xảy ra, sắp theo thiết bị và kiểm tra
+func (c *Checker) Findings() []Finding {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := make([]Finding, 0, len(c.findings))
+	for _, f := range c.findings {
+		cp := *f
+		cp.Signals = append([]string(nil), f.Signals...)
+		list = append(list, cp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Device != list[j].Device {
+			return list[i].Device < list[j].Device
+		}
+		if list[i].Check != list[j].Check {
+			return list[i].Check < list[j].Check
+		}
+		return list[i].Signals[0] < list[j].Signals[0]
+	})
+	return list
+}
+
+// checked cho biết tag có cần kiểm tra không (bỏ qua giá trị cũ do mất liên lạc)
+func checked(tag store.Tag) bool {
+	return tag.Quality.Usable() || tag.Quality == store.QualityOutOfRange
+}
+
+// checkRange kiểm tra NaN, vô cùng, độ lớn và dải hợp lệ, trả về lý do nếu sai
+func (c *Checker) checkRange(device, signal string, v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%s = %g, kiểm tra kiểu dữ liệu và thứ tự word", signal, v)
+	}
+	if math.Abs(v) > c.cfg.MaxMagnitude {
+		return fmt.Sprintf("%s = %g quá lớn, kiểm tra thứ tự word, hệ số và địa chỉ thanh ghi", signal, v)
+	}
+	for _, r := range c.cfg.Ranges {
+		if r.matches(device, signal) {
+			if v < r.Min || v > r.Max {
+				return fmt.Sprintf("%s = %g ngoài dải [%g, %g], kiểm tra hệ số và địa chỉ thanh ghi", signal, v, r.Min, r.Max)
+			}
+			break
+		}
+	}
+	return ""
+}
+
+// checkCounter kiểm tra bộ đếm không giảm, trả về lý do nếu sai.
+// Bộ đếm giảm liên tiếp Persist lần được coi là đã đặt lại và nhận mốc mới.
+func (c *Checker) checkCounter(device, signal string, v float64) string {
+	key := device + "/" + signal
+	ctr, ok := c.counters[key]
+	if !ok {
+		c.counters[key] = &counter{last: v}
+		return ""
+	}
+	if v >= ctr.last {
+		ctr.last, ctr.lower = v, 0
+		return ""
+	}
+	ctr.lower++
+	if ctr.lower >= c.cfg.Persist {
+		c.logger.Printf("Kiểm tra hợp lý: %s %s giảm từ %g xuống %g %d lần liên tiếp, coi như bộ đếm đã đặt lại", device, signal, ctr.last, v, ctr.lower)
+		ctr.last, ctr.lower = v, 0
+		return ""
+	}
+	return fmt.Sprintf("%s giảm từ %g xuống %g", signal, ctr.last, v)
+}
+
+// checkRelation kiểm tra sai lệch giữa các tín hiệu, báo khi kéo dài Persist lần liên tiếp
+func (c *Checker) checkRelation(device string, chk *Check, v []float64, now time.Time) {
+	var measured, expected float64
+	var formula string
+	switch chk.Type {
+	case CheckPower:
+		k := 1.0
+		formula = "U·I·PF"
+		if chk.Phases == 3 {
+			k = math.Sqrt(3)
+			formula = "√3·U·I·PF"
+		}
+		measured = math.Abs(v[0])
+		expected = k * v[1] * v[2] * math.Abs(v[3]) / 1000
+	case CheckTriangle:
+		measured = math.Abs(v[0])
+		expected = math.Hypot(v[1], v[2])
+		formula = "√(P²+Q²)"
+	}
+
+	key := device + "/" + chk.Name
+	scale := math.Max(measured, math.Abs(expected))
+	if scale < chk.MinValue || math.Abs(measured-expected) <= chk.Tolerance/100*scale {
+		c.mismatches[key] = 0
+		c.resolve(key + "/" + chk.Signals[0])
+		return
+	}
+	c.mismatches[key]++
+	if c.mismatches[key] < c.cfg.Persist {
+		return
+	}
+	c.report(key+"/"+chk.Signals[0], Finding{
+		Device:  device,
+		Check:   chk.Name,
+		Signals: chk.Signals,
+		Message: fmt.Sprintf("%s = %.4g khác %s = %.4g quá %g%%, nghi sai hệ số, thứ tự word hoặc địa chỉ thanh ghi",
+			chk.Signals[0], v[0], formula, expected, chk.Tolerance),
+	}, now)
+}
+
+// report ghi nhận một bất thường, ghi nhật ký khi mới phát hiện; gọi khi đang giữ khóa
+func (c *Checker) report(key string, f Finding, now time.Time) {
+	if old, ok := c.findings[key]; ok {
+		old.Message = f.Message
+		old.Last = now
+		old.Count++
+		return
+	}
+	f.Since, f.Last, f.Count = now, now, 1
+	c.findings[key] = &f
+	c.logger.Printf("Kiểm tra hợp lý: %s: %s", f.Device, f.Message)
+}
+
+// resolve xóa bất thường đã hết; gọi khi đang giữ khóa
+func (c *Checker) resolve(key string) {
+	if f, ok := c.findings[key]; ok {
+		delete(c.findings, key)
+		c.logger.Printf("Kiểm tra hợp lý: %s: hết bất thường %s %v", f.Device, f.Check, f.Signals)
+	}
+}
@@ -0,0 +1,99 @@
+package plausibility
+
+import (
+	"io"
+	"log"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/store"
+)
+
+// TestChecker kiểm tra dải, bộ đếm và kiểm tra chéo với cấu hình mặc định
+func TestChecker(t *testing.T) {
+	c, err := NewChecker(Config{}, log.New(io.Discard, "", 0))
+	require.NoError(t, err)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// 10 kW ≈ √3 · 400 V · 15.2 A · 0.95
+	good := map[string]float64{"active_power": 10, "voltage": 400, "current": 15.2, "power_factor": 0.95, "frequency": 50}
+
+	t.Run("Dải hợp lệ", func(t *testing.T) {
+		values := map[string]float64{"voltage": 3.4e38, "frequency": 50, "temperature": math.NaN()}
+		tags := c.Check("inverter1", store.Tags(values, now))
+		assert.Equal(t, store.QualityOutOfRange, tags["voltage"].Quality)
+		assert.Equal(t, store.QualityGood, tags["frequency"].Quality)
+		assert.Equal(t, store.QualityOutOfRange, tags["temperature"].Quality)
+
+		findings := c.Findings()
+		require.Len(t, findings, 2)
+		assert.Contains(t, findings[1].Message, "thứ tự word")
+
+		c.Check("inverter1", store.Tags(good, now.Add(time.Second)))
+		c.Check("inverter1", store.Tags(map[string]float64{"temperature": 40}, now.Add(time.Second)))
+		assert.Empty(t, c.Findings(), "Hết bất thường khi giá trị hợp lệ")
+	})
+
+	t.Run("Bộ đếm năng lượng", func(t *testing.T) {
+		energy := func(v float64) store.Quality {
+			tags := c.Check("meter1", store.Tags(map[string]float64{"active_energy_delivered_wh": v}, now.Add(2*time.Second)))
+			return tags["active_energy_delivered_wh"].Quality
+		}
+		assert.Equal(t, store.QualityGood, energy(100))
+		assert.Equal(t, store.QualityOutOfRange, energy(90))
+		assert.Equal(t, store.QualityGood, energy(101))
+
+		// Giảm liên tiếp được coi là đặt lại bộ đếm
+		energy(5)
+		energy(5)
+		assert.Equal(t, store.QualityGood, energy(5))
+		assert.Empty(t, c.Findings())
+	})
+
+	t.Run("Kiểm tra chéo", func(t *testing.T) {
+		wrong := map[string]float64{"active_power": 10, "voltage": 230, "current": 15.2, "power_factor": 0.95}
+		for i := 0; i < 2; i++ {
+			c.Check("inverter1", store.Tags(wrong, now.Add(5*time.Second)))
+		}
+		assert.Empty(t, c.Findings(), "Chưa đủ số lần liên tiếp")
+		tags := c.Check("inverter1", store.Tags(wrong, now.Add(6*time.Second)))
+		assert.Equal(t, store.QualityGood, tags["voltage"].Quality, "Chỉ báo, không đánh dấu giá trị")
+		findings := c.Findings()
+		require.Len(t, findings, 1)
+		assert.Equal(t, "inverter_power", findings[0].Check)
+
+		meter := map[string]float64{"apparent_power_total": 50, "active_power_total": 30, "reactive_power_total": 40}
+		c.Check("meter1", store.Tags(meter, now))
+		assert.Len(t, c.Findings(), 1, "S² = P² + Q²")
+
+		c.Check("inverter1", store.Tags(good, now.Add(7*time.Second)))
+		assert.Empty(t, c.Findings())
+	})
+}
+
+// TestCheckerDrop kiểm tra bỏ giá trị không hợp lý và cấu hình sai
+func TestCheckerDrop(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	c, err := NewChecker(Config{Action: ActionDrop, Ranges: []Range{{Device: "meter1", Signal: "frequency", Min: 49, Max: 51}}}, logger)
+	require.NoError(t, err)
+	tags := c.Check("meter1", store.Tags(map[string]float64{"frequency": 55, "current_a": 5}, time.Now()))
+	assert.NotContains(t, tags, "frequency")
+	assert.Contains(t, tags, "current_a")
+	tags = c.Check("inverter1", store.Tags(map[string]float64{"frequency": 55}, time.Now()))
+	assert.Contains(t, tags, "frequency", "Dải chỉ áp dụng cho meter1")
+
+	for _, cfg := range []Config{
+		{Action: "ignore"},
+		{Ranges: []Range{{Signal: "x", Min: 1, Max: 0}}},
+		{Checks: []Check{{Name: "a", Type: CheckPower, Signals: []string{"p"}}}},
+		{Checks: []Check{{Name: "a", Type: "ratio", Signals: []string{"p"}}}},
+		{Checks: []Check{{Name: "a", Type: CheckMonotonic, Signals: []string{"e"}}, {Name: "a", Type: CheckMonotonic, Signals: []string{"e"}}}},
+	} {
+		_, err := NewChecker(cfg, logger)
+		assert.Error(t, err, "%+v", cfg)
+	}
+}
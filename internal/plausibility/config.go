@@ -0,0 +1,126 @@
+package plausibility
+
+import (
+	"fmt"
+)
+
+// Loại kiểm tra chéo
+const (
+	CheckPower     = "power"     // P ≈ k·U·I·|PF|/1000 (k = √3 với 3 pha), Signals: P (kW), U (V), I (A), PF
+	CheckTriangle  = "triangle"  // S² ≈ P² + Q², Signals: S, P, Q
+	CheckMonotonic = "monotonic" // Bộ đếm năng lượng không giảm, Signals: các bộ đếm
+)
+
+// Cách xử lý giá trị không hợp lý
+const (
+	ActionFlag = "flag" // Giữ giá trị, đánh dấu chất lượng ngoài dải
+	ActionDrop = "drop" // Bỏ giá trị, store giữ giá trị cũ
+)
+
+// Config cấu hình kiểm tra tính hợp lý của giá trị đọc được
+type Config struct {
+	Enabled      bool    `json:"enabled"`
+	Action       string  `json:"action"`        // flag (mặc định) hoặc drop
+	MaxMagnitude float64 `json:"max_magnitude"` // Giá trị tuyệt đối lớn hơn bị coi là sai (ví dụ 3.4e38 do sai thứ tự word), mặc định 1e12
+	Ranges       []Range `json:"ranges"`        // Dải hợp lệ theo tín hiệu, bỏ trống dùng DefaultRanges
+	Checks       []Check `json:"checks"`        // Kiểm tra chéo giữa các tín hiệu, bỏ trống dùng DefaultChecks
+	Persist      int     `json:"persist"`       // Số lần sai lệch liên tiếp trước khi báo nghi sai cấu hình, mặc định 3
+}
+
+// Range là dải hợp lệ của một tín hiệu
+type Range struct {
+	Device string  `json:"device"` // Tên thiết bị, bỏ trống: mọi thiết bị
+	Signal string  `json:"signal"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// Check là một kiểm tra chéo, chỉ áp dụng cho thiết bị có đủ các tín hiệu
+type Check struct {
+	Name      string   `json:"name"`      // Tên kiểm tra, duy nhất
+	Device    string   `json:"device"`    // Tên thiết bị, bỏ trống: mọi thiết bị
+	Type      string   `json:"type"`      // power, triangle, monotonic
+	Signals   []string `json:"signals"`   // Tín hiệu theo thứ tự của loại kiểm tra
+	Phases    int      `json:"phases"`    // Số pha của power: 3 (mặc định, U là điện áp dây) hoặc 1
+	Tolerance float64  `json:"tolerance"` // Sai lệch tương đối cho phép (%), mặc định 10
+	MinValue  float64  `json:"min_value"` // Bỏ qua khi công suất nhỏ hơn, mặc định 1
+}
+
+// DefaultRanges trả về dải hợp lệ mặc định cho các tín hiệu của inverter và cảm biến thời tiết
+func DefaultRanges() []Range {
+	return []Range{
+		{Signal: "frequency", Min: 45, Max: 65},
+		{Signal: "power_factor", Min: -1, Max: 1},
+		{Signal: "voltage", Min: 0, Max: 1500},
+		{Signal: "temperature", Min: -40, Max: 150},
+		{Signal: "efficiency", Min: 0, Max: 100},
+		{Signal: "irradiance", Min: -10, Max: 2000},
+		{Signal: "module_temperature", Min: -40, Max: 120},
+		{Signal: "ambient_temperature", Min: -50, Max: 70},
+	}
+}
+
+// DefaultChecks trả về các kiểm tra chéo mặc định cho inverter và đồng hồ PM2120.
+// PM2120 không kiểm tra theo hệ số công suất vì kiểu 4Q_FP_PF mã hóa cả góc phần tư.
+// Bộ đếm 16 bit total_energy của inverter quay vòng nên do counter.Tracker xử lý.
+func DefaultChecks() []Check {
+	return []Check{
+		{Name: "inverter_power", Type: CheckPower, Signals: []string{"active_power", "voltage", "current", "power_factor"}},
+		{Name: "meter_power_triangle", Type: CheckTriangle, Signals: []string{"apparent_power_total", "active_power_total", "reactive_power_total"}},
+		{Name: "energy_counters", Type: CheckMonotonic, Signals: []string{
+			"active_energy_delivered_wh", "active_energy_received_wh",
+			"reactive_energy_delivered_varh", "reactive_energy_received_varh",
+			"apparent_energy_delivered_vah", "apparent_energy_received_vah",
+		}},
+	}
+}
+
+// validate kiểm tra và điền giá trị mặc định cho kiểm tra chéo
+func (c *Check) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("kiểm tra chéo chưa có tên")
+	}
+	want := 0
+	switch c.Type {
+	case CheckPower:
+		want = 4
+	case CheckTriangle:
+		want = 3
+	case CheckMonotonic:
+		if len(c.Signals) == 0 {
+			return fmt.Errorf("kiểm tra %q chưa có tín hiệu", c.Name)
+		}
+	default:
+		return fmt.Errorf("kiểm tra %q có loại không hợp lệ %q", c.Name, c.Type)
+	}
+	if want > 0 && len(c.Signals) != want {
+		return fmt.Errorf("kiểm tra %q cần %d tín hiệu", c.Name, want)
+	}
+	switch c.Phases {
+	case 0:
+		c.Phases = 3
+	case 1, 3:
+	default:
+		return fmt.Errorf("kiểm tra %q có số pha không hợp lệ %d", c.Name, c.Phases)
+	}
+	if c.Tolerance < 0 || c.MinValue < 0 {
+		return fmt.Errorf("kiểm tra %q có ngưỡng âm", c.Name)
+	}
+	if c.Tolerance == 0 {
+		c.Tolerance = 10
+	}
+	if c.MinValue == 0 {
+		c.MinValue = 1
+	}
+	return nil
+}
+
+// applies kiểm tra quy tắc có áp dụng cho thiết bị không
+func (c *Check) applies(device string) bool {
+	return c.Device == "" || c.Device == device
+}
+
+// matches kiểm tra dải có áp dụng cho tín hiệu của thiết bị không
+func (r *Range) matches(device, signal string) bool {
+	return (r.Device == "" || r.Device == device) && r.Signal == signal
+}
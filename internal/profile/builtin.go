@@ -0,0 +1,248 @@
+package profile
+
+// Tín hiệu của cảm biến bức xạ và trạm thời tiết
+const (
+	SignalIrradiance         = "irradiance"          // Bức xạ trên mặt phẳng tấm pin (W/m²)
+	SignalModuleTemperature  = "module_temperature"  // Nhiệt độ tấm pin (°C)
+	SignalAmbientTemperature = "ambient_temperature" // Nhiệt độ môi trường (°C)
+	SignalSensorTemperature  = "sensor_temperature"  // Nhiệt độ thân cảm biến (°C)
+	SignalHumidity           = "humidity"            // Độ ẩm tương đối (%)
+	SignalAirPressure        = "air_pressure"        // Áp suất không khí (hPa)
+	SignalWindSpeed          = "wind_speed"          // Tốc độ gió (m/s)
+	SignalWindDirection      = "wind_direction"      // Hướng gió (°)
+)
+
+// huaweiRunning là các trạng thái 32089 của Huawei SUN2000 đang phát lên lưới
+var huaweiRunning = map[uint32]float64{
+	0x0200: 1, // Hòa lưới
+	0x0201: 1, // Hòa lưới, bị giới hạn công suất
+	0x0202: 1, // Hòa lưới, tự giảm công suất
+	0x0401: 1, // Điều độ lưới: đường cong cosφ-P
+	0x0402: 1, // Điều độ lưới: đường cong Q-U
+	0x0403: 1, // Điều độ lưới: đường cong PF-U
+	0x0404: 1, // Điều độ lưới: tiếp điểm khô
+	0x0405: 1, // Điều độ lưới: đường cong Q-P
+}
+
+// sungrowRunning là các trạng thái làm việc (5038) của Sungrow SG đang phát lên lưới
+var sungrowRunning = map[uint32]float64{
+	0x0000: 1, // Đang chạy
+	0x8100: 1, // Chạy giảm công suất
+	0x8200: 1, // Chạy theo điều độ
+	0x9100: 1, // Chạy kèm cảnh báo
+}
+
+// smaRunning là các trạng thái vận hành (40029) của SMA đang phát lên lưới
+var smaRunning = map[uint32]float64{
+	295:  1, // MPP
+	443:  1, // Điện áp không đổi
+	2119: 1, // Giảm công suất
+}
+
+// Builtin trả về các profile có sẵn. Địa chỉ là địa chỉ 0-based trong khung
+// Modbus theo tài liệu của hãng, nên kiểm tra lại với phiên bản firmware của thiết bị.
+func Builtin() map[string]Profile {
+	profiles := map[string]Profile{
+		// IMT Si-RS485TC: cảm biến tế bào quang điện kèm nhiệt độ tấm pin và
+		// nhiệt độ môi trường (bản -T-Tm), giá trị nhân 10
+		"imt_si": {
+			Function: FunctionInput,
+			Registers: []Register{
+				{Signal: SignalIrradiance, Address: 0, Scale: 10},
+				{Signal: SignalModuleTemperature, Address: 1, Type: TypeS16, Scale: 10},
+				{Signal: SignalAmbientTemperature, Address: 2, Type: TypeS16, Scale: 10},
+			},
+		},
+		// Kipp & Zonen SMP3/SMP10/SMP22: bức xạ đã bù nhiệt (W/m²) và nhiệt độ thân
+		"kipp_zonen_smp": {
+			Function: FunctionInput,
+			MaxGap:   2,
+			Registers: []Register{
+				{Signal: SignalIrradiance, Address: 5, Type: TypeS16},
+				{Signal: SignalSensorTemperature, Address: 8, Type: TypeS16, Scale: 10},
+			},
+		},
+		// Lufft WS200/WS300/WS500/WS600 (UMB-Modbus): giá trị tức thời nhân 10,
+		// bức xạ chỉ có ở bản có pyranometer (WS301, WS501, WS601)
+		"lufft_ws": {
+			Function: FunctionInput,
+			MaxGap:   10,
+			Registers: []Register{
+				{Signal: SignalAmbientTemperature, Address: 31, Type: TypeS16, Scale: 10},
+				{Signal: SignalHumidity, Address: 37, Scale: 10},
+				{Signal: SignalAirPressure, Address: 40, Scale: 10},
+				{Signal: SignalWindDirection, Address: 43, Scale: 10},
+				{Signal: SignalWindSpeed, Address: 46, Scale: 10},
+				{Signal: SignalIrradiance, Address: 55, Type: TypeS16, Scale: 10},
+			},
+		},
+		// Huawei SUN2000 (KTL-M0/M1/M2/M3): thanh ghi chỉ đọc từ 32000 đọc bằng
+		// mã hàm 03, giá trị kỹ thuật = giá trị thanh ghi / gain. Các tín hiệu
+		// ngoài mô hình chung: bit cảnh báo, trạng thái gốc và 8 chuỗi PV đầu,
+		// model nhiều chuỗi hơn thêm pv9_voltage... qua cấu hình profiles.
+		"huawei_sun2000": {
+			Function: FunctionHolding,
+			MaxGap:   16,
+			Registers: []Register{
+				{Signal: "alarm_1", Address: 32008},
+				{Signal: "alarm_2", Address: 32009},
+				{Signal: "alarm_3", Address: 32010},
+				{Signal: "pv1_voltage", Address: 32016, Type: TypeS16, Scale: 10},
+				{Signal: "pv1_current", Address: 32017, Type: TypeS16, Scale: 100},
+				{Signal: "pv2_voltage", Address: 32018, Type: TypeS16, Scale: 10},
+				{Signal: "pv2_current", Address: 32019, Type: TypeS16, Scale: 100},
+				{Signal: "pv3_voltage", Address: 32020, Type: TypeS16, Scale: 10},
+				{Signal: "pv3_current", Address: 32021, Type: TypeS16, Scale: 100},
+				{Signal: "pv4_voltage", Address: 32022, Type: TypeS16, Scale: 10},
+				{Signal: "pv4_current", Address: 32023, Type: TypeS16, Scale: 100},
+				{Signal: "pv5_voltage", Address: 32024, Type: TypeS16, Scale: 10},
+				{Signal: "pv5_current", Address: 32025, Type: TypeS16, Scale: 100},
+				{Signal: "pv6_voltage", Address: 32026, Type: TypeS16, Scale: 10},
+				{Signal: "pv6_current", Address: 32027, Type: TypeS16, Scale: 100},
+				{Signal: "pv7_voltage", Address: 32028, Type: TypeS16, Scale: 10},
+				{Signal: "pv7_current", Address: 32029, Type: TypeS16, Scale: 100},
+				{Signal: "pv8_voltage", Address: 32030, Type: TypeS16, Scale: 10},
+				{Signal: "pv8_current", Address: 32031, Type: TypeS16, Scale: 100},
+				{Signal: "dc_power", Address: 32064, Type: TypeS32, Scale: 1000},
+				{Signal: "voltage", Address: 32066, Scale: 10},                  // Điện áp dây AB
+				{Signal: "current", Address: 32072, Type: TypeS32, Scale: 1000}, // Dòng pha A
+				{Signal: "voltage_an", Address: 32069, Scale: 10},
+				{Signal: "voltage_bn", Address: 32070, Scale: 10},
+				{Signal: "voltage_cn", Address: 32071, Scale: 10},
+				{Signal: "current_a", Address: 32072, Type: TypeS32, Scale: 1000},
+				{Signal: "current_b", Address: 32074, Type: TypeS32, Scale: 1000},
+				{Signal: "current_c", Address: 32076, Type: TypeS32, Scale: 1000},
+				{Signal: "active_power", Address: 32080, Type: TypeS32, Scale: 1000},
+				{Signal: "reactive_power", Address: 32082, Type: TypeS32, Scale: 1000},
+				{Signal: "power_factor", Address: 32084, Type: TypeS16, Scale: 1000},
+				{Signal: "frequency", Address: 32085, Scale: 100},
+				{Signal: "efficiency", Address: 32086, Scale: 100},
+				{Signal: "temperature", Address: 32087, Type: TypeS16, Scale: 10},
+				{Signal: "insulation_resistance", Address: 32088, Scale: 1000}, // MΩ
+				{Signal: "inverter_state", Address: 32089},
+				{Signal: "device_status", Address: 32089, Enum: huaweiRunning},
+				{Signal: "error_code", Address: 32090},
+				{Signal: "total_energy", Address: 32106, Type: TypeU32, Scale: 100},
+				{Signal: "daily_energy", Address: 32114, Type: TypeU32, Scale: 100},
+			},
+		},
+		// Sungrow SG (SG33CX...SG350HX): thanh ghi đầu vào 5001 trở đi trong tài
+		// liệu (địa chỉ = số thanh ghi - 1), kiểu 32 bit có word thấp trước.
+		// Không có hiệu suất; số MPPT tùy model, bảng có 3 MPPT đầu.
+		"sungrow_sg": {
+			Function: FunctionInput,
+			MaxGap:   8,
+			Registers: []Register{
+				{Signal: "daily_energy", Address: 5002, Scale: 10},
+				{Signal: "total_energy", Address: 5003, Type: TypeU32, Swap: true},
+				{Signal: "temperature", Address: 5007, Type: TypeS16, Scale: 10},
+				{Signal: "pv1_voltage", Address: 5010, Scale: 10},
+				{Signal: "pv1_current", Address: 5011, Scale: 10},
+				{Signal: "pv2_voltage", Address: 5012, Scale: 10},
+				{Signal: "pv2_current", Address: 5013, Scale: 10},
+				{Signal: "pv3_voltage", Address: 5014, Scale: 10},
+				{Signal: "pv3_current", Address: 5015, Scale: 10},
+				{Signal: "dc_power", Address: 5016, Type: TypeU32, Swap: true, Scale: 1000},
+				{Signal: "voltage", Address: 5018, Scale: 10}, // Điện áp dây AB
+				{Signal: "current", Address: 5021, Scale: 10}, // Dòng pha A
+				{Signal: "current_a", Address: 5021, Scale: 10},
+				{Signal: "current_b", Address: 5022, Scale: 10},
+				{Signal: "current_c", Address: 5023, Scale: 10},
+				{Signal: "active_power", Address: 5030, Type: TypeU32, Swap: true, Scale: 1000},
+				{Signal: "reactive_power", Address: 5032, Type: TypeS32, Swap: true, Scale: 1000},
+				{Signal: "power_factor", Address: 5034, Type: TypeS16, Scale: 1000},
+				{Signal: "frequency", Address: 5035, Scale: 10},
+				{Signal: "inverter_state", Address: 5037},
+				{Signal: "device_status", Address: 5037, Enum: sungrowRunning},
+				{Signal: "error_code", Address: 5044},
+			},
+		},
+		// Growatt MAX/MID (TL3-X, giao thức RTU II): thanh ghi đầu vào 0-124.
+		// Vùng này không có công suất phản kháng, hệ số công suất 0-20000 ở
+		// thanh ghi 100 không rõ quy ước dấu nên không đưa vào mô hình chung.
+		"growatt_max": {
+			Function: FunctionInput,
+			MaxGap:   40,
+			Registers: []Register{
+				{Signal: "inverter_state", Address: 0},
+				{Signal: "device_status", Address: 0, Enum: map[uint32]float64{1: 1}}, // 0: chờ, 1: bình thường, 3: lỗi
+				{Signal: "dc_power", Address: 1, Type: TypeU32, Scale: 10000},
+				{Signal: "pv1_voltage", Address: 3, Scale: 10},
+				{Signal: "pv1_current", Address: 4, Scale: 10},
+				{Signal: "pv1_power", Address: 5, Type: TypeU32, Scale: 10000},
+				{Signal: "pv2_voltage", Address: 7, Scale: 10},
+				{Signal: "pv2_current", Address: 8, Scale: 10},
+				{Signal: "pv2_power", Address: 9, Type: TypeU32, Scale: 10000},
+				{Signal: "pv3_voltage", Address: 11, Scale: 10},
+				{Signal: "pv3_current", Address: 12, Scale: 10},
+				{Signal: "pv3_power", Address: 13, Type: TypeU32, Scale: 10000},
+				{Signal: "pv4_voltage", Address: 15, Scale: 10},
+				{Signal: "pv4_current", Address: 16, Scale: 10},
+				{Signal: "pv4_power", Address: 17, Type: TypeU32, Scale: 10000},
+				{Signal: "active_power", Address: 35, Type: TypeU32, Scale: 10000},
+				{Signal: "frequency", Address: 37, Scale: 100},
+				{Signal: "current", Address: 39, Scale: 10}, // Dòng pha R
+				{Signal: "voltage_an", Address: 38, Scale: 10},
+				{Signal: "current_a", Address: 39, Scale: 10},
+				{Signal: "active_power_a", Address: 40, Type: TypeU32, Scale: 10000},
+				{Signal: "voltage_bn", Address: 42, Scale: 10},
+				{Signal: "current_b", Address: 43, Scale: 10},
+				{Signal: "active_power_b", Address: 44, Type: TypeU32, Scale: 10000},
+				{Signal: "voltage_cn", Address: 46, Scale: 10},
+				{Signal: "current_c", Address: 47, Scale: 10},
+				{Signal: "active_power_c", Address: 48, Type: TypeU32, Scale: 10000},
+				{Signal: "voltage", Address: 50, Scale: 10}, // Điện áp dây RS
+				{Signal: "daily_energy", Address: 53, Type: TypeU32, Scale: 10},
+				{Signal: "total_energy", Address: 55, Type: TypeU32, Scale: 10},
+				{Signal: "temperature", Address: 93, Scale: 10},
+				{Signal: "error_code", Address: 105},
+			},
+		},
+		// SMA Sunny Tripower (STP xx000TL-10/-20/-30, STP CORE): thanh ghi giữ
+		// 30000 trở đi, Unit ID mặc định 3. SMA báo không có giá trị bằng
+		// 0x80000000/0xFFFFFFFF (ví dụ công suất ban đêm) và từ chối đọc qua
+		// thanh ghi không tồn tại; các khoảng trống được gộp (30531-30534,
+		// 30791-30802, 30951-30956) đều là thanh ghi có trong tài liệu nên đọc
+		// hết trong 6 lần thay vì mỗi tín hiệu một lần.
+		"sma_stp": {
+			Function: FunctionHolding,
+			MaxGap:   12,
+			NaN:      true,
+			Registers: []Register{
+				{Signal: "error_code", Address: 30247, Type: TypeU32},
+				{Signal: "total_energy", Address: 30529, Type: TypeU32, Scale: 1000},
+				{Signal: "daily_energy", Address: 30535, Type: TypeU32, Scale: 1000},
+				{Signal: "pv1_current", Address: 30769, Type: TypeS32, Scale: 1000},
+				{Signal: "pv1_voltage", Address: 30771, Type: TypeS32, Scale: 100},
+				{Signal: "pv1_power", Address: 30773, Type: TypeS32, Scale: 1000},
+				{Signal: "active_power", Address: 30775, Type: TypeS32, Scale: 1000},
+				{Signal: "active_power_a", Address: 30777, Type: TypeS32, Scale: 1000},
+				{Signal: "active_power_b", Address: 30779, Type: TypeS32, Scale: 1000},
+				{Signal: "active_power_c", Address: 30781, Type: TypeS32, Scale: 1000},
+				{Signal: "voltage_an", Address: 30783, Type: TypeU32, Scale: 100},
+				{Signal: "voltage_bn", Address: 30785, Type: TypeU32, Scale: 100},
+				{Signal: "voltage_cn", Address: 30787, Type: TypeU32, Scale: 100},
+				{Signal: "voltage", Address: 30789, Type: TypeU32, Scale: 100}, // Điện áp dây L1-L2
+				{Signal: "frequency", Address: 30803, Type: TypeU32, Scale: 100},
+				{Signal: "reactive_power", Address: 30805, Type: TypeS32, Scale: 1000},
+				{Signal: "power_factor", Address: 30949, Type: TypeU32, Scale: 1000},
+				{Signal: "temperature", Address: 30953, Type: TypeS32, Scale: 10},
+				{Signal: "pv2_current", Address: 30957, Type: TypeS32, Scale: 1000},
+				{Signal: "pv2_voltage", Address: 30959, Type: TypeS32, Scale: 100},
+				{Signal: "pv2_power", Address: 30961, Type: TypeS32, Scale: 1000},
+				{Signal: "current", Address: 30977, Type: TypeS32, Scale: 1000}, // Dòng pha L1
+				{Signal: "current_a", Address: 30977, Type: TypeS32, Scale: 1000},
+				{Signal: "current_b", Address: 30979, Type: TypeS32, Scale: 1000},
+				{Signal: "current_c", Address: 30981, Type: TypeS32, Scale: 1000},
+				{Signal: "inverter_state", Address: 40029, Type: TypeU32},
+				{Signal: "device_status", Address: 40029, Type: TypeU32, Enum: smaRunning},
+			},
+		},
+	}
+	profiles["growatt_mid"] = profiles["growatt_max"]
+	for model, p := range profiles {
+		p.Model = model
+		profiles[model] = p
+	}
+	return profiles
+}
@@ -0,0 +1,267 @@
+// Package profile mô tả bảng thanh ghi đọc của từng model thiết bị (cảm biến bức
+// xạ, trạm thời tiết, inverter của các hãng) để poller đọc chung một cách.
+package profile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Loại thanh ghi
+const (
+	FunctionInput   = "input"   // Thanh ghi đầu vào (mã hàm 04)
+	FunctionHolding = "holding" // Thanh ghi giữ (mã hàm 03)
+)
+
+// Kiểu dữ liệu của thanh ghi
+const (
+	TypeU16 = "u16"
+	TypeS16 = "s16"
+	TypeU32 = "u32"
+	TypeS32 = "s32"
+	TypeF32 = "f32"
+)
+
+// maxQuantity số thanh ghi tối đa của một lần đọc theo chuẩn Modbus
+const maxQuantity = 125
+
+// Register mô tả thanh ghi của một tín hiệu
+type Register struct {
+	Signal   string  `json:"signal"`
+	Address  uint16  `json:"address"`  // Địa chỉ 0-based
+	Function string  `json:"function"` // input hoặc holding, mặc định theo profile
+	Type     string  `json:"type"`     // u16 (mặc định), s16, u32, s32, f32
+	Scale    float64 `json:"scale"`    // Giá trị thanh ghi = giá trị kỹ thuật * Scale, mặc định 1
+	Swap     bool    `json:"swap"`     // Kiểu 32 bit có word thấp trước
+
+	// Enum ánh xạ giá trị thô sang giá trị kỹ thuật, ví dụ mã trạng thái sang
+	// device_status; giá trị thô không có trong bảng thành 0
+	Enum map[uint32]float64 `json:"enum"`
+}
+
+// Profile là bảng thanh ghi đọc của một model thiết bị
+type Profile struct {
+	Model     string     `json:"model"`
+	Function  string     `json:"function"` // Loại thanh ghi mặc định: input (mặc định) hoặc holding
+	MaxGap    uint16     `json:"max_gap"`  // Số thanh ghi bỏ trống tối đa được gộp vào một lần đọc
+	NaN       bool       `json:"nan"`      // Giá trị 0xFFFF, 0x8000, 0xFFFFFFFF, 0x80000000 nghĩa là không có giá trị (SMA)
+	Registers []Register `json:"registers"`
+}
+
+// Reader là nguồn đọc thanh ghi của một thiết bị (modbus.SlaveClient)
+type Reader interface {
+	ReadHoldingRegisters(address uint16, quantity uint16) ([]byte, error)
+	ReadInputRegisters(address uint16, quantity uint16) ([]byte, error)
+}
+
+// block là một lần đọc các thanh ghi liền nhau
+type block struct {
+	function  string
+	address   uint16
+	quantity  uint16
+	registers []Register
+}
+
+// Validate kiểm tra bảng thanh ghi
+func (p Profile) Validate() error {
+	if p.Function != "" && p.Function != FunctionInput && p.Function != FunctionHolding {
+		return fmt.Errorf("profile %q có loại thanh ghi không hợp lệ %q", p.Model, p.Function)
+	}
+	if len(p.Registers) == 0 {
+		return fmt.Errorf("profile %q chưa có thanh ghi", p.Model)
+	}
+	signals := make(map[string]bool, len(p.Registers))
+	for _, r := range p.Registers {
+		if r.Signal == "" {
+			return fmt.Errorf("profile %q có thanh ghi %d chưa có tên tín hiệu", p.Model, r.Address)
+		}
+		if signals[r.Signal] {
+			return fmt.Errorf("profile %q có tín hiệu %q bị trùng", p.Model, r.Signal)
+		}
+		signals[r.Signal] = true
+		if r.Function != "" && r.Function != FunctionInput && r.Function != FunctionHolding {
+			return fmt.Errorf("tín hiệu %q có loại thanh ghi không hợp lệ %q", r.Signal, r.Function)
+		}
+		if r.size() == 0 {
+			return fmt.Errorf("tín hiệu %q có kiểu dữ liệu không hợp lệ %q", r.Signal, r.Type)
+		}
+		if r.Enum != nil && r.Type != "" && r.Type != TypeU16 && r.Type != TypeU32 {
+			return fmt.Errorf("tín hiệu %q chỉ dùng enum với kiểu u16 hoặc u32", r.Signal)
+		}
+		if int(r.Address)+int(r.size()) > math.MaxUint16+1 {
+			return fmt.Errorf("tín hiệu %q vượt quá vùng địa chỉ", r.Signal)
+		}
+	}
+	return nil
+}
+
+// Signals trả về tên các tín hiệu theo thứ tự khai báo
+func (p Profile) Signals() []string {
+	signals := make([]string, 0, len(p.Registers))
+	for _, r := range p.Registers {
+		signals = append(signals, r.Signal)
+	}
+	return signals
+}
+
+// Read đọc mọi tín hiệu của profile. Đọc lỗi một phần vẫn trả về các tín hiệu
+// đọc được cùng lỗi đầu tiên, các tín hiệu đọc lỗi hoặc không có giá trị
+// không có trong kết quả.
+func (p Profile) Read(r Reader) (map[string]float64, error) {
+	values, _, err := p.ReadPartial(r)
+	return values, err
+}
+
+// ReadPartial giống Read, trả thêm tên các tín hiệu thuộc những lần đọc lỗi để
+// đánh dấu mất liên lạc; tín hiệu thiết bị báo không có giá trị không nằm trong đó
+func (p Profile) ReadPartial(r Reader) (map[string]float64, []string, error) {
+	values := make(map[string]float64, len(p.Registers))
+	var failed []string
+	var firstErr error
+	for _, b := range p.blocks() {
+		var data []byte
+		var err error
+		if b.function == FunctionHolding {
+			data, err = r.ReadHoldingRegisters(b.address, b.quantity)
+		} else {
+			data, err = r.ReadInputRegisters(b.address, b.quantity)
+		}
+		if err == nil && len(data) != int(b.quantity)*2 {
+			err = fmt.Errorf("độ dài dữ liệu không đúng (%d bytes, cần %d)", len(data), b.quantity*2)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("đọc thanh ghi %d-%d lỗi: %w", b.address, b.address+b.quantity-1, err)
+			}
+			for _, reg := range b.registers {
+				failed = append(failed, reg.Signal)
+			}
+			continue
+		}
+		for _, reg := range b.registers {
+			offset := int(reg.Address-b.address) * 2
+			raw := data[offset : offset+int(reg.size())*2]
+			if p.NaN && isNaN(raw) {
+				continue
+			}
+			values[reg.Signal] = reg.decode(raw)
+		}
+	}
+	return values, failed, firstErr
+}
+
+// Modulus trả về giá trị mà thanh ghi của tín hiệu quay vòng về 0 (theo đơn vị
+// kỹ thuật), dùng cho bộ đếm năng lượng; 0 nếu không có tín hiệu hoặc không phải
+// kiểu số nguyên không dấu
+func (p Profile) Modulus(signal string) float64 {
+	for _, r := range p.Registers {
+		if r.Signal != signal || r.Enum != nil {
+			continue
+		}
+		switch r.Type {
+		case "", TypeU16:
+			return (math.MaxUint16 + 1) / r.scale()
+		case TypeU32:
+			return (math.MaxUint32 + 1) / r.scale()
+		}
+		return 0
+	}
+	return 0
+}
+
+// blocks gộp các thanh ghi cùng loại, cách nhau không quá MaxGap thành các lần đọc
+func (p Profile) blocks() []block {
+	regs := make([]Register, len(p.Registers))
+	copy(regs, p.Registers)
+	for i := range regs {
+		if regs[i].Function == "" {
+			regs[i].Function = p.Function
+		}
+		if regs[i].Function == "" {
+			regs[i].Function = FunctionInput
+		}
+	}
+	sort.SliceStable(regs, func(i, j int) bool {
+		if regs[i].Function != regs[j].Function {
+			return regs[i].Function < regs[j].Function
+		}
+		return regs[i].Address < regs[j].Address
+	})
+
+	var blocks []block
+	for _, r := range regs {
+		end := uint32(r.Address) + uint32(r.size())
+		if n := len(blocks); n > 0 {
+			b := &blocks[n-1]
+			last := uint32(b.address) + uint32(b.quantity)
+			if b.function == r.Function && uint32(r.Address) <= last+uint32(p.MaxGap) && end-uint32(b.address) <= maxQuantity {
+				if end > last {
+					b.quantity = uint16(end - uint32(b.address))
+				}
+				b.registers = append(b.registers, r)
+				continue
+			}
+		}
+		blocks = append(blocks, block{function: r.Function, address: r.Address, quantity: r.size(), registers: []Register{r}})
+	}
+	return blocks
+}
+
+// isNaN kiểm tra giá trị thô báo không có giá trị theo quy ước của SMA
+func isNaN(data []byte) bool {
+	if len(data) == 2 {
+		v := binary.BigEndian.Uint16(data)
+		return v == 0xFFFF || v == 0x8000
+	}
+	v := binary.BigEndian.Uint32(data)
+	return v == 0xFFFFFFFF || v == 0x80000000
+}
+
+// size trả về số thanh ghi theo kiểu dữ liệu, 0 nếu kiểu không hợp lệ
+func (r Register) size() uint16 {
+	switch r.Type {
+	case "", TypeU16, TypeS16:
+		return 1
+	case TypeU32, TypeS32, TypeF32:
+		return 2
+	}
+	return 0
+}
+
+// scale trả về hệ số tỉ lệ, mặc định 1
+func (r Register) scale() float64 {
+	if r.Scale == 0 {
+		return 1
+	}
+	return r.Scale
+}
+
+// decode chuyển dữ liệu thanh ghi thành giá trị kỹ thuật
+func (r Register) decode(data []byte) float64 {
+	var raw float64
+	switch r.Type {
+	case "", TypeU16:
+		raw = float64(binary.BigEndian.Uint16(data))
+	case TypeS16:
+		raw = float64(int16(binary.BigEndian.Uint16(data)))
+	default:
+		v := binary.BigEndian.Uint32(data)
+		if r.Swap {
+			v = v<<16 | v>>16
+		}
+		switch r.Type {
+		case TypeU32:
+			raw = float64(v)
+		case TypeS32:
+			raw = float64(int32(v))
+		case TypeF32:
+			raw = float64(math.Float32frombits(v))
+		}
+	}
+	if r.Enum != nil {
+		return r.Enum[uint32(raw)]
+	}
+	return raw / r.scale()
+}
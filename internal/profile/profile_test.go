@@ -0,0 +1,112 @@
+package profile
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReader giả lập thanh ghi của một thiết bị và ghi lại các lần đọc
+type fakeReader struct {
+	holding, input map[uint16]uint16
+	fail           map[uint16]bool // Địa chỉ đầu của lần đọc trả về lỗi
+	reads          []string
+}
+
+func (f *fakeReader) read(regs map[uint16]uint16, function string, address, quantity uint16) ([]byte, error) {
+	f.reads = append(f.reads, function)
+	if f.fail[address] {
+		return nil, errors.New("timeout")
+	}
+	data := make([]byte, 0, quantity*2)
+	for a := address; a < address+quantity; a++ {
+		data = append(data, byte(regs[a]>>8), byte(regs[a]))
+	}
+	return data, nil
+}
+
+func (f *fakeReader) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	return f.read(f.holding, FunctionHolding, address, quantity)
+}
+
+func (f *fakeReader) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	return f.read(f.input, FunctionInput, address, quantity)
+}
+
+// TestRead kiểm tra giải mã kiểu dữ liệu, gộp lần đọc và đọc lỗi một phần
+func TestRead(t *testing.T) {
+	p := Profile{
+		Model:  "test",
+		MaxGap: 4,
+		Registers: []Register{
+			{Signal: "u16", Address: 0, Scale: 10},
+			{Signal: "s16", Address: 1, Type: TypeS16, Scale: 10},
+			{Signal: "u32", Address: 4, Type: TypeU32, Scale: 100},
+			{Signal: "s32", Address: 6, Type: TypeS32, Swap: true},
+			{Signal: "f32", Address: 100, Type: TypeF32},
+			{Signal: "holding", Address: 0, Function: FunctionHolding},
+		},
+	}
+	require.NoError(t, p.Validate())
+	assert.Equal(t, []string{"u16", "s16", "u32", "s32", "f32", "holding"}, p.Signals())
+
+	r := &fakeReader{
+		input: map[uint16]uint16{
+			0: 2345, 1: 0xFF9C, // 234.5, -10.0
+			4: 0x0001, 5: 0x86A0, // 100000 / 100
+			6: 0xFFFE, 7: 0xFFFF, // -2 với word thấp trước
+			100: 0x4248, 101: 0x0000, // 50.0
+		},
+		holding: map[uint16]uint16{0: 7},
+	}
+	values, err := p.Read(r)
+	require.NoError(t, err)
+	assert.InDelta(t, 234.5, values["u16"], 1e-9)
+	assert.InDelta(t, -10, values["s16"], 1e-9)
+	assert.InDelta(t, 1000, values["u32"], 1e-9)
+	assert.InDelta(t, -2, values["s32"], 1e-9)
+	assert.InDelta(t, 50, values["f32"], 1e-9)
+	assert.InDelta(t, 7, values["holding"], 1e-9)
+	assert.Equal(t, []string{FunctionHolding, FunctionInput, FunctionInput}, r.reads, "Thanh ghi 0-7 gộp một lần đọc")
+
+	t.Run("Đọc lỗi một phần", func(t *testing.T) {
+		r.fail = map[uint16]bool{100: true}
+		values, err := p.Read(r)
+		assert.Error(t, err)
+		assert.Len(t, values, 5)
+		assert.NotContains(t, values, "f32")
+	})
+
+	for _, bad := range []Profile{
+		{Model: "rỗng"},
+		{Model: "kiểu", Registers: []Register{{Signal: "a", Type: "u8"}}},
+		{Model: "trùng", Registers: []Register{{Signal: "a"}, {Signal: "a", Address: 1}}},
+		{Model: "loại", Function: "coil", Registers: []Register{{Signal: "a"}}},
+		{Model: "địa chỉ", Registers: []Register{{Signal: "a", Address: 65535, Type: TypeU32}}},
+	} {
+		assert.Error(t, bad.Validate(), bad.Model)
+	}
+}
+
+// TestBuiltin kiểm tra các profile có sẵn
+func TestBuiltin(t *testing.T) {
+	for model, p := range Builtin() {
+		assert.Equal(t, model, p.Model)
+		assert.NoError(t, p.Validate(), model)
+	}
+
+	r := &fakeReader{input: map[uint16]uint16{5: 812, 8: 0xFFEC}}
+	values, err := Builtin()["kipp_zonen_smp"].Read(r)
+	require.NoError(t, err)
+	assert.InDelta(t, 812, values[SignalIrradiance], 1e-9)
+	assert.InDelta(t, -2, values[SignalSensorTemperature], 1e-9)
+	assert.Len(t, r.reads, 1)
+
+	huawei := Builtin()["huawei_sun2000"]
+	assert.InDelta(t, 42949672.96, huawei.Modulus("total_energy"), 1e-6, "u32, 0.01 kWh")
+	assert.InDelta(t, 6553.6, Builtin()["sungrow_sg"].Modulus("daily_energy"), 1e-9, "u16, 0.1 kWh")
+	assert.Zero(t, huawei.Modulus("active_power"), "Kiểu có dấu không quay vòng")
+	assert.Zero(t, huawei.Modulus("khong_co"))
+}
@@ -0,0 +1,205 @@
+package queue
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// segmentExt phần mở rộng của file đoạn hàng đợi
+const segmentExt = ".seg"
+
+// segment là một lô bản ghi được lưu thành một file
+type segment struct {
+	seq   uint64
+	lines int
+	size  int64
+}
+
+// Queue là hàng đợi lưu và chuyển tiếp trên đĩa: mỗi lô bản ghi gửi thất bại
+// được ghi thành một file đoạn, đọc lại theo thứ tự cũ nhất trước khi kết
+// nối phục hồi. Dữ liệu còn nguyên sau khi khởi động lại gateway.
+type Queue struct {
+	dir      string
+	maxBytes int64 // Dung lượng tối đa, vượt quá thì bỏ đoạn cũ nhất; 0: không giới hạn
+
+	mu       sync.Mutex
+	segments []segment // Sắp xếp theo seq
+	nextSeq  uint64
+	lines    int
+	size     int64
+	dropped  int // Số bản ghi đã bỏ do vượt dung lượng
+}
+
+// Open mở (hoặc tạo) hàng đợi trong thư mục dir
+func Open(dir string, maxBytes int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("tạo thư mục hàng đợi lỗi: %w", err)
+	}
+
+	q := &Queue{dir: dir, maxBytes: maxBytes, nextSeq: 1}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("đọc thư mục hàng đợi lỗi: %w", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), segmentExt+".tmp") {
+			// Đoạn ghi dở khi gateway dừng trước lúc đổi tên
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return nil, fmt.Errorf("xóa hàng đợi lỗi: %w", err)
+			}
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+		var seq uint64
+		if _, err := fmt.Sscanf(e.Name(), "%020d"+segmentExt, &seq); err != nil {
+			continue
+		}
+		// Đoạn không đọc được vẫn được giữ lại, Peek sẽ bỏ qua khi tới lượt
+		path := filepath.Join(dir, e.Name())
+		lines, _ := readLines(path)
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() == 0 {
+			// File rỗng còn lại khi gateway dừng giữa lúc tạo và ghi đoạn
+			if err := os.Remove(path); err != nil {
+				return nil, fmt.Errorf("xóa hàng đợi lỗi: %w", err)
+			}
+			continue
+		}
+		q.segments = append(q.segments, segment{seq: seq, lines: len(lines), size: info.Size()})
+		q.lines += len(lines)
+		q.size += info.Size()
+		if seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].seq < q.segments[j].seq })
+	return q, nil
+}
+
+// Push ghi một lô bản ghi vào cuối hàng đợi
+func (q *Queue) Push(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seq := q.nextSeq
+	data := strings.Join(lines, "\n") + "\n"
+	path := q.path(seq)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+		return fmt.Errorf("ghi hàng đợi lỗi: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("ghi hàng đợi lỗi: %w", err)
+	}
+
+	q.nextSeq++
+	q.segments = append(q.segments, segment{seq: seq, lines: len(lines), size: int64(len(data))})
+	q.lines += len(lines)
+	q.size += int64(len(data))
+
+	// Bỏ dữ liệu cũ nhất khi vượt dung lượng, luôn giữ lại lô mới nhất
+	for q.maxBytes > 0 && q.size > q.maxBytes && len(q.segments) > 1 {
+		q.dropped += q.segments[0].lines
+		if err := q.remove(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Peek đọc lô bản ghi cũ nhất, trả về nil nếu hàng đợi rỗng. Đoạn rỗng hoặc
+// không đọc được bị bỏ (tính vào Dropped) để không chặn các đoạn phía sau.
+func (q *Queue) Peek() ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.segments) > 0 {
+		lines, err := readLines(q.path(q.segments[0].seq))
+		if err == nil && len(lines) > 0 {
+			return lines, nil
+		}
+		q.dropped += q.segments[0].lines
+		if err := q.remove(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Pop xóa lô bản ghi cũ nhất sau khi đã gửi thành công
+func (q *Queue) Pop() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.segments) == 0 {
+		return nil
+	}
+	return q.remove()
+}
+
+// Len trả về tổng số bản ghi đang chờ
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.lines
+}
+
+// Dropped trả về số bản ghi đã bỏ do vượt dung lượng
+func (q *Queue) Dropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// remove xóa đoạn cũ nhất, gọi khi đang giữ khóa
+func (q *Queue) remove() error {
+	seg := q.segments[0]
+	if err := os.Remove(q.path(seg.seq)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("xóa hàng đợi lỗi: %w", err)
+	}
+	q.segments = q.segments[1:]
+	q.lines -= seg.lines
+	q.size -= seg.size
+	return nil
+}
+
+// path trả về đường dẫn file của đoạn
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// readLines đọc các dòng không rỗng của file
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("đọc hàng đợi lỗi: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("đọc hàng đợi lỗi: %w", err)
+	}
+	return lines, nil
+}
@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestQueue kiểm tra thứ tự, lưu bền và giới hạn dung lượng của hàng đợi
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := Open(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, q.Push([]string{"a", "b"}))
+	require.NoError(t, q.Push([]string{"c"}))
+	assert.Equal(t, 3, q.Len())
+
+	// Mở lại sau khi khởi động lại
+	q, err = Open(dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, q.Len())
+
+	lines, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, lines, "Lô cũ nhất trước")
+	require.NoError(t, q.Pop())
+
+	require.NoError(t, q.Push([]string{"d"}))
+	lines, _ = q.Peek()
+	assert.Equal(t, []string{"c"}, lines)
+	require.NoError(t, q.Pop())
+	lines, _ = q.Peek()
+	assert.Equal(t, []string{"d"}, lines, "Số thứ tự tiếp tục sau khi mở lại")
+	require.NoError(t, q.Pop())
+
+	lines, err = q.Peek()
+	require.NoError(t, err)
+	assert.Nil(t, lines)
+	assert.Equal(t, 0, q.Len())
+}
+
+// TestQueueMaxBytes kiểm tra bỏ dữ liệu cũ nhất khi vượt dung lượng
+func TestQueueMaxBytes(t *testing.T) {
+	q, err := Open(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	require.NoError(t, q.Push([]string{"1111"}))
+	require.NoError(t, q.Push([]string{"2222"}))
+	require.NoError(t, q.Push([]string{"3333"}))
+
+	assert.Equal(t, 2, q.Len())
+	assert.Equal(t, 1, q.Dropped())
+	lines, _ := q.Peek()
+	assert.Equal(t, []string{"2222"}, lines)
+}
+
+// TestQueueEmptySegment kiểm tra đoạn rỗng ở đầu hàng đợi không chặn các đoạn sau
+func TestQueueEmptySegment(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir, 0)
+	require.NoError(t, err)
+
+	// Đoạn rỗng do gateway dừng giữa lúc tạo file và đoạn chỉ có dòng trống
+	require.NoError(t, os.WriteFile(q.path(1), nil, 0o644))
+	require.NoError(t, os.WriteFile(q.path(2), []byte("\n\n"), 0o644))
+	require.NoError(t, os.WriteFile(q.path(3), []byte("a\n"), 0o644))
+	require.NoError(t, os.WriteFile(q.path(4)+".tmp", []byte("c\n"), 0o644))
+
+	q, err = Open(dir, 0)
+	require.NoError(t, err)
+	assert.NoFileExists(t, q.path(1))
+	assert.NoFileExists(t, q.path(4)+".tmp", "Đoạn ghi dở bị xóa")
+	require.NoError(t, q.Push([]string{"b"}))
+
+	lines, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, lines)
+	assert.NoFileExists(t, q.path(2))
+	require.NoError(t, q.Pop())
+
+	lines, _ = q.Peek()
+	assert.Equal(t, []string{"b"}, lines)
+	assert.Equal(t, 1, q.Len())
+}
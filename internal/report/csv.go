@@ -0,0 +1,72 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// CSVOptions tùy chọn định dạng file CSV
+type CSVOptions struct {
+	Comma        rune // Ký tự phân cách cột, mặc định ','
+	DecimalComma bool // Dùng dấu phẩy thập phân (Excel cài đặt vùng Việt Nam), nên dùng cùng Comma ';'
+	BOM          bool // Ghi UTF-8 BOM để Excel nhận đúng tiếng Việt
+}
+
+// header tiêu đề các cột của file báo cáo
+var header = []string{
+	"Ngày", "Từ", "Đến", "Thiết bị",
+	"Điện năng (kWh)", "P trung bình (kW)", "Q trung bình (kVar)", "Hệ số công suất", "Khả dụng (%)",
+}
+
+// WriteCSV ghi các khoảng 15 phút của một thiết bị ra CSV, một dòng mỗi khoảng
+// và một dòng tổng cuối file. Ô trống là không có dữ liệu.
+func WriteCSV(w io.Writer, device string, intervals []Interval, opts CSVOptions) error {
+	if opts.BOM {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+	}
+	cw := csv.NewWriter(w)
+	if opts.Comma != 0 {
+		cw.Comma = opts.Comma
+	}
+	num := func(v float64, prec int) string {
+		if math.IsNaN(v) {
+			return ""
+		}
+		s := strconv.FormatFloat(v, 'f', prec, 64)
+		if opts.DecimalComma {
+			s = strings.Replace(s, ".", ",", 1)
+		}
+		return s
+	}
+
+	cw.Write(header)
+	var total, avail float64
+	for _, iv := range intervals {
+		end := iv.Start.Add(IntervalLength)
+		endText := end.Format("15:04")
+		if endText == "00:00" {
+			endText = "24:00"
+		}
+		cw.Write([]string{
+			iv.Start.Format("02/01/2006"), iv.Start.Format("15:04"), endText, device,
+			num(iv.Energy, 3), num(iv.ActivePower, 3), num(iv.ReactivePower, 3), num(iv.PowerFactor, 3), num(iv.Availability, 1),
+		})
+		if !math.IsNaN(iv.Energy) {
+			total += iv.Energy
+		}
+		avail += iv.Availability
+	}
+	if len(intervals) > 0 {
+		cw.Write([]string{
+			intervals[0].Start.Format("02/01/2006"), "Tổng", "", device,
+			num(total, 3), "", "", "", num(avail/float64(len(intervals)), 1),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,167 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"modbus_inverter/internal/historian"
+)
+
+// IntervalLength độ dài mỗi khoảng báo cáo
+const IntervalLength = 15 * time.Minute
+
+// Source là nguồn dữ liệu lịch sử (historian.Historian)
+type Source interface {
+	Query(device, signal string, from, to time.Time, resolution string) ([]historian.Point, error)
+}
+
+// Interval là số liệu của một thiết bị (hoặc cả nhà máy) trong một khoảng 15
+// phút. Giá trị NaN nghĩa là không có dữ liệu.
+type Interval struct {
+	Start         time.Time
+	Energy        float64 // Điện năng phát trong khoảng (kWh), từ chênh lệch total_energy_acc (hoặc total_energy)
+	ActivePower   float64 // Công suất tác dụng trung bình (kW)
+	ReactivePower float64 // Công suất phản kháng trung bình (kVar)
+	PowerFactor   float64 // Hệ số công suất trung bình
+	Availability  float64 // Tỉ lệ số phút có dữ liệu và thiết bị hoạt động (%)
+}
+
+// Day tính các khoảng 15 phút trong ngày chứa day (theo múi giờ của day) cho một inverter
+func Day(src Source, device string, day time.Time) ([]Interval, error) {
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	to := from.AddDate(0, 0, 1)
+
+	// Lấy thêm khoảng trước đó để tính điện năng của khoảng đầu tiên. Ưu tiên
+	// năng lượng tích lũy của gateway vì bộ đếm của inverter quay vòng.
+	energy, err := index(src, device, "total_energy_acc", from.Add(-IntervalLength), to, historian.Resolution15m)
+	if err == nil && len(energy) == 0 {
+		energy, err = index(src, device, "total_energy", from.Add(-IntervalLength), to, historian.Resolution15m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	active, err := index(src, device, "active_power", from, to, historian.Resolution15m)
+	if err != nil {
+		return nil, err
+	}
+	reactive, err := index(src, device, "reactive_power", from, to, historian.Resolution15m)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := index(src, device, "power_factor", from, to, historian.Resolution15m)
+	if err != nil {
+		return nil, err
+	}
+	status, err := src.Query(device, "device_status", from, to, historian.Resolution1m)
+	if err != nil {
+		return nil, fmt.Errorf("đọc device_status của %s lỗi: %w", device, err)
+	}
+	running := make(map[int64]int)
+	for _, p := range status {
+		if p.Min >= 1 {
+			running[p.Time.Truncate(IntervalLength).Unix()]++
+		}
+	}
+
+	var result []Interval
+	for start := from; start.Before(to); start = start.Add(IntervalLength) {
+		iv := Interval{
+			Start:         start,
+			Energy:        math.NaN(),
+			ActivePower:   avg(active, start),
+			ReactivePower: avg(reactive, start),
+			PowerFactor:   avg(pf, start),
+			Availability:  float64(running[start.Unix()]) / IntervalLength.Minutes() * 100,
+		}
+		if cur, ok := energy[start.Unix()]; ok {
+			if prev, ok := energy[start.Add(-IntervalLength).Unix()]; ok {
+				iv.Energy = cur.Last - prev.Last
+			} else {
+				iv.Energy = cur.Last - cur.Min
+			}
+			// Bộ đếm bị đặt lại hoặc tràn: không xác định được điện năng
+			if iv.Energy < 0 {
+				iv.Energy = math.NaN()
+			}
+		}
+		result = append(result, iv)
+	}
+	return result, nil
+}
+
+// Plant cộng số liệu các inverter thành số liệu nhà máy. Hệ số công suất tính
+// từ tổng P và Q; khả dụng là trung bình theo công suất định mức (bằng nhau
+// nếu không có công suất định mức).
+func Plant(devices map[string][]Interval, rated map[string]float64) []Interval {
+	var result []Interval
+	for _, intervals := range devices {
+		// Mọi inverter có cùng các khoảng của cùng một ngày
+		for _, iv := range intervals {
+			result = append(result, Interval{Start: iv.Start})
+		}
+		break
+	}
+
+	for i := range result {
+		var energy, p, q, avail, weights float64
+		var hasEnergy, hasPower bool
+		for name, intervals := range devices {
+			iv := intervals[i]
+			if !math.IsNaN(iv.Energy) {
+				energy += iv.Energy
+				hasEnergy = true
+			}
+			if !math.IsNaN(iv.ActivePower) {
+				p += iv.ActivePower
+				hasPower = true
+			}
+			if !math.IsNaN(iv.ReactivePower) {
+				q += iv.ReactivePower
+			}
+			w := rated[name]
+			if w <= 0 {
+				w = 1
+			}
+			avail += iv.Availability * w
+			weights += w
+		}
+
+		result[i].Energy, result[i].ActivePower, result[i].ReactivePower, result[i].PowerFactor = math.NaN(), math.NaN(), math.NaN(), math.NaN()
+		if hasEnergy {
+			result[i].Energy = energy
+		}
+		if hasPower {
+			result[i].ActivePower = p
+			result[i].ReactivePower = q
+			if s := math.Hypot(p, q); s > 0 {
+				result[i].PowerFactor = p / s
+			}
+		}
+		if weights > 0 {
+			result[i].Availability = avail / weights
+		}
+	}
+	return result
+}
+
+// index truy vấn một tín hiệu và đánh chỉ mục theo thời điểm bắt đầu khoảng (Unix giây)
+func index(src Source, device, signal string, from, to time.Time, resolution string) (map[int64]historian.Point, error) {
+	points, err := src.Query(device, signal, from, to, resolution)
+	if err != nil {
+		return nil, fmt.Errorf("đọc %s của %s lỗi: %w", signal, device, err)
+	}
+	m := make(map[int64]historian.Point, len(points))
+	for _, p := range points {
+		m[p.Time.Unix()] = p
+	}
+	return m, nil
+}
+
+// avg trả về giá trị trung bình của khoảng bắt đầu tại start, NaN nếu không có dữ liệu
+func avg(points map[int64]historian.Point, start time.Time) float64 {
+	if p, ok := points[start.Unix()]; ok {
+		return p.Avg
+	}
+	return math.NaN()
+}
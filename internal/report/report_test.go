@@ -0,0 +1,115 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/historian"
+)
+
+// fakeSource giả lập dữ liệu lịch sử: inverter phát 4 kW từ 06:00 đến 18:00,
+// điện năng tăng 1 kWh mỗi khoảng 15 phút, bộ đếm của inverter quay vòng ở 110 kWh
+type fakeSource struct {
+	day     time.Time
+	rawOnly bool // Chưa có năng lượng tích lũy của gateway
+}
+
+func (s fakeSource) Query(device, signal string, from, to time.Time, resolution string) ([]historian.Point, error) {
+	step := 15 * time.Minute
+	if resolution == historian.Resolution1m {
+		step = time.Minute
+	}
+	var points []historian.Point
+	for t := from; t.Before(to); t = t.Add(step) {
+		h := t.Sub(s.day).Hours()
+		if h < 6 || h >= 18 {
+			continue
+		}
+		var v float64
+		switch signal {
+		case "total_energy":
+			v = math.Mod(100+(h-6)*4+1, 110)
+		case "total_energy_acc":
+			if s.rawOnly {
+				return nil, nil
+			}
+			v = 100 + (h-6)*4 + 1
+		case "active_power":
+			v = 4
+		case "reactive_power":
+			v = 3
+		case "power_factor":
+			v = 0.8
+		case "device_status":
+			v = 1
+		default:
+			return nil, nil
+		}
+		points = append(points, historian.Point{Time: t, Min: v, Max: v, Avg: v, Last: v, Count: 1})
+	}
+	return points, nil
+}
+
+// TestDay kiểm tra tính số liệu 15 phút của một inverter và cả nhà máy
+func TestDay(t *testing.T) {
+	loc := time.FixedZone("ICT", 7*3600)
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, loc)
+	src := fakeSource{day: day}
+
+	intervals, err := Day(src, "inv1", day.Add(10*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, intervals, 96)
+
+	night := intervals[0]
+	assert.True(t, math.IsNaN(night.Energy))
+	assert.True(t, math.IsNaN(night.ActivePower))
+	assert.Equal(t, 0.0, night.Availability)
+
+	first := intervals[24] // 06:00
+	assert.Equal(t, 0.0, first.Energy, "Khoảng đầu tiên không có khoảng trước để so sánh")
+	noon := intervals[48]
+	assert.Equal(t, day.Add(12*time.Hour), noon.Start)
+	assert.InDelta(t, 1, noon.Energy, 1e-9)
+	assert.Equal(t, 4.0, noon.ActivePower)
+	assert.Equal(t, 100.0, noon.Availability)
+	assert.InDelta(t, 1, intervals[33].Energy, 1e-9, "Năng lượng tích lũy không bị ảnh hưởng khi bộ đếm quay vòng")
+
+	raw, err := Day(fakeSource{day: day, rawOnly: true}, "inv1", day)
+	require.NoError(t, err)
+	assert.InDelta(t, 1, raw[48].Energy, 1e-9, "Dùng total_energy khi chưa có năng lượng tích lũy")
+	assert.True(t, math.IsNaN(raw[33].Energy), "Bộ đếm quay vòng")
+
+	plant := Plant(map[string][]Interval{"inv1": intervals, "inv2": intervals}, map[string]float64{"inv1": 10, "inv2": 30})
+	require.Len(t, plant, 96)
+	assert.InDelta(t, 2, plant[48].Energy, 1e-9)
+	assert.Equal(t, 8.0, plant[48].ActivePower)
+	assert.InDelta(t, 0.8, plant[48].PowerFactor, 1e-9)
+	assert.Equal(t, 100.0, plant[48].Availability)
+	assert.True(t, math.IsNaN(plant[0].Energy))
+}
+
+// TestWriteCSV kiểm tra định dạng file CSV cho Excel
+func TestWriteCSV(t *testing.T) {
+	start := time.Date(2024, 6, 1, 23, 45, 0, 0, time.UTC)
+	intervals := []Interval{{Start: start, Energy: 1.5, ActivePower: 6, ReactivePower: math.NaN(), PowerFactor: 0.95, Availability: 100}}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, "inv1", intervals, CSVOptions{Comma: ';', DecimalComma: true, BOM: true}))
+	require.True(t, strings.HasPrefix(buf.String(), "\ufeff"))
+
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), "\ufeff")))
+	r.Comma = ';'
+	rows, err := r.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"01/06/2024", "23:45", "24:00", "inv1", "1,500", "6,000", "", "0,950", "100,0"}, rows[1])
+	assert.Equal(t, "Tổng", rows[2][1])
+	assert.Equal(t, "1,500", rows[2][4])
+}
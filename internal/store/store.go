@@ -0,0 +1,344 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Quality chất lượng dữ liệu của một thiết bị hoặc một tín hiệu
+type Quality uint16
+
+const (
+	QualityGood        Quality = 0 // Dữ liệu đọc thành công ở chu kỳ gần nhất
+	QualityCommFailure Quality = 1 // Mất liên lạc hoặc đọc lỗi, giá trị là giá trị cũ
+	QualityStale       Quality = 2 // Quá thời gian không được cập nhật
+	QualityOutOfRange  Quality = 3 // Giá trị ngoài dải hợp lệ
+	QualitySubstituted Quality = 4 // Giá trị thay thế, không đọc trực tiếp từ thiết bị
+)
+
+// String trả về tên chất lượng dùng trong nhật ký
+func (q Quality) String() string {
+	switch q {
+	case QualityGood:
+		return "good"
+	case QualityCommFailure:
+		return "comm_failure"
+	case QualityStale:
+		return "stale"
+	case QualityOutOfRange:
+		return "out_of_range"
+	case QualitySubstituted:
+		return "substituted"
+	}
+	return fmt.Sprintf("quality(%d)", uint16(q))
+}
+
+// Usable cho biết giá trị có dùng được cho tính toán và lưu trữ không
+func (q Quality) Usable() bool {
+	return q == QualityGood || q == QualitySubstituted
+}
+
+// Tag là giá trị của một tín hiệu kèm chất lượng và nhãn thời gian
+type Tag struct {
+	Value    float64   `json:"value"`
+	Quality  Quality   `json:"quality"`
+	Source   time.Time `json:"source"`   // Thời điểm giá trị được tạo ra tại nguồn (thiết bị hoặc phép tính)
+	Acquired time.Time `json:"acquired"` // Thời điểm gateway nhận được giá trị
+}
+
+// Tags tạo các tag chất lượng tốt từ values với cùng nhãn thời gian ts,
+// giá trị NaN hoặc vô cùng được đánh dấu ngoài dải
+func Tags(values map[string]float64, ts time.Time) map[string]Tag {
+	tags := make(map[string]Tag, len(values))
+	for k, v := range values {
+		q := QualityGood
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			q = QualityOutOfRange
+		}
+		tags[k] = Tag{Value: v, Quality: q, Source: ts, Acquired: ts}
+	}
+	return tags
+}
+
+// PollStats thống kê các lần đọc của một thiết bị
+type PollStats struct {
+	Polls        uint64        `json:"polls"`         // Tổng số lần đọc
+	Errors       uint64        `json:"errors"`        // Số lần đọc lỗi
+	LastError    string        `json:"last_error"`    // Lỗi gần nhất
+	LastErrorAt  time.Time     `json:"last_error_at"` // Thời điểm lỗi gần nhất
+	LastDuration time.Duration `json:"last_duration"` // Thời gian đọc gần nhất (ns)
+	AvgDuration  time.Duration `json:"avg_duration"`  // Thời gian đọc trung bình (ns)
+}
+
+// Snapshot là giá trị mới nhất của một thiết bị
+type Snapshot struct {
+	Device    string             `json:"device"`
+	Values    map[string]float64 `json:"values"`
+	Tags      map[string]Tag     `json:"tags"` // Giá trị kèm chất lượng và nhãn thời gian của từng tín hiệu
+	Quality   Quality            `json:"quality"`
+	Timestamp time.Time          `json:"timestamp"` // Thời điểm đọc thành công gần nhất
+	Updated   time.Time          `json:"updated"`   // Thời điểm cập nhật gần nhất (kể cả lỗi)
+	Stats     PollStats          `json:"stats"`
+}
+
+// UpdateFunc nhận các giá trị vừa đọc được của một thiết bị, chỉ gồm các giá trị
+// dùng được (chất lượng tốt hoặc thay thế)
+type UpdateFunc func(device string, values map[string]float64, ts time.Time)
+
+// TagFunc nhận các tag vừa thay đổi giá trị hoặc chất lượng của một thiết bị
+type TagFunc func(device string, tags map[string]Tag)
+
+// Store lưu giá trị mới nhất của mọi thiết bị do poller cập nhật,
+// dùng chung cho các giao diện xuất dữ liệu (Modbus TCP, HTTP, ...)
+type Store struct {
+	mu           sync.RWMutex
+	devices      map[string]*Snapshot
+	listeners    []UpdateFunc
+	tagListeners []TagFunc
+	staleAfter   time.Duration
+}
+
+// New tạo store rỗng
+func New() *Store {
+	return &Store{devices: make(map[string]*Snapshot)}
+}
+
+// OnUpdate đăng ký hàm được gọi sau mỗi lần Update (đồng bộ, theo thứ tự đăng ký),
+// dùng cho các đầu ra chuỗi thời gian; gọi trước khi poller bắt đầu
+func (s *Store) OnUpdate(fn UpdateFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// OnTags đăng ký hàm được gọi khi tag của thiết bị thay đổi giá trị hoặc chất lượng
+// (đồng bộ, theo thứ tự đăng ký), dùng cho các đầu ra cần chất lượng từng tín hiệu;
+// gọi trước khi poller bắt đầu
+func (s *Store) OnTags(fn TagFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tagListeners = append(s.tagListeners, fn)
+}
+
+// SetStaleAfter đặt thời gian không được cập nhật để tag bị coi là cũ
+// khi gọi CheckStale, 0 để tắt; gọi trước khi poller bắt đầu
+func (s *Store) SetStaleAfter(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.staleAfter = d
+}
+
+// Update ghi giá trị mới của thiết bị với chất lượng tốt, các tín hiệu không có
+// trong values giữ giá trị cũ
+func (s *Store) Update(device string, values map[string]float64, ts time.Time) {
+	s.UpdateTags(device, Tags(values, ts))
+}
+
+// UpdateTags ghi các tag mới của thiết bị, các tín hiệu không có trong tags giữ
+// giá trị cũ. Tag mất liên lạc giữ giá trị và nhãn thời gian của lần đọc trước.
+func (s *Store) UpdateTags(device string, tags map[string]Tag) {
+	s.mu.Lock()
+	snap := s.snapshot(device)
+	values := make(map[string]float64, len(tags))
+	changed := make(map[string]Tag, len(tags))
+	var ts time.Time
+	for k, tag := range tags {
+		if tag.Quality == QualityCommFailure {
+			old, ok := snap.Tags[k]
+			if ok && old.Quality == QualityCommFailure {
+				continue
+			}
+			tag.Value, tag.Source, tag.Acquired = old.Value, old.Source, old.Acquired
+			snap.Tags[k] = tag
+			changed[k] = tag
+			continue
+		}
+		if math.IsNaN(tag.Value) || math.IsInf(tag.Value, 0) {
+			// Giữ giá trị cũ để không ghi NaN vào store (không mã hóa được JSON)
+			tag.Value = snap.Values[k]
+			if tag.Quality.Usable() {
+				tag.Quality = QualityOutOfRange
+			}
+		}
+		snap.Values[k] = tag.Value
+		snap.Tags[k] = tag
+		changed[k] = tag
+		if tag.Quality.Usable() {
+			values[k] = tag.Value
+		}
+		if tag.Acquired.After(ts) {
+			ts = tag.Acquired
+		}
+	}
+	if !ts.IsZero() {
+		snap.Quality = QualityGood
+		snap.Timestamp = ts
+		snap.Updated = ts
+	}
+	listeners, tagListeners := s.listeners, s.tagListeners
+	s.mu.Unlock()
+
+	if len(values) > 0 {
+		for _, fn := range listeners {
+			fn(device, values, ts)
+		}
+	}
+	s.notifyTags(tagListeners, device, changed)
+}
+
+// MarkInvalid đánh dấu thiết bị mất liên lạc, giữ nguyên giá trị cũ
+func (s *Store) MarkInvalid(device string, ts time.Time) {
+	s.markInvalid(device, "", ts)
+}
+
+// MarkDisconnected đánh dấu thiết bị mất liên lạc như MarkInvalid nhưng ghi tín
+// hiệu trạng thái kết nối signal = 0 với chất lượng tốt: trạng thái kết nối do
+// gateway xác định nên vẫn hợp lệ, SCADA đọc tín hiệu này làm trạng thái đường truyền
+func (s *Store) MarkDisconnected(device, signal string, ts time.Time) {
+	s.markInvalid(device, signal, ts)
+}
+
+// markInvalid đánh dấu mất liên lạc mọi tag trừ tín hiệu trạng thái kết nối status
+// (bỏ trống nếu không có), status được ghi 0 với chất lượng tốt
+func (s *Store) markInvalid(device, status string, ts time.Time) {
+	s.mu.Lock()
+	snap := s.snapshot(device)
+	snap.Quality = QualityCommFailure
+	snap.Updated = ts
+	changed := make(map[string]Tag)
+	for k, tag := range snap.Tags {
+		if k != status && tag.Quality != QualityCommFailure {
+			tag.Quality = QualityCommFailure
+			snap.Tags[k] = tag
+			changed[k] = tag
+		}
+	}
+	if status != "" {
+		old, ok := snap.Tags[status]
+		tag := Tag{Value: 0, Quality: QualityGood, Source: ts, Acquired: ts}
+		snap.Values[status] = 0
+		snap.Tags[status] = tag
+		if !ok || old.Value != 0 || old.Quality != QualityGood {
+			changed[status] = tag
+		}
+	}
+	listeners, tagListeners := s.listeners, s.tagListeners
+	s.mu.Unlock()
+
+	if status != "" {
+		for _, fn := range listeners {
+			fn(device, map[string]float64{status: 0}, ts)
+		}
+	}
+	s.notifyTags(tagListeners, device, changed)
+}
+
+// CheckStale đánh dấu cũ các tag chất lượng tốt không được cập nhật quá thời gian
+// đặt bởi SetStaleAfter tính tới now, gọi định kỳ
+func (s *Store) CheckStale(now time.Time) {
+	s.mu.Lock()
+	if s.staleAfter <= 0 {
+		s.mu.Unlock()
+		return
+	}
+	changed := make(map[string]map[string]Tag)
+	for device, snap := range s.devices {
+		for k, tag := range snap.Tags {
+			if tag.Quality == QualityGood && now.Sub(tag.Acquired) > s.staleAfter {
+				tag.Quality = QualityStale
+				snap.Tags[k] = tag
+				if changed[device] == nil {
+					changed[device] = make(map[string]Tag)
+				}
+				changed[device][k] = tag
+			}
+		}
+		if snap.Quality == QualityGood && now.Sub(snap.Timestamp) > s.staleAfter {
+			snap.Quality = QualityStale
+		}
+	}
+	tagListeners := s.tagListeners
+	s.mu.Unlock()
+
+	for device, tags := range changed {
+		s.notifyTags(tagListeners, device, tags)
+	}
+}
+
+// notifyTags gọi các hàm nhận tag thay đổi, gọi khi không giữ khóa
+func (s *Store) notifyTags(listeners []TagFunc, device string, tags map[string]Tag) {
+	if len(tags) == 0 {
+		return
+	}
+	for _, fn := range listeners {
+		fn(device, tags)
+	}
+}
+
+// RecordPoll ghi nhận thống kê một lần đọc thiết bị
+func (s *Store) RecordPoll(device string, duration time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := &s.snapshot(device).Stats
+	st.Polls++
+	st.LastDuration = duration
+	st.AvgDuration += (duration - st.AvgDuration) / time.Duration(st.Polls)
+	if err != nil {
+		st.Errors++
+		st.LastError = err.Error()
+		st.LastErrorAt = time.Now()
+	}
+}
+
+// Get trả về bản sao giá trị mới nhất của thiết bị
+func (s *Store) Get(device string) (Snapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap, ok := s.devices[device]
+	if !ok {
+		return Snapshot{}, false
+	}
+	return snap.clone(), true
+}
+
+// All trả về bản sao giá trị mới nhất của mọi thiết bị, sắp xếp theo tên
+func (s *Store) All() []Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make([]Snapshot, 0, len(s.devices))
+	for _, snap := range s.devices {
+		all = append(all, snap.clone())
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Device < all[j].Device })
+	return all
+}
+
+// snapshot trả về bản ghi của thiết bị, tạo mới nếu chưa có; gọi khi đang giữ khóa
+func (s *Store) snapshot(device string) *Snapshot {
+	snap, ok := s.devices[device]
+	if !ok {
+		snap = &Snapshot{Device: device, Values: make(map[string]float64), Tags: make(map[string]Tag), Quality: QualityCommFailure}
+		s.devices[device] = snap
+	}
+	return snap
+}
+
+// clone sao chép snapshot để trả ra ngoài
+func (s *Snapshot) clone() Snapshot {
+	c := *s
+	c.Values = make(map[string]float64, len(s.Values))
+	for k, v := range s.Values {
+		c.Values[k] = v
+	}
+	c.Tags = make(map[string]Tag, len(s.Tags))
+	for k, tag := range s.Tags {
+		c.Tags[k] = tag
+	}
+	return c
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStore kiểm tra lưu giá trị mới nhất và chất lượng dữ liệu
+func TestStore(t *testing.T) {
+	s := New()
+	ts := time.Now()
+
+	var updates []string
+	s.OnUpdate(func(device string, values map[string]float64, _ time.Time) {
+		updates = append(updates, device)
+	})
+
+	s.Update("inverter1", map[string]float64{"active_power": 1, "voltage": 230}, ts)
+	s.Update("inverter1", map[string]float64{"active_power": 2}, ts.Add(time.Second))
+	snap, ok := s.Get("inverter1")
+	require.True(t, ok)
+	assert.Equal(t, QualityGood, snap.Quality)
+	assert.Equal(t, 2.0, snap.Values["active_power"])
+	assert.Equal(t, 230.0, snap.Values["voltage"], "Tín hiệu không cập nhật giữ giá trị cũ")
+
+	// Bản sao không bị ảnh hưởng khi sửa
+	snap.Values["active_power"] = 99
+	snap, _ = s.Get("inverter1")
+	assert.Equal(t, 2.0, snap.Values["active_power"])
+
+	s.MarkInvalid("inverter1", ts.Add(2*time.Second))
+	snap, _ = s.Get("inverter1")
+	assert.Equal(t, QualityCommFailure, snap.Quality)
+	assert.Equal(t, ts.Add(time.Second), snap.Timestamp, "Giữ thời điểm đọc thành công gần nhất")
+
+	s.RecordPoll("inverter1", 100*time.Millisecond, nil)
+	s.RecordPoll("inverter1", 300*time.Millisecond, errors.New("timeout"))
+	snap, _ = s.Get("inverter1")
+	assert.Equal(t, uint64(2), snap.Stats.Polls)
+	assert.Equal(t, uint64(1), snap.Stats.Errors)
+	assert.Equal(t, "timeout", snap.Stats.LastError)
+	assert.Equal(t, 200*time.Millisecond, snap.Stats.AvgDuration)
+
+	assert.Equal(t, []string{"inverter1", "inverter1"}, updates, "Chỉ gọi khi có giá trị mới")
+
+	s.MarkInvalid("meter1", ts)
+	all := s.All()
+	require.Len(t, all, 2)
+	assert.Equal(t, "inverter1", all[0].Device)
+	assert.Equal(t, QualityCommFailure, all[1].Quality)
+}
+
+// TestTags kiểm tra chất lượng và nhãn thời gian từng tín hiệu
+func TestTags(t *testing.T) {
+	s := New()
+	s.SetStaleAfter(10 * time.Second)
+	ts := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var updated map[string]float64
+	s.OnUpdate(func(_ string, values map[string]float64, _ time.Time) { updated = values })
+	var changed []map[string]Tag
+	s.OnTags(func(_ string, tags map[string]Tag) { changed = append(changed, tags) })
+
+	tags := Tags(map[string]float64{"current_a": 5, "voltage_an": math.NaN()}, ts)
+	tags["frequency"] = Tag{Quality: QualityCommFailure}
+	s.UpdateTags("meter1", tags)
+	snap, _ := s.Get("meter1")
+	assert.Equal(t, QualityGood, snap.Quality, "Thiết bị vẫn trả lời")
+	assert.Equal(t, QualityGood, snap.Tags["current_a"].Quality)
+	assert.Equal(t, ts, snap.Tags["current_a"].Acquired)
+	assert.Equal(t, QualityOutOfRange, snap.Tags["voltage_an"].Quality)
+	assert.Equal(t, 0.0, snap.Values["voltage_an"], "Không ghi NaN vào store")
+	assert.Equal(t, QualityCommFailure, snap.Tags["frequency"].Quality)
+	assert.NotContains(t, snap.Values, "frequency", "Chưa từng đọc được")
+	assert.Equal(t, map[string]float64{"current_a": 5}, updated, "Chỉ chuyển giá trị dùng được")
+
+	// Đọc lỗi một tín hiệu giữ giá trị và nhãn thời gian cũ
+	s.UpdateTags("meter1", map[string]Tag{"current_a": {Quality: QualityCommFailure}})
+	snap, _ = s.Get("meter1")
+	assert.Equal(t, Tag{Value: 5, Quality: QualityCommFailure, Source: ts, Acquired: ts}, snap.Tags["current_a"])
+	assert.Equal(t, 5.0, snap.Values["current_a"])
+
+	// NaN, vô cùng giữ giá trị cũ kể cả khi tag chưa được đánh dấu
+	s.Update("meter1", map[string]float64{"voltage_an": 230}, ts)
+	s.UpdateTags("meter1", map[string]Tag{"voltage_an": {Value: math.Inf(1), Acquired: ts}})
+	snap, _ = s.Get("meter1")
+	assert.Equal(t, Tag{Value: 230, Quality: QualityOutOfRange, Acquired: ts}, snap.Tags["voltage_an"])
+	assert.Equal(t, 230.0, snap.Values["voltage_an"])
+
+	s.Update("meter1", map[string]float64{"current_a": 6}, ts.Add(5*time.Second))
+	changed = nil
+	s.CheckStale(ts.Add(12 * time.Second))
+	assert.Empty(t, changed, "Chưa quá thời gian")
+	s.CheckStale(ts.Add(20 * time.Second))
+	require.Len(t, changed, 1)
+	assert.Equal(t, QualityStale, changed[0]["current_a"].Quality)
+	snap, _ = s.Get("meter1")
+	assert.Equal(t, QualityStale, snap.Quality)
+
+	changed = nil
+	s.MarkInvalid("meter1", ts.Add(30*time.Second))
+	require.Len(t, changed, 1)
+	assert.Len(t, changed[0], 2, "Chỉ báo các tag chưa mất liên lạc")
+	assert.Equal(t, "comm_failure", changed[0]["current_a"].Quality.String())
+
+	// Trạng thái kết nối do gateway xác định vẫn hợp lệ khi mất liên lạc
+	s.Update("inv1", map[string]float64{"connection_status": 1, "active_power": 5}, ts)
+	changed = nil
+	s.MarkDisconnected("inv1", "connection_status", ts.Add(time.Second))
+	require.Len(t, changed, 1)
+	assert.Equal(t, Tag{Value: 0, Quality: QualityGood, Source: ts.Add(time.Second), Acquired: ts.Add(time.Second)}, changed[0]["connection_status"])
+	assert.Equal(t, QualityCommFailure, changed[0]["active_power"].Quality)
+	assert.Equal(t, map[string]float64{"connection_status": 0}, updated)
+	snap, _ = s.Get("inv1")
+	assert.Equal(t, QualityCommFailure, snap.Quality)
+	assert.Equal(t, 0.0, snap.Values["connection_status"])
+
+	changed = nil
+	s.MarkDisconnected("inv1", "connection_status", ts.Add(2*time.Second))
+	assert.Empty(t, changed, "Không báo lại khi không đổi")
+}
@@ -0,0 +1,266 @@
+// Package virtual tính các thiết bị ảo có tag là biểu thức trên tag của các
+// thiết bị khác, ví dụ tổng công suất và năng lượng của cả nhà máy, và các tag
+// tính toán của từng thiết bị, ví dụ công suất biểu kiến từ P và Q.
+package virtual
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"modbus_inverter/internal/expr"
+	"modbus_inverter/internal/store"
+)
+
+// DeviceType là loại của thiết bị ảo trong danh sách thiết bị
+const DeviceType = "virtual"
+
+// Device cấu hình một thiết bị ảo. Mẫu tên thiết bị trong biểu thức (ví dụ
+// sum(inverter*.active_power)) chỉ khớp thiết bị thật; thiết bị ảo khác được
+// tham chiếu bằng tên và phải khai báo trước.
+type Device struct {
+	Name string            `json:"name"`
+	Tags map[string]string `json:"tags"` // Tín hiệu -> biểu thức
+}
+
+// Calculated cấu hình một tag tính toán của thiết bị, tính sau mỗi lần đọc
+// thiết bị. Tín hiệu không ghi thiết bị trong biểu thức là của chính thiết bị;
+// tag chỉ được tính khi lần đọc có đủ các tín hiệu đó.
+type Calculated struct {
+	Device string `json:"device"` // Tên hoặc mẫu tên thiết bị (path.Match), bỏ trống: mọi thiết bị
+	Signal string `json:"signal"`
+	Expr   string `json:"expr"`
+}
+
+// signal là một tag của thiết bị ảo
+type signal struct {
+	name string
+	expr *expr.Expr
+}
+
+// device là thiết bị ảo đã phân tích biểu thức
+type device struct {
+	name    string
+	signals []signal // Sắp theo tên tín hiệu
+}
+
+// calculated là tag tính toán đã phân tích biểu thức
+type calculated struct {
+	Calculated
+	expr  *expr.Expr
+	needs []string // Tín hiệu của chính thiết bị cần có
+}
+
+// Engine tính các thiết bị ảo và tag tính toán từ giá trị trong store
+type Engine struct {
+	store      *store.Store
+	devices    []device
+	calculated []calculated
+	virtual    map[string]bool
+}
+
+// NewEngine phân tích biểu thức của các thiết bị ảo và tag tính toán
+func NewEngine(devices []Device, tags []Calculated, st *store.Store) (*Engine, error) {
+	e := &Engine{store: st, virtual: make(map[string]bool)}
+	for i, cfg := range devices {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("thiết bị ảo thứ %d chưa có tên", i+1)
+		}
+		if e.virtual[cfg.Name] {
+			return nil, fmt.Errorf("tên thiết bị ảo %q bị trùng", cfg.Name)
+		}
+		if len(cfg.Tags) == 0 {
+			return nil, fmt.Errorf("thiết bị ảo %q chưa có tag", cfg.Name)
+		}
+		dev := device{name: cfg.Name}
+		for name, src := range cfg.Tags {
+			x, err := expr.Parse(src)
+			if err != nil {
+				return nil, fmt.Errorf("thiết bị ảo %q tag %s: %w", cfg.Name, name, err)
+			}
+			if _, err := checkRefs(x); err != nil {
+				return nil, fmt.Errorf("thiết bị ảo %q tag %s: %w", cfg.Name, name, err)
+			}
+			dev.signals = append(dev.signals, signal{name: name, expr: x})
+		}
+		sort.Slice(dev.signals, func(i, j int) bool { return dev.signals[i].name < dev.signals[j].name })
+		e.devices = append(e.devices, dev)
+		e.virtual[cfg.Name] = true
+	}
+
+	for i, cfg := range tags {
+		if cfg.Signal == "" {
+			return nil, fmt.Errorf("tag tính toán thứ %d chưa có tín hiệu", i+1)
+		}
+		if _, err := path.Match(cfg.Device, ""); err != nil {
+			return nil, fmt.Errorf("tag tính toán %s: mẫu thiết bị %q không hợp lệ", cfg.Signal, cfg.Device)
+		}
+		x, err := expr.Parse(cfg.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("tag tính toán %s: %w", cfg.Signal, err)
+		}
+		needs, err := checkRefs(x)
+		if err != nil {
+			return nil, fmt.Errorf("tag tính toán %s: %w", cfg.Signal, err)
+		}
+		e.calculated = append(e.calculated, calculated{Calculated: cfg, expr: x, needs: needs})
+	}
+	return e, nil
+}
+
+// checkRefs kiểm tra mẫu thiết bị trong biểu thức, trả về các tín hiệu không ghi thiết bị
+func checkRefs(x *expr.Expr) ([]string, error) {
+	var own []string
+	for _, ref := range x.Refs() {
+		device, signal, _ := strings.Cut(ref, ".")
+		if device == "" {
+			own = append(own, signal)
+			continue
+		}
+		if _, err := path.Match(device, ""); err != nil {
+			return nil, fmt.Errorf("mẫu %q không hợp lệ", ref)
+		}
+	}
+	return own, nil
+}
+
+// Names trả về tên các thiết bị ảo theo thứ tự cấu hình
+func (e *Engine) Names() []string {
+	names := make([]string, 0, len(e.devices))
+	for _, dev := range e.devices {
+		names = append(names, dev.name)
+	}
+	return names
+}
+
+// Apply thêm các tag tính toán của thiết bị vào tags vừa đọc và trả về tags,
+// gọi sau mỗi lần đọc thiết bị. Tag tính toán sau dùng được tag tính toán trước.
+func (e *Engine) Apply(device string, tags map[string]store.Tag) map[string]store.Tag {
+	if len(e.calculated) == 0 {
+		return tags
+	}
+	env := e.newEnv()
+	old := make(map[string]store.Tag)
+	if snap, ok := e.store.Get(device); ok {
+		old = snap.Tags
+	}
+	own := make(map[string]store.Tag, len(old)+len(tags))
+	for k, tag := range old {
+		own[k] = tag
+	}
+	for k, tag := range tags {
+		own[k] = tag
+	}
+	env.tags[device] = own
+
+	var ts time.Time
+	for _, tag := range tags {
+		if tag.Acquired.After(ts) {
+			ts = tag.Acquired
+		}
+	}
+	for _, c := range e.calculated {
+		if ok, _ := path.Match(c.Device, device); c.Device != "" && !ok {
+			continue
+		}
+		if !hasAll(tags, c.needs) {
+			continue
+		}
+		tag := c.expr.Eval(env, device)
+		tag.Acquired = ts
+		tag.Value = finite(tag.Value, old[c.Signal].Value)
+		tags[c.Signal] = tag
+		own[c.Signal] = tag
+	}
+	return tags
+}
+
+// Evaluate tính các thiết bị ảo theo thứ tự cấu hình và ghi vào store, gọi sau
+// mỗi vòng đọc. Thiết bị ảo sau dùng được giá trị vừa tính của thiết bị ảo trước.
+func (e *Engine) Evaluate(now time.Time) {
+	env := e.newEnv()
+	for _, dev := range e.devices {
+		snap, _ := e.store.Get(dev.name)
+		tags := make(map[string]store.Tag, len(dev.signals))
+		for _, s := range dev.signals {
+			tag := s.expr.Eval(env, dev.name)
+			tag.Acquired = now
+			tag.Value = finite(tag.Value, snap.Tags[s.name].Value)
+			tags[s.name] = tag
+		}
+		e.store.UpdateTags(dev.name, tags)
+		if snap, ok := e.store.Get(dev.name); ok {
+			env.tags[dev.name] = snap.Tags
+		}
+	}
+}
+
+// finite trả về v, hoặc old nếu v là NaN hoặc vô cùng để không ghi NaN vào store
+// (ví dụ 0/0 khi công suất bằng 0); chất lượng đã được đánh dấu ngoài dải
+func finite(v, old float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return old
+	}
+	return v
+}
+
+// hasAll kiểm tra tags có đủ các tín hiệu
+func hasAll(tags map[string]store.Tag, signals []string) bool {
+	for _, s := range signals {
+		if _, ok := tags[s]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// newEnv tạo môi trường tính đọc từ store
+func (e *Engine) newEnv() *env {
+	return &env{store: e.store, virtual: e.virtual, tags: make(map[string]map[string]store.Tag)}
+}
+
+// env cung cấp tag từ store cho biểu thức, đọc store khi cần và giữ bản sao
+// trong một lần tính
+type env struct {
+	store   *store.Store
+	virtual map[string]bool
+	tags    map[string]map[string]store.Tag
+	names   []string // Thiết bị thật, sắp theo tên
+	loaded  bool     // Đã đọc mọi thiết bị
+}
+
+func (e *env) Tag(device, signal string) (store.Tag, bool) {
+	tags, ok := e.tags[device]
+	if !ok && !e.loaded {
+		if snap, found := e.store.Get(device); found {
+			tags = snap.Tags
+		}
+		e.tags[device] = tags
+	}
+	tag, ok := tags[signal]
+	return tag, ok
+}
+
+func (e *env) Devices(pattern string) []string {
+	if !e.loaded {
+		for _, snap := range e.store.All() {
+			if _, ok := e.tags[snap.Device]; !ok {
+				e.tags[snap.Device] = snap.Tags
+			}
+			if !e.virtual[snap.Device] {
+				e.names = append(e.names, snap.Device)
+			}
+		}
+		e.loaded = true
+	}
+	var names []string
+	for _, name := range e.names {
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
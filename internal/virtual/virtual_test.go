@@ -0,0 +1,114 @@
+package virtual
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"modbus_inverter/internal/store"
+)
+
+// TestEngine kiểm tra tổng hợp nhà máy từ nhiều inverter
+func TestEngine(t *testing.T) {
+	st := store.New()
+	e, err := NewEngine([]Device{
+		{Name: "plant", Tags: map[string]string{
+			"active_power": "sum(inverter*.active_power)",
+			"power_factor": "wavg(inverter*.power_factor, inverter*.active_power)",
+		}},
+		{Name: "site", Tags: map[string]string{
+			"losses": "plant.active_power - meter1.active_power_total",
+		}},
+	}, nil, st)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"plant", "site"}, e.Names())
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	st.Update("inverter1", map[string]float64{"active_power": 10, "power_factor": 1}, now)
+	st.Update("inverter2", map[string]float64{"active_power": 30, "power_factor": 0.9}, now)
+	st.Update("meter1", map[string]float64{"active_power_total": 39}, now)
+
+	var updated []string
+	st.OnUpdate(func(device string, values map[string]float64, ts time.Time) {
+		updated = append(updated, device)
+	})
+	e.Evaluate(now.Add(time.Second))
+	assert.Equal(t, []string{"plant", "site"}, updated)
+
+	plant, ok := st.Get("plant")
+	require.True(t, ok)
+	assert.InDelta(t, 40, plant.Values["active_power"], 1e-9)
+	assert.InDelta(t, 0.925, plant.Values["power_factor"], 1e-9)
+	assert.Equal(t, now.Add(time.Second), plant.Tags["active_power"].Acquired)
+	assert.Equal(t, now, plant.Tags["active_power"].Source)
+
+	site, _ := st.Get("site")
+	assert.InDelta(t, 1, site.Values["losses"], 1e-9, "Dùng giá trị vừa tính của thiết bị ảo trước")
+
+	// Một inverter mất liên lạc: tổng từ phần còn lại, chất lượng thay thế
+	st.MarkInvalid("inverter2", now.Add(2*time.Second))
+	e.Evaluate(now.Add(3 * time.Second))
+	plant, _ = st.Get("plant")
+	assert.InDelta(t, 10, plant.Values["active_power"], 1e-9)
+	assert.Equal(t, store.QualitySubstituted, plant.Tags["active_power"].Quality)
+
+	// Mọi inverter mất liên lạc: giữ giá trị cũ
+	st.MarkInvalid("inverter1", now.Add(4*time.Second))
+	e.Evaluate(now.Add(5 * time.Second))
+	plant, _ = st.Get("plant")
+	assert.InDelta(t, 10, plant.Values["active_power"], 1e-9)
+	assert.Equal(t, store.QualityCommFailure, plant.Tags["active_power"].Quality)
+
+	for _, devices := range [][]Device{
+		{{Tags: map[string]string{"p": "1"}}},
+		{{Name: "plant"}},
+		{{Name: "plant", Tags: map[string]string{"p": "sum("}}},
+		{{Name: "plant", Tags: map[string]string{"p": "sum([.p)"}}},
+		{{Name: "plant", Tags: map[string]string{"p": "1"}}, {Name: "plant", Tags: map[string]string{"p": "1"}}},
+	} {
+		_, err := NewEngine(devices, nil, st)
+		assert.Error(t, err, "%+v", devices)
+	}
+}
+
+// TestCalculated kiểm tra tag tính toán của từng thiết bị
+func TestCalculated(t *testing.T) {
+	st := store.New()
+	e, err := NewEngine(nil, []Calculated{
+		{Device: "inverter*", Signal: "apparent_power", Expr: "hypot(active_power, reactive_power)"},
+		{Device: "meter1", Signal: "current_imbalance", Expr: "(max(current_a, current_b, current_c) - avg(current_a, current_b, current_c)) / avg(current_a, current_b, current_c) * 100"},
+		{Device: "meter1", Signal: "self_consumption", Expr: "sum(inverter*.active_power) - active_power_total"},
+		{Signal: "self_consumption_ratio", Expr: "self_consumption / sum(inverter*.active_power) * 100"},
+	}, st)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	st.Update("inverter2", map[string]float64{"active_power": 20}, now)
+
+	tags := e.Apply("inverter1", store.Tags(map[string]float64{"active_power": 30, "reactive_power": 40}, now))
+	assert.InDelta(t, 50, tags["apparent_power"].Value, 1e-9)
+	assert.Equal(t, now, tags["apparent_power"].Acquired)
+	assert.NotContains(t, tags, "self_consumption_ratio", "Thiếu tín hiệu self_consumption")
+	st.UpdateTags("inverter1", tags)
+
+	tags = e.Apply("meter1", store.Tags(map[string]float64{
+		"current_a": 10, "current_b": 10, "current_c": 13, "active_power_total": 40,
+	}, now))
+	assert.InDelta(t, 18.181818, tags["current_imbalance"].Value, 1e-6)
+	assert.InDelta(t, 10, tags["self_consumption"].Value, 1e-9)
+	assert.InDelta(t, 20, tags["self_consumption_ratio"].Value, 1e-9, "Dùng tag tính toán trước")
+	assert.NotContains(t, tags, "apparent_power", "Chỉ áp dụng cho inverter")
+
+	// Giá trị đầu vào mất liên lạc
+	bad := store.Tags(map[string]float64{"active_power": 30}, now)
+	bad["reactive_power"] = store.Tag{Quality: store.QualityCommFailure}
+	tags = e.Apply("inverter1", bad)
+	assert.Equal(t, store.QualityCommFailure, tags["apparent_power"].Quality)
+
+	_, err = NewEngine(nil, []Calculated{{Expr: "1"}}, st)
+	assert.Error(t, err)
+	_, err = NewEngine(nil, []Calculated{{Signal: "p", Expr: "1 +"}}, st)
+	assert.Error(t, err)
+}
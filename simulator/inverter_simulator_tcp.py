@@ -34,6 +34,13 @@ class InverterData:
         self.total_energy = 100000  # Thanh ghi 11: 100.0 kWh
         self.efficiency = 980       # Thanh ghi 12: 98%
 
+        # Thanh ghi điều khiển (model "generic" trong internal/control)
+        self.p_limit_percent = 1000 # Thanh ghi 100: 100.0%
+        self.p_limit_kw = 0         # Thanh ghi 101: kW * 100
+        self.q_setpoint = 0         # Thanh ghi 102: kVar * 100
+        self.pf_setpoint = 1000     # Thanh ghi 103: 1.000
+        self.on_off = 1             # Thanh ghi 104: 1 bật, 0 tắt
+
     def update_values(self):
         """Cập nhật các giá trị với một chút biến động ngẫu nhiên"""
         # Cập nhật công suất tác dụng (0.8 - 1.2 kW)
@@ -78,13 +85,25 @@ class InverterData:
             self.efficiency
         ]
 
+    def get_control_values(self):
+        """Trả về giá trị ban đầu của các thanh ghi điều khiển"""
+        return [
+            self.p_limit_percent,
+            self.p_limit_kw,
+            self.q_setpoint,
+            self.pf_setpoint,
+            self.on_off
+        ]
+
 async def run_server():
     """Chạy server Modbus TCP"""
     # Khởi tạo dữ liệu inverter
     inverter = InverterData()
     
     # Tạo block dữ liệu Modbus
-    block = ModbusSequentialDataBlock(0, inverter.get_register_values())
+    registers = inverter.get_register_values()
+    registers += [0] * (100 - len(registers)) + inverter.get_control_values()
+    block = ModbusSequentialDataBlock(0, registers)
     
     # Tạo context cho slave
     store = ModbusSlaveContext(